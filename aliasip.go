@@ -0,0 +1,128 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Parse the "additionalIPs" key of a network config: how many
+// addresses to allocate for the pod on top of the delegate's.
+func parseAdditionalIPs(netconf map[string]interface{}) (int, error) {
+	n := 0
+	if _, err := decodeNetConfKey(netconf, "additionalIPs", &n); err != nil {
+		return 0, err
+	}
+
+	if n < 0 {
+		return 0, fmt.Errorf("Invalid additionalIPs %d.", n)
+	}
+	if n > 0 {
+		if _, ok := ipamType(netconf); !ok {
+			return 0, errors.New("additionalIPs requires an ipam config.")
+		}
+	}
+
+	return n, nil
+}
+
+// Return the IPAM plugin type of a network config.
+func ipamType(netconf map[string]interface{}) (string, bool) {
+	ipam, _ := netconf["ipam"].(map[string]interface{})
+	t, _ := ipam["type"].(string)
+	return t, t != ""
+}
+
+// Return the environment to run the IPAM plugin in for the i'th
+// additional address of a container.  Each address is allocated under
+// its own container ID, so that IPAM plugins keeping one lease per
+// container hand out distinct addresses, and each can be released.
+func aliasIPAMEnv(env *selector.DelegateEnv, args *skel.CmdArgs, command string, i int) *selector.DelegateEnv {
+	return &selector.DelegateEnv{
+		CNIPath: env.CNIPath,
+		Args: &invoke.Args{
+			Command:       command,
+			ContainerID:   fmt.Sprintf("%s-alias-%d", args.ContainerID, i),
+			NetNS:         args.Netns,
+			PluginArgsStr: args.Args,
+			IfName:        args.IfName,
+			Path:          env.CNIPath,
+		},
+		Log: env.Log,
+	}
+}
+
+// Allocate n additional addresses from the network's IPAM plugin and
+// add them to the pod interface as secondary addresses.  Returns the
+// addresses, in CIDR notation.
+func addAdditionalIPs(env *selector.DelegateEnv, args *skel.CmdArgs, netconf map[string]interface{}, n int) ([]string, error) {
+	plugin, _ := ipamType(netconf)
+
+	var addrs []string
+	for i := 0; i < n; i++ {
+		result, err := aliasIPAMEnv(env, args, "ADD", i).AddWithType(plugin, netconf)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ipc := range []*types.IPConfig{result.IP4, result.IP6} {
+			if ipc != nil {
+				addrs = append(addrs, ipc.IP.String())
+			}
+		}
+	}
+
+	err := withNetNS(args.Netns, func() error {
+		for _, addr := range addrs {
+			if err := addrAdd(args.IfName, addr); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	env.Logger().WithFields(logrus.Fields{
+		"addresses": addrs,
+	}).Debug("Added additional addresses.")
+
+	return addrs, nil
+}
+
+// Release n additional addresses of a container.  The addresses
+// themselves go away with the pod interface.  Release is best effort,
+// so that DEL can always succeed.
+func releaseAdditionalIPs(env *selector.DelegateEnv, args *skel.CmdArgs, netconf map[string]interface{}, n int) {
+	plugin, _ := ipamType(netconf)
+
+	for i := 0; i < n; i++ {
+		if err := aliasIPAMEnv(env, args, "DEL", i).DelWithType(plugin, netconf); err != nil {
+			env.Logger().WithField("error", err).Warn("Failed to release additional address.")
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+	"github.com/stretchr/testify/assert"
+)
+
+// Parse additionalIPs, which needs an IPAM plugin to allocate from.
+func TestParseAdditionalIPs(t *testing.T) {
+	n, err := parseAdditionalIPs(map[string]interface{}{
+		"additionalIPs": 2,
+		"ipam":          map[string]interface{}{"type": "host-local"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	_, err = parseAdditionalIPs(map[string]interface{}{"additionalIPs": 2})
+	assert.Error(t, err)
+
+	_, err = parseAdditionalIPs(map[string]interface{}{"additionalIPs": -1})
+	assert.Error(t, err)
+}
+
+// Allocate each additional address under its own container ID.
+func TestAliasIPAMEnv(t *testing.T) {
+	env := &selector.DelegateEnv{CNIPath: "/opt/cni/bin"}
+	args := &skel.CmdArgs{ContainerID: "abc", Netns: "/var/run/netns/abc", IfName: "eth0"}
+
+	assert.Contains(t, aliasIPAMEnv(env, args, "ADD", 1).Args.AsEnv(), "CNI_CONTAINERID=abc-alias-1")
+}
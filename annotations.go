@@ -0,0 +1,74 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// With annotatePods, kube-namespace records on each pod it attaches
+// which profile and delegate it chose and the addresses the pod got,
+// so that users and controllers can find out without node access.
+const (
+	annotationProfile  = "cni.coreos.com/attached-profile"
+	annotationNetwork  = "cni.coreos.com/attached-network"
+	annotationDelegate = "cni.coreos.com/attached-delegate"
+	annotationIPs      = "cni.coreos.com/attached-ips"
+)
+
+// Merge annotations into a pod's.
+func (c *kubeClient) annotatePod(namespace, name string, annotations map[string]string) error {
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", url.PathEscape(namespace), url.PathEscape(name))
+	return c.do("PATCH", path, patch, nil)
+}
+
+// Return the annotations recording an attachment.
+func attachmentAnnotations(att *attachment) map[string]string {
+	var ips []string
+	for _, ip := range podAddresses(att.Result, att.AdditionalIPs) {
+		ips = append(ips, ip.String())
+	}
+
+	return map[string]string{
+		annotationProfile:  att.Rule,
+		annotationNetwork:  att.Network,
+		annotationDelegate: att.DelegateType,
+		annotationIPs:      strings.Join(ips, ","),
+	}
+}
+
+// Annotate the pod of a successful ADD with its attachment.  This is
+// best effort: the pod's networking is set up either way.
+func (c *config) annotatePod(att *attachment) {
+	if !c.AnnotatePods || att.Namespace == "" || att.Pod == "" {
+		return
+	}
+
+	client, err := c.Kubernetes.client()
+	if err == nil {
+		err = client.annotatePod(att.Namespace, att.Pod, attachmentAnnotations(att))
+	}
+	if err != nil {
+		c.Logger().WithField("error", err).Warn("Failed to annotate pod.")
+	}
+}
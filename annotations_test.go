@@ -0,0 +1,67 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// Merge-patch the chosen profile, delegate and addresses into the
+// pod's annotations.
+func TestAnnotatePod(t *testing.T) {
+	var method, path, contentType string
+	patch := map[string]map[string]map[string]string{}
+	k, cleanup := fakeKubeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		method, path, contentType = r.Method, r.URL.Path, r.Header.Get("Content-Type")
+		json.NewDecoder(r.Body).Decode(&patch)
+		w.Write([]byte("{}"))
+	})
+	defer cleanup()
+
+	att := &attachment{
+		Namespace:       "tenant-a",
+		Pod:             "web-1",
+		DelegateType:    "bridge",
+		networkMetadata: networkMetadata{Network: "tenant-a-net", Rule: "tenant-a"},
+		Result:          &types.Result{IP4: &types.IPConfig{IP: net.IPNet{IP: net.ParseIP("10.2.0.5"), Mask: net.CIDRMask(16, 32)}}},
+		AdditionalIPs:   []string{"10.2.0.6/16"},
+	}
+
+	// Annotations are off by default.
+	config := &config{Kubernetes: k}
+	config.annotatePod(att)
+	assert.Equal(t, "", path)
+
+	config.AnnotatePods = true
+	config.annotatePod(att)
+	assert.Equal(t, "PATCH", method)
+	assert.Equal(t, "/api/v1/namespaces/tenant-a/pods/web-1", path)
+	assert.Equal(t, "application/merge-patch+json", contentType)
+	assert.Equal(t, map[string]string{
+		"cni.coreos.com/attached-profile":  "tenant-a",
+		"cni.coreos.com/attached-network":  "tenant-a-net",
+		"cni.coreos.com/attached-delegate": "bridge",
+		"cni.coreos.com/attached-ips":      "10.2.0.5,10.2.0.6",
+	}, patch["metadata"]["annotations"])
+}
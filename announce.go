@@ -0,0 +1,80 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"net"
+
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Return the commands announcing an address from ifName: a gratuitous
+// ARP for IPv4, an unsolicited neighbor advertisement for IPv6.
+func announceCommand(ifName string, ip net.IP) []string {
+	if ip.To4() != nil {
+		return []string{"arping", "-U", "-c", "1", "-I", ifName, ip.String()}
+	}
+
+	return []string{"ndsend", ip.String(), ifName}
+}
+
+// Return the pod's addresses: those in the result, and any additional
+// ones.
+func podAddresses(result *types.Result, additional []string) []net.IP {
+	var ips []net.IP
+	for _, ipc := range []*types.IPConfig{result.IP4, result.IP6} {
+		if ipc != nil {
+			ips = append(ips, ipc.IP.IP)
+		}
+	}
+
+	for _, addr := range additional {
+		if ip, _, err := net.ParseCIDR(addr); err == nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	return ips
+}
+
+// Announce the pod's addresses from inside its network namespace, so
+// that switches and neighbors replace stale entries for addresses the
+// pod took over, e.g. after it was rescheduled.  Announcing is best
+// effort: failures are only logged.
+func announceAddresses(log *logrus.Entry, netns, ifName string, ips []net.IP) {
+	err := withNetNS(netns, func() error {
+		for _, ip := range ips {
+			cmd := announceCommand(ifName, ip)
+			if _, err := runCommand(cmd[0], cmd[1:]...); err != nil {
+				log.WithFields(logrus.Fields{
+					"address": ip,
+					"error":   err,
+				}).Warn("Failed to announce address.")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithField("error", err).Warn("Failed to announce addresses.")
+		return
+	}
+
+	log.WithField("addresses", ips).Debug("Announced addresses.")
+}
@@ -0,0 +1,44 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// Announce IPv4 with ARP and IPv6 with NA.
+func TestAnnounceCommand(t *testing.T) {
+	assert.Equal(t, []string{"arping", "-U", "-c", "1", "-I", "eth0", "10.2.0.5"},
+		announceCommand("eth0", net.ParseIP("10.2.0.5")))
+	assert.Equal(t, []string{"ndsend", "fd00::5", "eth0"},
+		announceCommand("eth0", net.ParseIP("fd00::5")))
+}
+
+// Collect the result's and the additional addresses.
+func TestPodAddresses(t *testing.T) {
+	result := &types.Result{
+		IP4: &types.IPConfig{IP: net.IPNet{IP: net.IPv4(10, 2, 0, 5), Mask: net.CIDRMask(16, 32)}},
+	}
+
+	ips := podAddresses(result, []string{"10.2.0.6/16"})
+	assert.Equal(t, []string{"10.2.0.5", "10.2.0.6"}, []string{ips[0].String(), ips[1].String()})
+}
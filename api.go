@@ -0,0 +1,314 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// The API lets node agents, e.g. for monitoring, look up which network
+// and addresses a container has, and follow changes, without parsing
+// the state directory themselves.  Clients send one JSON request per
+// line on a unix socket:
+//
+//	{"method": "get", "containerID": "..."}
+//	{"method": "list"}
+//	{"method": "watch"}
+//
+// and get one JSON response per line.  A watch is answered with an
+// "added" event for each current attachment, then an event for each
+// change, until the client disconnects.  The API is read-only.
+
+// A request to the API.
+type apiRequest struct {
+	Method      string `json:"method"`
+	ContainerID string `json:"containerID,omitempty"`
+}
+
+// A response to a get or list request.
+type apiResponse struct {
+	Entry   *statusEntry  `json:"entry,omitempty"`
+	Entries []statusEntry `json:"entries,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// A change to an attachment, sent to watchers.
+type apiEvent struct {
+	// "added", "updated" or "removed".
+	Event string      `json:"event"`
+	Entry statusEntry `json:"entry"`
+}
+
+// The most events buffered for a watcher before it is disconnected
+// for falling behind.
+const apiWatchBuffer = 256
+
+// The API server keeps the attachments last read from the state store
+// and the watchers to tell of changes.
+type apiServer struct {
+	store *attachmentStore
+
+	mu       sync.Mutex
+	entries  map[string]statusEntry
+	watchers map[chan apiEvent]bool
+}
+
+func newAPIServer(store *attachmentStore) *apiServer {
+	return &apiServer{
+		store:    store,
+		entries:  map[string]statusEntry{},
+		watchers: map[chan apiEvent]bool{},
+	}
+}
+
+// Re-read the state store and send watchers an event for each change
+// since the last read.
+func (s *apiServer) poll() error {
+	attachments, err := s.store.list()
+	if err != nil {
+		return err
+	}
+
+	current := map[string]statusEntry{}
+	for _, entry := range statusEntries(attachments) {
+		current[entry.ContainerID] = entry
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []apiEvent
+	for id, entry := range current {
+		old, ok := s.entries[id]
+		if !ok {
+			events = append(events, apiEvent{"added", entry})
+		} else if !reflect.DeepEqual(old, entry) {
+			events = append(events, apiEvent{"updated", entry})
+		}
+	}
+	for id, entry := range s.entries {
+		if _, ok := current[id]; !ok {
+			events = append(events, apiEvent{"removed", entry})
+		}
+	}
+	s.entries = current
+
+	for _, event := range events {
+		for watcher := range s.watchers {
+			select {
+			case watcher <- event:
+			default:
+				log.Warn("API watcher fell behind. Disconnecting it.")
+				delete(s.watchers, watcher)
+				close(watcher)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Return the attachments, sorted as by status.
+func (s *apiServer) list() []statusEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := []statusEntry{}
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	sortStatusEntries(entries)
+
+	return entries
+}
+
+// Register a watcher, returning its channel, already holding an
+// "added" event for each current attachment.
+func (s *apiServer) watch() chan apiEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	watcher := make(chan apiEvent, len(s.entries)+apiWatchBuffer)
+	for _, entry := range s.entries {
+		watcher <- apiEvent{"added", entry}
+	}
+	s.watchers[watcher] = true
+
+	return watcher
+}
+
+// Unregister a watcher, unless it was already dropped.
+func (s *apiServer) unwatch(watcher chan apiEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.watchers[watcher] {
+		delete(s.watchers, watcher)
+		close(watcher)
+	}
+}
+
+// Answer a client's requests until it disconnects.
+func (s *apiServer) serve(conn io.ReadWriteCloser) {
+	defer conn.Close()
+
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	for {
+		req := &apiRequest{}
+		if err := dec.Decode(req); err != nil {
+			if err != io.EOF {
+				enc.Encode(&apiResponse{Error: fmt.Sprintf("Invalid request: %v", err)})
+			}
+			return
+		}
+
+		var resp *apiResponse
+		switch req.Method {
+		case "get":
+			resp = &apiResponse{Error: fmt.Sprintf("No attachment for container %q.", req.ContainerID)}
+			s.mu.Lock()
+			if entry, ok := s.entries[req.ContainerID]; ok {
+				resp = &apiResponse{Entry: &entry}
+			}
+			s.mu.Unlock()
+		case "list":
+			resp = &apiResponse{Entries: s.list()}
+		case "watch":
+			s.stream(conn, enc)
+			return
+		default:
+			resp = &apiResponse{Error: fmt.Sprintf("Unknown method %q.", req.Method)}
+		}
+
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// Send a client events until it disconnects or falls behind.
+func (s *apiServer) stream(conn io.Reader, enc *json.Encoder) {
+	watcher := s.watch()
+	defer s.unwatch(watcher)
+
+	// Clients send nothing more once watching; a read failing means
+	// they went away.  A client may close its side for writing once
+	// it has asked to watch, so end of file is not one.
+	gone := make(chan struct{})
+	go func() {
+		if _, err := io.Copy(ioutil.Discard, conn); err != nil {
+			close(gone)
+		}
+	}()
+
+	for {
+		select {
+		case event, ok := <-watcher:
+			if !ok {
+				return
+			}
+			if err := enc.Encode(&event); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+// Serve the read-only API on a unix socket until terminated.
+func cmdAPI(args []string, stdin io.Reader, _ io.Writer) error {
+	flags := flag.NewFlagSet("api", flag.ContinueOnError)
+	configPath := flags.String("config", "", "plugin config file (default stdin)")
+	stateDir := flags.String("state-dir", "", "state directory to serve instead of the config's")
+	socket := flags.String("socket", "/run/kube-namespace/api.sock", "unix socket to listen on")
+	interval := flags.Duration("interval", time.Second, "how often to check the state directory for changes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *stateDir == "" {
+		c, err := readConfig(*configPath, stdin)
+		if err != nil {
+			return err
+		}
+		c.setLogLevel()
+		*stateDir = c.StateDir
+	}
+
+	server := newAPIServer(newAttachmentStore(*stateDir))
+	if err := server.poll(); err != nil {
+		return err
+	}
+
+	listener, err := listenUnix(*socket)
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	stop := make(chan struct{})
+	go func() {
+		<-signals
+		close(stop)
+		listener.Close()
+	}()
+
+	go func() {
+		ticker := time.NewTicker(*interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := server.poll(); err != nil {
+					log.WithField("error", err).Warn("Failed to read the state directory.")
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	log.WithFields(logrus.Fields{
+		"socket":    *socket,
+		"state_dir": *stateDir,
+	}).Info("API listening.")
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Info("API stopped.")
+			return nil
+		}
+
+		go server.serve(conn)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Send a request to the API and decode one response line into v.
+func apiCall(t *testing.T, conn net.Conn, r *bufio.Reader, req string, v interface{}) {
+	if _, err := conn.Write([]byte(req + "\n")); err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	assert.NoError(t, json.Unmarshal(line, v))
+}
+
+// Answer get and list requests from the state store.
+func TestAPIGetList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-api")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := newAttachmentStore(dir)
+	assert.NoError(t, store.save(&attachment{ContainerID: "abc", Namespace: "web", Pod: "web-1",
+		networkMetadata: networkMetadata{Network: "web-net", Rule: "web"}}))
+	server := newAPIServer(store)
+	assert.NoError(t, server.poll())
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	go server.serve(conn)
+	r := bufio.NewReader(client)
+
+	resp := &apiResponse{}
+	apiCall(t, client, r, `{"method": "get", "containerID": "abc"}`, resp)
+	if assert.NotNil(t, resp.Entry) {
+		assert.Equal(t, "web-net", resp.Entry.Network)
+		assert.Equal(t, "web", resp.Entry.Rule)
+	}
+
+	resp = &apiResponse{}
+	apiCall(t, client, r, `{"method": "get", "containerID": "missing"}`, resp)
+	assert.Equal(t, `No attachment for container "missing".`, resp.Error)
+
+	resp = &apiResponse{}
+	apiCall(t, client, r, `{"method": "list"}`, resp)
+	assert.Len(t, resp.Entries, 1)
+
+	resp = &apiResponse{}
+	apiCall(t, client, r, `{"method": "delete"}`, resp)
+	assert.Equal(t, `Unknown method "delete".`, resp.Error)
+}
+
+// Stream the current attachments, then changes to them, to watchers.
+func TestAPIWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-api")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := newAttachmentStore(dir)
+	assert.NoError(t, store.save(&attachment{ContainerID: "abc", Namespace: "web", Pod: "web-1"}))
+	server := newAPIServer(store)
+	assert.NoError(t, server.poll())
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	go server.serve(conn)
+	r := bufio.NewReader(client)
+
+	event := &apiEvent{}
+	apiCall(t, client, r, `{"method": "watch"}`, event)
+	assert.Equal(t, "added", event.Event)
+	assert.Equal(t, "abc", event.Entry.ContainerID)
+
+	assert.NoError(t, store.save(&attachment{ContainerID: "abc", Namespace: "web", Pod: "web-1",
+		networkMetadata: networkMetadata{Network: "web-net"}}))
+	assert.NoError(t, server.poll())
+	line, err := r.ReadBytes('\n')
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(line, event))
+	assert.Equal(t, "updated", event.Event)
+	assert.Equal(t, "web-net", event.Entry.Network)
+
+	assert.NoError(t, store.remove("abc"))
+	assert.NoError(t, server.poll())
+	line, err = r.ReadBytes('\n')
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(line, event))
+	assert.Equal(t, "removed", event.Event)
+}
+
+// Keep streaming to clients that close their side for writing once
+// they asked to watch.
+func TestAPIWatchHalfClosed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-api")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := newAttachmentStore(filepath.Join(dir, "state"))
+	assert.NoError(t, store.save(&attachment{ContainerID: "abc", Namespace: "web", Pod: "web-1"}))
+	server := newAPIServer(store)
+	assert.NoError(t, server.poll())
+
+	listener, err := listenUnix(filepath.Join(dir, "api.sock"))
+	assert.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			server.serve(conn)
+		}
+	}()
+
+	client, err := net.Dial("unix", filepath.Join(dir, "api.sock"))
+	assert.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte(`{"method": "watch"}` + "\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, client.(*net.UnixConn).CloseWrite())
+
+	r := bufio.NewReader(client)
+	event := &apiEvent{}
+	line, err := r.ReadBytes('\n')
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(line, event))
+	assert.Equal(t, "added", event.Event)
+
+	assert.NoError(t, store.remove("abc"))
+	assert.NoError(t, server.poll())
+	line, err = r.ReadBytes('\n')
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(line, event))
+	assert.Equal(t, "removed", event.Event)
+}
@@ -0,0 +1,118 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+)
+
+// Audit events.
+const (
+	auditAdd = "add"
+	auditDel = "del"
+)
+
+// A line of the audit log.
+type auditRecord struct {
+	Time        time.Time `json:"time"`
+	Event       string    `json:"event"`
+	ContainerID string    `json:"containerID"`
+	Namespace   string    `json:"namespace,omitempty"`
+	Pod         string    `json:"pod,omitempty"`
+	networkMetadata
+	DelegateType string   `json:"delegateType,omitempty"`
+	IPs          []string `json:"ips,omitempty"`
+	// When the attachment was created, for DEL events.
+	Created *time.Time `json:"created,omitempty"`
+	// The pod interface's counters just before it was removed, for
+	// DEL events.
+	Stats *interfaceStats `json:"stats,omitempty"`
+	// How the config was picked from the sources defining the pod's
+	// namespace, if there were several, for ADD events.
+	Duplicate *selector.DuplicateResolution `json:"duplicate,omitempty"`
+}
+
+// Return the audit record for an event on an attachment.
+func newAuditRecord(event string, att *attachment) *auditRecord {
+	r := &auditRecord{
+		Time:            time.Now().UTC(),
+		Event:           event,
+		ContainerID:     att.ContainerID,
+		Namespace:       att.Namespace,
+		Pod:             att.Pod,
+		networkMetadata: att.networkMetadata,
+		DelegateType:    att.DelegateType,
+	}
+
+	if att.Result != nil {
+		if att.Result.IP4 != nil {
+			r.IPs = append(r.IPs, att.Result.IP4.IP.String())
+		}
+		if att.Result.IP6 != nil {
+			r.IPs = append(r.IPs, att.Result.IP6.IP.String())
+		}
+	}
+	r.IPs = append(r.IPs, att.AdditionalIPs...)
+
+	if event == auditDel && !att.Created.IsZero() {
+		created := att.Created
+		r.Created = &created
+	}
+
+	return r
+}
+
+// Return the audit record for the ADD of an attachment given the
+// selected config.
+func newAddAuditRecord(sel *selection, att *attachment) *auditRecord {
+	r := newAuditRecord(auditAdd, att)
+	r.Duplicate = sel.Duplicate
+	return r
+}
+
+// Append a record to the audit log at path.  Each record is written
+// with a single append, so concurrent plugin invocations do not
+// interleave.
+func writeAudit(path string, r *auditRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("Failed to create audit log directory: %v", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to open audit log: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("Failed to write audit log: %v", err)
+	}
+
+	return f.Sync()
+}
@@ -0,0 +1,75 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// Append one JSON line per event.
+func TestWriteAudit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	att := &attachment{
+		ContainerID:     "abc",
+		Namespace:       "isolated",
+		Pod:             "web-1",
+		DelegateType:    "bridge",
+		networkMetadata: networkMetadata{Network: "isolated", Rule: "isolated"},
+		Result: &types.Result{
+			IP4: &types.IPConfig{IP: net.IPNet{IP: net.IPv4(10, 1, 0, 5), Mask: net.CIDRMask(16, 32)}},
+		},
+		Created: time.Date(2016, 8, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	path := filepath.Join(dir, "audit", "audit.jsonl")
+	assert.NoError(t, writeAudit(path, newAuditRecord(auditAdd, att)))
+	assert.NoError(t, writeAudit(path, newAuditRecord(auditDel, att)))
+
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		record := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+
+	assert.Len(t, records, 2)
+	assert.Equal(t, "add", records[0]["event"])
+	assert.Equal(t, "isolated", records[0]["network"])
+	assert.Equal(t, []interface{}{"10.1.0.5/16"}, records[0]["ips"])
+	assert.Nil(t, records[0]["created"])
+	assert.Equal(t, "del", records[1]["event"])
+	assert.Equal(t, "2016-08-01T00:00:00Z", records[1]["created"])
+}
@@ -0,0 +1,82 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Minimum token bucket size in bytes; large enough for one full frame.
+const minBurst = 1600
+
+// Rate limits for a pod's traffic.  Rates and bursts are in bits, to
+// match the CNI bandwidth plugin.  A zero rate means unlimited.
+type bandwidthConfig struct {
+	IngressRate  uint64 `json:"ingressRate"`
+	IngressBurst uint64 `json:"ingressBurst"`
+	EgressRate   uint64 `json:"egressRate"`
+	EgressBurst  uint64 `json:"egressBurst"`
+}
+
+// Parse the "bandwidth" block of a network config.
+func parseBandwidth(netconf map[string]interface{}) (*bandwidthConfig, error) {
+	bw := &bandwidthConfig{}
+	if ok, err := decodeNetConfKey(netconf, "bandwidth", bw); !ok || err != nil {
+		return nil, err
+	}
+
+	if bw.IngressRate == 0 && bw.EgressRate == 0 {
+		return nil, errors.New("Bandwidth config sets neither ingressRate nor egressRate.")
+	}
+
+	return bw, nil
+}
+
+// Shape the pod's traffic.  Egress is limited on the pod's interface,
+// and ingress on the host side of its veth pair, so ingress limits
+// require a veth-based delegate such as bridge or ptp.
+func (bw *bandwidthConfig) apply(log *logrus.Entry, netns, ifName string) error {
+	if bw.EgressRate > 0 {
+		err := withNetNS(netns, func() error {
+			return qdiscAddTBF(ifName, bw.EgressRate, bw.EgressBurst)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if bw.IngressRate > 0 {
+		hostIf, err := hostPeer(netns, ifName)
+		if err != nil {
+			return err
+		}
+
+		if err := qdiscAddTBF(hostIf.Name, bw.IngressRate, bw.IngressBurst); err != nil {
+			return err
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		"ingress_rate": bw.IngressRate,
+		"egress_rate":  bw.EgressRate,
+	}).Debug("Applied bandwidth limits.")
+
+	return nil
+}
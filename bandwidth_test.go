@@ -0,0 +1,54 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Parse the bandwidth block.
+func TestParseBandwidth(t *testing.T) {
+	netconf := map[string]interface{}{
+		"bandwidth": map[string]interface{}{"egressRate": 1000000},
+	}
+
+	bw, err := parseBandwidth(netconf)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &bandwidthConfig{EgressRate: 1000000}, bw)
+}
+
+// Error if no rate is set.
+func TestParseBandwidthNoRate(t *testing.T) {
+	netconf := map[string]interface{}{
+		"bandwidth": map[string]interface{}{},
+	}
+
+	_, err := parseBandwidth(netconf)
+
+	assert.Error(t, err)
+}
+
+// Default the burst to 100ms of traffic, but never below one frame.
+func TestTBFBurstBytes(t *testing.T) {
+	assert.Equal(t, uint64(1000000), tbfBurstBytes(80000000, 0))
+	assert.Equal(t, uint64(20000), tbfBurstBytes(80000000, 160000))
+	assert.Equal(t, uint64(1600), tbfBurstBytes(8000, 0))
+}
@@ -0,0 +1,131 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// IFF_PROMISC, in a device's sysfs flags.
+const iffPromisc = 0x100
+
+// The bridge delegate's "hairpinMode" and "promiscMode", which a
+// namespace's config can turn on where service traffic hairpinning
+// back to the pod breaks.  They are passed to the delegate as they
+// are, but older bridge plugins ignore them, so kube-namespace checks
+// after ADD that they took effect, and sets them itself if not.
+type bridgeModes struct {
+	hairpin bool
+	promisc bool
+}
+
+// Parse the "hairpinMode" and "promiscMode" of a network config.
+// Returns nil if neither is on.
+func parseBridgeModes(netconf map[string]interface{}) (*bridgeModes, error) {
+	m := &bridgeModes{}
+	if _, err := decodeNetConfKey(netconf, "hairpinMode", &m.hairpin); err != nil {
+		return nil, err
+	}
+	if _, err := decodeNetConfKey(netconf, "promiscMode", &m.promisc); err != nil {
+		return nil, err
+	}
+	if !m.hairpin && !m.promisc {
+		return nil, nil
+	}
+
+	if netconf["type"] != "bridge" {
+		return nil, errors.New("hairpinMode and promiscMode need the bridge delegate.")
+	}
+
+	return m, nil
+}
+
+// Make sure the modes are set for a pod whose container end is ifName.
+func (m *bridgeModes) apply(log *logrus.Entry, netconf map[string]interface{}, netns, ifName string) error {
+	if m.hairpin {
+		hostIf, err := hostPeer(netns, ifName)
+		if err != nil {
+			return err
+		}
+		if err := ensureHairpin(log, hostIf.Name); err != nil {
+			return err
+		}
+	}
+
+	if m.promisc {
+		if err := ensurePromisc(log, bridgeName(netconf)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Turn on hairpin mode on a bridge port, unless it is on.
+func ensureHairpin(log *logrus.Entry, port string) error {
+	path := filepath.Join(sysClassNet, port, "brport", "hairpin_mode")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read hairpin mode of %q: %v", port, err)
+	}
+	if strings.TrimSpace(string(data)) == "1" {
+		return nil
+	}
+
+	if err := ioutil.WriteFile(path, []byte("1"), 0644); err != nil {
+		return fmt.Errorf("Failed to set hairpin mode of %q: %v", port, err)
+	}
+
+	log.WithField("interface", port).Info("Delegate did not set hairpin mode; set it.")
+	return nil
+}
+
+// Put a bridge in promiscuous mode, unless it is.
+func ensurePromisc(log *logrus.Entry, bridge string) error {
+	path := filepath.Join(sysClassNet, bridge, "flags")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read flags of %q: %v", bridge, err)
+	}
+
+	flags, err := strconv.ParseUint(strings.TrimSpace(string(data)), 0, 32)
+	if err != nil {
+		return fmt.Errorf("Invalid flags of %q: %v", bridge, err)
+	}
+	if flags&iffPromisc != 0 {
+		return nil
+	}
+
+	flags |= iffPromisc
+	if err := ioutil.WriteFile(path, []byte(fmt.Sprintf("0x%x", flags)), 0644); err != nil {
+		return fmt.Errorf("Failed to set promiscuous mode of %q: %v", bridge, err)
+	}
+
+	log.WithFields(logrus.Fields{
+		"bridge": bridge,
+		"flags":  fmt.Sprintf("0x%x", flags),
+	}).Info("Delegate did not set promiscuous mode; set it.")
+	return nil
+}
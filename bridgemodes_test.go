@@ -0,0 +1,86 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Only parse the modes if one is on, and only for the bridge delegate.
+func TestParseBridgeModes(t *testing.T) {
+	m, err := parseBridgeModes(map[string]interface{}{"type": "ptp", "hairpinMode": false})
+	assert.NoError(t, err)
+	assert.Nil(t, m)
+
+	m, err = parseBridgeModes(map[string]interface{}{"type": "bridge", "hairpinMode": true})
+	assert.NoError(t, err)
+	assert.Equal(t, &bridgeModes{hairpin: true}, m)
+
+	_, err = parseBridgeModes(map[string]interface{}{"type": "macvlan", "promiscMode": true})
+	assert.EqualError(t, err, "hairpinMode and promiscMode need the bridge delegate.")
+
+	_, err = parseBridgeModes(map[string]interface{}{"type": "bridge", "promiscMode": "yes"})
+	assert.Error(t, err)
+}
+
+// Set hairpin and promiscuous mode where the delegate did not.
+func TestEnsureBridgeModes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-bridgemodes")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sysClassNet = dir
+	defer func() { sysClassNet = "/sys/class/net" }()
+
+	files := map[string]string{
+		filepath.Join(dir, "veth1", "brport", "hairpin_mode"): "0\n",
+		filepath.Join(dir, "veth2", "brport", "hairpin_mode"): "1\n",
+		filepath.Join(dir, "cni0", "flags"):                   "0x1003\n",
+		filepath.Join(dir, "cni1", "flags"):                   "0x1103\n",
+	}
+	for path, value := range files {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(value), 0644))
+	}
+
+	for _, port := range []string{"veth1", "veth2"} {
+		assert.NoError(t, ensureHairpin(log, port))
+	}
+	for _, bridge := range []string{"cni0", "cni1"} {
+		assert.NoError(t, ensurePromisc(log, bridge))
+	}
+
+	expected := map[string]string{
+		filepath.Join(dir, "veth1", "brport", "hairpin_mode"): "1",
+		filepath.Join(dir, "veth2", "brport", "hairpin_mode"): "1\n",
+		filepath.Join(dir, "cni0", "flags"):                   "0x1103",
+		filepath.Join(dir, "cni1", "flags"):                   "0x1103\n",
+	}
+	for path, value := range expected {
+		data, err := ioutil.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, value, string(data))
+	}
+
+	assert.Error(t, ensureHairpin(log, "veth3"))
+}
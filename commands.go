@@ -0,0 +1,181 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// A subcommand for operating kube-namespace by hand.  CNI runtimes
+// always invoke the plugin without arguments, so these never clash
+// with plugin invocations.
+type command struct {
+	usage string
+	run   func(args []string, stdin io.Reader, stdout io.Writer) error
+}
+
+var commands = map[string]command{
+	"api": {
+		usage: "Serve the attachments on this node to node agents over a unix socket",
+		run:   cmdAPI,
+	},
+	"daemon": {
+		usage: "Serve ADD and DEL for the plugin over a unix socket",
+		run:   cmdDaemon,
+	},
+	"debug-bundle": {
+		usage: "Collect config, state and delegate versions into a tarball for bug reports",
+		run:   cmdDebugBundle,
+	},
+	"etcd-watch": {
+		usage: "Keep the cache of namespaces in etcd current",
+		run:   cmdEtcdWatch,
+	},
+	"gc": {
+		usage: "Tear down attachments whose pods are gone",
+		run:   cmdGC,
+	},
+	"ipam-report": {
+		usage: "Print address usage of host-local networks by namespace",
+		run:   cmdIPAMReport,
+	},
+	"lint": {
+		usage: "Cross-check the config against the namespaces and nodes of the cluster",
+		run:   cmdLint,
+	},
+	"preview": {
+		usage: "Report which namespaces a config change affects",
+		run:   cmdPreview,
+	},
+	"reconcile": {
+		usage: "Repair pod default routes after gateway health changes",
+		run:   cmdReconcile,
+	},
+	"replay": {
+		usage: "Re-run a delegate invocation dumped to debug_dir",
+		run:   cmdReplay,
+	},
+	"resolve": {
+		usage: "Print the delegate config a pod would get",
+		run:   cmdResolve,
+	},
+	"status": {
+		usage: "List the pods attached on this node",
+		run:   cmdStatus,
+	},
+	"verify-drained": {
+		usage: "Check that no pod networking is left on a drained node",
+		run:   cmdVerifyDrained,
+	},
+}
+
+// Run the subcommand named by args[0], returning the exit status.
+func runSubcommand(args []string) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q.\n\nCommands:\n", args[0])
+
+		var names []string
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, commands[name].usage)
+		}
+		return 2
+	}
+
+	if err := cmd.run(args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+
+	return 0
+}
+
+// Read the plugin config from the file named by path, or from stdin
+// if path is empty or "-".
+func readConfig(path string, stdin io.Reader) (*config, error) {
+	var data []byte
+	var err error
+
+	if path == "" || path == "-" {
+		data, err = ioutil.ReadAll(stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read config: %v", err)
+	}
+
+	return parseConfig(data)
+}
+
+// Return CNI_ARGS as Kubernetes would pass them for a pod.
+func kubeArgs(namespace, pod string) string {
+	var args []string
+	if namespace != "" {
+		args = append(args, "K8S_POD_NAMESPACE="+namespace)
+	}
+	if pod != "" {
+		args = append(args, "K8S_POD_NAME="+pod)
+	}
+
+	return strings.Join(args, ";")
+}
+
+// Print the delegate config that would be used for a pod, without
+// touching the network.
+func cmdResolve(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("resolve", flag.ContinueOnError)
+	namespace := flags.String("namespace", "", "pod namespace")
+	pod := flags.String("pod", "", "pod name")
+	configPath := flags.String("config", "", "plugin config file (default stdin)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := readConfig(*configPath, stdin)
+	if err != nil {
+		return err
+	}
+	config.setLogLevel()
+
+	sel, err := config.Select(kubeArgs(*namespace, *pod))
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(delegateNetConf(sel.NetConf), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Selected by rule %q.\n", sel.Rule)
+	_, err = fmt.Fprintf(stdout, "%s\n", data)
+	return err
+}
@@ -0,0 +1,48 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Print the delegate config for a hypothetical pod.
+func TestCmdResolve(t *testing.T) {
+	stdout := &bytes.Buffer{}
+
+	err := cmdResolve([]string{"--namespace", "isolated", "--pod", "web-1"},
+		strings.NewReader(configWithDefault), stdout)
+	assert.NoError(t, err)
+
+	netconf := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(stdout.Bytes(), &netconf))
+	assert.Equal(t, "isolated", netconf["name"])
+}
+
+// Fail if no config would be selected.
+func TestCmdResolveNoConfig(t *testing.T) {
+	err := cmdResolve([]string{"--namespace", "non-existent"},
+		strings.NewReader(configNoDefault), &bytes.Buffer{})
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,119 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// How long to wait for a free delegate slot before failing.
+const delegateSlotTimeout = 60 * time.Second
+
+// How often to poll for a free slot.  Variable for tests.
+var delegateSlotPoll = 100 * time.Millisecond
+
+// The slot name for maxParallelOps, shared by all delegate types.
+const anyDelegateSlot = "any-delegate"
+
+// Each plugin invocation is its own process, so a delegate type's
+// slots are lock files under the state directory, numbered up to its
+// limit.  A slot is held by taking an exclusive flock on its file,
+// which the kernel releases if the process dies.
+
+// Take a slot for invoking the delegate of netconf, waiting if all
+// are in use: one of its type's, if the type has a limit, then one of
+// maxParallelOps node-wide.  Slots are always taken in that order, so
+// that waiting processes cannot deadlock.  Returns a function
+// releasing them.
+func (c *config) delegateSlot(netconf map[string]interface{}) (func(), error) {
+	dir := c.StateDir
+	if dir == "" {
+		dir = defaultStateDir
+	}
+	dir = filepath.Join(dir, "locks")
+
+	releaseType := func() {}
+	delegateType, _ := netconf["type"].(string)
+	if limit := c.DelegateConcurrency[delegateType]; limit > 0 {
+		var err error
+		if releaseType, err = acquireSlot(c.Logger(), dir, delegateType, limit, delegateSlotTimeout); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.MaxParallelOps <= 0 {
+		return releaseType, nil
+	}
+
+	releaseAny, err := acquireSlot(c.Logger(), dir, anyDelegateSlot, c.MaxParallelOps, delegateSlotTimeout)
+	if err != nil {
+		releaseType()
+		return nil, err
+	}
+
+	return func() {
+		releaseAny()
+		releaseType()
+	}, nil
+}
+
+// Lock one of the limit slot files named after name in dir.
+func acquireSlot(log *logrus.Entry, dir, name string, limit int, timeout time.Duration) (func(), error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create lock directory: %v", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for waited := false; ; waited = true {
+		for i := 0; i < limit; i++ {
+			path := filepath.Join(dir, fmt.Sprintf("%s.%d", name, i))
+			f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to open lock file: %v", err)
+			}
+
+			if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err == nil {
+				log.WithFields(logrus.Fields{
+					"delegate": name,
+					"slot":     i,
+				}).Debug("Took delegate slot.")
+
+				return func() { f.Close() }, nil
+			}
+			f.Close()
+		}
+
+		if !waited {
+			log.WithFields(logrus.Fields{
+				"delegate": name,
+				"limit":    limit,
+			}).Info("Waiting for a delegate slot.")
+		}
+
+		if time.Now().After(deadline) {
+			return nil, newError(errCodeDelegateTimeout, "Timed out waiting for one of %d %q delegate slots.", limit, name)
+		}
+		time.Sleep(delegateSlotPoll)
+	}
+}
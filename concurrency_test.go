@@ -0,0 +1,84 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+	"github.com/stretchr/testify/assert"
+)
+
+// Hand out at most limit slots, and reuse released ones.
+func TestAcquireSlot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-locks")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	delegateSlotPoll = time.Millisecond
+	defer func() { delegateSlotPoll = 100 * time.Millisecond }()
+
+	release1, err := acquireSlot(log, dir, "dhcp", 2, 0)
+	assert.NoError(t, err)
+	release2, err := acquireSlot(log, dir, "dhcp", 2, 0)
+	assert.NoError(t, err)
+
+	_, err = acquireSlot(log, dir, "dhcp", 2, 10*time.Millisecond)
+	assert.True(t, selector.IsDelegateTimeout(err))
+
+	release1()
+	release3, err := acquireSlot(log, dir, "dhcp", 2, 0)
+	assert.NoError(t, err)
+
+	release2()
+	release3()
+}
+
+// Limit invocations of all delegate types together with
+// maxParallelOps.
+func TestDelegateSlotMaxParallelOps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-locks")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := &config{StateDir: dir, MaxParallelOps: 1, DelegateConcurrency: map[string]int{"dhcp": 2}}
+
+	release, err := c.delegateSlot(map[string]interface{}{"type": "bridge"})
+	assert.NoError(t, err)
+
+	_, err = acquireSlot(log, filepath.Join(dir, "locks"), anyDelegateSlot, 1, 0)
+	assert.True(t, selector.IsDelegateTimeout(err))
+	release()
+
+	release, err = c.delegateSlot(map[string]interface{}{"type": "dhcp"})
+	assert.NoError(t, err)
+	release()
+}
+
+// Take no slot for delegate types without a limit.
+func TestDelegateSlotUnlimited(t *testing.T) {
+	c := &config{StateDir: "/nonexistent", DelegateConcurrency: map[string]int{"dhcp": 1}}
+
+	release, err := c.delegateSlot(map[string]interface{}{"type": "bridge"})
+	assert.NoError(t, err)
+	release()
+}
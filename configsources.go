@@ -0,0 +1,143 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+)
+
+// The plugin config the runtime passes may be a stub listing the files
+// the real config is kept in, with "configFiles".  The first file that
+// parses is used, and snapshotted to the state directory as the
+// last-known-good config.  If none does, e.g. because a config
+// management run left the primary half-written, the snapshot is used,
+// and failing that the stub itself, which may hold a minimal config of
+// its own.
+
+// Keys the runtime sets or injects into the config it passes, which
+// are kept from the stub whichever source is used.
+var runtimeConfigKeys = []string{"cniVersion", "name", "type", "prevResult", "runtimeConfig"}
+
+// The parts of the stub config needed to find the real one.
+type configSources struct {
+	ConfigFiles []string `json:"configFiles"`
+	// The format of the config files, if their names do not tell.
+	ConfigFormat string `json:"configFormat"`
+	StateDir     string `json:"stateDir"`
+}
+
+// Return the path of the last-known-good config snapshot.
+func configSnapshotPath(stateDir string) string {
+	return filepath.Join(newAttachmentStore(stateDir).dir, "config-snapshot.json")
+}
+
+// Return the config in data with the runtime's keys from the stub.
+func overlayRuntimeKeys(data []byte, stub map[string]interface{}) ([]byte, error) {
+	conf := map[string]interface{}{}
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, err
+	}
+
+	for _, k := range runtimeConfigKeys {
+		if v, ok := stub[k]; ok {
+			conf[k] = v
+		}
+	}
+	delete(conf, "configFiles")
+
+	return json.Marshal(conf)
+}
+
+// Save data as the last-known-good config, unless it already is.  The
+// file is written atomically, so a crash cannot leave a partial
+// snapshot.
+func saveConfigSnapshot(path string, data []byte) error {
+	if current, err := ioutil.ReadFile(path); err == nil && bytes.Equal(current, data) {
+		return nil
+	}
+
+	return writeFileAtomic(path, data)
+}
+
+// Parse the plugin config passed by the runtime with parse, trying its
+// configFiles, the last-known-good snapshot and the stub itself in
+// turn.
+func parseConfigSources(data []byte, parse func([]byte) (*config, error)) (*config, error) {
+	sources := configSources{}
+	stub := map[string]interface{}{}
+	if json.Unmarshal(data, &sources) != nil || json.Unmarshal(data, &stub) != nil || len(sources.ConfigFiles) == 0 {
+		return parse(data)
+	}
+
+	if err := selector.CheckFormat(sources.ConfigFormat); err != nil {
+		return nil, err
+	}
+
+	snapshot := configSnapshotPath(sources.StateDir)
+
+	for _, path := range sources.ConfigFiles {
+		fileData, err := ioutil.ReadFile(path)
+		if err == nil {
+			fileData, err = selector.ToJSON(path, sources.ConfigFormat, fileData)
+		}
+		if err == nil {
+			var config *config
+			if config, err = parseConfigSource(fileData, stub, parse); err == nil {
+				if err := saveConfigSnapshot(snapshot, fileData); err != nil {
+					log.WithField("error", err).Warn("Failed to snapshot config.")
+				}
+				return config, nil
+			}
+		}
+
+		log.WithFields(logrus.Fields{
+			"file":  path,
+			"error": err,
+		}).Warn("Config file unusable. Trying the next source.")
+	}
+
+	if snapshotData, err := ioutil.ReadFile(snapshot); err == nil {
+		config, err := parseConfigSource(snapshotData, stub, parse)
+		if err == nil {
+			log.WithField("file", snapshot).Warn("Using last-known-good config.")
+			return config, nil
+		}
+		log.WithField("error", err).Warn("Last-known-good config unusable.")
+	}
+
+	log.Warn("No config file usable. Using the config passed by the runtime.")
+	return parse(data)
+}
+
+// Parse a config source, with the runtime's keys from the stub.
+func parseConfigSource(data []byte, stub map[string]interface{}, parse func([]byte) (*config, error)) (*config, error) {
+	merged, err := overlayRuntimeKeys(data, stub)
+	if err != nil {
+		// Not JSON; let parse report it.
+		return parse(data)
+	}
+
+	return parse(merged)
+}
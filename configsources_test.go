@@ -0,0 +1,112 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Fall back from the primary config file to the backup, the
+// last-known-good snapshot and the stub, in turn.
+func TestParseConfigSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-sources")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	primary := filepath.Join(dir, "primary.json")
+	backup := filepath.Join(dir, "backup.json")
+	stub := []byte(fmt.Sprintf(`{
+	  "cniVersion": "0.3.1",
+	  "name": "kube-namespace",
+	  "type": "kube-namespace",
+	  "stateDir": %q,
+	  "configFiles": [%q, %q],
+	  "default": {"name": "stub", "type": "bridge"}
+	}`, dir, primary, backup))
+
+	defaultNetwork := func() string {
+		config, err := parseConfig(stub)
+		if !assert.NoError(t, err) {
+			return ""
+		}
+		assert.Equal(t, "0.3.1", config.CNIVersion)
+		return fmt.Sprint(config.Default["name"])
+	}
+
+	// Nothing but the stub.
+	assert.Equal(t, "stub", defaultNetwork())
+
+	assert.NoError(t, ioutil.WriteFile(backup, []byte(`{"default": {"name": "backup", "type": "bridge"}}`), 0644))
+	assert.Equal(t, "backup", defaultNetwork())
+
+	assert.NoError(t, ioutil.WriteFile(primary, []byte(`{"default": {"name": "primary", "type": "bridge"}}`), 0644))
+	assert.Equal(t, "primary", defaultNetwork())
+
+	// A corrupt primary falls back to the backup, and without that to
+	// the snapshot of the last file used.
+	assert.NoError(t, ioutil.WriteFile(primary, []byte(`{"default": `), 0644))
+	assert.Equal(t, "backup", defaultNetwork())
+
+	assert.NoError(t, os.Remove(backup))
+	assert.Equal(t, "backup", defaultNetwork())
+}
+
+// Read config files kept as YAML or TOML, and snapshot them as JSON.
+func TestParseConfigSourcesFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-sources")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for name, data := range map[string]string{
+		"config.yaml": "default:\n  name: yaml\n  type: bridge\n",
+		"config.toml": "[default]\nname = \"toml\"\ntype = \"bridge\"\n",
+	} {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, ioutil.WriteFile(path, []byte(data), 0644))
+
+		config, err := parseConfig([]byte(fmt.Sprintf(`{
+		  "cniVersion": "0.3.1",
+		  "name": "kube-namespace",
+		  "type": "kube-namespace",
+		  "stateDir": %q,
+		  "configFiles": [%q]
+		}`, dir, path)))
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, "0.3.1", config.CNIVersion, name)
+			assert.Equal(t, strings.TrimPrefix(filepath.Ext(name), "."), config.Default["name"], name)
+		}
+
+		snapshot, err := ioutil.ReadFile(configSnapshotPath(dir))
+		assert.NoError(t, err)
+		assert.Equal(t, byte('{'), snapshot[0], name)
+	}
+}
+
+// Leave configs without configFiles alone.
+func TestParseConfigWithoutSources(t *testing.T) {
+	config, err := parseConfig([]byte(configWithDefault))
+	assert.NoError(t, err)
+	assert.Equal(t, "default-bridge", config.Default["name"])
+}
@@ -0,0 +1,51 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+// Return the network config to pass to the delegate for a selection.
+// In a plugin chain, the delegate takes kube-namespace's place, so it
+// is given the previous plugin's result, and the chain's version if
+// its own config does not set one.  hostPort mappings are passed on
+// if the network config hands them to the delegate, and kube-namespace
+// is made the IPAM plugin if it keeps the leases.
+func (c *config) delegateNetConf(sel *selection, options *netOptions) map[string]interface{} {
+	netconf := delegateNetConf(sel.NetConf)
+
+	if options.hostPorts == hostPortsDelegate && len(c.RuntimeConfig.PortMappings) > 0 {
+		netconf["runtimeConfig"] = runtimeConfig{PortMappings: c.RuntimeConfig.PortMappings}
+	}
+
+	if options.ipamStore == ipamStoreDB {
+		ipam, _ := netconf["ipam"].(map[string]interface{})
+		netconf["ipam"] = c.ipamStoreConf(ipam)
+	}
+
+	if options.dhcp != nil {
+		ipam, _ := netconf["ipam"].(map[string]interface{})
+		netconf["ipam"] = options.dhcp.ipamConf(ipam)
+	}
+
+	if c.PrevResult != nil {
+		netconf["prevResult"] = c.PrevResult
+		if _, ok := netconf["cniVersion"]; !ok && c.CNIVersion != "" {
+			netconf["cniVersion"] = c.CNIVersion
+		}
+	}
+
+	return netconf
+}
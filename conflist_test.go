@@ -0,0 +1,52 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Pass the previous plugin's result on to the delegate.
+func TestDelegateNetConfPrevResult(t *testing.T) {
+	config, err := parseConfig([]byte(`{
+	  "cniVersion": "0.3.1",
+	  "prevResult": {"ips": [{"version": "4", "address": "10.1.0.5/16"}]},
+	  "namespaces": {
+	    "isolated": {"name": "isolated", "type": "bridge", "frozen": false}
+	  }
+	}`))
+	assert.NoError(t, err)
+
+	sel, err := config.Select("K8S_POD_NAMESPACE=isolated")
+	assert.NoError(t, err)
+
+	options, err := parseNetOptions(sel.NetConf)
+	assert.NoError(t, err)
+
+	netconf := config.delegateNetConf(sel, options)
+	assert.Equal(t, "0.3.1", netconf["cniVersion"])
+	assert.Equal(t, config.PrevResult, netconf["prevResult"])
+	assert.NotContains(t, netconf, "frozen")
+
+	config.PrevResult = nil
+	netconf = config.delegateNetConf(sel, options)
+	assert.NotContains(t, netconf, "prevResult")
+	assert.NotContains(t, netconf, "cniVersion")
+}
@@ -0,0 +1,365 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build integration && linux
+// +build integration,linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+	"github.com/stretchr/testify/assert"
+)
+
+// The conformance tests run kube-namespace against real delegate
+// plugins, in network namespaces of their own, and check what ends up
+// in the pods' namespaces.  They need root and the delegates in
+// KUBE_NAMESPACE_CNI_PATH; "make integration" builds the vendored ones
+// and runs them.
+
+// The host side interface macvlan pods hang off: one end of a veth
+// pair, as dummy interfaces are not always available.
+const conformanceMaster = "knmaster0"
+
+const conformanceConfig = `{
+  "name": "kube-namespace",
+  "type": "kube-namespace",
+  "stateDir": %q,
+  "namespaces": {
+    "bridged": {
+      "name": "kn-conformance-bridge",
+      "type": "bridge",
+      "bridge": "knbr0",
+      "isGateway": true,
+      "ipam": {"type": "host-local", "subnet": "10.250.1.0/24", "routes": [{"dst": "0.0.0.0/0"}]}
+    },
+    "routed": {
+      "name": "kn-conformance-ptp",
+      "type": "ptp",
+      "ipam": {"type": "host-local", "subnet": "10.250.2.0/24", "routes": [{"dst": "0.0.0.0/0"}]}
+    },
+    "scratch": {"name": "kn-conformance-networkless", "networkless": true},
+    "named": {
+      "name": "kn-conformance-named",
+      "type": "ptp",
+      "interfaceNames": {"container": "net0", "hostPrefix": "kn"},
+      "ipam": {"type": "host-local", "subnet": "10.250.4.0/24"}
+    },
+    "flat": {
+      "name": "kn-conformance-macvlan",
+      "type": "macvlan",
+      "master": "` + conformanceMaster + `",
+      "ipam": {"type": "host-local", "subnet": "10.250.3.0/24", "gateway": "10.250.3.1", "routes": [{"dst": "0.0.0.0/0"}]}
+    }
+  }
+}`
+
+// A pod to attach, and what its attachment must look like.
+type conformanceCase struct {
+	namespace string
+	network   string
+	subnet    string
+}
+
+var conformanceCases = []conformanceCase{
+	{namespace: "bridged", network: "kn-conformance-bridge", subnet: "10.250.1.0/24"},
+	{namespace: "routed", network: "kn-conformance-ptp", subnet: "10.250.2.0/24"},
+	{namespace: "flat", network: "kn-conformance-macvlan", subnet: "10.250.3.0/24"},
+}
+
+// Return where to find the delegates, skipping the test if they or
+// root are missing.
+func conformanceCNIPath(t *testing.T) string {
+	if os.Geteuid() != 0 {
+		t.Skip("Conformance tests need root.")
+	}
+
+	cniPath := os.Getenv("KUBE_NAMESPACE_CNI_PATH")
+	if cniPath == "" {
+		t.Skip("KUBE_NAMESPACE_CNI_PATH not set.")
+	}
+
+	return cniPath
+}
+
+// Return CNI_ARGS for a pod in namespace.  Like the kubelet, allow
+// delegates to ignore the Kubernetes ones.
+func conformanceArgs(namespace string) string {
+	return "IgnoreUnknown=1;" + kubeArgs(namespace, "pod")
+}
+
+// Return the environment for running delegates on behalf of a pod.
+func conformanceEnv(cniPath, command string, args *skel.CmdArgs) *selector.DelegateEnv {
+	return &selector.DelegateEnv{
+		CNIPath: cniPath,
+		Args: &invoke.Args{
+			Command:       command,
+			ContainerID:   args.ContainerID,
+			NetNS:         args.Netns,
+			PluginArgsStr: args.Args,
+			IfName:        args.IfName,
+			Path:          cniPath,
+		},
+	}
+}
+
+// Return the output of an ip command run in a network namespace.
+func ipIn(netns ns.NetNS, args ...string) (string, error) {
+	var out string
+	err := netns.Do(func(ns.NetNS) error {
+		var err error
+		out, err = runCommand("ip", args...)
+		return err
+	})
+
+	return out, err
+}
+
+// Check that the pod's interface has the result's address and a
+// default route.
+func checkAttached(t *testing.T, podNS ns.NetNS, ifName string, result *types.Result, c conformanceCase) {
+	_, subnet, _ := net.ParseCIDR(c.subnet)
+	if !assert.NotNil(t, result.IP4, "%s: no IPv4 address", c.namespace) {
+		return
+	}
+	assert.True(t, subnet.Contains(result.IP4.IP.IP), "%s: %s not in %s", c.namespace, result.IP4.IP, c.subnet)
+
+	addrs, err := ipIn(podNS, "-4", "-o", "addr", "show", "dev", ifName)
+	assert.NoError(t, err, c.namespace)
+	assert.Contains(t, addrs, result.IP4.IP.String(), c.namespace)
+
+	routes, err := ipIn(podNS, "-4", "route", "show", "default")
+	assert.NoError(t, err, c.namespace)
+	assert.Contains(t, routes, "default via", c.namespace)
+}
+
+// Check that nothing of the pod's attachment is left.
+func checkDetached(t *testing.T, podNS ns.NetNS, ifName string, result *types.Result, c conformanceCase, store *attachmentStore, containerID string) {
+	_, err := ipIn(podNS, "link", "show", "dev", ifName)
+	assert.Error(t, err, "%s: %s still exists", c.namespace, ifName)
+
+	att, err := store.load(containerID)
+	assert.NoError(t, err, c.namespace)
+	assert.Nil(t, att, c.namespace)
+
+	if result.IP4 != nil {
+		lease := filepath.Join("/var/lib/cni/networks", c.network, result.IP4.IP.IP.String())
+		_, err = os.Stat(lease)
+		assert.True(t, os.IsNotExist(err), "%s: lease %s not released", c.namespace, lease)
+	}
+}
+
+// Set up a networkless pod, which gets only a loopback interface.
+func TestConformanceNetworkless(t *testing.T) {
+	conformanceCNIPath(t)
+
+	stateDir, err := ioutil.TempDir("", "kube-namespace-conformance")
+	assert.NoError(t, err)
+	defer os.RemoveAll(stateDir)
+
+	config, err := parseConfig([]byte(fmt.Sprintf(conformanceConfig, stateDir)))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	podNS, err := ns.NewNS()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer podNS.Close()
+
+	args := &skel.CmdArgs{ContainerID: "conformance-scratch", Netns: podNS.Path(), IfName: "eth0", Args: conformanceArgs("scratch")}
+	assert.NoError(t, addNetwork(config, args, conformanceEnv("", "ADD", args), &bytes.Buffer{}))
+
+	links, err := ipIn(podNS, "-o", "link", "show")
+	assert.NoError(t, err)
+	assert.Contains(t, links, "lo: <LOOPBACK,UP")
+	assert.NotContains(t, links, "eth0")
+
+	assert.NoError(t, delNetwork(config, args, conformanceEnv("", "DEL", args)))
+}
+
+// Name the pod's interfaces as configured.
+func TestConformanceInterfaceNames(t *testing.T) {
+	cniPath := conformanceCNIPath(t)
+
+	stateDir, err := ioutil.TempDir("", "kube-namespace-conformance")
+	assert.NoError(t, err)
+	defer os.RemoveAll(stateDir)
+	defer os.RemoveAll("/var/lib/cni/networks/kn-conformance-named")
+
+	config, err := parseConfig([]byte(fmt.Sprintf(conformanceConfig, stateDir)))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	hostNS, err := ns.NewNS()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer hostNS.Close()
+
+	podNS, err := ns.NewNS()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer podNS.Close()
+
+	args := &skel.CmdArgs{ContainerID: "conformance-named", Netns: podNS.Path(), IfName: "eth0", Args: conformanceArgs("named")}
+	err = hostNS.Do(func(ns.NetNS) error {
+		return addNetwork(config, args, conformanceEnv(cniPath, "ADD", args), &bytes.Buffer{})
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	links, err := ipIn(podNS, "-o", "link", "show")
+	assert.NoError(t, err)
+	assert.Contains(t, links, " net0@")
+	assert.NotContains(t, links, "eth0")
+
+	hostName := hostIfName("kn", "named", "pod", 0)
+	att, err := newAttachmentStore(stateDir).load(args.ContainerID)
+	if assert.NoError(t, err) && assert.NotNil(t, att) {
+		assert.Equal(t, hostName, att.HostInterface)
+	}
+	hostLinks, err := ipIn(hostNS, "-o", "link", "show")
+	assert.NoError(t, err)
+	assert.Contains(t, hostLinks, " "+hostName+"@")
+
+	err = hostNS.Do(func(ns.NetNS) error {
+		return delNetwork(config, args, conformanceEnv(cniPath, "DEL", args))
+	})
+	assert.NoError(t, err)
+}
+
+// Attach and detach pods of several namespaces, each with its own
+// delegate, and check their interfaces, addresses and routes.
+func TestConformance(t *testing.T) {
+	cniPath := conformanceCNIPath(t)
+
+	stateDir, err := ioutil.TempDir("", "kube-namespace-conformance")
+	assert.NoError(t, err)
+	defer os.RemoveAll(stateDir)
+	for _, c := range conformanceCases {
+		defer os.RemoveAll(filepath.Join("/var/lib/cni/networks", c.network))
+	}
+
+	config, err := parseConfig([]byte(fmt.Sprintf(conformanceConfig, stateDir)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	store := newAttachmentStore(stateDir)
+
+	// Stand in for the host, so that bridges and routes are not left
+	// behind on the real one.
+	hostNS, err := ns.NewNS()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer hostNS.Close()
+
+	err = hostNS.Do(func(ns.NetNS) error {
+		if _, err := runCommand("ip", "link", "add", conformanceMaster, "type", "veth", "peer", "name", "knmaster1"); err != nil {
+			return err
+		}
+		_, err := runCommand("ip", "link", "set", conformanceMaster, "up")
+		return err
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var podNSes []ns.NetNS
+	var results []*types.Result
+	defer func() {
+		for _, podNS := range podNSes {
+			podNS.Close()
+		}
+	}()
+
+	for i, c := range conformanceCases {
+		podNS, err := ns.NewNS()
+		if !assert.NoError(t, err) {
+			return
+		}
+		podNSes = append(podNSes, podNS)
+
+		args := &skel.CmdArgs{
+			ContainerID: fmt.Sprintf("conformance-%d", i),
+			Netns:       podNS.Path(),
+			IfName:      "eth0",
+			Args:        conformanceArgs(c.namespace),
+		}
+
+		out := &bytes.Buffer{}
+		err = hostNS.Do(func(ns.NetNS) error {
+			return addNetwork(config, args, conformanceEnv(cniPath, "ADD", args), out)
+		})
+		if !assert.NoError(t, err, c.namespace) {
+			return
+		}
+
+		result := &types.Result{}
+		assert.NoError(t, json.Unmarshal(out.Bytes(), result), c.namespace)
+		results = append(results, result)
+
+		checkAttached(t, podNS, args.IfName, result, c)
+
+		att, err := store.load(args.ContainerID)
+		assert.NoError(t, err, c.namespace)
+		if assert.NotNil(t, att, c.namespace) {
+			assert.Equal(t, c.namespace, att.Namespace)
+		}
+	}
+
+	// Each namespace's pod got its own network.
+	seen := map[string]bool{}
+	for _, result := range results {
+		if result.IP4 != nil {
+			subnet := result.IP4.IP.IP.Mask(result.IP4.IP.Mask).String()
+			assert.False(t, seen[subnet], "two namespaces in %s", subnet)
+			seen[subnet] = true
+		}
+	}
+
+	for i, c := range conformanceCases {
+		args := &skel.CmdArgs{
+			ContainerID: fmt.Sprintf("conformance-%d", i),
+			Netns:       podNSes[i].Path(),
+			IfName:      "eth0",
+			Args:        conformanceArgs(c.namespace),
+		}
+
+		err := hostNS.Do(func(ns.NetNS) error {
+			return delNetwork(config, args, conformanceEnv(cniPath, "DEL", args))
+		})
+		assert.NoError(t, err, c.namespace)
+
+		checkDetached(t, podNSes[i], args.IfName, results[i], c, store, args.ContainerID)
+	}
+}
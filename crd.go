@@ -0,0 +1,260 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	namespaceNetworksPath   = "/apis/kube-namespace.coreos.com/v1/namespacenetworks"
+	defaultCRDCacheTTL      = 60
+	defaultCRDCacheFileName = "namespacenetworks.json"
+)
+
+// Reading namespace configs from NamespaceNetwork custom resources,
+// set by the top-level "namespaceNetworks" block.  A NamespaceNetwork
+// in a namespace takes the place of the namespace's entry in the
+// plugin config; namespaces without one keep their static config.
+type crdConfig struct {
+	Enabled bool `json:"enabled"`
+	// Where to cache the resources, so that most invocations do not
+	// query the API server, and ones that cannot reach it still use
+	// the last known resources.  Defaults to a file in stateDir.
+	CacheFile       string `json:"cacheFile"`
+	CacheTTLSeconds int    `json:"cacheTTLSeconds"`
+}
+
+// A NamespaceNetwork resource.  Its spec gives either a delegate
+// config, or the name of a network config in the plugin config.
+type namespaceNetwork struct {
+	Metadata kubeObjectMeta `json:"metadata"`
+	Spec     struct {
+		Config  map[string]interface{} `json:"config,omitempty"`
+		Network string                 `json:"network,omitempty"`
+	} `json:"spec"`
+}
+
+// The cached list of resources.
+type crdCache struct {
+	Fetched time.Time          `json:"fetched"`
+	Items   []namespaceNetwork `json:"items"`
+}
+
+func (c *config) crdCacheFile() string {
+	if c.NamespaceNetworks.CacheFile != "" {
+		return c.NamespaceNetworks.CacheFile
+	}
+
+	return filepath.Join(newAttachmentStore(c.StateDir).dir, defaultCRDCacheFileName)
+}
+
+// Read the cache, returning nil if there is none.
+func readCRDCache(path string) *crdCache {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	cache := &crdCache{}
+	if err := json.Unmarshal(data, cache); err != nil {
+		log.WithField("error", err).Warn("Ignoring invalid NamespaceNetwork cache.")
+		return nil
+	}
+
+	return cache
+}
+
+// Write the cache atomically, as concurrent invocations may read it.
+func writeCRDCache(path string, cache *crdCache) error {
+	return writeJSONAtomic(path, cache)
+}
+
+// Write v to path as JSON, replacing the file atomically; see
+// writeFileAtomic.
+func writeJSONAtomic(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, data)
+}
+
+// Write data to path, replacing the file atomically.  The data is
+// synced before the rename, so that after a crash the file holds
+// either the old or the new contents.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Return the NamespaceNetwork resources, from the cache while it is
+// fresh, and from the API server otherwise.  If the API server cannot
+// be reached, a stale cache is used.
+func (c *config) listNamespaceNetworks() ([]namespaceNetwork, error) {
+	ttl := c.NamespaceNetworks.CacheTTLSeconds
+	if ttl <= 0 {
+		ttl = defaultCRDCacheTTL
+	}
+
+	path := c.crdCacheFile()
+	cache := readCRDCache(path)
+	if cache != nil && time.Since(cache.Fetched) < time.Duration(ttl)*time.Second {
+		return cache.Items, nil
+	}
+
+	items, err := c.fetchNamespaceNetworks()
+	if err != nil {
+		if cache == nil {
+			return nil, err
+		}
+
+		c.Logger().WithFields(logrus.Fields{
+			"error":   err,
+			"fetched": cache.Fetched,
+		}).Warn("Failed to list NamespaceNetworks. Using cached ones.")
+		return cache.Items, nil
+	}
+
+	if err := writeCRDCache(path, &crdCache{Fetched: time.Now().UTC(), Items: items}); err != nil {
+		c.Logger().WithField("error", err).Warn("Failed to cache NamespaceNetworks.")
+	}
+
+	return items, nil
+}
+
+func (c *config) fetchNamespaceNetworks() ([]namespaceNetwork, error) {
+	client, err := c.Kubernetes.client()
+	if err != nil {
+		return nil, err
+	}
+
+	list := struct {
+		Items []namespaceNetwork `json:"items"`
+	}{}
+	if err := client.do("GET", namespaceNetworksPath, nil, &list); err != nil {
+		return nil, err
+	}
+
+	return list.Items, nil
+}
+
+// Return the network config in the plugin config with the given name.
+func (c *config) namedNetConf(name string) (map[string]interface{}, bool) {
+	if c.Default["name"] == name {
+		return c.Default, true
+	}
+
+	var rules []string
+	for rule := range c.Namespaces {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+
+	for _, rule := range rules {
+		if c.Namespaces[rule]["name"] == name {
+			return c.Namespaces[rule], true
+		}
+	}
+
+	return nil, false
+}
+
+// Return the namespace configs given by NamespaceNetwork resources.
+// Resources that do not resolve are skipped; of several in one
+// namespace, the first by name is used.
+func (c *config) namespaceNetworkConfigs(items []namespaceNetwork) map[string]map[string]interface{} {
+	sort.Slice(items, func(i, j int) bool { return items[i].Metadata.Name < items[j].Metadata.Name })
+
+	namespaces := map[string]map[string]interface{}{}
+	for _, item := range items {
+		fields := logrus.Fields{
+			"namespace": item.Metadata.Namespace,
+			"name":      item.Metadata.Name,
+		}
+
+		if _, ok := namespaces[item.Metadata.Namespace]; ok {
+			c.Logger().WithFields(fields).Warn("Ignoring extra NamespaceNetwork in namespace.")
+			continue
+		}
+
+		netconf, err := c.resolveNamespaceNetwork(&item)
+		if err != nil {
+			fields["error"] = err
+			c.Logger().WithFields(fields).Warn("Ignoring invalid NamespaceNetwork.")
+			continue
+		}
+
+		namespaces[item.Metadata.Namespace] = netconf
+	}
+
+	return namespaces
+}
+
+func (c *config) resolveNamespaceNetwork(item *namespaceNetwork) (map[string]interface{}, error) {
+	switch {
+	case item.Spec.Config != nil && item.Spec.Network != "":
+		return nil, errors.New("Spec sets both config and network.")
+	case item.Spec.Config != nil:
+		if _, ok := item.Spec.Config["type"].(string); !ok && !selector.Networkless(item.Spec.Config) {
+			return nil, errors.New("Config has no type.")
+		}
+		return item.Spec.Config, nil
+	case item.Spec.Network != "":
+		netconf, ok := c.namedNetConf(item.Spec.Network)
+		if !ok {
+			return nil, fmt.Errorf("Network %q not found.", item.Spec.Network)
+		}
+		return netconf, nil
+	}
+
+	return nil, errors.New("Spec sets neither config nor network.")
+}
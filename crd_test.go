@@ -0,0 +1,136 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const namespaceNetworkList = `{"items": [
+  {"metadata": {"name": "a", "namespace": "isolated"}, "spec": {"config": {"name": "crd", "type": "ptp"}}},
+  {"metadata": {"name": "b", "namespace": "isolated"}, "spec": {"network": "default-bridge"}},
+  {"metadata": {"name": "a", "namespace": "other"}, "spec": {"network": "default-bridge"}},
+  {"metadata": {"name": "a", "namespace": "broken"}, "spec": {"network": "missing"}}
+]}`
+
+// Select configs from NamespaceNetworks, falling back to the static
+// config, and use the cache when the API server is unreachable.
+func TestNamespaceNetworks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-crd")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	requests := 0
+	k, cleanup := fakeKubeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != namespaceNetworksPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, namespaceNetworkList)
+	})
+
+	config, err := parseConfig([]byte(configWithDefault))
+	assert.NoError(t, err)
+	config.Kubernetes = k
+	config.NamespaceNetworks = &crdConfig{Enabled: true, CacheFile: filepath.Join(dir, "cache.json")}
+
+	sel, err := config.selectPod("K8S_POD_NAMESPACE=isolated")
+	assert.NoError(t, err)
+	assert.Equal(t, "ptp", sel.NetConf["type"])
+
+	sel, err = config.selectPod("K8S_POD_NAMESPACE=other")
+	assert.NoError(t, err)
+	assert.Equal(t, "other", sel.Rule)
+	assert.Equal(t, "default-bridge", sel.NetConf["name"])
+
+	sel, err = config.selectPod("K8S_POD_NAMESPACE=broken")
+	assert.NoError(t, err)
+	assert.Equal(t, defaultRule, sel.Rule)
+
+	// The static config is left alone.
+	sel, err = config.Select("K8S_POD_NAMESPACE=isolated")
+	assert.NoError(t, err)
+	assert.Equal(t, "bridge", sel.NetConf["type"])
+
+	// Fresh cache: no request.
+	config.selectPod("K8S_POD_NAMESPACE=isolated")
+	assert.Equal(t, 1, requests)
+
+	// Stale cache and no API server: the cache is still used.
+	cleanup()
+	cache := readCRDCache(config.crdCacheFile())
+	cache.Fetched = cache.Fetched.Add(-time.Hour)
+	assert.NoError(t, writeCRDCache(config.crdCacheFile(), cache))
+
+	sel, err = config.selectPod("K8S_POD_NAMESPACE=isolated")
+	assert.NoError(t, err)
+	assert.Equal(t, "ptp", sel.NetConf["type"])
+}
+
+// Resolve NamespaceNetworks against the static config with the
+// duplicateNamespaces policy, and audit the resolution.
+func TestNamespaceNetworksDuplicates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-crd")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	k, cleanup := fakeKubeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, namespaceNetworkList)
+	})
+	defer cleanup()
+
+	for policy, delegateType := range map[string]string{"last": "ptp", "first": "bridge", "error": ""} {
+		config, err := parseConfig([]byte(strings.Replace(configWithDefault, `"log_level"`, fmt.Sprintf(`"duplicateNamespaces": %q, "log_level"`, policy), 1)))
+		if !assert.NoError(t, err, policy) {
+			continue
+		}
+		config.Kubernetes = k
+		config.NamespaceNetworks = &crdConfig{Enabled: true, CacheFile: filepath.Join(dir, policy+".json")}
+
+		sel, err := config.selectPod("K8S_POD_NAMESPACE=isolated")
+		if delegateType == "" {
+			assert.Error(t, err, policy)
+			continue
+		}
+		if !assert.NoError(t, err, policy) {
+			continue
+		}
+		assert.Equal(t, delegateType, sel.NetConf["type"], policy)
+
+		record := newAddAuditRecord(sel, &attachment{ContainerID: "abc"})
+		if assert.NotNil(t, record.Duplicate, policy) {
+			assert.Equal(t, policy, record.Duplicate.Policy)
+			winner, loser := "NamespaceNetwork", "config"
+			if policy == "first" {
+				winner, loser = loser, winner
+			}
+			assert.Equal(t, winner, record.Duplicate.Winner, policy)
+			assert.Equal(t, []string{loser}, record.Duplicate.Losers, policy)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+)
+
+// The daemon handles ADD and DEL for the plugin binary, which then
+// only has to forward them over a unix socket.  This saves parsing
+// the config and starting a process for every pod sandbox.  Requests
+// are JSON-RPC calls of Daemon.Exec, handled concurrently, each with
+// its own logger.
+
+// An ADD or DEL forwarded by the plugin.  Exported, as net/rpc
+// requires.
+type DaemonRequest struct {
+	// "ADD" or "DEL".
+	Command string
+	Args    skel.CmdArgs
+}
+
+// The daemon's answer: the plugin's output, or its error.
+type DaemonResponse struct {
+	Output []byte
+	Error  *types.Error
+}
+
+// The most parsed configs the daemon keeps.
+const maxCachedConfigs = 64
+
+// The RPC service.
+type daemon struct {
+	mu      sync.Mutex
+	configs map[string]*config
+}
+
+func newDaemon() *daemon {
+	return &daemon{configs: map[string]*config{}}
+}
+
+// Return the parsed config, from the cache if possible.  Configs with
+// a namespacesDir are parsed every time, so that changes to the
+// directory are picked up, as are configs with a nodeLabelsFile, so
+// that label changes select other variants, and configs kept in
+// configFiles, whose stub stays the same when the files change.
+func (d *daemon) config(data []byte) (*config, error) {
+	key := shortHash(string(data))
+
+	d.mu.Lock()
+	c, ok := d.configs[key]
+	d.mu.Unlock()
+	if ok {
+		return c, nil
+	}
+
+	c, err := parseConfig(data)
+	if err != nil || c.NamespacesDir != "" || c.NodeLabelsFile != "" {
+		return c, err
+	}
+
+	sources := configSources{}
+	if json.Unmarshal(data, &sources) == nil && len(sources.ConfigFiles) > 0 {
+		return c, nil
+	}
+
+	d.mu.Lock()
+	if len(d.configs) >= maxCachedConfigs {
+		d.configs = map[string]*config{}
+	}
+	d.configs[key] = c
+	d.mu.Unlock()
+
+	return c, nil
+}
+
+// Handle a forwarded request.  Errors from the plugin logic are
+// returned in the response, so that the shim can pass them on.
+func (d *daemon) Exec(req *DaemonRequest, resp *DaemonResponse) error {
+	args := &req.Args
+	env := &selector.DelegateEnv{
+		CNIPath: args.Path,
+		Args: &invoke.Args{
+			Command:       req.Command,
+			ContainerID:   args.ContainerID,
+			NetNS:         args.Netns,
+			PluginArgsStr: args.Args,
+			IfName:        args.IfName,
+			Path:          args.Path,
+		},
+	}
+
+	start := time.Now()
+	var out bytes.Buffer
+	cached, err := d.config(args.StdinData)
+	if err == nil {
+		// Keep the request's trace and logger off the cached config.
+		config := *cached
+		finish := beginInvocation(&config, log.Logger, req.Command, args, start)
+		env.Log = config.Logger()
+		config.Logger().WithField("command", req.Command).Info("Handling forwarded request.")
+
+		switch req.Command {
+		case "ADD":
+			err = addNetwork(&config, args, env, &out)
+		case "DEL":
+			err = delNetwork(&config, args, env)
+		default:
+			err = fmt.Errorf("Unknown command %q.", req.Command)
+		}
+		finish(err)
+	}
+
+	if err != nil {
+		typed, ok := err.(*types.Error)
+		if !ok {
+			typed = &types.Error{Code: 100, Msg: err.Error()}
+		}
+		resp.Error = typed
+		return nil
+	}
+
+	resp.Output = out.Bytes()
+	return nil
+}
+
+// Forward a plugin invocation to the daemon, if the config names a
+// daemon socket and the daemon is listening.  Returns whether it was
+// forwarded, and the daemon's error.
+func forwardToDaemon(command string, args *skel.CmdArgs, stdout io.Writer) (bool, error) {
+	shim := struct {
+		DaemonSocket string `json:"daemonSocket"`
+	}{}
+	if err := json.Unmarshal(args.StdinData, &shim); err != nil || shim.DaemonSocket == "" {
+		return false, nil
+	}
+
+	client, err := jsonrpc.Dial("unix", shim.DaemonSocket)
+	if err != nil {
+		log.WithField("error", err).Warn("Daemon unavailable. Handling request in-process.")
+		return false, nil
+	}
+	defer client.Close()
+
+	resp := &DaemonResponse{}
+	if err := client.Call("Daemon.Exec", &DaemonRequest{command, *args}, resp); err != nil {
+		return true, fmt.Errorf("Daemon request failed: %v", err)
+	}
+	if resp.Error != nil {
+		return true, resp.Error
+	}
+
+	_, err = stdout.Write(resp.Output)
+	return true, err
+}
+
+// Serve forwarded requests on a unix socket until terminated.
+func cmdDaemon(args []string, _ io.Reader, _ io.Writer) error {
+	flags := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	socket := flags.String("socket", "/run/kube-namespace/daemon.sock", "unix socket to listen on")
+	logLevel := flags.String("log-level", "info", "log level")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	(&config{LogLevel: *logLevel}).setLogLevel()
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("Daemon", newDaemon()); err != nil {
+		return err
+	}
+
+	listener, err := listenUnix(*socket)
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		listener.Close()
+	}()
+
+	log.WithField("socket", *socket).Info("Daemon listening.")
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			// Closing the listener also removes the socket.
+			log.Info("Daemon stopped.")
+			return nil
+		}
+
+		go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+// Listen on a unix socket only root can connect to, replacing any
+// stale socket.
+func listenUnix(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create socket directory: %v", err)
+	}
+	os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to listen on %q: %v", path, err)
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("Failed to restrict %q: %v", path, err)
+	}
+
+	return listener, nil
+}
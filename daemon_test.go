@@ -0,0 +1,210 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Forward a request to the daemon, and pass back its typed error.
+func TestForwardToDaemon(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-daemon")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "daemon.sock")
+	listener, err := listenUnix(socket)
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	server := rpc.NewServer()
+	assert.NoError(t, server.RegisterName("Daemon", newDaemon()))
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+		}
+	}()
+
+	args := &skel.CmdArgs{
+		ContainerID: "abc",
+		Args:        "K8S_POD_NAMESPACE=non-existent",
+		StdinData:   []byte(`{"daemonSocket": "` + socket + `", "namespaces": {}}`),
+	}
+
+	forwarded, err := forwardToDaemon("ADD", args, &bytes.Buffer{})
+	assert.True(t, forwarded)
+	assert.Equal(t, errCodeNoNetworkConfig, err.(*types.Error).Code)
+}
+
+// Handle the request in-process if the daemon is not running.
+func TestForwardToDaemonUnavailable(t *testing.T) {
+	args := &skel.CmdArgs{StdinData: []byte(`{"daemonSocket": "/nonexistent/daemon.sock"}`)}
+
+	forwarded, err := forwardToDaemon("ADD", args, &bytes.Buffer{})
+	assert.False(t, forwarded)
+	assert.NoError(t, err)
+
+	forwarded, _ = forwardToDaemon("ADD", &skel.CmdArgs{StdinData: []byte(`{}`)}, &bytes.Buffer{})
+	assert.False(t, forwarded)
+}
+
+// Cache parsed configs.
+func TestDaemonConfigCache(t *testing.T) {
+	d := newDaemon()
+
+	c1, err := d.config([]byte(configWithDefault))
+	assert.NoError(t, err)
+	c2, err := d.config([]byte(configWithDefault))
+	assert.NoError(t, err)
+
+	assert.True(t, c1 == c2)
+}
+
+// Parse configs kept in configFiles on every request, so that edits to
+// the files, including fixes after a fallback, are picked up.
+func TestDaemonConfigFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-daemon")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "kube-namespace.json")
+	stub := []byte(`{"type": "kube-namespace", "configFiles": ["` + path + `"], "stateDir": "` + dir + `"}`)
+	write := func(data string) {
+		assert.NoError(t, ioutil.WriteFile(path, []byte(data), 0644))
+	}
+
+	d := newDaemon()
+
+	write(`{"default": {"name": "first", "type": "bridge"}}`)
+	c, err := d.config(stub)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "first", c.Default["name"])
+	}
+
+	write(`{"default": {"name": "second", "type": "bridge"}}`)
+	c, err = d.config(stub)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "second", c.Default["name"])
+	}
+
+	// A broken file falls back to the last-known-good config until it
+	// is fixed.
+	write(`{"default": `)
+	c, err = d.config(stub)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "second", c.Default["name"])
+	}
+
+	write(`{"default": {"name": "third", "type": "bridge"}}`)
+	c, err = d.config(stub)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "third", c.Default["name"])
+	}
+}
+
+// Re-read nodeLabelsFile on every request, so that label changes
+// select other variants.
+func TestDaemonNodeLabelsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-daemon")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	labels := filepath.Join(dir, "labels")
+	data := []byte(`{
+	  "nodeLabelsFile": "` + labels + `",
+	  "default": {
+	    "name": "default", "type": "bridge",
+	    "variants": [{"nodeSelectorTerms": [{"matchExpressions": [{"key": "zone", "operator": "In", "values": ["edge"]}]}],
+	                  "config": {"mtu": 1400}}]
+	  }
+	}`)
+
+	d := newDaemon()
+	mtu := func() interface{} {
+		c, err := d.config(data)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		sel, err := c.Select("K8S_POD_NAMESPACE=web")
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		return sel.NetConf["mtu"]
+	}
+
+	assert.NoError(t, ioutil.WriteFile(labels, []byte(`zone="core"`), 0644))
+	assert.Nil(t, mtu())
+
+	assert.NoError(t, ioutil.WriteFile(labels, []byte(`zone="edge"`), 0644))
+	assert.Equal(t, float64(1400), mtu())
+}
+
+// Trace and identify forwarded requests as in-process ones, without
+// touching the cached config.
+func TestDaemonExecInvocation(t *testing.T) {
+	saved := log
+	defer func() { log = saved }()
+	var logged bytes.Buffer
+	log = logrus.NewEntry(&logrus.Logger{
+		Out:       &logged,
+		Formatter: &logrus.TextFormatter{DisableColors: true},
+		Hooks:     make(logrus.LevelHooks),
+		Level:     logrus.InfoLevel,
+	})
+
+	received := make(chan []receivedSpan, 1)
+	server := httptest.NewServer(collector(received))
+	defer server.Close()
+
+	data := []byte(`{"tracing": {"endpoint": "` + server.URL + `"}, "default": {"name": "default", "type": "bridge"}}`)
+	d := newDaemon()
+	resp := &DaemonResponse{}
+	assert.NoError(t, d.Exec(&DaemonRequest{Command: "CHECK", Args: skel.CmdArgs{ContainerID: "abc", StdinData: data}}, resp))
+	assert.NotNil(t, resp.Error)
+
+	spans := <-received
+	if assert.NotEmpty(t, spans) {
+		assert.Equal(t, "CHECK", spans[0].Name)
+	}
+	// The request logs with its own fields, leaving the daemon's logger
+	// alone.
+	assert.Contains(t, logged.String(), "container_id=abc")
+	assert.Contains(t, logged.String(), "request_id=")
+	assert.Empty(t, log.Data)
+
+	cached, err := d.config(data)
+	assert.NoError(t, err)
+	assert.Nil(t, cached.trace)
+}
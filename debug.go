@@ -0,0 +1,198 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+)
+
+// A record of one delegate invocation, written to the debug directory
+// so that it can be inspected and replayed.
+type invocationDump struct {
+	Time        time.Time `json:"time"`
+	Command     string    `json:"command"`
+	ContainerID string    `json:"containerID"`
+	// The CNI_* environment the delegate was run with.
+	Env map[string]string `json:"env"`
+	// The config kube-namespace was invoked with.
+	Stdin json.RawMessage `json:"stdin"`
+	// The config passed to the delegate.
+	Delegate map[string]interface{} `json:"delegate"`
+	Result   *types.Result          `json:"result,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+}
+
+// Write a dump of a delegate invocation to the debug directory, if
+// one is configured.  Failing to do so is only logged.
+func (c *config) dumpInvocation(command string, args *skel.CmdArgs, env *selector.DelegateEnv, delegate map[string]interface{}, result *selector.Result, err error) {
+	if c.DebugDir == "" {
+		return
+	}
+
+	d := &invocationDump{
+		Time:        time.Now().UTC(),
+		Command:     command,
+		ContainerID: args.ContainerID,
+		Env:         map[string]string{},
+		Delegate:    delegate,
+	}
+
+	if json.Valid(args.StdinData) {
+		d.Stdin = args.StdinData
+	}
+	if result != nil {
+		d.Result = result.Result
+	}
+	if err != nil {
+		d.Error = err.Error()
+	}
+
+	if env.Args != nil {
+		for _, kv := range env.Args.AsEnv() {
+			if i := strings.Index(kv, "="); i > 0 && strings.HasPrefix(kv, "CNI_") {
+				d.Env[kv[:i]] = kv[i+1:]
+			}
+		}
+	}
+	d.Env["CNI_PATH"] = env.CNIPath
+
+	if err := d.write(c.DebugDir); err != nil {
+		c.Logger().WithField("error", err).Warn("Failed to write debug dump.")
+	}
+}
+
+// Write the dump to a new file in dir, named after its time.
+func (d *invocationDump) write(dir string) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s-%s.json", d.Time.Format("20060102T150405.000000000Z"),
+		d.Command, shortHash(d.ContainerID))
+	return ioutil.WriteFile(filepath.Join(dir, name), data, 0600)
+}
+
+// Read a dump written by dumpInvocation.
+func readInvocationDump(path string) (*invocationDump, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read dump: %v", err)
+	}
+
+	d := &invocationDump{}
+	if err := json.Unmarshal(data, d); err != nil {
+		return nil, fmt.Errorf("Failed to parse dump %q: %v", path, err)
+	}
+
+	if d.Command != "ADD" && d.Command != "DEL" {
+		return nil, fmt.Errorf("Dump %q has unknown command %q.", path, d.Command)
+	}
+
+	return d, nil
+}
+
+// Return the environment to replay a dump in: this process's, with
+// the recorded CNI_* variables.
+func (d *invocationDump) environ(cniPath string) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "CNI_") {
+			env = append(env, kv)
+		}
+	}
+
+	for k, v := range d.Env {
+		if k == "CNI_PATH" && cniPath != "" {
+			v = cniPath
+		}
+		env = append(env, k+"="+v)
+	}
+
+	return env
+}
+
+// Re-run the delegate invocation recorded in a dump, printing the
+// delegate's output.  This runs the delegate for real, so it is meant
+// for a test node or a throwaway network namespace.
+func cmdReplay(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	cniPath := flags.String("cni-path", "", "directories to look for the delegate in (default the recorded CNI_PATH)")
+	netns := flags.String("netns", "", "network namespace to use instead of the recorded one")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("Usage: replay [flags] <dump>")
+	}
+
+	d, err := readInvocationDump(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *netns != "" {
+		d.Env["CNI_NETNS"] = *netns
+	}
+
+	delegateEnv := &selector.DelegateEnv{CNIPath: d.Env["CNI_PATH"]}
+	if *cniPath != "" {
+		delegateEnv.CNIPath = *cniPath
+	}
+
+	path, err := delegateEnv.FindDelegate(d.Delegate)
+	if err != nil {
+		return err
+	}
+
+	ncBytes, err := json.Marshal(d.Delegate)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Replaying %s of %q for container %q.\n", d.Command, path, d.ContainerID)
+	if d.Error != "" {
+		fmt.Fprintf(os.Stderr, "Recorded error: %s\n", d.Error)
+	}
+
+	raw := &invoke.RawExec{Stderr: os.Stderr}
+	out, err := raw.ExecPlugin(path, ncBytes, d.environ(*cniPath))
+	if err != nil {
+		return err
+	}
+
+	_, err = stdout.Write(out)
+	return err
+}
@@ -0,0 +1,63 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+	"github.com/stretchr/testify/assert"
+)
+
+// Dump an invocation and read it back for replaying.
+func TestDumpInvocation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-debug")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := &config{DebugDir: dir}
+	args := &skel.CmdArgs{ContainerID: "abc", StdinData: []byte(`{"name": "kube-namespace"}`)}
+	env := &selector.DelegateEnv{
+		CNIPath: "/opt/cni/bin",
+		Args:    &invoke.Args{Command: "ADD", ContainerID: "abc", NetNS: "/var/run/netns/abc", IfName: "eth0"},
+	}
+	delegate := map[string]interface{}{"name": "default-bridge", "type": "bridge"}
+
+	config.dumpInvocation("ADD", args, env, delegate, nil, selector.ErrDelegateFailed)
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "*-ADD-*.json"))
+	if !assert.Len(t, paths, 1) {
+		return
+	}
+
+	d, err := readInvocationDump(paths[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", d.ContainerID)
+	assert.Equal(t, delegate, d.Delegate)
+	assert.JSONEq(t, `{"name": "kube-namespace"}`, string(d.Stdin))
+	assert.Equal(t, "/var/run/netns/abc", d.Env["CNI_NETNS"])
+	assert.Equal(t, "/opt/cni/bin", d.Env["CNI_PATH"])
+	assert.NotEmpty(t, d.Error)
+
+	assert.Contains(t, d.environ("/usr/libexec/cni"), "CNI_PATH=/usr/libexec/cni")
+}
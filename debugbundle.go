@@ -0,0 +1,443 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+)
+
+// The directory of the CNI runtime's own state, e.g. host-local
+// leases, which the debug bundle includes too.
+const defaultCNIStateDir = "/var/lib/cni"
+
+// Files larger than this are listed in the bundle but not included.
+const maxBundleFileSize = 1 << 20
+
+// What replaces redacted values.
+const redacted = "REDACTED"
+
+// Substrings of the keys whose values are redacted from the bundle.
+var secretKeyParts = []string{"password", "passwd", "secret", "token", "credential", "authorization", "apikey", "api_key", "privatekey", "private_key", "client-key-data"}
+
+// Return whether the value of a config key may be a secret.  Keys
+// naming a file, path or reference only say where a secret is, so are
+// kept.
+func secretKey(key string) bool {
+	k := strings.ToLower(key)
+	for _, suffix := range []string{"file", "path", "ref"} {
+		if strings.HasSuffix(k, suffix) {
+			return false
+		}
+	}
+
+	for _, part := range secretKeyParts {
+		if strings.Contains(k, part) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Return a copy of a decoded JSON value with secrets redacted.
+func redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for k, value := range v {
+			if secretKey(k) && value != nil {
+				copied[k] = redacted
+			} else {
+				copied[k] = redact(value)
+			}
+		}
+		return copied
+
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, value := range v {
+			copied[i] = redact(value)
+		}
+		return copied
+	}
+
+	return v
+}
+
+// Return JSON data with secrets redacted, or false if it is not JSON.
+func redactJSON(data []byte) ([]byte, bool) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, false
+	}
+
+	redactedData, err := json.MarshalIndent(redact(v), "", "  ")
+	if err != nil {
+		return nil, false
+	}
+
+	return append(redactedData, '\n'), true
+}
+
+// A "key: value" or "KEY=value" line, as in YAML, env and ini files.
+var assignmentLine = regexp.MustCompile(`^(\s*(?:- |export )?["']?[\w.-]+["']?\s*(?::\s+|=\s*)).*\S`)
+
+// Redact the value of every assignment line in a text file, whatever
+// its key, since a kubeconfig or env file need not name its secrets
+// in a way secretKey recognises.  Returns false if data is not text.
+func redactLines(data []byte) ([]byte, bool) {
+	if !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+		return nil, false
+	}
+
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		lines[i] = assignmentLine.ReplaceAll(line, []byte("${1}"+redacted))
+	}
+	return bytes.Join(lines, []byte("\n")), true
+}
+
+// A gzipped tarball being written, with every file under one
+// top-level directory.
+type debugBundle struct {
+	tw   *tar.Writer
+	root string
+	now  time.Time
+	// Problems collecting the bundle, written to errors.txt.
+	errors []string
+}
+
+// Note a problem collecting the bundle.  The bundle is still written,
+// with what could be collected.
+func (b *debugBundle) errorf(format string, args ...interface{}) {
+	b.errors = append(b.errors, fmt.Sprintf(format, args...))
+}
+
+func (b *debugBundle) add(name string, data []byte) error {
+	hdr := &tar.Header{
+		Name:    b.root + "/" + name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: b.now,
+	}
+	if err := b.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	_, err := b.tw.Write(data)
+	return err
+}
+
+func (b *debugBundle) addJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return b.add(name, append(data, '\n'))
+}
+
+// Add the regular files under dir as name, redacting JSON files and
+// the values of key: value and KEY=value lines in other text files.
+// Binary files, and files whose name suggests a key or other secret,
+// are left out.  Directories in skip are left out.
+func (b *debugBundle) addTree(dir, name string, skip ...string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return nil
+			}
+			b.errorf("%s: %v", path, err)
+			return nil
+		}
+
+		for _, s := range skip {
+			if path == filepath.Clean(s) {
+				return filepath.SkipDir
+			}
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		base := filepath.Base(path)
+		if secretKey(base) || strings.HasSuffix(base, ".key") || strings.HasSuffix(base, ".pem") {
+			b.errorf("%s: left out, as it may hold a secret", path)
+			return nil
+		}
+		if info.Size() > maxBundleFileSize {
+			b.errorf("%s: left out, as it is %d bytes", path, info.Size())
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			b.errorf("%s: %v", path, err)
+			return nil
+		}
+		if redactedData, ok := redactJSON(data); ok {
+			data = redactedData
+		} else if redactedData, ok := redactLines(data); ok {
+			data = redactedData
+		} else {
+			b.errorf("%s: left out, as it is not text", path)
+			return nil
+		}
+
+		return b.add(filepath.ToSlash(filepath.Join(name, rel)), data)
+	})
+}
+
+// Return the last n lines of a file.
+func tailLines(path string, n int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxBundleFileSize)
+	for scanner.Scan() {
+		lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	for _, line := range lines {
+		var v interface{}
+		if err := json.Unmarshal(line, &v); err == nil {
+			if redactedLine, err := json.Marshal(redact(v)); err == nil {
+				line = redactedLine
+			}
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes(), nil
+}
+
+// A delegate plugin the config uses, and the CNI versions it reports.
+type delegateVersion struct {
+	Type     string   `json:"type"`
+	Path     string   `json:"path,omitempty"`
+	Versions []string `json:"versions,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Return the versions of the delegate plugins the config uses.
+func delegateVersions(c *config, cniPath string) ([]*delegateVersion, error) {
+	rendering, err := c.render()
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []*delegateVersion
+	for _, binary := range delegateBinaries(rendering) {
+		v := &delegateVersion{Type: binary}
+		versions = append(versions, v)
+
+		if v.Path, err = invoke.FindInPath(binary, filepath.SplitList(cniPath)); err != nil {
+			v.Error = err.Error()
+			continue
+		}
+
+		info, err := invoke.GetVersionInfo(v.Path)
+		if err != nil {
+			v.Error = err.Error()
+			continue
+		}
+		v.Versions = info.SupportedVersions()
+	}
+
+	return versions, nil
+}
+
+// Where debug-bundle collects from.
+type debugBundleSources struct {
+	// The plugin config as read, and the error parsing it, if any.
+	configData []byte
+	config     *config
+	configErr  error
+
+	cniPath     string
+	cniStateDir string
+	auditLines  int
+}
+
+// Write a debug bundle to w.
+func writeDebugBundle(w io.Writer, src *debugBundleSources, now time.Time) error {
+	gz := gzip.NewWriter(w)
+	b := &debugBundle{
+		tw:   tar.NewWriter(gz),
+		root: "kube-namespace-debug-" + now.UTC().Format("20060102T150405Z"),
+		now:  now,
+	}
+
+	hostname, _ := os.Hostname()
+	if err := b.addJSON("info.json", map[string]interface{}{
+		"time":     now.UTC(),
+		"hostname": hostname,
+		"os":       runtime.GOOS,
+		"arch":     runtime.GOARCH,
+		"go":       runtime.Version(),
+		"cniPath":  src.cniPath,
+	}); err != nil {
+		return err
+	}
+
+	if data, ok := redactJSON(src.configData); ok {
+		if err := b.add("config.json", data); err != nil {
+			return err
+		}
+	} else {
+		b.errorf("config: not JSON, left out")
+	}
+	if src.configErr != nil {
+		b.errorf("config: %v", src.configErr)
+	}
+
+	// The state directory is still found in a config that otherwise
+	// fails to parse.
+	var dirs struct {
+		StateDir string `json:"stateDir"`
+	}
+	json.Unmarshal(src.configData, &dirs)
+	stateDir := dirs.StateDir
+	if stateDir == "" {
+		stateDir = defaultStateDir
+	}
+
+	if c := src.config; c != nil {
+
+		if c.AuditLog != "" {
+			if data, err := tailLines(c.AuditLog, src.auditLines); err != nil {
+				b.errorf("audit log: %v", err)
+			} else if err := b.add("audit.log", data); err != nil {
+				return err
+			}
+		}
+
+		if versions, err := delegateVersions(c, src.cniPath); err != nil {
+			b.errorf("delegates: %v", err)
+		} else if err := b.addJSON("delegates.json", versions); err != nil {
+			return err
+		}
+	}
+
+	if err := b.addTree(stateDir, "state"); err != nil {
+		return err
+	}
+	if err := b.addTree(src.cniStateDir, "cni", stateDir); err != nil {
+		return err
+	}
+
+	if len(b.errors) > 0 {
+		if err := b.add("errors.txt", []byte(strings.Join(b.errors, "\n")+"\n")); err != nil {
+			return err
+		}
+	}
+
+	if err := b.tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Collect the config, state and delegate versions into a tarball for
+// attaching to bug reports, with secrets redacted.
+func cmdDebugBundle(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("debug-bundle", flag.ContinueOnError)
+	configPath := flags.String("config", "", "plugin config file (default stdin)")
+	output := flags.String("output", "", "file to write the bundle to, or - for stdout (default kube-namespace-debug-<time>.tar.gz)")
+	flags.StringVar(output, "o", "", "alias for --output")
+	cniPath := flags.String("cni-path", defaultCNIPath, "directories to find delegate plugins in")
+	cniStateDir := flags.String("cni-dir", defaultCNIStateDir, "CNI state directory to include")
+	auditLines := flags.Int("audit-lines", 1000, "how many of the last audit log entries to include")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	src := &debugBundleSources{cniPath: *cniPath, cniStateDir: *cniStateDir, auditLines: *auditLines}
+
+	var err error
+	if *configPath == "" || *configPath == "-" {
+		src.configData, err = ioutil.ReadAll(stdin)
+	} else {
+		src.configData, err = ioutil.ReadFile(*configPath)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to read config: %v", err)
+	}
+	// A config that fails to parse is what many bug reports are
+	// about, so it is still bundled.
+	src.config, src.configErr = parseConfig(src.configData)
+
+	now := time.Now()
+	if *output == "-" {
+		return writeDebugBundle(stdout, src, now)
+	}
+
+	path := *output
+	if path == "" {
+		path = fmt.Sprintf("kube-namespace-debug-%s.tar.gz", now.UTC().Format("20060102T150405Z"))
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := writeDebugBundle(f, src, now); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Wrote %s\n", path)
+	return nil
+}
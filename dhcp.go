@@ -0,0 +1,188 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// The dhcp IPAM plugin only talks to its daemon, "dhcp daemon", which
+// holds and renews the leases.  Without the daemon every ADD fails
+// with a dial error buried in the delegate's, so kube-namespace checks
+// that it is listening first, and with "startDaemon" starts it.
+
+const (
+	// Where the dhcp daemon listens unless told otherwise.
+	defaultDHCPSocket = "/run/cni/dhcp.sock"
+
+	defaultDHCPStartTimeoutMs = 5000
+)
+
+// The "dhcp" block of a network config using the dhcp IPAM plugin.
+type dhcpConfig struct {
+	// The daemon's socket.  Namespaces can have their own daemon;
+	// other sockets than the default are passed to the plugin as
+	// "daemonSocketPath", which needs a recent dhcp plugin.
+	Socket string `json:"socket"`
+	// Start the daemon from CNI_PATH if it is not listening.
+	StartDaemon    bool `json:"startDaemon"`
+	StartTimeoutMs int  `json:"startTimeoutMs"`
+}
+
+// Parse the "dhcp" block of a network config.  Networks using dhcp
+// IPAM get the defaults without one.
+func parseDHCP(netconf map[string]interface{}) (*dhcpConfig, error) {
+	ipam, _ := netconf["ipam"].(map[string]interface{})
+	isDHCP := ipam["type"] == "dhcp"
+
+	d := &dhcpConfig{}
+	ok, err := decodeNetConfKey(netconf, "dhcp", d)
+	if err != nil {
+		return nil, err
+	}
+	if !ok && !isDHCP {
+		return nil, nil
+	}
+	if !isDHCP {
+		return nil, errors.New("dhcp needs the dhcp IPAM plugin.")
+	}
+
+	if d.Socket == "" {
+		d.Socket = defaultDHCPSocket
+	}
+	if !filepath.IsAbs(d.Socket) {
+		return nil, fmt.Errorf("dhcp socket %q is not an absolute path.", d.Socket)
+	}
+
+	if d.StartTimeoutMs < 0 {
+		return nil, fmt.Errorf("Invalid dhcp startTimeoutMs %d.", d.StartTimeoutMs)
+	}
+	if d.StartTimeoutMs == 0 {
+		d.StartTimeoutMs = defaultDHCPStartTimeoutMs
+	}
+
+	return d, nil
+}
+
+// Return the IPAM config to pass to the delegate.
+func (d *dhcpConfig) ipamConf(ipam map[string]interface{}) map[string]interface{} {
+	if d.Socket == defaultDHCPSocket {
+		return ipam
+	}
+
+	conf := make(map[string]interface{}, len(ipam)+1)
+	for k, v := range ipam {
+		conf[k] = v
+	}
+	conf["daemonSocketPath"] = d.Socket
+	return conf
+}
+
+// Return whether a daemon is listening on socket.
+func dhcpDaemonUp(socket string) bool {
+	conn, err := net.DialTimeout("unix", socket, time.Second)
+	if err != nil {
+		return false
+	}
+
+	conn.Close()
+	return true
+}
+
+// Make sure the daemon is listening, starting it if configured to.
+// Fails with a retryable error if it is not.
+func (d *dhcpConfig) ensureDaemon(log *logrus.Entry, cniPath string) error {
+	if dhcpDaemonUp(d.Socket) {
+		return nil
+	}
+	if !d.StartDaemon {
+		return newError(errCodeNetworkNotReady, "DHCP daemon not listening on %q.", d.Socket)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(d.Socket), 0700); err != nil {
+		return err
+	}
+
+	// Concurrent ADDs must not each start a daemon.
+	lock, err := os.OpenFile(d.Socket+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to open DHCP daemon lock: %v", err)
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("Failed to lock DHCP daemon lock: %v", err)
+	}
+	if dhcpDaemonUp(d.Socket) {
+		return nil
+	}
+
+	path, err := invoke.FindInPath("dhcp", filepath.SplitList(cniPath))
+	if err != nil {
+		return err
+	}
+
+	// A daemon that died leaves its socket behind.
+	os.Remove(d.Socket)
+
+	args := []string{"daemon"}
+	if d.Socket != defaultDHCPSocket {
+		args = append(args, "-socketpath", d.Socket)
+	}
+	cmd := exec.Command(path, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Failed to start DHCP daemon: %v", err)
+	}
+	pid := cmd.Process.Pid
+	cmd.Process.Release()
+
+	deadline := time.Now().Add(time.Duration(d.StartTimeoutMs) * time.Millisecond)
+	for !dhcpDaemonUp(d.Socket) {
+		if time.Now().After(deadline) {
+			return newError(errCodeNetworkNotReady, "DHCP daemon started but not listening on %q after %dms.",
+				d.Socket, d.StartTimeoutMs)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	log.WithFields(logrus.Fields{
+		"pid":    pid,
+		"socket": d.Socket,
+	}).Info("Started DHCP daemon.")
+
+	return nil
+}
+
+// Return whether a delegate DEL failed only because the daemon does
+// not know the lease, as after it restarted.  The lease will expire
+// on the DHCP server, so there is nothing left to release.
+func dhcpLeaseGone(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "lease not found")
+}
@@ -0,0 +1,92 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+	"github.com/stretchr/testify/assert"
+)
+
+// Default the dhcp block for dhcp IPAM, and refuse it for other IPAM.
+func TestParseDHCP(t *testing.T) {
+	d, err := parseDHCP(map[string]interface{}{"type": "bridge", "ipam": map[string]interface{}{"type": "host-local"}})
+	assert.NoError(t, err)
+	assert.Nil(t, d)
+
+	d, err = parseDHCP(map[string]interface{}{"type": "macvlan", "ipam": map[string]interface{}{"type": "dhcp"}})
+	assert.NoError(t, err)
+	assert.Equal(t, &dhcpConfig{Socket: defaultDHCPSocket, StartTimeoutMs: defaultDHCPStartTimeoutMs}, d)
+
+	_, err = parseDHCP(map[string]interface{}{"type": "bridge", "dhcp": map[string]interface{}{"startDaemon": true}})
+	assert.EqualError(t, err, "dhcp needs the dhcp IPAM plugin.")
+
+	_, err = parseDHCP(map[string]interface{}{
+		"ipam": map[string]interface{}{"type": "dhcp"},
+		"dhcp": map[string]interface{}{"socket": "dhcp.sock"},
+	})
+	assert.EqualError(t, err, `dhcp socket "dhcp.sock" is not an absolute path.`)
+}
+
+// Pass a namespace's own daemon socket on to the dhcp plugin.
+func TestDHCPIPAMConf(t *testing.T) {
+	ipam := map[string]interface{}{"type": "dhcp"}
+
+	d := &dhcpConfig{Socket: defaultDHCPSocket}
+	assert.Equal(t, ipam, d.ipamConf(ipam))
+
+	d.Socket = "/run/cni/dhcp-tenant-a.sock"
+	assert.Equal(t, map[string]interface{}{"type": "dhcp", "daemonSocketPath": "/run/cni/dhcp-tenant-a.sock"}, d.ipamConf(ipam))
+	assert.Equal(t, map[string]interface{}{"type": "dhcp"}, ipam)
+}
+
+// Use a listening daemon, and fail with a retryable error without one.
+func TestEnsureDHCPDaemon(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-dhcp")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	d := &dhcpConfig{Socket: filepath.Join(dir, "dhcp.sock"), StartTimeoutMs: 100}
+	err = d.ensureDaemon(log, dir)
+	assert.True(t, selector.Is(err, selector.ErrNetworkNotReady))
+	assert.True(t, selector.IsTemporary(err))
+
+	// No dhcp plugin to start.
+	d.StartDaemon = true
+	assert.Error(t, d.ensureDaemon(log, dir))
+
+	l, err := net.Listen("unix", d.Socket)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	assert.NoError(t, d.ensureDaemon(log, dir))
+}
+
+// Recognize DEL failing for a lease the daemon forgot.
+func TestDHCPLeaseGone(t *testing.T) {
+	assert.True(t, dhcpLeaseGone(errors.New(`Delegate "macvlan" failed: lease not found: abc/net`)))
+	assert.False(t, dhcpLeaseGone(errors.New(`Delegate "macvlan" failed: error dialing DHCP daemon`)))
+	assert.False(t, dhcpLeaseGone(nil))
+}
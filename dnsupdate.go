@@ -0,0 +1,246 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	defaultDNSTTL        = 30
+	defaultTSIGAlgorithm = "hmac-sha256"
+)
+
+// Registration of pods in DNS with RFC 2136 dynamic updates, set by
+// the "registerDNS" block of a network config.  Each pod gets A and
+// AAAA records "<pod>.<zone>" for its addresses.
+type dnsRegistration struct {
+	Zone string `json:"zone"`
+	// The primary server, as host or host:port.
+	Server string `json:"server"`
+	TTL    int    `json:"ttl"`
+	// A Kubernetes secret holding the TSIG key, with the keys
+	// "keyName", "secret" (base64, as in a BIND key file) and
+	// optionally "algorithm".
+	TSIGSecretRef *secretRef `json:"tsigSecretRef"`
+}
+
+// A reference to a Kubernetes secret.
+type secretRef struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// A TSIG key.
+type tsigKey struct {
+	Name      string
+	Algorithm string
+	Secret    string
+}
+
+// Parse the "registerDNS" block of a network config.
+func parseDNSRegistration(netconf map[string]interface{}) (*dnsRegistration, error) {
+	r := &dnsRegistration{}
+	if ok, err := decodeNetConfKey(netconf, "registerDNS", r); !ok || err != nil {
+		return nil, err
+	}
+
+	if r.Zone == "" || r.Server == "" {
+		return nil, errors.New("registerDNS requires a zone and a server.")
+	}
+	r.Zone = strings.TrimSuffix(r.Zone, ".")
+
+	if r.TSIGSecretRef != nil && (r.TSIGSecretRef.Namespace == "" || r.TSIGSecretRef.Name == "") {
+		return nil, errors.New("registerDNS tsigSecretRef requires a namespace and a name.")
+	}
+
+	if r.TTL <= 0 {
+		r.TTL = defaultDNSTTL
+	}
+
+	return r, nil
+}
+
+// Return the name registered for a pod.
+func (r *dnsRegistration) name(pod string) string {
+	return pod + "." + r.Zone + "."
+}
+
+// Return the header of an nsupdate script for the zone's server.
+func (r *dnsRegistration) header() []string {
+	server := r.Server
+	port := ""
+	if host, p, err := net.SplitHostPort(r.Server); err == nil {
+		server, port = host, " "+p
+	}
+
+	return []string{
+		"server " + server + port,
+		"zone " + r.Zone + ".",
+	}
+}
+
+// Return the record type for ip.
+func recordType(ip net.IP) string {
+	if ip.To4() == nil {
+		return "AAAA"
+	}
+	return "A"
+}
+
+// Return the nsupdate script replacing the pod's records with records
+// for ips.
+func (r *dnsRegistration) script(pod string, ips []net.IP) string {
+	name := r.name(pod)
+
+	lines := append(r.header(),
+		"update delete "+name+" A",
+		"update delete "+name+" AAAA",
+	)
+	for _, ip := range ips {
+		lines = append(lines, fmt.Sprintf("update add %s %d %s %s", name, r.TTL, recordType(ip), ip))
+	}
+	lines = append(lines, "send")
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Return the nsupdate script deleting only the pod's records for ips,
+// so that records registered since for another sandbox of the same
+// pod, e.g. a StatefulSet pod rescheduled on another node, are kept.
+func (r *dnsRegistration) deleteScript(pod string, ips []net.IP) string {
+	name := r.name(pod)
+
+	lines := r.header()
+	for _, ip := range ips {
+		lines = append(lines, fmt.Sprintf("update delete %s %s %s", name, recordType(ip), ip))
+	}
+	lines = append(lines, "send")
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Fetch the TSIG key from its Kubernetes secret.
+func (c *config) tsigKey(ref *secretRef) (*tsigKey, error) {
+	client, err := c.Kubernetes.client()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := client.getSecret(ref.Namespace, ref.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &tsigKey{
+		Name:      string(data["keyName"]),
+		Algorithm: string(data["algorithm"]),
+		Secret:    string(data["secret"]),
+	}
+	if key.Name == "" || key.Secret == "" {
+		return nil, fmt.Errorf("Secret %s/%s lacks keyName or secret.", ref.Namespace, ref.Name)
+	}
+	if _, err := base64.StdEncoding.DecodeString(key.Secret); err != nil {
+		return nil, fmt.Errorf("Secret %s/%s has an invalid TSIG secret.", ref.Namespace, ref.Name)
+	}
+	if key.Algorithm == "" {
+		key.Algorithm = defaultTSIGAlgorithm
+	}
+
+	return key, nil
+}
+
+// Send an update with nsupdate, signed with key if it is not nil.
+func sendDNSUpdate(script string, key *tsigKey) error {
+	var args []string
+	if key != nil {
+		f, err := ioutil.TempFile("", "kube-namespace-tsig-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+
+		fmt.Fprintf(f, "key %q {\n\talgorithm %s;\n\tsecret %q;\n};\n", key.Name, key.Algorithm, key.Secret)
+		if err := f.Close(); err != nil {
+			return err
+		}
+		args = append(args, "-k", f.Name())
+	}
+
+	_, err := runCommandInput(script, "nsupdate", args...)
+	return err
+}
+
+// Register the pod's addresses in DNS.
+func (c *config) updateDNS(r *dnsRegistration, pod string, ips []net.IP) error {
+	if pod == "" {
+		return errors.New("registerDNS requires the pod name.")
+	}
+
+	if err := c.sendDNSUpdate(r, r.script(pod, ips)); err != nil {
+		return err
+	}
+
+	c.Logger().WithFields(logrus.Fields{
+		"name":      r.name(pod),
+		"addresses": ips,
+	}).Debug("Updated DNS records.")
+
+	return nil
+}
+
+// Remove the pod's records for ips, the addresses registered for this
+// attachment, from DNS.
+func (c *config) removeDNS(r *dnsRegistration, pod string, ips []net.IP) error {
+	if pod == "" || len(ips) == 0 {
+		return nil
+	}
+
+	if err := c.sendDNSUpdate(r, r.deleteScript(pod, ips)); err != nil {
+		return err
+	}
+
+	c.Logger().WithFields(logrus.Fields{
+		"name":      r.name(pod),
+		"addresses": ips,
+	}).Debug("Removed DNS records.")
+
+	return nil
+}
+
+// Send an update script to the zone's server, signed with the TSIG
+// key if one is configured.
+func (c *config) sendDNSUpdate(r *dnsRegistration, script string) error {
+	var key *tsigKey
+	if r.TSIGSecretRef != nil {
+		var err error
+		if key, err = c.tsigKey(r.TSIGSecretRef); err != nil {
+			return err
+		}
+	}
+
+	return sendDNSUpdate(script, key)
+}
@@ -0,0 +1,80 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Replace a pod's records in one update.
+func TestDNSUpdateScript(t *testing.T) {
+	r, err := parseDNSRegistration(map[string]interface{}{
+		"registerDNS": map[string]interface{}{"zone": "legacy.example.com.", "server": "10.0.0.53:5353"},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, `server 10.0.0.53 5353
+zone legacy.example.com.
+update delete web-1.legacy.example.com. A
+update delete web-1.legacy.example.com. AAAA
+update add web-1.legacy.example.com. 30 A 10.2.0.5
+update add web-1.legacy.example.com. 30 AAAA fd00::5
+send
+`, r.script("web-1", []net.IP{net.ParseIP("10.2.0.5"), net.ParseIP("fd00::5")}))
+
+	_, err = parseDNSRegistration(map[string]interface{}{"registerDNS": map[string]interface{}{"zone": "example.com"}})
+	assert.Error(t, err)
+}
+
+// Delete only the records of the attachment's addresses on DEL.
+func TestDNSDeleteScript(t *testing.T) {
+	r, err := parseDNSRegistration(map[string]interface{}{
+		"registerDNS": map[string]interface{}{"zone": "legacy.example.com", "server": "10.0.0.53"},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, `server 10.0.0.53
+zone legacy.example.com.
+update delete web-1.legacy.example.com. A 10.2.0.5
+update delete web-1.legacy.example.com. AAAA fd00::5
+send
+`, r.deleteScript("web-1", []net.IP{net.ParseIP("10.2.0.5"), net.ParseIP("fd00::5")}))
+
+	// Nothing is sent without addresses.
+	config := &config{}
+	assert.NoError(t, config.removeDNS(r, "web-1", nil))
+}
+
+// Read the TSIG key from a Kubernetes secret.
+func TestTSIGKey(t *testing.T) {
+	k, cleanup := fakeKubeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		// "keyName": "pods", "secret": "c2VjcmV0"
+		fmt.Fprint(w, `{"data": {"keyName": "cG9kcw==", "secret": "YzJWamNtVjA="}}`)
+	})
+	defer cleanup()
+
+	config := &config{Kubernetes: k}
+	key, err := config.tsigKey(&secretRef{Namespace: "kube-system", Name: "dns-tsig"})
+	assert.NoError(t, err)
+	assert.Equal(t, &tsigKey{Name: "pods", Algorithm: "hmac-sha256", Secret: "c2VjcmV0"}, key)
+}
@@ -0,0 +1,249 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const defaultIPAMDir = "/var/lib/cni/networks"
+
+// A check for leftovers of pod networking on a node that should have
+// no pods.  It returns a description of each leftover it finds.
+type drainCheck struct {
+	name string
+	run  func(c *config, ipamDir string) ([]string, error)
+}
+
+var drainChecks = []drainCheck{
+	{"attachments", checkAttachments},
+	{"host-local leases", checkLeases},
+	{"veths", checkVeths},
+	{"iptables chains", checkIptablesChains},
+	{"ebtables chains", checkEbtablesChains},
+}
+
+// Return every network config in the plugin config.
+func (c *config) allNetConfs() []map[string]interface{} {
+	var netconfs []map[string]interface{}
+	if len(c.Default) > 0 {
+		netconfs = append(netconfs, c.Default)
+	}
+	for _, netconf := range c.Namespaces {
+		netconfs = append(netconfs, netconf)
+	}
+
+	return netconfs
+}
+
+func checkAttachments(c *config, _ string) ([]string, error) {
+	attachments, err := newAttachmentStore(c.StateDir).list()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []string
+	for _, a := range attachments {
+		found = append(found, fmt.Sprintf("attachment %s (%s/%s)", a.ContainerID, a.Namespace, a.Pod))
+	}
+
+	return found, nil
+}
+
+// Check the host-local stores of all configured networks for leases.
+func checkLeases(c *config, ipamDir string) ([]string, error) {
+	var found []string
+	seen := make(map[string]bool)
+
+	for _, netconf := range c.allNetConfs() {
+		ipam, _ := netconf["ipam"].(map[string]interface{})
+		name, _ := netconf["name"].(string)
+		if ipam["type"] != "host-local" || name == "" {
+			continue
+		}
+
+		dir := ipamDir
+		if dataDir, ok := ipam["dataDir"].(string); ok && dataDir != "" {
+			dir = dataDir
+		}
+		dir = filepath.Join(dir, name)
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+
+		files, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			if f.Name() == "lock" || strings.HasPrefix(f.Name(), "last_reserved_ip") {
+				continue
+			}
+			found = append(found, fmt.Sprintf("lease %s in network %q", f.Name(), name))
+		}
+	}
+
+	return found, nil
+}
+
+var vethMasterRegexp = regexp.MustCompile(`^\d+: ([^:@]+)[@:].* master (\S+)`)
+
+// Check for veths still attached to configured bridges.
+func checkVeths(c *config, _ string) ([]string, error) {
+	bridges := make(map[string]bool)
+	for _, netconf := range c.allNetConfs() {
+		if netconf["type"] != "bridge" {
+			continue
+		}
+		bridges[bridgeName(netconf)] = true
+	}
+
+	out, err := runCommand("ip", "-o", "link", "show", "type", "veth")
+	if err != nil {
+		return nil, err
+	}
+
+	var found []string
+	for _, line := range strings.Split(out, "\n") {
+		m := vethMasterRegexp.FindStringSubmatch(line)
+		if m != nil && bridges[m[2]] {
+			found = append(found, fmt.Sprintf("veth %s on bridge %s", m[1], m[2]))
+		}
+	}
+
+	return found, nil
+}
+
+// Return the chains in the output of "iptables -S" or "ebtables -L"
+// that kube-namespace creates per pod.
+func podChains(out string) []string {
+	var chains []string
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+
+		var chain string
+		switch {
+		case len(fields) == 2 && fields[0] == "-N":
+			chain = fields[1]
+		case len(fields) >= 3 && fields[0] == "Bridge" && fields[1] == "chain:":
+			chain = strings.TrimSuffix(fields[2], ",")
+		}
+
+		for _, prefix := range []string{"KN-EGRESS-", "KN-SRC-", "KN-DST-"} {
+			if strings.HasPrefix(chain, prefix) {
+				chains = append(chains, chain)
+			}
+		}
+	}
+
+	return chains
+}
+
+func checkIptablesChains(_ *config, _ string) ([]string, error) {
+	var found []string
+	for _, iptables := range []string{"iptables", "ip6tables"} {
+		if _, err := exec.LookPath(iptables); err != nil {
+			continue
+		}
+
+		out, err := runCommand(iptables, "-w", "-S")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, chain := range podChains(out) {
+			found = append(found, fmt.Sprintf("%s chain %s", iptables, chain))
+		}
+	}
+
+	return found, nil
+}
+
+func checkEbtablesChains(_ *config, _ string) ([]string, error) {
+	if _, err := exec.LookPath("ebtables"); err != nil {
+		return nil, nil
+	}
+
+	out, err := runCommand("ebtables", "-L")
+	if err != nil {
+		return nil, err
+	}
+
+	var found []string
+	for _, chain := range podChains(out) {
+		found = append(found, "ebtables chain "+chain)
+	}
+
+	return found, nil
+}
+
+// Verify that a node with no pods has no pod networking left behind.
+// Veths are removed along with any qdiscs on them, so finding no veths
+// also means finding no leftover qdiscs.
+func cmdVerifyDrained(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("verify-drained", flag.ContinueOnError)
+	configPath := flags.String("config", "", "plugin config file (default stdin)")
+	ipamDir := flags.String("ipam-dir", defaultIPAMDir, "host-local data directory")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := readConfig(*configPath, stdin)
+	if err != nil {
+		return err
+	}
+
+	problems := 0
+	for _, check := range drainChecks {
+		found, err := check.run(config, *ipamDir)
+		if err != nil {
+			fmt.Fprintf(stdout, "FAIL %s: could not check: %v\n", check.name, err)
+			problems++
+			continue
+		}
+
+		if len(found) == 0 {
+			fmt.Fprintf(stdout, "ok   %s\n", check.name)
+			continue
+		}
+
+		fmt.Fprintf(stdout, "FAIL %s:\n", check.name)
+		for _, f := range found {
+			fmt.Fprintf(stdout, "       %s\n", f)
+		}
+		problems += len(found)
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("Cleanup incomplete: %d problems found.", problems)
+	}
+
+	return nil
+}
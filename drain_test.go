@@ -0,0 +1,62 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Report host-local leases, but not the allocator's own files.
+func TestCheckLeases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	network := filepath.Join(dir, "isolated")
+	os.MkdirAll(network, 0755)
+	for _, name := range []string{"10.2.0.5", "last_reserved_ip", "lock"} {
+		ioutil.WriteFile(filepath.Join(network, name), []byte("abc"), 0644)
+	}
+
+	config, err := parseConfig([]byte(configNoDefault))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	found, err := checkLeases(config, dir)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`lease 10.2.0.5 in network "isolated"`}, found)
+}
+
+// Find per-pod chains in iptables and ebtables listings.
+func TestPodChains(t *testing.T) {
+	iptables := "-P FORWARD ACCEPT\n-N KN-EGRESS-0123456789abcdef\n-N DOCKER\n"
+	ebtables := "Bridge table: filter\n\nBridge chain: KN-SRC-0123456789abcdef, entries: 1, policy: RETURN\n" +
+		"Bridge chain: KN-ISO-0123456789abcdef, entries: 1, policy: DROP\n"
+
+	assert.Equal(t, []string{"KN-EGRESS-0123456789abcdef"}, podChains(iptables))
+	assert.Equal(t, []string{"KN-SRC-0123456789abcdef"}, podChains(ebtables))
+}
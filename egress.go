@@ -0,0 +1,206 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// An egress firewall rule.  Rules are evaluated in order, and traffic
+// that matches no rule is allowed.
+type egressRule struct {
+	// "allow" or "deny".
+	Action   string   `json:"action"`
+	CIDRs    []string `json:"cidrs"`
+	Protocol string   `json:"protocol"`
+	Ports    []int    `json:"ports"`
+}
+
+// Parse and validate the "egressRules" list of a network config.
+func parseEgressRules(netconf map[string]interface{}) ([]egressRule, error) {
+	var rules []egressRule
+	if ok, err := decodeNetConfKey(netconf, "egressRules", &rules); !ok || err != nil {
+		return nil, err
+	}
+
+	for i, r := range rules {
+		if r.Action != "allow" && r.Action != "deny" {
+			return nil, fmt.Errorf("Egress rule %d: action must be allow or deny.", i)
+		}
+
+		for _, cidr := range r.CIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return nil, fmt.Errorf("Egress rule %d: %v", i, err)
+			}
+		}
+
+		if len(r.Ports) > 0 && r.Protocol != "tcp" && r.Protocol != "udp" {
+			return nil, fmt.Errorf("Egress rule %d: ports require protocol tcp or udp.", i)
+		}
+	}
+
+	return rules, nil
+}
+
+// Return the name of the egress chain for a container.
+func egressChain(containerID string) string {
+	return "KN-EGRESS-" + shortHash(containerID)
+}
+
+// Return the iptables rules, as argument lists for -A, that make up
+// the egress chain for the given address family.
+func egressChainRules(chain string, rules []egressRule, ipv6 bool) [][]string {
+	specs := [][]string{
+		{chain, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+	}
+
+	for _, r := range rules {
+		target := "RETURN"
+		if r.Action == "deny" {
+			target = "REJECT"
+		}
+
+		var match []string
+		if r.Protocol != "" {
+			match = append(match, "-p", r.Protocol)
+		}
+		if len(r.Ports) > 0 {
+			ports := make([]string, len(r.Ports))
+			for i, p := range r.Ports {
+				ports[i] = strconv.Itoa(p)
+			}
+			match = append(match, "-m", "multiport", "--dports", strings.Join(ports, ","))
+		}
+
+		cidrs := r.CIDRs
+		if len(cidrs) == 0 {
+			cidrs = []string{""}
+		}
+
+		for _, cidr := range cidrs {
+			spec := []string{chain}
+			if cidr != "" {
+				if isIPv6CIDR(cidr) != ipv6 {
+					continue
+				}
+				spec = append(spec, "-d", cidr)
+			}
+			spec = append(spec, match...)
+			specs = append(specs, append(spec, "-j", target))
+		}
+	}
+
+	return specs
+}
+
+func isIPv6CIDR(cidr string) bool {
+	ip, _, _ := net.ParseCIDR(cidr)
+	return ip.To4() == nil
+}
+
+// Install the egress chain for a pod, and jump to it from FORWARD for
+// traffic from the pod's addresses.
+func installEgressRules(log *logrus.Entry, containerID string, rules []egressRule, result *types.Result) error {
+	chain := egressChain(containerID)
+	comment := "kube-namespace:" + containerID
+
+	for _, ipc := range []*types.IPConfig{result.IP4, result.IP6} {
+		if ipc == nil {
+			continue
+		}
+
+		ipv6 := ipc.IP.IP.To4() == nil
+		iptables := iptablesCommand(ipv6)
+
+		// The chain may be left over from an earlier, failed ADD.
+		runCommand(iptables, "-w", "-N", chain)
+		if _, err := runCommand(iptables, "-w", "-F", chain); err != nil {
+			return err
+		}
+
+		for _, spec := range egressChainRules(chain, rules, ipv6) {
+			if _, err := runCommand(iptables, append([]string{"-w", "-A"}, spec...)...); err != nil {
+				return err
+			}
+		}
+
+		jump := []string{"FORWARD", "-s", hostRoute(ipc.IP.IP),
+			"-m", "comment", "--comment", comment, "-j", chain}
+		if _, err := runCommand(iptables, append([]string{"-w", "-C"}, jump...)...); err != nil {
+			if _, err := runCommand(iptables, append([]string{"-w", "-I"}, jump...)...); err != nil {
+				return err
+			}
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		"chain": chain,
+		"rules": len(rules),
+	}).Debug("Installed egress rules.")
+
+	return nil
+}
+
+// Remove a pod's egress chain and the FORWARD rules that jump to it.
+// The pod's addresses are not known at DEL time, so the jump rules are
+// found by listing FORWARD.
+func teardownEgressRules(log *logrus.Entry, containerID string) {
+	chain := egressChain(containerID)
+
+	for _, ipv6 := range []bool{false, true} {
+		iptables := iptablesCommand(ipv6)
+
+		out, err := runCommand(iptables, "-w", "-S", "FORWARD")
+		if err != nil {
+			log.WithField("error", err).Warn("Failed to list FORWARD rules.")
+			continue
+		}
+
+		for _, line := range strings.Split(out, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 || fields[0] != "-A" || fields[len(fields)-1] != chain {
+				continue
+			}
+
+			fields[0] = "-D"
+			if _, err := runCommand(iptables, append([]string{"-w"}, fields...)...); err != nil {
+				log.WithField("error", err).Warn("Failed to remove egress jump rule.")
+			}
+		}
+
+		// The chain only exists if the pod had an address of this family.
+		if _, err := runCommand(iptables, "-w", "-F", chain); err == nil {
+			runCommand(iptables, "-w", "-X", chain)
+		}
+	}
+}
+
+func iptablesCommand(ipv6 bool) string {
+	if ipv6 {
+		return "ip6tables"
+	}
+
+	return "iptables"
+}
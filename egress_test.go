@@ -0,0 +1,73 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const egressConfig = `{
+  "type": "bridge",
+  "egressRules": [
+    {"action": "allow", "cidrs": ["10.0.0.0/8", "fd00::/8"], "protocol": "tcp", "ports": [80, 443]},
+    {"action": "deny", "cidrs": ["0.0.0.0/0"]}
+  ]
+}`
+
+// Render egress rules for one address family.
+func TestEgressChainRules(t *testing.T) {
+	netconf := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(egressConfig), &netconf); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	rules, err := parseEgressRules(netconf)
+	if err != nil {
+		t.Fatalf("Failed to parse egress rules: %v", err)
+	}
+
+	assert.Equal(t, [][]string{
+		{"C", "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+		{"C", "-d", "10.0.0.0/8", "-p", "tcp", "-m", "multiport", "--dports", "80,443", "-j", "RETURN"},
+		{"C", "-d", "0.0.0.0/0", "-j", "REJECT"},
+	}, egressChainRules("C", rules, false))
+}
+
+// Error on ports without a protocol.
+func TestParseEgressRulesPortsNoProtocol(t *testing.T) {
+	netconf := map[string]interface{}{
+		"egressRules": []interface{}{
+			map[string]interface{}{"action": "allow", "ports": []int{53}},
+		},
+	}
+
+	_, err := parseEgressRules(netconf)
+
+	assert.Error(t, err)
+}
+
+// Keep chain names within the iptables limit.
+func TestEgressChain(t *testing.T) {
+	chain := egressChain("0123456789abcdef0123456789abcdef0123456789abcdef")
+
+	assert.True(t, len(chain) <= 28)
+	assert.Equal(t, chain, egressChain("0123456789abcdef0123456789abcdef0123456789abcdef"))
+}
@@ -0,0 +1,49 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+)
+
+// Error codes returned in the CNI error result.  They are defined,
+// with sentinel errors to compare against, in pkg/selector.
+const (
+	errCodeMissingNamespace   = selector.CodeMissingNamespace
+	errCodeNoNetworkConfig    = selector.CodeNamespaceNotConfigured
+	errCodeDelegateNotFound   = selector.CodeDelegateNotFound
+	errCodeDelegateFailed     = selector.CodeDelegateFailed
+	errCodeDelegateTimeout    = selector.CodeDelegateTimeout
+	errCodeQuotaExceeded      = selector.CodeQuotaExceeded
+	errCodeNamespaceFrozen    = selector.CodeNamespaceFrozen
+	errCodePodNotPermitted    = selector.CodePodNotPermitted
+	errCodeDelegateNotAllowed = selector.CodeDelegateNotAllowed
+	errCodeTierNotAllowed     = selector.CodeTierNotAllowed
+	errCodeVFPoolExhausted    = selector.CodeVFPoolExhausted
+	errCodeNetworkNotReady    = selector.CodeNetworkNotReady
+	errCodeDeviceUnavailable  = selector.CodeDeviceUnavailable
+	errCodeIfNameNotAllowed   = selector.CodeIfNameNotAllowed
+)
+
+// Return a CNI error with the given code.
+func newError(code uint, format string, args ...interface{}) *types.Error {
+	return &types.Error{
+		Code: code,
+		Msg:  fmt.Sprintf(format, args...),
+	}
+}
@@ -0,0 +1,446 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	defaultEtcdPrefix        = "/kube-namespace/namespaces/"
+	defaultEtcdCacheTTL      = 60
+	defaultEtcdCacheFileName = "etcd-namespaces.json"
+	etcdRequestTimeout       = 5 * time.Second
+)
+
+// Reading the namespace to profile mapping from etcd, set by the
+// top-level "etcd" block.  The key <prefix><namespace> holds the name
+// of a profile, or of a network config in the plugin config, for the
+// namespace's pods.  etcd is reached through its v3 JSON gateway.
+type etcdConfig struct {
+	Endpoints []string `json:"endpoints"`
+	Prefix    string   `json:"prefix"`
+
+	// Client certificate, key and CA bundle for TLS endpoints.
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	CAFile   string `json:"caFile"`
+
+	// Where to cache the mapping, as for namespaceNetworks.  The
+	// etcd-watch subcommand keeps the cache current.
+	CacheFile       string `json:"cacheFile"`
+	CacheTTLSeconds int    `json:"cacheTTLSeconds"`
+}
+
+// The cached mapping of namespaces to profiles.
+type etcdCache struct {
+	Fetched    time.Time         `json:"fetched"`
+	Revision   int64             `json:"revision"`
+	Namespaces map[string]string `json:"namespaces"`
+}
+
+func (e *etcdConfig) prefix() string {
+	if e.Prefix != "" {
+		return e.Prefix
+	}
+
+	return defaultEtcdPrefix
+}
+
+func (e *etcdConfig) ttl() time.Duration {
+	if e.CacheTTLSeconds > 0 {
+		return time.Duration(e.CacheTTLSeconds) * time.Second
+	}
+
+	return defaultEtcdCacheTTL * time.Second
+}
+
+func (c *config) etcdCacheFile() string {
+	if c.Etcd.CacheFile != "" {
+		return c.Etcd.CacheFile
+	}
+
+	return filepath.Join(newAttachmentStore(c.StateDir).dir, defaultEtcdCacheFileName)
+}
+
+// Return an HTTP client for the endpoints, using the TLS config.
+func (e *etcdConfig) client() (*http.Client, error) {
+	if e.CertFile == "" && e.KeyFile == "" && e.CAFile == "" {
+		return &http.Client{}, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if e.CertFile != "" || e.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(e.CertFile, e.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load etcd client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if e.CAFile != "" {
+		data, err := ioutil.ReadFile(e.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read etcd CA file: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("No certificates in etcd CA file %s.", e.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}, nil
+}
+
+// Return the key ending the range of keys with prefix.
+func etcdRangeEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+
+	// Every key is in the range.
+	return "\x00"
+}
+
+func etcdKeyRange(prefix string) map[string]string {
+	return map[string]string{
+		"key":       base64.StdEncoding.EncodeToString([]byte(prefix)),
+		"range_end": base64.StdEncoding.EncodeToString([]byte(etcdRangeEnd(prefix))),
+	}
+}
+
+// POST a request to path on each endpoint in turn, until one answers.
+func (e *etcdConfig) post(ctx context.Context, client *http.Client, path string, body interface{}) (*http.Response, error) {
+	if len(e.Endpoints) == 0 {
+		return nil, errors.New("No etcd endpoints given.")
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []string
+	for _, endpoint := range e.Endpoints {
+		req, err := http.NewRequest("POST", strings.TrimSuffix(endpoint, "/")+path, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		if resp.StatusCode/100 != 2 {
+			msg, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			errs = append(errs, fmt.Sprintf("%s returned %s: %s", endpoint, resp.Status, strings.TrimSpace(string(msg))))
+			continue
+		}
+
+		return resp, nil
+	}
+
+	return nil, fmt.Errorf("etcd request failed: %s", strings.Join(errs, "; "))
+}
+
+// Read the mapping from etcd.
+func (e *etcdConfig) fetch() (*etcdCache, error) {
+	client, err := e.client()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdRequestTimeout)
+	defer cancel()
+
+	resp, err := e.post(ctx, client, "/v3/kv/range", etcdKeyRange(e.prefix()))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Header struct {
+			Revision string `json:"revision"`
+		} `json:"header"`
+		KVs []struct {
+			Key   []byte `json:"key"`
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("Failed to parse etcd response: %v", err)
+	}
+
+	cache := &etcdCache{Fetched: time.Now().UTC(), Namespaces: map[string]string{}}
+	cache.Revision, _ = strconv.ParseInt(result.Header.Revision, 10, 64)
+	for _, kv := range result.KVs {
+		namespace := strings.TrimPrefix(string(kv.Key), e.prefix())
+		if namespace == "" || strings.Contains(namespace, "/") {
+			continue
+		}
+		cache.Namespaces[namespace] = strings.TrimSpace(string(kv.Value))
+	}
+
+	return cache, nil
+}
+
+// Read the cache, returning nil if there is none.
+func readEtcdCache(path string) *etcdCache {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	cache := &etcdCache{}
+	if err := json.Unmarshal(data, cache); err != nil {
+		log.WithField("error", err).Warn("Ignoring invalid etcd cache.")
+		return nil
+	}
+
+	return cache
+}
+
+// Return the namespace to profile mapping, from the cache while it is
+// fresh, and from etcd otherwise.  If etcd cannot be reached, a stale
+// cache is used.
+func (c *config) etcdNamespaces() (map[string]string, error) {
+	path := c.etcdCacheFile()
+	cache := readEtcdCache(path)
+	if cache != nil && time.Since(cache.Fetched) < c.Etcd.ttl() {
+		return cache.Namespaces, nil
+	}
+
+	fetched, err := c.Etcd.fetch()
+	if err != nil {
+		if cache == nil {
+			return nil, err
+		}
+
+		c.Logger().WithFields(logrus.Fields{
+			"error":   err,
+			"fetched": cache.Fetched,
+		}).Warn("Failed to read namespaces from etcd. Using cached ones.")
+		return cache.Namespaces, nil
+	}
+
+	if err := writeJSONAtomic(path, fetched); err != nil {
+		c.Logger().WithField("error", err).Warn("Failed to cache etcd namespaces.")
+	}
+
+	return fetched.Namespaces, nil
+}
+
+// Return the config for the profile or network config called name.
+func (c *config) etcdNetConf(name string) (map[string]interface{}, error) {
+	if _, ok := c.Profiles[name]; ok {
+		return c.Profile(name)
+	}
+
+	if netconf, ok := c.namedNetConf(name); ok {
+		return netconf, nil
+	}
+
+	return nil, fmt.Errorf("No profile or network %q.", name)
+}
+
+// Selects the config named in etcd for the pod's namespace, if an etcd
+// block is given.
+type byEtcd struct {
+	c *config
+}
+
+func (s byEtcd) Select(c *selector.Config, args string, pod selector.PodMetadata) (*selection, error) {
+	if s.c.Etcd == nil {
+		return nil, nil
+	}
+
+	namespaces, err := s.c.etcdNamespaces()
+	if err != nil {
+		c.Logger().WithField("error", err).Warn("Failed to read namespaces from etcd. Using static config.")
+		return nil, nil
+	}
+
+	namespace := selector.ParseExtraArgs(args)["K8S_POD_NAMESPACE"]
+	name, ok := namespaces[namespace]
+	if !ok {
+		return nil, nil
+	}
+
+	netconf, err := s.c.etcdNetConf(name)
+	if err != nil {
+		c.Logger().WithFields(logrus.Fields{
+			"namespace": namespace,
+			"error":     err,
+		}).Warn("Ignoring invalid etcd namespace entry.")
+		return nil, nil
+	}
+
+	return c.WithNamespaces(map[string]map[string]interface{}{namespace: netconf}).Select(args)
+}
+
+// Watch the namespaces in etcd, rewriting the cache whenever they
+// change, and at least every half cache TTL so that it stays fresh.
+// Returns when stop is closed.
+func (c *config) watchEtcd(stop <-chan struct{}) {
+	client, err := c.Etcd.client()
+	if err != nil {
+		c.Logger().WithField("error", err).Error("Failed to set up etcd client.")
+		return
+	}
+
+	path := c.etcdCacheFile()
+	for {
+		cache, err := c.Etcd.fetch()
+		if err != nil {
+			c.Logger().WithField("error", err).Warn("Failed to read namespaces from etcd.")
+		} else if err := writeJSONAtomic(path, cache); err != nil {
+			c.Logger().WithField("error", err).Warn("Failed to cache etcd namespaces.")
+		} else {
+			c.Logger().WithFields(logrus.Fields{
+				"namespaces": len(cache.Namespaces),
+				"revision":   cache.Revision,
+			}).Debug("Cached etcd namespaces.")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), c.Etcd.ttl()/2)
+		go func() {
+			select {
+			case <-stop:
+			case <-ctx.Done():
+			}
+			cancel()
+		}()
+
+		if err == nil {
+			if err := c.Etcd.waitForChange(ctx, client, cache.Revision+1); err != nil && ctx.Err() == nil {
+				c.Logger().WithField("error", err).Warn("Failed to watch etcd.")
+			}
+		}
+		<-ctx.Done()
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+// Block until a key under the prefix changes at or after revision, or
+// ctx is done.
+func (e *etcdConfig) waitForChange(ctx context.Context, client *http.Client, revision int64) error {
+	req := etcdKeyRange(e.prefix())
+	watch := map[string]interface{}{"create_request": map[string]interface{}{
+		"key":            req["key"],
+		"range_end":      req["range_end"],
+		"start_revision": strconv.FormatInt(revision, 10),
+	}}
+
+	resp, err := e.post(ctx, client, "/v3/watch", watch)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The gateway streams one JSON object per line; the first
+	// confirms the watch, and later ones carry events.
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var msg struct {
+			Result struct {
+				Events []json.RawMessage `json:"events"`
+			} `json:"result"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return fmt.Errorf("Failed to parse etcd watch response: %v", err)
+		}
+		if len(msg.Result.Events) > 0 {
+			return nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// Keep the cache of the namespaces in etcd current, until interrupted.
+func cmdEtcdWatch(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("etcd-watch", flag.ContinueOnError)
+	configPath := flags.String("config", "", "plugin config file (default stdin)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := readConfig(*configPath, stdin)
+	if err != nil {
+		return err
+	}
+	config.setLogLevel()
+
+	if config.Etcd == nil {
+		return errors.New("Config has no etcd block.")
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	stop := make(chan struct{})
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	config.watchEtcd(stop)
+	return nil
+}
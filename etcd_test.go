@@ -0,0 +1,145 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const etcdConfigJSON = `{
+  "name": "kube-namespace",
+  "type": "kube-namespace",
+  "profiles": {"fast": {"name": "fast", "type": "ptp", "ipam": {"type": "host-local", "subnet": "10.5.0.0/16"}}},
+  "default": {"name": "default-bridge", "type": "bridge", "ipam": {"type": "host-local", "subnet": "10.1.0.0/16"}}
+}`
+
+// Return a fake etcd gateway serving the given keys.
+func fakeEtcd(t *testing.T, kvs map[string]string, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		if r.URL.Path != "/v3/kv/range" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var req struct{ Key []byte }
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, defaultEtcdPrefix, string(req.Key))
+
+		var items []map[string]string
+		for k, v := range kvs {
+			items = append(items, map[string]string{
+				"key":   base64.StdEncoding.EncodeToString([]byte(k)),
+				"value": base64.StdEncoding.EncodeToString([]byte(v)),
+			})
+		}
+		data, _ := json.Marshal(items)
+		fmt.Fprintf(w, `{"header": {"revision": "7"}, "kvs": %s}`, data)
+	}))
+}
+
+// Select profiles named in etcd, falling back to the static config,
+// and use the cache when etcd is unreachable.
+func TestEtcdNamespaces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-etcd")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	requests := 0
+	server := fakeEtcd(t, map[string]string{
+		defaultEtcdPrefix + "tenant-a": "fast",
+		defaultEtcdPrefix + "tenant-b": "default-bridge",
+		defaultEtcdPrefix + "broken":   "missing",
+	}, &requests)
+
+	config, err := parseConfig([]byte(etcdConfigJSON))
+	assert.NoError(t, err)
+	config.Etcd = &etcdConfig{Endpoints: []string{"http://127.0.0.1:1", server.URL}, CacheFile: filepath.Join(dir, "cache.json")}
+
+	sel, err := config.selectPod("K8S_POD_NAMESPACE=tenant-a")
+	assert.NoError(t, err)
+	assert.Equal(t, "tenant-a", sel.Rule)
+	assert.Equal(t, "ptp", sel.NetConf["type"])
+
+	sel, err = config.selectPod("K8S_POD_NAMESPACE=tenant-b")
+	assert.NoError(t, err)
+	assert.Equal(t, "bridge", sel.NetConf["type"])
+
+	sel, err = config.selectPod("K8S_POD_NAMESPACE=broken")
+	assert.NoError(t, err)
+	assert.Equal(t, defaultRule, sel.Rule)
+
+	// Fresh cache: one request, after the dead endpoint.
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, int64(7), readEtcdCache(config.etcdCacheFile()).Revision)
+
+	// Stale cache and no etcd: the cache is still used.
+	server.Close()
+	cache := readEtcdCache(config.etcdCacheFile())
+	cache.Fetched = cache.Fetched.Add(-time.Hour)
+	assert.NoError(t, writeJSONAtomic(config.etcdCacheFile(), cache))
+
+	sel, err = config.selectPod("K8S_POD_NAMESPACE=tenant-a")
+	assert.NoError(t, err)
+	assert.Equal(t, "ptp", sel.NetConf["type"])
+}
+
+// Compute the end of a key range.
+func TestEtcdRangeEnd(t *testing.T) {
+	assert.Equal(t, "/kube-namespace/namespaces0", etcdRangeEnd("/kube-namespace/namespaces/"))
+	assert.Equal(t, "b", etcdRangeEnd("a\xff"))
+	assert.Equal(t, "\x00", etcdRangeEnd("\xff"))
+}
+
+// An etcd block needs endpoints.
+func TestEtcdConfigValidation(t *testing.T) {
+	_, err := parseConfig([]byte(`{"name": "kube-namespace", "etcd": {}}`))
+	assert.EqualError(t, err, "etcd block given without endpoints.")
+}
+
+// Wait for events on a watch stream.
+func TestEtcdWaitForChange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			CreateRequest struct {
+				StartRevision string `json:"start_revision"`
+			} `json:"create_request"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "8", req.CreateRequest.StartRevision)
+
+		fmt.Fprintln(w, `{"result": {"created": true}}`)
+		fmt.Fprintln(w, `{"result": {"events": [{"kv": {}}]}}`)
+	}))
+	defer server.Close()
+
+	e := &etcdConfig{Endpoints: []string{server.URL}}
+	assert.NoError(t, e.waitForChange(context.Background(), http.DefaultClient, 8))
+}
@@ -0,0 +1,159 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+)
+
+// The reasons of the Events posted when a pod's delegate ADD fails,
+// when it is given a config from a deprecated profile, and when it is
+// rejected because its namespace is frozen.
+const (
+	eventReasonAttachFailed      = "NetworkAttachmentFailed"
+	eventReasonProfileDeprecated = "NetworkProfileDeprecated"
+	eventReasonNamespaceFrozen   = "NetworkNamespaceFrozen"
+)
+
+// A Kubernetes Event, as far as kube-namespace fills it in.
+type kubeEvent struct {
+	Metadata       kubeObjectMeta      `json:"metadata"`
+	InvolvedObject kubeObjectReference `json:"involvedObject"`
+	Reason         string              `json:"reason"`
+	Message        string              `json:"message"`
+	Type           string              `json:"type"`
+	Source         struct {
+		Component string `json:"component"`
+		Host      string `json:"host,omitempty"`
+	} `json:"source"`
+	FirstTimestamp time.Time `json:"firstTimestamp"`
+	LastTimestamp  time.Time `json:"lastTimestamp"`
+	Count          int       `json:"count"`
+}
+
+// A reference to the object an Event is about.
+type kubeObjectReference struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	UID       string `json:"uid,omitempty"`
+}
+
+// Create an Event.
+func (c *kubeClient) createEvent(event *kubeEvent) error {
+	path := fmt.Sprintf("/api/v1/namespaces/%s/events", url.PathEscape(event.Metadata.Namespace))
+	return c.do("POST", path, event, nil)
+}
+
+// Return a Warning Event on a pod.
+func newPodWarning(namespace, pod, uid, reason, message string) *kubeEvent {
+	now := time.Now().UTC()
+
+	event := &kubeEvent{
+		Metadata: kubeObjectMeta{
+			// Named like the kubelet names its events.
+			Name:      fmt.Sprintf("%s.%x", pod, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: kubeObjectReference{
+			Kind:      "Pod",
+			Namespace: namespace,
+			Name:      pod,
+			UID:       uid,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           "Warning",
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	event.Source.Component = "kube-namespace"
+	event.Source.Host, _ = os.Hostname()
+
+	return event
+}
+
+// Post a Warning Event on the selected pod, if podEvents is set, so
+// that it shows up in "kubectl describe pod".  Posting is best effort:
+// the error returned to the runtime is what matters.
+func (c *config) postPodWarning(sel *selection, args *skel.CmdArgs, reason, message string) {
+	if !c.PodEvents || sel.Namespace == "" || sel.Pod == "" {
+		return
+	}
+
+	client, err := c.Kubernetes.client()
+	if err != nil {
+		c.Logger().WithField("error", err).Warn("Failed to post pod event.")
+		return
+	}
+
+	uid := selector.ParseExtraArgs(args.Args)["K8S_POD_UID"]
+	if err := client.createEvent(newPodWarning(sel.Namespace, sel.Pod, uid, reason, message)); err != nil {
+		c.Logger().WithField("error", err).Warn("Failed to post pod event.")
+	}
+}
+
+// Post a Warning Event on the pod about its failed delegate ADD.
+func (c *config) reportAddFailure(sel *selection, args *skel.CmdArgs, addErr error) {
+	delegateType, _ := sel.NetConf["type"].(string)
+	message := fmt.Sprintf("network profile %s: %s: %v", sel.Rule, delegateType, addErr)
+	c.postPodWarning(sel, args, eventReasonAttachFailed, message)
+}
+
+// Post a Warning Event on a pod rejected because its namespace's
+// network config is frozen.
+func (c *config) reportFrozen(sel *selection, args *skel.CmdArgs) {
+	message := fmt.Sprintf("network profile %s is frozen; new pods in namespace %s are not networked", sel.Rule, sel.Namespace)
+	c.postPodWarning(sel, args, eventReasonNamespaceFrozen, message)
+}
+
+// Return the message of the Event posted about a pod whose config comes
+// from a deprecated profile, or "" if it does not.
+func deprecationMessage(sel *selection) string {
+	d := sel.Deprecation
+	if d == nil {
+		return ""
+	}
+
+	message := fmt.Sprintf("network profile %s is deprecated", d.Profile)
+	switch {
+	case sel.SunsetFallback:
+		message += fmt.Sprintf(" and was sunset after %s; using the default network config instead", d.SunsetAfter)
+	case d.Sunset(time.Now()):
+		message += fmt.Sprintf(" and was sunset after %s", d.SunsetAfter)
+	case d.SunsetAfter != "":
+		message += fmt.Sprintf(" and will be sunset after %s", d.SunsetAfter)
+	}
+
+	return message
+}
+
+// Post a Warning Event on a pod given a config from a deprecated
+// profile, so that its owners learn to move off it.
+func (c *config) reportDeprecation(sel *selection, args *skel.CmdArgs) {
+	if message := deprecationMessage(sel); message != "" {
+		c.postPodWarning(sel, args, eventReasonProfileDeprecated, message)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+	"github.com/stretchr/testify/assert"
+)
+
+// Post a Warning Event naming the profile, delegate type and error.
+func TestReportAddFailure(t *testing.T) {
+	var path string
+	event := &kubeEvent{}
+	k, cleanup := fakeKubeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(event)
+		w.WriteHeader(http.StatusCreated)
+	})
+	defer cleanup()
+
+	config := &config{Kubernetes: k}
+	sel := &selection{
+		Namespace: "tenant-a",
+		Pod:       "web-1",
+		Rule:      "isolated",
+		NetConf:   map[string]interface{}{"type": "bridge"},
+	}
+	args := &skel.CmdArgs{Args: "K8S_POD_NAMESPACE=tenant-a;K8S_POD_NAME=web-1;K8S_POD_UID=6a2f"}
+
+	// Events are off by default.
+	config.reportAddFailure(sel, args, errors.New("no IPs available"))
+	assert.Equal(t, "", path)
+
+	config.PodEvents = true
+	config.reportAddFailure(sel, args, errors.New("no IPs available"))
+
+	assert.Equal(t, "/api/v1/namespaces/tenant-a/events", path)
+	assert.Equal(t, "Warning", event.Type)
+	assert.Equal(t, eventReasonAttachFailed, event.Reason)
+	assert.Equal(t, "network profile isolated: bridge: no IPs available", event.Message)
+	assert.Equal(t, kubeObjectReference{Kind: "Pod", Namespace: "tenant-a", Name: "web-1", UID: "6a2f"}, event.InvolvedObject)
+}
+
+// Post a Warning Event on pods given a config from a deprecated profile.
+func TestReportDeprecation(t *testing.T) {
+	var events []*kubeEvent
+	k, cleanup := fakeKubeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		event := &kubeEvent{}
+		json.NewDecoder(r.Body).Decode(event)
+		events = append(events, event)
+		w.WriteHeader(http.StatusCreated)
+	})
+	defer cleanup()
+
+	config := &config{Kubernetes: k, PodEvents: true}
+	args := &skel.CmdArgs{Args: "K8S_POD_NAMESPACE=tenant-a;K8S_POD_NAME=web-1"}
+
+	// Nothing is posted for configs that are not deprecated.
+	config.reportDeprecation(&selection{Namespace: "tenant-a", Pod: "web-1", Rule: "tenant-a"}, args)
+	assert.Empty(t, events)
+
+	config.reportDeprecation(&selection{
+		Namespace:   "tenant-a",
+		Pod:         "web-1",
+		Rule:        "tenant-a",
+		Deprecation: &selector.Deprecation{Profile: "legacy-vlan", SunsetAfter: "2999-12-31"},
+	}, args)
+	config.reportDeprecation(&selection{
+		Namespace:      "tenant-a",
+		Pod:            "web-1",
+		Rule:           defaultRule,
+		Deprecation:    &selector.Deprecation{Profile: "legacy-vlan", SunsetAfter: "2026-06-30", FallbackAfterSunset: true},
+		SunsetFallback: true,
+	}, args)
+
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, eventReasonProfileDeprecated, events[0].Reason)
+		assert.Equal(t, "network profile legacy-vlan is deprecated and will be sunset after 2999-12-31", events[0].Message)
+		assert.Equal(t, "network profile legacy-vlan is deprecated and was sunset after 2026-06-30; using the default network config instead", events[1].Message)
+	}
+}
+
+// Post a Warning Event on pods rejected in a frozen namespace.
+func TestReportFrozen(t *testing.T) {
+	event := &kubeEvent{}
+	k, cleanup := fakeKubeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(event)
+		w.WriteHeader(http.StatusCreated)
+	})
+	defer cleanup()
+
+	config, err := parseConfig([]byte(`{
+	  "podEvents": true,
+	  "namespaces": {"tenant-a": {"name": "tenant-a", "type": "bridge", "frozen": true}}
+	}`))
+	assert.NoError(t, err)
+	config.Kubernetes = k
+
+	args := &skel.CmdArgs{ContainerID: "abc", Args: "K8S_POD_NAMESPACE=tenant-a;K8S_POD_NAME=web-1;K8S_POD_UID=6a2f"}
+	err = addNetwork(config, args, &selector.DelegateEnv{CNIPath: "/nonexistent"}, &bytes.Buffer{})
+	assert.True(t, selector.Is(err, selector.ErrNamespaceFrozen))
+
+	assert.Equal(t, eventReasonNamespaceFrozen, event.Reason)
+	assert.Equal(t, "network profile tenant-a is frozen; new pods in namespace tenant-a are not networked", event.Message)
+	assert.Equal(t, kubeObjectReference{Kind: "Pod", Namespace: "tenant-a", Name: "web-1", UID: "6a2f"}, event.InvolvedObject)
+}
@@ -0,0 +1,106 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Fault injection settings, used to rehearse CNI failure handling.
+// They only take effect in binaries built with the "faultinject" tag.
+type faultConfig struct {
+	// Delay every delegate invocation by this many milliseconds.
+	DelayMs int `json:"delayMs"`
+
+	// Fail every Nth ADD.  The count is kept in CounterFile so that
+	// it survives across plugin invocations.
+	FailEvery   int    `json:"failEvery"`
+	CounterFile string `json:"counterFile"`
+
+	// Print a truncated, unparseable result after a successful ADD.
+	CorruptResult bool `json:"corruptResult"`
+}
+
+// Sleep before invoking the delegate, if a delay is configured.
+func (f *faultConfig) delay(log *logrus.Entry) {
+	if f.DelayMs <= 0 {
+		return
+	}
+
+	log.WithField("delay_ms", f.DelayMs).Warn("Injected fault: delaying delegate.")
+	time.Sleep(time.Duration(f.DelayMs) * time.Millisecond)
+}
+
+// Return an error if this ADD is one that should fail.
+func (f *faultConfig) failAdd(log *logrus.Entry) error {
+	if f.FailEvery <= 0 {
+		return nil
+	}
+
+	counterFile := f.CounterFile
+	if counterFile == "" {
+		counterFile = filepath.Join(os.TempDir(), "kube-namespace-fault-counter")
+	}
+
+	count := 0
+	if data, err := ioutil.ReadFile(counterFile); err == nil {
+		count, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+	}
+	count++
+
+	if err := ioutil.WriteFile(counterFile, []byte(strconv.Itoa(count)), 0644); err != nil {
+		return fmt.Errorf("Failed to write fault counter: %v", err)
+	}
+
+	if count%f.FailEvery == 0 {
+		log.WithFields(logrus.Fields{
+			"count":      count,
+			"fail_every": f.FailEvery,
+		}).Warn("Injected fault: failing ADD.")
+
+		return fmt.Errorf("Injected fault: failing ADD %d.", count)
+	}
+
+	return nil
+}
+
+// Print the result, corrupting it first if configured to.
+func (f *faultConfig) printResult(log *logrus.Entry, result *result, w io.Writer) error {
+	if !f.CorruptResult {
+		return result.print(w)
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	log.Warn("Injected fault: corrupting result.")
+	_, err = w.Write(data[:len(data)/2])
+	return err
+}
@@ -0,0 +1,55 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// Fail every Nth ADD, counting across invocations.
+func TestFaultFailEvery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := &faultConfig{FailEvery: 3, CounterFile: filepath.Join(dir, "counter")}
+
+	assert.NoError(t, f.failAdd(log))
+	assert.NoError(t, f.failAdd(log))
+	assert.Error(t, f.failAdd(log))
+	assert.NoError(t, f.failAdd(log))
+}
+
+// Write an unparseable result when corruption is enabled.
+func TestFaultCorruptResult(t *testing.T) {
+	f := &faultConfig{CorruptResult: true}
+	buf := &bytes.Buffer{}
+
+	assert.NoError(t, f.printResult(log, &result{Result: &types.Result{}}, buf))
+	assert.Error(t, json.Unmarshal(buf.Bytes(), &types.Result{}))
+}
@@ -0,0 +1,172 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const defaultGatewayTimeoutMs = 1000
+
+// Primary and secondary gateways for a pod's default route.  The pod
+// routes via the primary while it answers pings from the pod, and via
+// the secondary otherwise.
+type gatewayConfig struct {
+	Primary   string `json:"primary"`
+	Secondary string `json:"secondary"`
+	TimeoutMs int    `json:"timeoutMs"`
+}
+
+// Parse the "gateways" block of a network config.
+func parseGateways(netconf map[string]interface{}) (*gatewayConfig, error) {
+	gw := &gatewayConfig{}
+	if ok, err := decodeNetConfKey(netconf, "gateways", gw); !ok || err != nil {
+		return nil, err
+	}
+
+	for _, addr := range []string{gw.Primary, gw.Secondary} {
+		if net.ParseIP(addr) == nil {
+			return nil, errors.New("Gateways config requires primary and secondary addresses.")
+		}
+	}
+
+	if gw.TimeoutMs <= 0 {
+		gw.TimeoutMs = defaultGatewayTimeoutMs
+	}
+
+	return gw, nil
+}
+
+// Return whether addr answers a ping.  Must be called in the pod's
+// network namespace.
+func (gw *gatewayConfig) healthy(addr string) bool {
+	return ping(addr, gw.TimeoutMs)
+}
+
+// Pick the gateway to route through: the primary if it is healthy,
+// otherwise the secondary if that is, otherwise the current one.
+func choose(primaryOK, secondaryOK bool, gw *gatewayConfig, current string) string {
+	switch {
+	case primaryOK:
+		return gw.Primary
+	case secondaryOK:
+		return gw.Secondary
+	case current != "":
+		return current
+	}
+
+	return gw.Primary
+}
+
+// Check the gateways from inside the pod and point its default route
+// at the chosen one.  Returns the gateway chosen.
+func (gw *gatewayConfig) apply(log *logrus.Entry, netns, ifName, current string) (string, error) {
+	var chosen string
+
+	err := withNetNS(netns, func() error {
+		chosen = choose(gw.healthy(gw.Primary), gw.healthy(gw.Secondary), gw, current)
+		if chosen == current {
+			return nil
+		}
+
+		return routeReplaceDefault(chosen, ifName)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if chosen != current {
+		log.WithFields(logrus.Fields{
+			"gateway":  chosen,
+			"previous": current,
+		}).Info("Routing pod via gateway.")
+	}
+
+	return chosen, nil
+}
+
+// Re-check the gateways of every attachment that has them, and repair
+// default routes where the healthy gateway has changed.  This is meant
+// to be run periodically, e.g. from a systemd timer.
+func cmdReconcile(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	configPath := flags.String("config", "", "plugin config file (default stdin)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := readConfig(*configPath, stdin)
+	if err != nil {
+		return err
+	}
+	config.setLogLevel()
+
+	store := newAttachmentStore(config.StateDir)
+	attachments, err := store.list()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, att := range attachments {
+		if att.Gateway == "" {
+			continue
+		}
+
+		sel, err := config.Select(kubeArgs(att.Namespace, att.Pod))
+		if err != nil {
+			fmt.Fprintf(stdout, "%s: %v\n", att.ContainerID, err)
+			failed++
+			continue
+		}
+
+		gw, err := parseGateways(sel.NetConf)
+		if err != nil || gw == nil {
+			fmt.Fprintf(stdout, "%s: no gateways config: %v\n", att.ContainerID, err)
+			failed++
+			continue
+		}
+
+		chosen, err := gw.apply(log, att.Netns, att.IfName, att.Gateway)
+		if err != nil {
+			fmt.Fprintf(stdout, "%s: %v\n", att.ContainerID, err)
+			failed++
+			continue
+		}
+
+		if chosen != att.Gateway {
+			fmt.Fprintf(stdout, "%s: switched gateway from %s to %s\n", att.ContainerID, att.Gateway, chosen)
+			att.Gateway = chosen
+			if err := store.save(att); err != nil {
+				return err
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("Failed to reconcile %d attachments.", failed)
+	}
+
+	return nil
+}
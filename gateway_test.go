@@ -0,0 +1,46 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Require both gateways.
+func TestParseGateways(t *testing.T) {
+	netconf := map[string]interface{}{
+		"gateways": map[string]interface{}{"primary": "10.2.0.1"},
+	}
+
+	_, err := parseGateways(netconf)
+
+	assert.Error(t, err)
+}
+
+// Prefer the primary, fall back to the secondary, and otherwise leave
+// the route alone.
+func TestChooseGateway(t *testing.T) {
+	gw := &gatewayConfig{Primary: "10.2.0.1", Secondary: "10.2.0.254"}
+
+	assert.Equal(t, "10.2.0.1", choose(true, true, gw, "10.2.0.254"))
+	assert.Equal(t, "10.2.0.254", choose(false, true, gw, "10.2.0.1"))
+	assert.Equal(t, "10.2.0.254", choose(false, false, gw, "10.2.0.254"))
+	assert.Equal(t, "10.2.0.1", choose(false, false, gw, ""))
+}
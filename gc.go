@@ -0,0 +1,164 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const defaultCNIPath = "/opt/cni/bin"
+
+// Return whether the network namespace of an attachment is gone, as
+// it is once its pod sandbox has been removed, or the node rebooted.
+func netnsGone(path string) bool {
+	if path == "" {
+		return true
+	}
+
+	return ns.IsNSorErr(path) != nil
+}
+
+// Return the attachments whose network namespace is gone.
+func findOrphans(attachments []*attachment) []*attachment {
+	var orphans []*attachment
+	for _, a := range attachments {
+		if netnsGone(a.Netns) {
+			orphans = append(orphans, a)
+		}
+	}
+
+	return orphans
+}
+
+// Tear down attachments whose pods are gone, running the delegate's
+// DEL for each so that IPAM leases and host-side state are released.
+func cmdGC(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	configPath := flags.String("cni-conf", "", "plugin config file (default stdin)")
+	flags.StringVar(configPath, "config", "", "alias for --cni-conf")
+	cniPath := flags.String("cni-path", defaultCNIPath, "directories to find delegate plugins in")
+	dryRun := flags.Bool("dry-run", false, "only list orphaned attachments")
+	force := flags.Bool("force", false, "forget orphaned attachments even if the delegate DEL fails")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := readConfig(*configPath, stdin)
+	if err != nil {
+		return err
+	}
+	config.setLogLevel()
+
+	store := newAttachmentStore(config.StateDir)
+	attachments, err := store.list()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, a := range findOrphans(attachments) {
+		fmt.Fprintf(stdout, "orphan %s (%s/%s, netns %s)\n", a.ContainerID, a.Namespace, a.Pod, a.Netns)
+		if *dryRun {
+			continue
+		}
+
+		cmdArgs := &skel.CmdArgs{
+			ContainerID: a.ContainerID,
+			IfName:      a.IfName,
+			Args:        kubeArgs(a.Namespace, a.Pod),
+			Path:        *cniPath,
+		}
+		env := &selector.DelegateEnv{
+			CNIPath: *cniPath,
+			Args: &invoke.Args{
+				Command:       "DEL",
+				ContainerID:   a.ContainerID,
+				PluginArgsStr: cmdArgs.Args,
+				IfName:        a.IfName,
+				Path:          *cniPath,
+			},
+		}
+
+		err := delNetwork(config, cmdArgs, env)
+		if err == nil {
+			fmt.Fprintf(stdout, "  removed\n")
+			continue
+		}
+
+		log.WithFields(logrus.Fields{
+			"container_id": a.ContainerID,
+			"error":        err,
+		}).Warn("Failed to remove orphaned attachment.")
+
+		if *force {
+			// DEL stopped short of kube-namespace's own cleanup.
+			config.releaseOrphan(a, cmdArgs, env)
+			if err := store.remove(a.ContainerID); err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, "  delegate DEL failed, forgotten: %v\n", err)
+			continue
+		}
+
+		fmt.Fprintf(stdout, "  delegate DEL failed: %v\n", err)
+		failed++
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("Failed to remove %d orphaned attachments.", failed)
+	}
+
+	return nil
+}
+
+// Release what kube-namespace set up around the delegate for an
+// orphaned attachment whose delegate DEL failed, as DEL does once it
+// succeeds.  The network is looked up again for what the attachment
+// does not record, such as where its DNS records are and how to
+// release its additional addresses; if the network is gone, only what
+// the attachment records is released.
+func (c *config) releaseOrphan(att *attachment, args *skel.CmdArgs, env *selector.DelegateEnv) {
+	sel, err := c.SelectNamed(att.Network, args.Args)
+	var options *netOptions
+	if err == nil {
+		options, err = parseNetOptions(sel.NetConf)
+	}
+	if err == nil && options.ifNames != nil {
+		args, env, err = options.ifNames.containerArgs(args, env, "DEL")
+	}
+	if err != nil {
+		c.Logger().WithFields(logrus.Fields{
+			"container_id": att.ContainerID,
+			"network":      att.Network,
+			"error":        err,
+		}).Warn("Network of orphaned attachment unavailable. Releasing what the attachment records.")
+		c.releaseRecorded(att)
+		return
+	}
+
+	c.releaseAttachment(sel, options, args, env, c.delegateNetConf(sel, options), att)
+}
@@ -0,0 +1,85 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Find attachments whose network namespace no longer exists.
+func TestFindOrphans(t *testing.T) {
+	live := &attachment{ContainerID: "live", Netns: fmt.Sprintf("/proc/%d/ns/net", os.Getpid())}
+	gone := &attachment{ContainerID: "gone", Netns: "/var/run/netns/nonexistent"}
+
+	orphans := findOrphans([]*attachment{live, gone})
+
+	assert.Equal(t, []*attachment{gone}, orphans)
+}
+
+// List orphans without removing them on a dry run.
+func TestCmdGCDryRun(t *testing.T) {
+	store := tempStore(t)
+	defer os.RemoveAll(store.dir)
+
+	assert.NoError(t, store.save(&attachment{ContainerID: "gone", Namespace: "isolated", Pod: "web-1", Netns: "/nonexistent"}))
+
+	config := fmt.Sprintf(`{"stateDir": %q, "default": {"name": "default", "type": "bridge"}}`, store.dir)
+	stdout := &bytes.Buffer{}
+	assert.NoError(t, cmdGC([]string{"--dry-run"}, strings.NewReader(config), stdout))
+
+	assert.Contains(t, stdout.String(), "orphan gone (isolated/web-1")
+	a, err := store.load("gone")
+	assert.NoError(t, err)
+	assert.NotNil(t, a)
+}
+
+// With --force, forget an orphan whose delegate DEL fails, releasing
+// what kube-namespace recorded for it.
+func TestCmdGCForce(t *testing.T) {
+	store := tempStore(t)
+	defer os.RemoveAll(store.dir)
+
+	assert.NoError(t, store.save(&attachment{ContainerID: "gone", Namespace: "web", Pod: "web-1", Netns: "/nonexistent", PTPAuto: true}))
+	allocator := newPTPAllocator(store.dir)
+	assert.NoError(t, allocator.update(func(state *ptpAutoState) error {
+		state.Blocks["web"] = "10.99.0.0/28"
+		state.Links["gone"] = ptpLink{Namespace: "web", Link: "10.99.0.0/31"}
+		state.Links["live"] = ptpLink{Namespace: "web", Link: "10.99.0.2/31"}
+		return nil
+	}))
+
+	config := fmt.Sprintf(`{"stateDir": %q, "default": {"name": "default", "type": "bridge"}}`, store.dir)
+	stdout := &bytes.Buffer{}
+	assert.NoError(t, cmdGC([]string{"--force", "--cni-path", store.dir}, strings.NewReader(config), stdout))
+
+	assert.Contains(t, stdout.String(), "forgotten")
+	a, err := store.load("gone")
+	assert.NoError(t, err)
+	assert.Nil(t, a)
+	assert.NoError(t, allocator.update(func(state *ptpAutoState) error {
+		assert.NotContains(t, state.Links, "gone")
+		assert.Contains(t, state.Links, "live")
+		return nil
+	}))
+}
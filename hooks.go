@@ -0,0 +1,195 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const defaultHookTimeoutMs = 10000
+
+// Hook events.
+const (
+	hookPreAdd  = "preAdd"
+	hookPostAdd = "postAdd"
+	hookPreDel  = "preDel"
+	hookPostDel = "postDel"
+)
+
+// Hook failure policies.
+const (
+	hookFail   = "fail"
+	hookIgnore = "ignore"
+)
+
+// Executables run around ADD and DEL for site-specific logic, such as
+// registering pod addresses in an external system.
+type hooksConfig struct {
+	PreAdd  []hookConfig `json:"preAdd"`
+	PostAdd []hookConfig `json:"postAdd"`
+	PreDel  []hookConfig `json:"preDel"`
+	PostDel []hookConfig `json:"postDel"`
+}
+
+// A hook executable.  With the "fail" policy, the default, a hook
+// exiting non-zero fails the operation; with "ignore" it is only
+// logged.
+type hookConfig struct {
+	Path          string `json:"path"`
+	FailurePolicy string `json:"failurePolicy"`
+	TimeoutMs     int    `json:"timeoutMs"`
+}
+
+// What a hook gets on stdin.
+type hookInput struct {
+	Event       string                 `json:"event"`
+	ContainerID string                 `json:"containerID"`
+	Netns       string                 `json:"netns"`
+	IfName      string                 `json:"ifName"`
+	Namespace   string                 `json:"namespace"`
+	Pod         string                 `json:"pod"`
+	Rule        string                 `json:"rule"`
+	Config      map[string]interface{} `json:"config"`
+	// The result so far, for postAdd.
+	Result *types.Result `json:"result,omitempty"`
+}
+
+// Validate the hooks, filling in defaults.
+func (h *hooksConfig) validate() error {
+	for event, hooks := range h.byEvent() {
+		for i := range hooks {
+			hook := &hooks[i]
+			if hook.Path == "" {
+				return fmt.Errorf("%s hook %d has no path.", event, i)
+			}
+
+			switch hook.FailurePolicy {
+			case "":
+				hook.FailurePolicy = hookFail
+			case hookFail, hookIgnore:
+			default:
+				return fmt.Errorf("%s hook %q: unknown failurePolicy %q.", event, hook.Path, hook.FailurePolicy)
+			}
+
+			if hook.TimeoutMs <= 0 {
+				hook.TimeoutMs = defaultHookTimeoutMs
+			}
+		}
+	}
+
+	return nil
+}
+
+func (h *hooksConfig) byEvent() map[string][]hookConfig {
+	return map[string][]hookConfig{
+		hookPreAdd:  h.PreAdd,
+		hookPostAdd: h.PostAdd,
+		hookPreDel:  h.PreDel,
+		hookPostDel: h.PostDel,
+	}
+}
+
+// Run the hooks for an event in order.  For postAdd, a hook printing
+// a result replaces the result with it, so later hooks and the runtime
+// see the replacement.
+func (h *hooksConfig) run(log *logrus.Entry, event string, args *skel.CmdArgs, sel *selection, netconf map[string]interface{}, result *selector.Result) (*selector.Result, error) {
+	if h == nil {
+		return result, nil
+	}
+
+	for _, hook := range h.byEvent()[event] {
+		var legacy *types.Result
+		if result != nil {
+			legacy = result.Result
+		}
+
+		input := &hookInput{
+			Event:       event,
+			ContainerID: args.ContainerID,
+			Netns:       args.Netns,
+			IfName:      args.IfName,
+			Namespace:   sel.Namespace,
+			Pod:         sel.Pod,
+			Rule:        sel.Rule,
+			Config:      netconf,
+			Result:      legacy,
+		}
+
+		out, err := hook.exec(input)
+		if err != nil {
+			if hook.FailurePolicy == hookIgnore {
+				log.WithFields(logrus.Fields{
+					"hook":  hook.Path,
+					"event": event,
+					"error": err,
+				}).Warn("Hook failed. Ignoring.")
+				continue
+			}
+			return nil, err
+		}
+
+		if event == hookPostAdd && len(bytes.TrimSpace(out)) > 0 {
+			if result, err = selector.ParsePluginResult(out); err != nil {
+				return nil, fmt.Errorf("Hook %q printed an invalid result: %v", hook.Path, err)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Run the hook with input on stdin, returning its stdout.
+func (hook *hookConfig) exec(input *hookInput) ([]byte, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(hook.TimeoutMs)*time.Millisecond)
+	defer cancel()
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, hook.Path)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = append(os.Environ(), "KUBE_NAMESPACE_HOOK="+input.Event)
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("%s hook %q failed: %v: %s", input.Event, hook.Path, err,
+			strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+	"github.com/stretchr/testify/assert"
+)
+
+// Write an executable hook script to dir.
+func writeHook(t *testing.T, dir, name, script string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatalf("Failed to write hook: %v", err)
+	}
+	return path
+}
+
+// Run hooks, replacing the result with a postAdd hook's output and
+// honoring failure policies.
+func TestHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-hooks")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	mutate := writeHook(t, dir, "mutate", `cat > /dev/null; echo '{"ip4": {"ip": "10.9.0.5/16"}}'`)
+	fail := writeHook(t, dir, "fail", "echo broken >&2; exit 1")
+	record := writeHook(t, dir, "record", "cat > "+filepath.Join(dir, "input.json"))
+
+	hooks := &hooksConfig{
+		PreAdd:  []hookConfig{{Path: fail, FailurePolicy: hookIgnore}, {Path: record}},
+		PostAdd: []hookConfig{{Path: mutate}},
+		PreDel:  []hookConfig{{Path: fail}},
+	}
+	assert.NoError(t, hooks.validate())
+
+	args := &skel.CmdArgs{ContainerID: "abc", IfName: "eth0"}
+	sel := &selection{Namespace: "tenant-a", Pod: "web-1", Rule: "tenant-a"}
+	netconf := map[string]interface{}{"type": "bridge"}
+
+	_, err = hooks.run(log, hookPreAdd, args, sel, netconf, nil)
+	assert.NoError(t, err)
+	input, _ := ioutil.ReadFile(filepath.Join(dir, "input.json"))
+	assert.Contains(t, string(input), `"event":"preAdd"`)
+	assert.Contains(t, string(input), `"pod":"web-1"`)
+
+	result, err := hooks.run(log, hookPostAdd, args, sel, netconf, &selector.Result{Result: &types.Result{}})
+	assert.NoError(t, err)
+	assert.Equal(t, "10.9.0.5/16", result.IP4.IP.String())
+
+	_, err = hooks.run(log, hookPreDel, args, sel, netconf, nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "broken")
+	}
+
+	var none *hooksConfig
+	result, err = none.run(log, hookPostAdd, args, sel, netconf, &selector.Result{Result: &types.Result{}})
+	assert.NoError(t, err)
+	assert.NotNil(t, result)
+}
+
+// Reject hooks without a path or with an unknown policy.
+func TestHooksValidate(t *testing.T) {
+	assert.Error(t, (&hooksConfig{PreAdd: []hookConfig{{}}}).validate())
+	assert.Error(t, (&hooksConfig{PostDel: []hookConfig{{Path: "/bin/true", FailurePolicy: "retry"}}}).validate())
+}
@@ -0,0 +1,409 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// A network config with a "hostDevice" block moves a host NIC into
+// each pod's network namespace, as the pod's interface, and moves it
+// back on DEL.  kube-namespace does this itself; no delegate is run.
+// The NIC is named, or matched by PCI address or driver, and must be
+// allowed for the pod's namespace by the top-level "hostDevices"
+// block.  The NIC a pod holds is recorded in its attachment, which is
+// how free NICs are told apart from those in use.
+
+// How long to wait for another ADD to pick a host device.
+const hostDeviceLockTimeout = 30 * time.Second
+
+// Which host NIC a network config passes through.
+type hostDeviceConfig struct {
+	Device     string `json:"device"`
+	PCIAddress string `json:"pciAddress"`
+	Driver     string `json:"driver"`
+}
+
+// The host NIC a pod holds, as recorded in its attachment.
+type hostDevice struct {
+	Name       string `json:"name"`
+	PCIAddress string `json:"pciAddress,omitempty"`
+	Driver     string `json:"driver,omitempty"`
+}
+
+// A host NIC allowed for some namespace, and the pod holding it, as
+// listed by "status --devices".
+type hostDeviceUsage struct {
+	hostDevice
+	Namespaces  []string `json:"namespaces"`
+	ContainerID string   `json:"containerID,omitempty"`
+	Pod         string   `json:"pod,omitempty"`
+}
+
+// Parse the "hostDevice" block of a network config.
+func parseHostDevice(netconf map[string]interface{}) (*hostDeviceConfig, error) {
+	h := &hostDeviceConfig{}
+	if ok, err := decodeNetConfKey(netconf, "hostDevice", h); !ok || err != nil {
+		return nil, err
+	}
+
+	if h.Device == "" && h.PCIAddress == "" && h.Driver == "" {
+		return nil, errors.New("hostDevice needs a device, pciAddress or driver.")
+	}
+	if h.Device != "" && h.PCIAddress != "" {
+		return nil, errors.New("hostDevice sets both device and pciAddress.")
+	}
+
+	return h, nil
+}
+
+// Return whether a NIC is the one, or one of those, a config asks for.
+func (h *hostDeviceConfig) matches(dev *hostDevice) bool {
+	return (h.Device == "" || h.Device == dev.Name) &&
+		(h.PCIAddress == "" || h.PCIAddress == dev.PCIAddress) &&
+		(h.Driver == "" || h.Driver == dev.Driver)
+}
+
+// Return whether a NIC is in an allow-list of names and PCI addresses.
+func allowsHostDevice(allowed []string, dev *hostDevice) bool {
+	for _, a := range allowed {
+		if a == dev.Name || (dev.PCIAddress != "" && a == dev.PCIAddress) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// List the host's NICs that have a device, with their PCI address and
+// driver, sorted by name.
+func listHostDevices() ([]*hostDevice, error) {
+	links, err := ioutil.ReadDir(sysClassNet)
+	if err != nil {
+		return nil, err
+	}
+
+	var devs []*hostDevice
+	for _, link := range links {
+		device, err := filepath.EvalSymlinks(filepath.Join(sysClassNet, link.Name(), "device"))
+		if err != nil {
+			// Virtual interfaces have none.
+			continue
+		}
+
+		dev := &hostDevice{Name: link.Name()}
+		if strings.Contains(device, "/pci") {
+			dev.PCIAddress = filepath.Base(device)
+		}
+		if driver, err := filepath.EvalSymlinks(filepath.Join(device, "driver")); err == nil {
+			dev.Driver = filepath.Base(driver)
+		}
+		devs = append(devs, dev)
+	}
+
+	sort.Slice(devs, func(i, j int) bool { return devs[i].Name < devs[j].Name })
+	return devs, nil
+}
+
+// Return the NICs held by attachments other than the container's.
+func usedHostDevices(attachments []*attachment, containerID string) map[string]*attachment {
+	used := map[string]*attachment{}
+	for _, att := range attachments {
+		if att.HostDevice != nil && att.ContainerID != containerID {
+			used[att.HostDevice.Name] = att
+			if att.HostDevice.PCIAddress != "" {
+				used[att.HostDevice.PCIAddress] = att
+			}
+		}
+	}
+
+	return used
+}
+
+// Pick a free, allowed NIC matching the config for the pod, recording
+// it in a partial attachment, as reserveVF does, so that concurrent
+// ADDs cannot pick the same one.  A pod that already holds one keeps
+// it.
+func (c *config) reserveHostDevice(h *hostDeviceConfig, sel *selection, args *skel.CmdArgs) (*attachment, error) {
+	store := newAttachmentStore(c.StateDir)
+	release, err := acquireSlot(c.Logger(), filepath.Join(store.dir, "locks"), "host-device", 1, hostDeviceLockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	att, err := store.load(args.ContainerID)
+	if err != nil {
+		return nil, err
+	}
+	if att != nil && att.HostDevice != nil {
+		return att, nil
+	}
+
+	devs, err := listHostDevices()
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := store.list()
+	if err != nil {
+		return nil, err
+	}
+	used := usedHostDevices(attachments, args.ContainerID)
+
+	allowed := c.HostDevices[sel.Namespace]
+	for _, dev := range devs {
+		if !h.matches(dev) || !allowsHostDevice(allowed, dev) || used[dev.Name] != nil {
+			continue
+		}
+		if dev.PCIAddress != "" && used[dev.PCIAddress] != nil {
+			continue
+		}
+
+		if att == nil {
+			att = &attachment{
+				ContainerID:     args.ContainerID,
+				Namespace:       sel.Namespace,
+				Pod:             sel.Pod,
+				Netns:           args.Netns,
+				IfName:          args.IfName,
+				networkMetadata: newNetworkMetadata(sel),
+				Created:         time.Now().UTC(),
+			}
+		}
+		att.HostDevice = dev
+		if err := store.save(att); err != nil {
+			return nil, err
+		}
+
+		return att, nil
+	}
+
+	c.Logger().WithField("namespace", sel.Namespace).Warn("Rejecting pod: no free host device.")
+	return nil, newError(errCodeDeviceUnavailable, "No free host device matching %+v is allowed for namespace %q.", *h, sel.Namespace)
+}
+
+// Move a pod's NIC into its network namespace as its interface, and
+// write the result, which has no addresses, to stdout.
+func (c *config) addHostDevice(h *hostDeviceConfig, sel *selection, args *skel.CmdArgs, stdout io.Writer) error {
+	att, err := c.reserveHostDevice(h, sel, args)
+	if err != nil {
+		return err
+	}
+	dev := att.HostDevice
+
+	// An interrupted ADD may have moved it already.
+	if _, err := os.Stat(filepath.Join(sysClassNet, dev.Name)); err == nil {
+		podNS, err := ns.GetNS(args.Netns)
+		if err != nil {
+			return err
+		}
+		err = linkSetNetns(dev.Name, podNS.Fd())
+		podNS.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = withNetNS(args.Netns, func() error {
+		// An interrupted ADD may have renamed it already.
+		if _, err := linkIndex(args.IfName); err != nil {
+			if err := linkRename(dev.Name, args.IfName); err != nil {
+				return err
+			}
+		}
+		return linkSetUp(args.IfName)
+	})
+	if err != nil {
+		return err
+	}
+
+	att.Result = &types.Result{}
+	if err := newAttachmentStore(c.StateDir).save(att); err != nil {
+		return err
+	}
+
+	if c.AuditLog != "" {
+		if err := writeAudit(c.AuditLog, newAddAuditRecord(sel, att)); err != nil {
+			return err
+		}
+	}
+
+	c.Logger().WithFields(logrus.Fields{
+		"device":      dev.Name,
+		"pci_address": dev.PCIAddress,
+	}).Info("Moved host device into pod.")
+
+	result := newResult(att)
+	result.cniVersion = c.CNIVersion
+	return result.print(stdout)
+}
+
+// Move a pod's NIC back to the host under its own name, and forget
+// the attachment.
+func (c *config) delHostDevice(sel *selection, args *skel.CmdArgs) error {
+	store := newAttachmentStore(c.StateDir)
+	att, err := store.load(args.ContainerID)
+	if err != nil {
+		return err
+	}
+	if att == nil || att.HostDevice == nil {
+		return nil
+	}
+
+	if err := restoreHostDevice(c.Logger(), att.HostDevice, att.Netns, att.IfName); err != nil {
+		return err
+	}
+
+	if err := store.remove(args.ContainerID); err != nil {
+		return err
+	}
+
+	if c.AuditLog != "" {
+		if err := writeAudit(c.AuditLog, newAuditRecord(auditDel, att)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Move a NIC from the pod's network namespace back to the host's, and
+// give it back its name, unless it is still on the host.  It is
+// renamed before it leaves, so that it cannot clash with a host
+// interface called ifName.  If the namespace is gone, the kernel has
+// moved the NIC back already, possibly renamed, and it is found by its
+// PCI address.
+func restoreHostDevice(log *logrus.Entry, dev *hostDevice, netns, ifName string) error {
+	// After an interrupted ADD, it may never have left.
+	if _, err := os.Stat(filepath.Join(sysClassNet, dev.Name)); err == nil {
+		return nil
+	}
+
+	hostNS, err := ns.GetCurrentNS()
+	if err != nil {
+		return err
+	}
+	defer hostNS.Close()
+
+	name := dev.Name
+	err = withNetNS(netns, func() error {
+		// An interrupted DEL may have renamed it already.
+		current := ifName
+		if _, err := linkIndex(dev.Name); err == nil {
+			current = dev.Name
+		}
+
+		if err := linkSetDown(current); err != nil {
+			return err
+		}
+		if current != dev.Name {
+			if err := linkRename(current, dev.Name); err != nil {
+				return err
+			}
+		}
+		return linkSetNetns(dev.Name, hostNS.Fd())
+	})
+	if _, gone := err.(ns.NSPathNotExistErr); gone || netns == "" {
+		if name, err = findHostDevice(dev); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if name != dev.Name {
+		if err := linkRename(name, dev.Name); err != nil {
+			return err
+		}
+	}
+
+	log.WithField("device", dev.Name).Info("Moved host device back to the host.")
+	return nil
+}
+
+// Return the current name of a NIC back on the host.
+func findHostDevice(dev *hostDevice) (string, error) {
+	devs, err := listHostDevices()
+	if err != nil {
+		return "", err
+	}
+
+	for _, d := range devs {
+		if d.Name == dev.Name || (dev.PCIAddress != "" && d.PCIAddress == dev.PCIAddress) {
+			return d.Name, nil
+		}
+	}
+
+	return "", fmt.Errorf("Host device %s is neither in the pod nor on the host.", dev.Name)
+}
+
+// Return the allowed NICs on the host and the pods holding them,
+// sorted by name.
+func (c *config) hostDeviceUsage(attachments []*attachment) ([]hostDeviceUsage, error) {
+	devs, err := listHostDevices()
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]*hostDevice{}
+	for _, dev := range devs {
+		byName[dev.Name] = dev
+	}
+	// NICs in pods are not on the host.
+	used := usedHostDevices(attachments, "")
+	for _, att := range attachments {
+		if att.HostDevice != nil {
+			byName[att.HostDevice.Name] = att.HostDevice
+		}
+	}
+
+	usage := []hostDeviceUsage{}
+	for _, dev := range byName {
+		u := hostDeviceUsage{hostDevice: *dev, Namespaces: []string{}}
+		for namespace, allowed := range c.HostDevices {
+			if allowsHostDevice(allowed, dev) {
+				u.Namespaces = append(u.Namespaces, namespace)
+			}
+		}
+		if len(u.Namespaces) == 0 {
+			continue
+		}
+		sort.Strings(u.Namespaces)
+
+		if att := used[dev.Name]; att != nil {
+			u.ContainerID, u.Pod = att.ContainerID, att.Namespace+"/"+att.Pod
+		}
+		usage = append(usage, u)
+	}
+
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Name < usage[j].Name })
+	return usage, nil
+}
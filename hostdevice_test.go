@@ -0,0 +1,171 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+	"github.com/stretchr/testify/assert"
+)
+
+// Fake the sysfs entries of NICs, by name, PCI address and driver.
+func fakeHostDevices(t *testing.T, dir string, devs ...hostDevice) {
+	sysClassNet = filepath.Join(dir, "class", "net")
+	for _, dev := range devs {
+		device := filepath.Join(dir, "devices", "pci0000:00", dev.PCIAddress)
+		driver := filepath.Join(dir, "bus", "pci", "drivers", dev.Driver)
+		for _, d := range []string{device, driver, filepath.Join(sysClassNet, dev.Name)} {
+			assert.NoError(t, os.MkdirAll(d, 0755))
+		}
+		assert.NoError(t, os.Symlink(driver, filepath.Join(device, "driver")))
+		assert.NoError(t, os.Symlink(device, filepath.Join(sysClassNet, dev.Name, "device")))
+	}
+	// A virtual interface, without a device.
+	assert.NoError(t, os.MkdirAll(filepath.Join(sysClassNet, "cni0"), 0755))
+}
+
+// Require a device, PCI address or driver to match.
+func TestParseHostDevice(t *testing.T) {
+	h, err := parseHostDevice(map[string]interface{}{"hostDevice": map[string]interface{}{"driver": "mlx5_core"}})
+	assert.NoError(t, err)
+	assert.Equal(t, &hostDeviceConfig{Driver: "mlx5_core"}, h)
+
+	h, err = parseHostDevice(map[string]interface{}{"type": "bridge"})
+	assert.NoError(t, err)
+	assert.Nil(t, h)
+
+	_, err = parseHostDevice(map[string]interface{}{"hostDevice": map[string]interface{}{}})
+	assert.EqualError(t, err, "hostDevice needs a device, pciAddress or driver.")
+
+	_, err = parseHostDevice(map[string]interface{}{"hostDevice": map[string]interface{}{"device": "ens1f0", "pciAddress": "0000:03:00.0"}})
+	assert.Error(t, err)
+}
+
+// Pick free NICs allowed for the namespace, keeping a pod's NIC across
+// ADDs, and list who holds them.
+func TestReserveHostDevice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-hostdevice")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func() { sysClassNet = "/sys/class/net" }()
+
+	fakeHostDevices(t, dir,
+		hostDevice{Name: "ens1f0", PCIAddress: "0000:03:00.0", Driver: "mlx5_core"},
+		hostDevice{Name: "ens1f1", PCIAddress: "0000:03:00.1", Driver: "mlx5_core"},
+		hostDevice{Name: "eno1", PCIAddress: "0000:01:00.0", Driver: "igb"})
+
+	c := &config{
+		StateDir:    filepath.Join(dir, "state"),
+		HostDevices: map[string][]string{"dpdk": {"ens1f0", "0000:03:00.1", "eno1"}, "other": {"ens1f1"}},
+	}
+	h := &hostDeviceConfig{Driver: "mlx5_core"}
+	sel := &selection{Namespace: "dpdk", Pod: "pktgen-1"}
+
+	att, err := c.reserveHostDevice(h, sel, &skel.CmdArgs{ContainerID: "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, &hostDevice{Name: "ens1f0", PCIAddress: "0000:03:00.0", Driver: "mlx5_core"}, att.HostDevice)
+
+	att, err = c.reserveHostDevice(h, sel, &skel.CmdArgs{ContainerID: "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, "ens1f0", att.HostDevice.Name)
+
+	att, err = c.reserveHostDevice(h, sel, &skel.CmdArgs{ContainerID: "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, "ens1f1", att.HostDevice.Name)
+
+	_, err = c.reserveHostDevice(h, sel, &skel.CmdArgs{ContainerID: "c"})
+	assert.True(t, selector.Is(err, selector.ErrDeviceUnavailable))
+
+	// Not allowed for the namespace.
+	_, err = c.reserveHostDevice(&hostDeviceConfig{Device: "eno1"}, &selection{Namespace: "other"}, &skel.CmdArgs{ContainerID: "d"})
+	assert.True(t, selector.Is(err, selector.ErrDeviceUnavailable))
+
+	attachments, err := newAttachmentStore(c.StateDir).list()
+	assert.NoError(t, err)
+	usage, err := c.hostDeviceUsage(attachments)
+	assert.NoError(t, err)
+	if assert.Len(t, usage, 3) {
+		assert.Equal(t, "eno1", usage[0].Name)
+		assert.Equal(t, "", usage[0].Pod)
+		assert.Equal(t, "dpdk/pktgen-1", usage[1].Pod)
+		assert.Equal(t, []string{"dpdk", "other"}, usage[2].Namespaces)
+	}
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, printHostDeviceUsage(usage, "text", out))
+	assert.Contains(t, out.String(), "ens1f1  0000:03:00.1  mlx5_core  dpdk,other  dpdk/pktgen-1")
+}
+
+// Move a NIC back to the host under its own name, even if a host
+// interface has the name it had in the pod, and after a DEL that was
+// interrupted once it was renamed.
+func TestRestoreHostDevice(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Creating network namespaces needs root.")
+	}
+
+	dir, err := ioutil.TempDir("", "kube-namespace-hostdevice")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func() { sysClassNet = "/sys/class/net" }()
+	fakeHostDevices(t, dir)
+
+	hostNS, err := ns.NewNS()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer hostNS.Close()
+
+	for i, podName := range []string{"eth0", "nic0"} {
+		podNS, err := ns.NewNS()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer podNS.Close()
+
+		assert.NoError(t, withNetNS(podNS.Path(), func() error {
+			_, err := runCommand("ip", "link", "add", podName, "type", "veth", "peer", "name", "peer0")
+			return err
+		}))
+
+		err = hostNS.Do(func(ns.NetNS) error {
+			if i == 0 {
+				if _, err := runCommand("ip", "link", "add", "eth0", "type", "veth", "peer", "name", "peer0"); err != nil {
+					return err
+				}
+			}
+			if err := restoreHostDevice(log, &hostDevice{Name: "nic0"}, podNS.Path(), "eth0"); err != nil {
+				return err
+			}
+			_, err := net.InterfaceByName("nic0")
+			if err == nil {
+				_, err = runCommand("ip", "link", "del", "nic0")
+			}
+			return err
+		})
+		assert.NoError(t, err, podName)
+	}
+}
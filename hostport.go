@@ -0,0 +1,213 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// How hostPort mappings passed by the runtime are handled, set by the
+// "hostPorts" key of a network config.
+const (
+	// Program DNAT rules for the mappings.  The default.
+	hostPortsDNAT = "dnat"
+	// Pass the mappings on to the delegate as runtimeConfig, e.g. for
+	// a delegate that handles them itself.
+	hostPortsDelegate = "delegate"
+	// Ignore the mappings.
+	hostPortsOff = "off"
+)
+
+// The runtime config kube-namespace takes, per the CNI conventions for
+// capability arguments.  The runtime only passes it if the plugin's
+// entry in a .conflist declares the capability.
+type runtimeConfig struct {
+	PortMappings []portMapping `json:"portMappings,omitempty"`
+	// The node's pod CIDRs; see podcidr.go.
+	IPRanges [][]ipRange `json:"ipRanges,omitempty"`
+}
+
+// A hostPort mapping, in the format of the portMappings capability.
+type portMapping struct {
+	HostPort      int    `json:"hostPort"`
+	ContainerPort int    `json:"containerPort"`
+	Protocol      string `json:"protocol"`
+	HostIP        string `json:"hostIP,omitempty"`
+}
+
+// Validate the mappings passed by the runtime, defaulting the
+// protocol to tcp.
+func validatePortMappings(mappings []portMapping) error {
+	for i := range mappings {
+		m := &mappings[i]
+
+		m.Protocol = strings.ToLower(m.Protocol)
+		if m.Protocol == "" {
+			m.Protocol = "tcp"
+		}
+		if m.Protocol != "tcp" && m.Protocol != "udp" && m.Protocol != "sctp" {
+			return fmt.Errorf("Invalid protocol %q in port mapping %d.", m.Protocol, i)
+		}
+
+		if m.HostPort <= 0 || m.HostPort > 65535 || m.ContainerPort <= 0 || m.ContainerPort > 65535 {
+			return fmt.Errorf("Invalid ports in port mapping %d.", i)
+		}
+
+		if m.HostIP != "" && net.ParseIP(m.HostIP) == nil {
+			return fmt.Errorf("Invalid host IP %q in port mapping %d.", m.HostIP, i)
+		}
+	}
+
+	return nil
+}
+
+// Parse the "hostPorts" key of a network config.
+func parseHostPorts(netconf map[string]interface{}) (string, error) {
+	mode := hostPortsDNAT
+	if _, err := decodeNetConfKey(netconf, "hostPorts", &mode); err != nil {
+		return "", err
+	}
+
+	switch mode {
+	case hostPortsDNAT, hostPortsDelegate, hostPortsOff:
+		return mode, nil
+	}
+
+	return "", fmt.Errorf("Invalid hostPorts %q; must be dnat, delegate or off.", mode)
+}
+
+// Return the name of the hostPort chain for a container.
+func hostPortChain(containerID string) string {
+	return "KN-HOSTPORT-" + shortHash(containerID)
+}
+
+// Return the iptables rules, as argument lists for -A, that make up
+// the hostPort chain for a pod address.
+func hostPortChainRules(chain string, mappings []portMapping, podIP net.IP) [][]string {
+	ipv6 := podIP.To4() == nil
+
+	var specs [][]string
+	for _, m := range mappings {
+		spec := []string{chain, "-p", m.Protocol, "--dport", strconv.Itoa(m.HostPort)}
+		if m.HostIP != "" {
+			hostIP := net.ParseIP(m.HostIP)
+			if (hostIP.To4() == nil) != ipv6 {
+				continue
+			}
+			spec = append(spec, "-d", m.HostIP)
+		}
+
+		dest := net.JoinHostPort(podIP.String(), strconv.Itoa(m.ContainerPort))
+		specs = append(specs, append(spec, "-j", "DNAT", "--to-destination", dest))
+	}
+
+	return specs
+}
+
+// Install DNAT rules forwarding the pod's hostPorts to it, for traffic
+// to local addresses, whether arriving or generated on the host.
+func installHostPorts(log *logrus.Entry, containerID string, mappings []portMapping, result *types.Result) error {
+	chain := hostPortChain(containerID)
+	comment := "kube-namespace:" + containerID
+
+	for _, ipc := range []*types.IPConfig{result.IP4, result.IP6} {
+		if ipc == nil {
+			continue
+		}
+
+		iptables := iptablesCommand(ipc.IP.IP.To4() == nil)
+
+		// The chain may be left over from an earlier, failed ADD.
+		runCommand(iptables, "-w", "-t", "nat", "-N", chain)
+		if _, err := runCommand(iptables, "-w", "-t", "nat", "-F", chain); err != nil {
+			return err
+		}
+
+		for _, spec := range hostPortChainRules(chain, mappings, ipc.IP.IP) {
+			if _, err := runCommand(iptables, append([]string{"-w", "-t", "nat", "-A"}, spec...)...); err != nil {
+				return err
+			}
+		}
+
+		for _, from := range []string{"PREROUTING", "OUTPUT"} {
+			jump := []string{from, "-m", "addrtype", "--dst-type", "LOCAL",
+				"-m", "comment", "--comment", comment, "-j", chain}
+			if _, err := runCommand(iptables, append([]string{"-w", "-t", "nat", "-C"}, jump...)...); err != nil {
+				if _, err := runCommand(iptables, append([]string{"-w", "-t", "nat", "-I"}, jump...)...); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		"chain":    chain,
+		"mappings": len(mappings),
+	}).Debug("Installed hostPort rules.")
+
+	return nil
+}
+
+// Remove the rules in the nat table's chain from that jump to chain.
+func removeNATJumps(log *logrus.Entry, iptables, from, chain string) {
+	out, err := runCommand(iptables, "-w", "-t", "nat", "-S", from)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"chain": from,
+			"error": err,
+		}).Warn("Failed to list jump rules.")
+		return
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "-A" || fields[len(fields)-1] != chain {
+			continue
+		}
+
+		fields[0] = "-D"
+		if _, err := runCommand(iptables, append([]string{"-w", "-t", "nat"}, fields...)...); err != nil {
+			log.WithField("error", err).Warn("Failed to remove jump rule.")
+		}
+	}
+}
+
+// Remove a pod's hostPort chain and the rules that jump to it.
+func teardownHostPorts(log *logrus.Entry, containerID string) {
+	chain := hostPortChain(containerID)
+
+	for _, ipv6 := range []bool{false, true} {
+		iptables := iptablesCommand(ipv6)
+
+		for _, from := range []string{"PREROUTING", "OUTPUT"} {
+			removeNATJumps(log, iptables, from, chain)
+		}
+
+		// The chain only exists if the pod had an address of this family.
+		if _, err := runCommand(iptables, "-w", "-t", "nat", "-F", chain); err == nil {
+			runCommand(iptables, "-w", "-t", "nat", "-X", chain)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Render DNAT rules for each address family.
+func TestHostPortChainRules(t *testing.T) {
+	mappings := []portMapping{
+		{HostPort: 8080, ContainerPort: 80},
+		{HostPort: 5353, ContainerPort: 53, Protocol: "UDP", HostIP: "192.0.2.10"},
+	}
+	assert.NoError(t, validatePortMappings(mappings))
+
+	assert.Equal(t, [][]string{
+		{"C", "-p", "tcp", "--dport", "8080", "-j", "DNAT", "--to-destination", "10.2.0.5:80"},
+		{"C", "-p", "udp", "--dport", "5353", "-d", "192.0.2.10", "-j", "DNAT", "--to-destination", "10.2.0.5:53"},
+	}, hostPortChainRules("C", mappings, net.ParseIP("10.2.0.5")))
+
+	assert.Equal(t, [][]string{
+		{"C", "-p", "tcp", "--dport", "8080", "-j", "DNAT", "--to-destination", "[fd00::5]:80"},
+	}, hostPortChainRules("C", mappings, net.ParseIP("fd00::5")))
+}
+
+// Reject invalid mappings.
+func TestValidatePortMappings(t *testing.T) {
+	assert.Error(t, validatePortMappings([]portMapping{{HostPort: 0, ContainerPort: 80}}))
+	assert.Error(t, validatePortMappings([]portMapping{{HostPort: 80, ContainerPort: 80, Protocol: "icmp"}}))
+	assert.Error(t, validatePortMappings([]portMapping{{HostPort: 80, ContainerPort: 80, HostIP: "nope"}}))
+}
+
+// Default to DNAT, and hand mappings to the delegate when asked to.
+func TestHostPortsMode(t *testing.T) {
+	config, err := parseConfig([]byte(`{
+	  "runtimeConfig": {"portMappings": [{"hostPort": 8080, "containerPort": 80}]},
+	  "namespaces": {
+	    "dnat": {"name": "dnat", "type": "bridge"},
+	    "delegated": {"name": "delegated", "type": "bridge", "hostPorts": "delegate"}
+	  }
+	}`))
+	assert.NoError(t, err)
+
+	sel, _ := config.Select("K8S_POD_NAMESPACE=dnat")
+	options, err := parseNetOptions(sel.NetConf)
+	assert.NoError(t, err)
+	assert.Equal(t, hostPortsDNAT, options.hostPorts)
+	assert.NotContains(t, config.delegateNetConf(sel, options), "runtimeConfig")
+
+	sel, _ = config.Select("K8S_POD_NAMESPACE=delegated")
+	options, err = parseNetOptions(sel.NetConf)
+	assert.NoError(t, err)
+	netconf := config.delegateNetConf(sel, options)
+	assert.NotContains(t, netconf, "hostPorts")
+	assert.Equal(t, runtimeConfig{PortMappings: config.RuntimeConfig.PortMappings}, netconf["runtimeConfig"])
+
+	_, err = parseNetOptions(map[string]interface{}{"hostPorts": "maybe"})
+	assert.Error(t, err)
+}
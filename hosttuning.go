@@ -0,0 +1,173 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Roots of the host's sysctl and sysfs trees.  Variables for tests.
+var (
+	procSysDir  = "/proc/sys"
+	sysClassNet = "/sys/class/net"
+)
+
+// The "hostTuning" block of a network config: host-side neighbor
+// table and bridge parameters, for namespaces large enough to
+// overflow the defaults.
+type hostTuning struct {
+	// Seconds a learned MAC stays in the bridge's forwarding table.
+	AgeingTime *int `json:"ageingTime"`
+	// Seconds before a stale neighbor entry on the bridge may be
+	// garbage collected.
+	GCStaleTime *int `json:"gcStaleTime"`
+
+	// Neighbor table garbage collection thresholds.  These are host
+	// wide, so they are only ever raised, never lowered.
+	GCThresh1 int `json:"gcThresh1"`
+	GCThresh2 int `json:"gcThresh2"`
+	GCThresh3 int `json:"gcThresh3"`
+}
+
+// A host setting to write.
+type hostSetting struct {
+	path  string
+	value int
+	// Only write the value if it is higher than the current one.
+	raiseOnly bool
+	// Ignore the setting if the file does not exist, e.g. for IPv6
+	// when it is disabled.
+	optional bool
+}
+
+// Parse the "hostTuning" block of a network config.
+func parseHostTuning(netconf map[string]interface{}) (*hostTuning, error) {
+	t := &hostTuning{}
+	if ok, err := decodeNetConfKey(netconf, "hostTuning", t); !ok || err != nil {
+		return nil, err
+	}
+
+	if (t.AgeingTime != nil || t.GCStaleTime != nil) && netconf["type"] != "bridge" {
+		return nil, fmt.Errorf("hostTuning ageingTime and gcStaleTime need the bridge delegate.")
+	}
+	if (t.AgeingTime != nil && *t.AgeingTime < 0) || (t.GCStaleTime != nil && *t.GCStaleTime < 0) {
+		return nil, fmt.Errorf("hostTuning times must not be negative.")
+	}
+
+	thresholds := []int{t.GCThresh1, t.GCThresh2, t.GCThresh3}
+	for i, n := range thresholds {
+		if n < 0 {
+			return nil, fmt.Errorf("Invalid hostTuning gcThresh%d %d.", i+1, n)
+		}
+		for _, m := range thresholds[i+1:] {
+			if n > 0 && m > 0 && n > m {
+				return nil, fmt.Errorf("hostTuning gc thresholds must not decrease.")
+			}
+		}
+	}
+
+	return t, nil
+}
+
+// Return the settings to write for a pod on the given bridge.
+func (t *hostTuning) settings(bridge string) []hostSetting {
+	var settings []hostSetting
+
+	if t.AgeingTime != nil {
+		// In hundredths of a second.
+		settings = append(settings, hostSetting{
+			path:  filepath.Join(sysClassNet, bridge, "bridge", "ageing_time"),
+			value: *t.AgeingTime * 100,
+		})
+	}
+
+	for _, family := range []string{"ipv4", "ipv6"} {
+		neigh := filepath.Join(procSysDir, "net", family, "neigh")
+
+		if t.GCStaleTime != nil {
+			settings = append(settings, hostSetting{
+				path:     filepath.Join(neigh, bridge, "gc_stale_time"),
+				value:    *t.GCStaleTime,
+				optional: family == "ipv6",
+			})
+		}
+
+		for i, n := range []int{t.GCThresh1, t.GCThresh2, t.GCThresh3} {
+			if n > 0 {
+				settings = append(settings, hostSetting{
+					path:      filepath.Join(neigh, "default", fmt.Sprintf("gc_thresh%d", i+1)),
+					value:     n,
+					raiseOnly: true,
+					optional:  family == "ipv6",
+				})
+			}
+		}
+	}
+
+	return settings
+}
+
+// Apply the tuning for a pod using netconf.
+func (t *hostTuning) apply(log *logrus.Entry, netconf map[string]interface{}) error {
+	var bridge string
+	if netconf["type"] == "bridge" {
+		bridge = bridgeName(netconf)
+	}
+
+	for _, s := range t.settings(bridge) {
+		if err := s.write(log); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Write the setting, if needed.
+func (s *hostSetting) write(log *logrus.Entry) error {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) && s.optional {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Failed to read %q: %v", s.path, err)
+	}
+
+	current, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err == nil && (current == s.value || (s.raiseOnly && current > s.value)) {
+		return nil
+	}
+
+	if err := ioutil.WriteFile(s.path, []byte(strconv.Itoa(s.value)), 0644); err != nil {
+		return fmt.Errorf("Failed to write %q: %v", s.path, err)
+	}
+
+	log.WithFields(logrus.Fields{
+		"path":  s.path,
+		"old":   current,
+		"value": s.value,
+	}).Debug("Tuned host setting.")
+
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Parse and validate the hostTuning block.
+func TestParseHostTuning(t *testing.T) {
+	tuning, err := parseHostTuning(map[string]interface{}{
+		"type":       "bridge",
+		"hostTuning": map[string]interface{}{"ageingTime": 60, "gcThresh3": 8192},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 60, *tuning.AgeingTime)
+	assert.Equal(t, 8192, tuning.GCThresh3)
+
+	_, err = parseHostTuning(map[string]interface{}{
+		"type":       "macvlan",
+		"hostTuning": map[string]interface{}{"ageingTime": 60},
+	})
+	assert.Error(t, err)
+
+	_, err = parseHostTuning(map[string]interface{}{
+		"type":       "bridge",
+		"hostTuning": map[string]interface{}{"gcThresh2": 4096, "gcThresh3": 1024},
+	})
+	assert.Error(t, err)
+}
+
+// Write bridge settings, and only ever raise the gc thresholds.
+func TestHostTuningApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-tuning")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	procSysDir, sysClassNet = filepath.Join(dir, "proc"), filepath.Join(dir, "sys")
+	defer func() { procSysDir, sysClassNet = "/proc/sys", "/sys/class/net" }()
+
+	files := map[string]string{
+		filepath.Join(sysClassNet, "br-big", "bridge", "ageing_time"):                "30000",
+		filepath.Join(procSysDir, "net", "ipv4", "neigh", "br-big", "gc_stale_time"): "60",
+		filepath.Join(procSysDir, "net", "ipv4", "neigh", "default", "gc_thresh1"):   "2048",
+		filepath.Join(procSysDir, "net", "ipv4", "neigh", "default", "gc_thresh3"):   "1024",
+	}
+	for path, value := range files {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(value+"\n"), 0644))
+	}
+
+	ageing, stale := 60, 120
+	tuning := &hostTuning{AgeingTime: &ageing, GCStaleTime: &stale, GCThresh1: 1024, GCThresh3: 8192}
+	assert.NoError(t, tuning.apply(log, map[string]interface{}{"type": "bridge", "bridge": "br-big"}))
+
+	expected := []string{"6000", "120", "2048\n", "8192"}
+	i := 0
+	for _, path := range []string{
+		filepath.Join(sysClassNet, "br-big", "bridge", "ageing_time"),
+		filepath.Join(procSysDir, "net", "ipv4", "neigh", "br-big", "gc_stale_time"),
+		filepath.Join(procSysDir, "net", "ipv4", "neigh", "default", "gc_thresh1"),
+		filepath.Join(procSysDir, "net", "ipv4", "neigh", "default", "gc_thresh3"),
+	} {
+		data, err := ioutil.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, expected[i], string(data))
+		i++
+	}
+}
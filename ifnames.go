@@ -0,0 +1,209 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// The longest interface name Linux accepts.
+const maxIfNameLen = 15
+
+// The longest host veth prefix, leaving room for 8 hex digits of
+// hash.
+const maxHostPrefixLen = maxIfNameLen - 8
+
+// How many names to try for a host veth before giving up.
+const hostNameAttempts = 8
+
+// Names of the pod's interfaces.  Container replaces the interface
+// name the runtime asks for, unless it is one of Allowed, shell
+// patterns of the names the runtime may use; without Container, other
+// names are refused.  HostPrefix renames the host end of the pod's
+// veth to the prefix followed by a hash of the pod's namespace and
+// name.
+type ifNamesConfig struct {
+	Container  string   `json:"container"`
+	Allowed    []string `json:"allowed"`
+	HostPrefix string   `json:"hostPrefix"`
+}
+
+// Return an error if name cannot be an interface name.
+func validIfName(name string) error {
+	if name == "" || len(name) > maxIfNameLen || name == "." || name == ".." ||
+		strings.ContainsAny(name, "/: \t\n") {
+		return fmt.Errorf("Invalid interface name %q.", name)
+	}
+
+	return nil
+}
+
+// Parse the "interfaceNames" block of a network config.
+func parseIfNames(netconf map[string]interface{}) (*ifNamesConfig, error) {
+	n := &ifNamesConfig{}
+	if ok, err := decodeNetConfKey(netconf, "interfaceNames", n); !ok || err != nil {
+		return nil, err
+	}
+
+	if n.Container == "" && n.HostPrefix == "" && len(n.Allowed) == 0 {
+		return nil, errors.New("interfaceNames config sets none of container, allowed and hostPrefix.")
+	}
+
+	for _, pattern := range n.Allowed {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid allowed interface name pattern %q.", pattern)
+		}
+	}
+
+	if n.Container != "" {
+		if err := validIfName(n.Container); err != nil {
+			return nil, err
+		}
+	}
+
+	if n.HostPrefix != "" {
+		if err := validIfName(n.HostPrefix); err != nil {
+			return nil, err
+		}
+		if len(n.HostPrefix) > maxHostPrefixLen {
+			return nil, fmt.Errorf("hostPrefix %q is longer than %d characters.", n.HostPrefix, maxHostPrefixLen)
+		}
+	}
+
+	return n, nil
+}
+
+// Return whether the runtime may name the container interface name.
+func (n *ifNamesConfig) allowed(name string) bool {
+	for _, pattern := range n.Allowed {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Return the args and delegate environment with the container
+// interface renamed, if Container is set and the runtime's name is not
+// allowed.  Without Container, an ADD for a name that is not allowed
+// is refused; a DEL is left alone, so that it can still clean up.
+func (n *ifNamesConfig) containerArgs(args *skel.CmdArgs, env *selector.DelegateEnv, command string) (*skel.CmdArgs, *selector.DelegateEnv, error) {
+	if n.allowed(args.IfName) || n.Container == args.IfName {
+		return args, env, nil
+	}
+
+	if n.Container == "" {
+		if len(n.Allowed) == 0 || command != "ADD" {
+			return args, env, nil
+		}
+
+		return nil, nil, newError(errCodeIfNameNotAllowed, "Interface name %q is not allowed by the network config; allowed are %v.",
+			args.IfName, n.Allowed)
+	}
+
+	renamed := *args
+	renamed.IfName = n.Container
+
+	return &renamed, &selector.DelegateEnv{
+		CNIPath: env.CNIPath,
+		Args: &invoke.Args{
+			Command:       command,
+			ContainerID:   renamed.ContainerID,
+			NetNS:         renamed.Netns,
+			PluginArgsStr: renamed.Args,
+			IfName:        renamed.IfName,
+			Path:          env.CNIPath,
+		},
+		Log: env.Log,
+	}, nil
+}
+
+// Return the i'th candidate name for the host veth of a pod.  The
+// first is the same for every sandbox of the pod; later ones are only
+// used if it is taken.
+func hostIfName(prefix, namespace, pod string, i int) string {
+	key := namespace + "/" + pod
+	if i > 0 {
+		key = fmt.Sprintf("%s#%d", key, i)
+	}
+
+	name := prefix + shortHash(key)
+	if len(name) > maxIfNameLen {
+		name = name[:maxIfNameLen]
+	}
+	return name
+}
+
+// Rename the host end of the pod's veth.  Returns the new name.
+func (n *ifNamesConfig) renameHost(log *logrus.Entry, netns, ifName string, att *attachment) (string, error) {
+	hostIf, err := hostPeer(netns, ifName)
+	if err != nil {
+		return "", err
+	}
+
+	for i := 0; i < hostNameAttempts; i++ {
+		name := hostIfName(n.HostPrefix, att.Namespace, att.Pod, i)
+		if name == hostIf.Name {
+			return name, nil
+		}
+		if _, err := net.InterfaceByName(name); err == nil {
+			log.WithField("interface", name).Debug("Host interface name is taken.")
+			continue
+		}
+
+		if _, err := runCommand("ip", "link", "set", "dev", hostIf.Name, "down"); err != nil {
+			return "", err
+		}
+		if _, err := runCommand("ip", "link", "set", "dev", hostIf.Name, "name", name); err != nil {
+			return "", err
+		}
+		if _, err := runCommand("ip", "link", "set", "dev", name, "up"); err != nil {
+			return "", err
+		}
+
+		log.WithFields(logrus.Fields{
+			"interface": name,
+			"previous":  hostIf.Name,
+		}).Debug("Renamed host veth.")
+
+		// Keep the delegate's 0.3 result listing the veth.
+		if att.Result030 != nil {
+			for _, iface := range att.Result030.Interfaces {
+				if iface.Name == hostIf.Name && iface.Sandbox == "" {
+					iface.Name = name
+				}
+			}
+		}
+
+		return name, nil
+	}
+
+	return "", fmt.Errorf("No free host interface name with prefix %q for pod %q after %d attempts.",
+		n.HostPrefix, att.Pod, hostNameAttempts)
+}
@@ -0,0 +1,109 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+	"github.com/stretchr/testify/assert"
+)
+
+// Validate interface names before running the delegate.
+func TestParseIfNames(t *testing.T) {
+	n, err := parseIfNames(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Nil(t, n)
+
+	n, err = parseIfNames(map[string]interface{}{
+		"interfaceNames": map[string]interface{}{"container": "net0", "hostPrefix": "veth"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &ifNamesConfig{Container: "net0", HostPrefix: "veth"}, n)
+
+	for _, names := range []map[string]interface{}{
+		{},
+		{"container": "a-very-long-interface"},
+		{"container": "net/0"},
+		{"hostPrefix": "toolongpfx"},
+		{"allowed": []interface{}{"net["}},
+	} {
+		_, err = parseIfNames(map[string]interface{}{"interfaceNames": names})
+		assert.Error(t, err, "%v", names)
+	}
+}
+
+// Name a pod's host veth the same in every sandbox, unless taken.
+func TestHostIfName(t *testing.T) {
+	name := hostIfName("veth", "tenant-a", "web-1", 0)
+	assert.Equal(t, name, hostIfName("veth", "tenant-a", "web-1", 0))
+	assert.Len(t, name, maxIfNameLen)
+	assert.Equal(t, "veth", name[:4])
+
+	assert.NotEqual(t, name, hostIfName("veth", "tenant-a", "web-1", 1))
+	assert.NotEqual(t, name, hostIfName("veth", "tenant-a", "web-2", 0))
+}
+
+// Hand the delegate the configured container interface name.
+func TestContainerArgs(t *testing.T) {
+	args := &skel.CmdArgs{ContainerID: "abc", Netns: "/proc/1/ns/net", IfName: "eth0"}
+	env := &selector.DelegateEnv{CNIPath: "/opt/cni/bin"}
+
+	renamedArgs, renamedEnv, err := (&ifNamesConfig{Container: "net0"}).containerArgs(args, env, "ADD")
+	assert.NoError(t, err)
+	assert.Equal(t, "net0", renamedArgs.IfName)
+	assert.Equal(t, "eth0", args.IfName)
+	assert.Contains(t, renamedEnv.Args.AsEnv(), "CNI_IFNAME=net0")
+	assert.Contains(t, renamedEnv.Args.AsEnv(), "CNI_COMMAND=ADD")
+
+	sameArgs, sameEnv, err := (&ifNamesConfig{HostPrefix: "veth"}).containerArgs(args, env, "ADD")
+	assert.NoError(t, err)
+	assert.Equal(t, args, sameArgs)
+	assert.Equal(t, env, sameEnv)
+}
+
+// Pass allowed names through, and rewrite or refuse others.
+func TestContainerArgsAllowed(t *testing.T) {
+	env := &selector.DelegateEnv{CNIPath: "/opt/cni/bin"}
+	secondary := &ifNamesConfig{Container: "net1", Allowed: []string{"net[1-9]"}}
+
+	args := &skel.CmdArgs{ContainerID: "abc", IfName: "net2"}
+	sameArgs, _, err := secondary.containerArgs(args, env, "ADD")
+	assert.NoError(t, err)
+	assert.Equal(t, args, sameArgs)
+
+	args.IfName = "eth0"
+	renamedArgs, _, err := secondary.containerArgs(args, env, "ADD")
+	assert.NoError(t, err)
+	assert.Equal(t, "net1", renamedArgs.IfName)
+
+	strict := &ifNamesConfig{Allowed: []string{"eth0"}}
+	sameArgs, _, err = strict.containerArgs(args, env, "ADD")
+	assert.NoError(t, err)
+	assert.Equal(t, args, sameArgs)
+
+	args.IfName = "net1"
+	_, _, err = strict.containerArgs(args, env, "ADD")
+	assert.True(t, selector.Is(err, selector.ErrIfNameNotAllowed))
+
+	// DEL still cleans up whatever an earlier config added.
+	sameArgs, _, err = strict.containerArgs(args, env, "DEL")
+	assert.NoError(t, err)
+	assert.Equal(t, args, sameArgs)
+}
@@ -0,0 +1,333 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/coreos/kube-namespace-cni/pkg/preview"
+)
+
+// An address range managed by host-local.
+type ipamRange struct {
+	Subnet     string `json:"subnet"`
+	RangeStart string `json:"rangeStart"`
+	RangeEnd   string `json:"rangeEnd"`
+	Gateway    string `json:"gateway"`
+}
+
+// The host-local settings kube-namespace reports on.  Both the single
+// range format and the "ranges" format are understood.
+type hostLocalConfig struct {
+	Type    string        `json:"type"`
+	DataDir string        `json:"dataDir"`
+	Ranges  [][]ipamRange `json:"ranges"`
+	ipamRange
+}
+
+// Address usage of one range of a namespace's network.  Counts are
+// capped at the largest uint64, which only matters for IPv6 ranges.
+type ipamUsage struct {
+	Namespace string `json:"namespace"`
+	Network   string `json:"network"`
+	Subnet    string `json:"subnet"`
+	Capacity  uint64 `json:"capacity"`
+	Allocated uint64 `json:"allocated"`
+	Free      uint64 `json:"free"`
+	// Free addresses come in FreeBlocks runs, the largest of
+	// LargestFreeBlock addresses.
+	FreeBlocks       int    `json:"freeBlocks"`
+	LargestFreeBlock uint64 `json:"largestFreeBlock"`
+	// 0 when the free addresses are one run, approaching 1 as they
+	// are scattered.
+	Fragmentation float64 `json:"fragmentation"`
+}
+
+// Return the ranges of a host-local IPAM config.
+func (h *hostLocalConfig) ranges() []ipamRange {
+	var ranges []ipamRange
+	for _, set := range h.Ranges {
+		ranges = append(ranges, set...)
+	}
+	if h.Subnet != "" {
+		ranges = append(ranges, h.ipamRange)
+	}
+
+	return ranges
+}
+
+func ipToInt(ip net.IP) *big.Int {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	return new(big.Int).SetBytes(ip)
+}
+
+// Return the address n, of the family of an IPv4 address if v4.
+func intToIP(n *big.Int, v4 bool) net.IP {
+	size := net.IPv6len
+	if v4 {
+		size = net.IPv4len
+	}
+
+	b := n.Bytes()
+	ip := make(net.IP, size)
+	copy(ip[size-len(b):], b)
+	return ip
+}
+
+func capUint64(n *big.Int) uint64 {
+	if !n.IsUint64() {
+		return math.MaxUint64
+	}
+
+	return n.Uint64()
+}
+
+// Return the subnet of a range, and the first and last addresses
+// handed out from it.  As host-local, the network and last addresses
+// of the subnet are not handed out.
+func (r ipamRange) bounds() (*net.IPNet, *big.Int, *big.Int, error) {
+	_, subnet, err := net.ParseCIDR(r.Subnet)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("Invalid subnet %q: %v", r.Subnet, err)
+	}
+
+	one := big.NewInt(1)
+	ones, bits := subnet.Mask.Size()
+	start := new(big.Int).Add(ipToInt(subnet.IP), one)
+	end := new(big.Int).Add(ipToInt(subnet.IP), new(big.Int).Lsh(one, uint(bits-ones)))
+	end.Sub(end, big.NewInt(2))
+
+	if ip := net.ParseIP(r.RangeStart); ip != nil {
+		start = ipToInt(ip)
+	}
+	if ip := net.ParseIP(r.RangeEnd); ip != nil {
+		end = ipToInt(ip)
+	}
+
+	return subnet, start, end, nil
+}
+
+// Compute the usage of a range, given the addresses leased from it.
+func rangeUsage(r ipamRange, leases []net.IP) (*ipamUsage, error) {
+	subnet, start, end, err := r.bounds()
+	if err != nil {
+		return nil, err
+	}
+	one := big.NewInt(1)
+
+	inRange := func(n *big.Int) bool {
+		return n.Cmp(start) >= 0 && n.Cmp(end) <= 0
+	}
+
+	// Addresses in the range that are not free, in order.
+	var taken []*big.Int
+	allocated := 0
+	for _, ip := range leases {
+		if n := ipToInt(ip); inRange(n) && subnet.Contains(ip) {
+			taken = append(taken, n)
+			allocated++
+		}
+	}
+
+	capacity := new(big.Int).Sub(end, start)
+	capacity.Add(capacity, one)
+	// The gateway is not handed out.
+	if gw := net.ParseIP(r.Gateway); gw != nil && inRange(ipToInt(gw)) {
+		taken = append(taken, ipToInt(gw))
+		capacity.Sub(capacity, one)
+	}
+	if capacity.Sign() < 0 {
+		capacity.SetInt64(0)
+	}
+
+	sort.Slice(taken, func(i, j int) bool { return taken[i].Cmp(taken[j]) < 0 })
+
+	u := &ipamUsage{
+		Subnet:    r.Subnet,
+		Capacity:  capUint64(capacity),
+		Allocated: uint64(allocated),
+	}
+
+	free := new(big.Int).Sub(capacity, big.NewInt(int64(allocated)))
+	if free.Sign() < 0 {
+		free.SetInt64(0)
+	}
+	u.Free = capUint64(free)
+
+	largest := new(big.Int)
+	next := new(big.Int).Set(start)
+	addRun := func(last *big.Int) {
+		run := new(big.Int).Sub(last, next)
+		run.Add(run, one)
+		if run.Sign() > 0 {
+			u.FreeBlocks++
+			if run.Cmp(largest) > 0 {
+				largest = run
+			}
+		}
+	}
+	for _, n := range taken {
+		addRun(new(big.Int).Sub(n, one))
+		next = new(big.Int).Add(n, one)
+	}
+	addRun(end)
+	u.LargestFreeBlock = capUint64(largest)
+
+	if free.Sign() > 0 {
+		ratio, _ := new(big.Float).Quo(new(big.Float).SetInt(largest), new(big.Float).SetInt(free)).Float64()
+		u.Fragmentation = math.Max(0, 1-ratio)
+	}
+
+	return u, nil
+}
+
+// Read the addresses leased in a host-local store.
+func readLeases(dir string) ([]net.IP, error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var leases []net.IP
+	for _, f := range files {
+		if ip := net.ParseIP(f.Name()); ip != nil {
+			leases = append(leases, ip)
+		}
+	}
+
+	return leases, nil
+}
+
+// Report the usage of every host-local range in the plugin config, by
+// namespace.  The default config is reported as "*".
+func ipamReport(c *config, ipamDir string) ([]*ipamUsage, error) {
+	netconfs := map[string]map[string]interface{}{}
+	for namespace := range c.Namespaces {
+		sel, err := c.Select(kubeArgs(namespace, ""))
+		if err != nil {
+			return nil, err
+		}
+		netconfs[namespace] = sel.NetConf
+	}
+	if len(c.Default) > 0 {
+		netconfs[preview.DefaultKey] = c.Default
+	}
+
+	var namespaces []string
+	for namespace := range netconfs {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	var report []*ipamUsage
+	for _, namespace := range namespaces {
+		netconf := netconfs[namespace]
+
+		ipam := &hostLocalConfig{}
+		if _, err := decodeNetConfKey(netconf, "ipam", ipam); err != nil {
+			return nil, fmt.Errorf("Namespace %q: %v", namespace, err)
+		}
+		name, _ := netconf["name"].(string)
+		if ipam.Type != "host-local" || name == "" {
+			continue
+		}
+
+		dir := ipamDir
+		if ipam.DataDir != "" {
+			dir = ipam.DataDir
+		}
+		leases, err := readLeases(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range ipam.ranges() {
+			u, err := rangeUsage(r, leases)
+			if err != nil {
+				return nil, fmt.Errorf("Namespace %q: %v", namespace, err)
+			}
+			u.Namespace = namespace
+			u.Network = name
+			report = append(report, u)
+		}
+	}
+
+	return report, nil
+}
+
+// Print per-namespace address usage of host-local networks, for
+// capacity planning.
+func cmdIPAMReport(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("ipam-report", flag.ContinueOnError)
+	configPath := flags.String("config", "", "plugin config file (default stdin)")
+	ipamDir := flags.String("ipam-dir", defaultIPAMDir, "host-local data directory")
+	output := flags.String("output", "text", "output format: text or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("Unknown output format %q.", *output)
+	}
+
+	config, err := readConfig(*configPath, stdin)
+	if err != nil {
+		return err
+	}
+
+	report, err := ipamReport(config, *ipamDir)
+	if err != nil {
+		return err
+	}
+
+	if *output == "json" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s\n", data)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tNETWORK\tSUBNET\tALLOCATED\tFREE\tUSED\tFRAGMENTATION")
+	for _, u := range report {
+		used := 0.0
+		if u.Capacity > 0 {
+			used = 100 * float64(u.Allocated) / float64(u.Capacity)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%.1f%%\t%.2f\n", u.Namespace, u.Network, u.Subnet,
+			u.Allocated, u.Free, used, u.Fragmentation)
+	}
+	return w.Flush()
+}
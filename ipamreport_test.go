@@ -0,0 +1,81 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Count free addresses and the runs they form.
+func TestRangeUsage(t *testing.T) {
+	r := ipamRange{Subnet: "10.1.0.0/28", Gateway: "10.1.0.1"}
+	leases := []net.IP{net.ParseIP("10.1.0.2"), net.ParseIP("10.1.0.3"), net.ParseIP("10.1.0.8"), net.ParseIP("10.2.0.5")}
+
+	u, err := rangeUsage(r, leases)
+	assert.NoError(t, err)
+	// .1 to .14, less the gateway.
+	assert.EqualValues(t, 13, u.Capacity)
+	assert.EqualValues(t, 3, u.Allocated)
+	assert.EqualValues(t, 10, u.Free)
+	// .4-.7 and .9-.14.
+	assert.Equal(t, 2, u.FreeBlocks)
+	assert.EqualValues(t, 6, u.LargestFreeBlock)
+	assert.InDelta(t, 0.4, u.Fragmentation, 0.001)
+
+	u, err = rangeUsage(ipamRange{Subnet: "fd00::/64"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, u.FreeBlocks)
+	assert.Equal(t, 0.0, u.Fragmentation)
+}
+
+// Report on the networks of a config from their host-local stores.
+func TestIPAMReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-ipam")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "isolated"), 0755)
+	for _, f := range []string{"10.2.0.2", "10.2.0.3", "lock", "last_reserved_ip.0"} {
+		ioutil.WriteFile(filepath.Join(dir, "isolated", f), []byte("abc"), 0644)
+	}
+
+	config, err := parseConfig([]byte(configWithDefault))
+	assert.NoError(t, err)
+
+	report, err := ipamReport(config, dir)
+	assert.NoError(t, err)
+	if !assert.Len(t, report, 2) {
+		return
+	}
+	assert.Equal(t, "*", report[0].Namespace)
+	assert.EqualValues(t, 0, report[0].Allocated)
+	assert.Equal(t, "isolated", report[1].Namespace)
+	assert.EqualValues(t, 2, report[1].Allocated)
+
+	out := &bytes.Buffer{}
+	err = cmdIPAMReport([]string{"--ipam-dir", dir}, bytes.NewBufferString(configWithDefault), out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "10.2.0.0/16")
+}
@@ -0,0 +1,477 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// host-local keeps one file per leased address, and a crash between
+// writing a lease and the rest of an ADD or DEL can leak or lose
+// addresses.  With "ipamStore": "db" on a network config whose IPAM
+// is host-local, kube-namespace allocates the addresses itself, from
+// the same ranges, keeping all of a node's leases in one file that is
+// rewritten atomically under a lock.  The delegate is given
+// kube-namespace as its IPAM plugin, and kube-namespace recognizes
+// such invocations by the IPAM type in their config.
+
+// Values of the ipamStore option.
+const (
+	ipamStoreDisk = "disk"
+	ipamStoreDB   = "db"
+)
+
+// The IPAM type kube-namespace sets when it is the delegate's IPAM
+// plugin.  It must be the name of the kube-namespace binary in
+// CNI_PATH.
+const ipamPluginType = "kube-namespace"
+
+// The file in the state directory holding leases.
+const ipamStoreFile = "ipam.json"
+
+// The version of the IPAM store format, and the migrations from older
+// ones; see attachmentSchemaVersion.
+const ipamSchemaVersion = 1
+
+var ipamMigrations = map[int]func(record map[string]interface{}) error{}
+
+// The leases of a node's networks, and of kube-namespace-ipam's
+// namespace ranges.
+type ipamDB struct {
+	SchemaVersion int `json:"schemaVersion"`
+
+	Networks   map[string]*ipamNetwork `json:"networks"`
+	Namespaces map[string]*ipamNetwork `json:"namespaces,omitempty"`
+}
+
+// The leases of a network.
+type ipamNetwork struct {
+	// Container IDs by leased address.
+	Leases map[string]string `json:"leases"`
+	// The address last handed out; allocation goes round-robin from
+	// it, as host-local's does.
+	Last string `json:"last,omitempty"`
+	// Whether host-local's leases were imported.
+	Migrated bool `json:"migrated,omitempty"`
+}
+
+// The IPAM config kube-namespace is invoked with: host-local's, or
+// kube-namespace-ipam's, and where the leases are kept.
+type ipamStoreConfig struct {
+	hostLocalConfig
+	Routes []types.Route `json:"routes"`
+	Store  string        `json:"store"`
+
+	// Set for kube-namespace-ipam; see nsipam.go.
+	NamespaceRanges map[string][]ipamRange `json:"namespaceRanges"`
+	Reservations    []ipamReservation      `json:"reservations"`
+}
+
+// The network config of an IPAM invocation.
+type ipamNetConf struct {
+	CNIVersion string           `json:"cniVersion"`
+	Name       string           `json:"name"`
+	Type       string           `json:"type"`
+	IPAM       *ipamStoreConfig `json:"ipam"`
+}
+
+// Parse and validate the ipamStore option of a network config.
+// kube-namespace-ipam always keeps its leases in the db store.
+func parseIPAMStore(netconf map[string]interface{}) (string, error) {
+	store := ""
+	if _, err := decodeNetConfKey(netconf, "ipamStore", &store); err != nil {
+		return "", err
+	}
+
+	ipam, _ := netconf["ipam"].(map[string]interface{})
+	if ipam["type"] == embeddedIPAMType {
+		if store == ipamStoreDisk {
+			return "", fmt.Errorf("ipamStore %q given for IPAM type %s, which always uses %q.", store, embeddedIPAMType, ipamStoreDB)
+		}
+		return ipamStoreDB, validateEmbeddedIPAM(ipam)
+	}
+
+	switch store {
+	case "", ipamStoreDisk:
+		return "", nil
+	case ipamStoreDB:
+	default:
+		return "", fmt.Errorf("Unknown ipamStore %q; use %q or %q.", store, ipamStoreDisk, ipamStoreDB)
+	}
+
+	if ipam["type"] != "host-local" {
+		return "", fmt.Errorf("ipamStore given for IPAM type %v; it needs host-local.", ipam["type"])
+	}
+
+	return store, nil
+}
+
+// Return the delegate's IPAM config with kube-namespace as its IPAM
+// plugin, keeping leases in the state directory.
+func (c *config) ipamStoreConf(ipam map[string]interface{}) map[string]interface{} {
+	conf := make(map[string]interface{}, len(ipam)+1)
+	for k, v := range ipam {
+		conf[k] = v
+	}
+
+	dir := c.StateDir
+	if dir == "" {
+		dir = defaultStateDir
+	}
+	conf["type"] = ipamPluginType
+	conf["store"] = filepath.Join(dir, ipamStoreFile)
+
+	return conf
+}
+
+// Parse stdin as an IPAM invocation by a delegate.  Returns nil if it
+// is a plugin invocation.
+func parseIPAMInvocation(stdin []byte) *ipamNetConf {
+	netconf := &ipamNetConf{}
+	if err := json.Unmarshal(stdin, netconf); err != nil {
+		return nil
+	}
+
+	if netconf.Type == ipamPluginType || netconf.IPAM == nil {
+		return nil
+	}
+
+	switch netconf.IPAM.Type {
+	case ipamPluginType:
+	case embeddedIPAMType:
+		// Installed under that name, and run by a delegate directly.
+		if netconf.IPAM.Store == "" {
+			netconf.IPAM.Store = filepath.Join(defaultStateDir, ipamStoreFile)
+		}
+	default:
+		return nil
+	}
+
+	return netconf
+}
+
+// Run update on the leases in the store at path, holding its lock, and
+// save them if update succeeds.
+func updateIPAMStore(path string, update func(db *ipamDB) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to open IPAM store lock: %v", err)
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("Failed to lock IPAM store: %v", err)
+	}
+
+	db := &ipamDB{}
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		if _, err := decodeVersioned(path, data, db, ipamSchemaVersion, ipamMigrations); err != nil {
+			return fmt.Errorf("Failed to parse IPAM store %q: %v", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if db.Networks == nil {
+		db.Networks = map[string]*ipamNetwork{}
+	}
+	if db.Namespaces == nil {
+		db.Namespaces = map[string]*ipamNetwork{}
+	}
+
+	if err := update(db); err != nil {
+		return err
+	}
+
+	db.SchemaVersion = ipamSchemaVersion
+	return writeJSONAtomic(path, db)
+}
+
+// Return the leases of a network, importing those host-local made the
+// first time, so that running pods keep their addresses.
+func (db *ipamDB) network(name, dataDir string) (*ipamNetwork, error) {
+	n := db.Networks[name]
+	if n == nil {
+		n = &ipamNetwork{Leases: map[string]string{}}
+		db.Networks[name] = n
+	}
+	if n.Migrated {
+		return n, nil
+	}
+
+	if dataDir == "" {
+		dataDir = defaultIPAMDir
+	}
+	if err := n.importHostLocal(filepath.Join(dataDir, name)); err != nil {
+		return nil, err
+	}
+	n.Migrated = true
+
+	return n, nil
+}
+
+// Import the leases of a host-local store.  Each file named after an
+// address holds the container ID it is leased to.
+func (n *ipamNetwork) importHostLocal(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return err
+		}
+		value := strings.TrimSpace(strings.SplitN(string(data), "\n", 2)[0])
+
+		if ip := net.ParseIP(f.Name()); ip != nil {
+			n.Leases[ip.String()] = value
+		} else if strings.HasPrefix(f.Name(), "last_reserved_ip") && n.Last == "" {
+			n.Last = value
+		}
+	}
+
+	if len(n.Leases) > 0 {
+		log.WithFields(logrus.Fields{
+			"dir":    dir,
+			"leases": len(n.Leases),
+		}).Info("Imported host-local leases.")
+	}
+
+	return nil
+}
+
+// Lease an address from the first range with one free to containerID,
+// or return the address it already has.  If want is set, that address
+// is leased, from the range containing it.  Reserved addresses are
+// not handed out otherwise.
+func (n *ipamNetwork) allocate(ranges []ipamRange, containerID string, want net.IP, reserved map[string]bool) (*types.IPConfig, ipamRange, error) {
+	for _, r := range ranges {
+		subnet, start, end, err := r.bounds()
+		if err != nil {
+			return nil, r, err
+		}
+
+		for addr, owner := range n.Leases {
+			if ip := net.ParseIP(addr); owner == containerID && subnet.Contains(ip) {
+				return &types.IPConfig{IP: net.IPNet{IP: ip, Mask: subnet.Mask}}, r, nil
+			}
+		}
+
+		if want != nil {
+			if !subnet.Contains(want) {
+				continue
+			}
+			if owner, leased := n.Leases[want.String()]; leased {
+				return nil, r, fmt.Errorf("Reserved address %s is leased to container %s.", want, owner)
+			}
+			n.Leases[want.String()] = containerID
+			return &types.IPConfig{IP: net.IPNet{IP: want, Mask: subnet.Mask}}, r, nil
+		}
+
+		if ip := n.nextFree(subnet, start, end, net.ParseIP(r.Gateway), reserved); ip != nil {
+			n.Leases[ip.String()] = containerID
+			n.Last = ip.String()
+			return &types.IPConfig{IP: net.IPNet{IP: ip, Mask: subnet.Mask}}, r, nil
+		}
+	}
+
+	if want != nil {
+		return nil, ipamRange{}, fmt.Errorf("Reserved address %s is in none of the ranges.", want)
+	}
+	return nil, ipamRange{}, errors.New("No free addresses in the ranges of the network.")
+}
+
+// Return the first free, unreserved address after the last one handed
+// out, wrapping around, or nil if there is none.
+func (n *ipamNetwork) nextFree(subnet *net.IPNet, start, end *big.Int, gateway net.IP, reserved map[string]bool) net.IP {
+	if start.Cmp(end) > 0 {
+		return nil
+	}
+	v4 := subnet.IP.To4() != nil
+
+	first := start
+	if last := net.ParseIP(n.Last); last != nil && subnet.Contains(last) {
+		if next := new(big.Int).Add(ipToInt(last), big.NewInt(1)); next.Cmp(start) >= 0 && next.Cmp(end) <= 0 {
+			first = next
+		}
+	}
+
+	candidate := new(big.Int).Set(first)
+	for {
+		ip := intToIP(candidate, v4)
+		if _, leased := n.Leases[ip.String()]; !leased && !reserved[ip.String()] && !ip.Equal(gateway) {
+			return ip
+		}
+
+		candidate.Add(candidate, big.NewInt(1))
+		if candidate.Cmp(end) > 0 {
+			candidate.Set(start)
+		}
+		if candidate.Cmp(first) == 0 {
+			return nil
+		}
+	}
+}
+
+// Return whether ip is within one of the ranges, is not its gateway,
+// and is not leased.
+func (n *ipamNetwork) free(ranges []ipamRange, ip net.IP) bool {
+	if _, leased := n.Leases[ip.String()]; leased {
+		return false
+	}
+
+	for _, r := range ranges {
+		subnet, start, end, err := r.bounds()
+		if err != nil || !subnet.Contains(ip) {
+			continue
+		}
+
+		v := ipToInt(ip)
+		return v.Cmp(start) >= 0 && v.Cmp(end) <= 0 && !ip.Equal(net.ParseIP(r.Gateway))
+	}
+
+	return false
+}
+
+// Release the addresses leased to containerID.
+func (n *ipamNetwork) release(containerID string) {
+	for addr, owner := range n.Leases {
+		if owner == containerID {
+			delete(n.Leases, addr)
+		}
+	}
+}
+
+// Lease an address to the pod of args from the ranges of the network.
+func (ipam *ipamStoreConfig) lease(db *ipamDB, network string, args *skel.CmdArgs) (*types.IPConfig, ipamRange, error) {
+	if ipam.NamespaceRanges != nil {
+		return ipam.leaseForNamespace(db, args)
+	}
+
+	ranges := ipam.ranges()
+	if len(ranges) == 0 {
+		return nil, ipamRange{}, fmt.Errorf("IPAM config of network %q has no ranges.", network)
+	}
+
+	n, err := db.network(network, ipam.DataDir)
+	if err != nil {
+		return nil, ipamRange{}, err
+	}
+
+	// An address asked for with the IP argument, as stickyIP does, is
+	// leased if it is free, as host-local would.
+	if want := net.ParseIP(selector.ParseExtraArgs(args.Args)["IP"]); want != nil && n.free(ranges, want) {
+		return n.allocate(ranges, args.ContainerID, want, nil)
+	}
+
+	return n.allocate(ranges, args.ContainerID, nil, nil)
+}
+
+// Handle an IPAM ADD from the delegate, printing the lease in the
+// format of the network's CNI version.
+func ipamAdd(netconf *ipamNetConf, args *skel.CmdArgs, stdout io.Writer) error {
+	ipam := netconf.IPAM
+
+	var ipc *types.IPConfig
+	var r ipamRange
+	err := updateIPAMStore(ipam.Store, func(db *ipamDB) error {
+		var err error
+		ipc, r, err = ipam.lease(db, netconf.Name, args)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	ipc.Gateway = net.ParseIP(r.Gateway)
+	v4 := ipc.IP.IP.To4() != nil
+	for _, route := range ipam.Routes {
+		if (route.Dst.IP.To4() != nil) == v4 {
+			ipc.Routes = append(ipc.Routes, route)
+		}
+	}
+
+	result := &types.Result{IP4: ipc}
+	if !v4 {
+		result = &types.Result{IP6: ipc}
+	}
+
+	log.WithFields(logrus.Fields{
+		"network": netconf.Name,
+		"address": ipc.IP.String(),
+	}).Info("Leased address.")
+
+	return printIPAMResult(result, netconf.CNIVersion, stdout)
+}
+
+// Handle an IPAM DEL from the delegate.
+func ipamDel(netconf *ipamNetConf, args *skel.CmdArgs) error {
+	return updateIPAMStore(netconf.IPAM.Store, func(db *ipamDB) error {
+		if netconf.IPAM.NamespaceRanges != nil {
+			db.releaseFromNamespaces(args.ContainerID)
+			return nil
+		}
+
+		n, err := db.network(netconf.Name, netconf.IPAM.DataDir)
+		if err != nil {
+			return err
+		}
+
+		n.release(args.ContainerID)
+		return nil
+	})
+}
+
+// Print an IPAM result.  0.3 IPAM results name no interfaces; the
+// delegate fills them in.
+func printIPAMResult(result *types.Result, cniVersion string, stdout io.Writer) error {
+	var out interface{} = result
+	if selector.Is030(cniVersion) {
+		r030 := selector.ConvertTo030(result, cniVersion, "", "")
+		r030.Interfaces = nil
+		for _, ip := range r030.IPs {
+			ip.Interface = nil
+		}
+		out = r030
+	}
+
+	return json.NewEncoder(stdout).Encode(out)
+}
@@ -0,0 +1,156 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/stretchr/testify/assert"
+)
+
+// Accept the db store only for host-local IPAM.
+func TestParseIPAMStore(t *testing.T) {
+	store, err := parseIPAMStore(map[string]interface{}{"type": "bridge", "ipamStore": "db", "ipam": map[string]interface{}{"type": "host-local"}})
+	assert.NoError(t, err)
+	assert.Equal(t, ipamStoreDB, store)
+
+	store, err = parseIPAMStore(map[string]interface{}{"type": "bridge", "ipamStore": "disk"})
+	assert.NoError(t, err)
+	assert.Equal(t, "", store)
+
+	_, err = parseIPAMStore(map[string]interface{}{"type": "bridge", "ipamStore": "db", "ipam": map[string]interface{}{"type": "dhcp"}})
+	assert.EqualError(t, err, "ipamStore given for IPAM type dhcp; it needs host-local.")
+
+	_, err = parseIPAMStore(map[string]interface{}{"ipamStore": "sqlite"})
+	assert.Error(t, err)
+}
+
+// Make kube-namespace the delegate's IPAM plugin, and recognize its
+// invocations as such.
+func TestIPAMStoreConf(t *testing.T) {
+	c := &config{StateDir: "/run/kube-namespace"}
+	sel := &selection{NetConf: map[string]interface{}{
+		"name": "web-net", "type": "bridge", "ipamStore": "db",
+		"ipam": map[string]interface{}{"type": "host-local", "subnet": "10.2.0.0/16"},
+	}}
+	options, err := parseNetOptions(sel.NetConf)
+	assert.NoError(t, err)
+
+	netconf := c.delegateNetConf(sel, options)
+	assert.Equal(t, map[string]interface{}{
+		"type": "kube-namespace", "subnet": "10.2.0.0/16", "store": "/run/kube-namespace/ipam.json",
+	}, netconf["ipam"])
+	assert.NotContains(t, netconf, "ipamStore")
+
+	stdin, err := json.Marshal(netconf)
+	assert.NoError(t, err)
+	ipam := parseIPAMInvocation(stdin)
+	if assert.NotNil(t, ipam) {
+		assert.Equal(t, "web-net", ipam.Name)
+		assert.Equal(t, "10.2.0.0/16", ipam.IPAM.Subnet)
+	}
+
+	assert.Nil(t, parseIPAMInvocation([]byte(`{"type": "kube-namespace", "ipam": {"type": "kube-namespace"}}`)))
+}
+
+// Lease addresses round-robin, skipping the gateway and those
+// host-local leased, and release them on DEL.
+func TestIPAMAddDel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-ipamstore")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	hostLocal := filepath.Join(dir, "networks", "web-net")
+	assert.NoError(t, os.MkdirAll(hostLocal, 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(hostLocal, "10.2.0.2"), []byte("old"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(hostLocal, "last_reserved_ip"), []byte("10.2.0.2"), 0644))
+
+	netconf := &ipamNetConf{Name: "web-net", IPAM: &ipamStoreConfig{Store: filepath.Join(dir, "ipam.json")}}
+	netconf.IPAM.DataDir = filepath.Join(dir, "networks")
+	netconf.IPAM.Subnet = "10.2.0.0/29"
+	netconf.IPAM.Gateway = "10.2.0.3"
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, ipamAdd(netconf, &skel.CmdArgs{ContainerID: "a"}, out))
+	assert.Contains(t, out.String(), `"ip":"10.2.0.4/29"`)
+	assert.Contains(t, out.String(), `"gateway":"10.2.0.3"`)
+
+	// A repeated ADD gets the same address.
+	out.Reset()
+	assert.NoError(t, ipamAdd(netconf, &skel.CmdArgs{ContainerID: "a"}, out))
+	assert.Contains(t, out.String(), `"ip":"10.2.0.4/29"`)
+
+	for _, id := range []string{"b", "c"} {
+		assert.NoError(t, ipamAdd(netconf, &skel.CmdArgs{ContainerID: id}, &bytes.Buffer{}))
+	}
+	// .1 is left; .7 is the broadcast address.
+	out.Reset()
+	assert.NoError(t, ipamAdd(netconf, &skel.CmdArgs{ContainerID: "d"}, out))
+	assert.Contains(t, out.String(), `"ip":"10.2.0.1/29"`)
+	assert.Error(t, ipamAdd(netconf, &skel.CmdArgs{ContainerID: "e"}, &bytes.Buffer{}))
+
+	assert.NoError(t, ipamDel(netconf, &skel.CmdArgs{ContainerID: "old"}))
+	out.Reset()
+	assert.NoError(t, ipamAdd(netconf, &skel.CmdArgs{ContainerID: "e"}, out))
+	assert.Contains(t, out.String(), `"ip":"10.2.0.2/29"`)
+}
+
+// Lease the address asked for with the IP argument if it is free, and
+// otherwise the next one.
+func TestIPAMAddRequestedIP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-ipamstore")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	netconf := &ipamNetConf{Name: "web-net", IPAM: &ipamStoreConfig{Store: filepath.Join(dir, "ipam.json")}}
+	netconf.IPAM.DataDir = filepath.Join(dir, "networks")
+	netconf.IPAM.Subnet = "10.2.0.0/29"
+	netconf.IPAM.Gateway = "10.2.0.1"
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, ipamAdd(netconf, &skel.CmdArgs{ContainerID: "a", Args: "K8S_POD_NAME=web-1;IP=10.2.0.5"}, out))
+	assert.Contains(t, out.String(), `"ip":"10.2.0.5/29"`)
+
+	// Leased, the gateway, or out of the range: the next free one.
+	for _, ip := range []string{"10.2.0.5", "10.2.0.1", "10.3.0.5"} {
+		out.Reset()
+		assert.NoError(t, ipamAdd(netconf, &skel.CmdArgs{ContainerID: "b" + ip, Args: "IP=" + ip}, out))
+		assert.NotContains(t, out.String(), `"ip":"`+ip+`/29"`, ip)
+	}
+}
+
+// Print 0.3 IPAM results without interfaces.
+func TestPrintIPAMResult(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-ipamstore")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	netconf := &ipamNetConf{CNIVersion: "0.3.1", Name: "web-net", IPAM: &ipamStoreConfig{Store: filepath.Join(dir, "ipam.json")}}
+	netconf.IPAM.DataDir = filepath.Join(dir, "networks")
+	netconf.IPAM.Subnet = "10.2.0.0/24"
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, ipamAdd(netconf, &skel.CmdArgs{ContainerID: "a"}, out))
+	assert.JSONEq(t, `{"cniVersion": "0.3.1", "ips": [{"version": "4", "address": "10.2.0.1/24"}], "dns": {}}`, out.String())
+}
@@ -0,0 +1,187 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Return whether the selected network config has its delegate
+// masquerade the pod's traffic itself, as bridge and ptp do with
+// "ipMasq": true.
+func delegateMasquerades(netconf map[string]interface{}) bool {
+	masq, _ := netconf["ipMasq"].(bool)
+	return masq
+}
+
+// Return the name of the masquerading chain for a container on a
+// network.
+func ipMasqChain(network, containerID string) string {
+	return "KN-MASQ-" + shortHash(network+"/"+containerID)
+}
+
+// Return the iptables rules, as argument lists for -A, that make up
+// the masquerading chain for a pod address: traffic within the pod's
+// subnet and multicast are left alone, everything else is
+// masqueraded.
+func ipMasqChainRules(chain string, addr net.IPNet) [][]string {
+	subnet := net.IPNet{IP: addr.IP.Mask(addr.Mask), Mask: addr.Mask}
+	multicast := "224.0.0.0/4"
+	if addr.IP.To4() == nil {
+		multicast = "ff00::/8"
+	}
+
+	return [][]string{
+		{chain, "-d", subnet.String(), "-j", "ACCEPT"},
+		{chain, "!", "-d", multicast, "-j", "MASQUERADE"},
+	}
+}
+
+// Masquerade traffic from the pod's addresses leaving its subnet.
+// Returns the chain the rules are in.
+func installIPMasq(log *logrus.Entry, network, containerID string, result *types.Result) (string, error) {
+	chain := ipMasqChain(network, containerID)
+	comment := "kube-namespace:" + network + ":" + containerID
+
+	for _, ipc := range []*types.IPConfig{result.IP4, result.IP6} {
+		if ipc == nil {
+			continue
+		}
+
+		iptables := iptablesCommand(ipc.IP.IP.To4() == nil)
+
+		// The chain may be left over from an earlier, failed ADD.
+		runCommand(iptables, "-w", "-t", "nat", "-N", chain)
+		if _, err := runCommand(iptables, "-w", "-t", "nat", "-F", chain); err != nil {
+			return "", err
+		}
+
+		for _, spec := range ipMasqChainRules(chain, ipc.IP) {
+			if _, err := runCommand(iptables, append([]string{"-w", "-t", "nat", "-A"}, spec...)...); err != nil {
+				return "", err
+			}
+		}
+
+		jump := []string{"POSTROUTING", "-s", ipc.IP.IP.String(),
+			"-m", "comment", "--comment", comment, "-j", chain}
+		if _, err := runCommand(iptables, append([]string{"-w", "-t", "nat", "-C"}, jump...)...); err != nil {
+			if _, err := runCommand(iptables, append([]string{"-w", "-t", "nat", "-A"}, jump...)...); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		"chain": chain,
+	}).Debug("Installed masquerading rules.")
+
+	return chain, nil
+}
+
+// Remove a pod's masquerading or exclusion chain and the rule that
+// jumps to it.
+func teardownIPMasq(log *logrus.Entry, chain string) {
+	for _, ipv6 := range []bool{false, true} {
+		iptables := iptablesCommand(ipv6)
+
+		removeNATJumps(log, iptables, "POSTROUTING", chain)
+
+		// The chain only exists if the pod had an address of this family.
+		if _, err := runCommand(iptables, "-w", "-t", "nat", "-F", chain); err == nil {
+			runCommand(iptables, "-w", "-t", "nat", "-X", chain)
+		}
+	}
+}
+
+// Parse the "ipMasqExcludeCIDRs" key of a network config: destinations
+// the pod's traffic to is never masqueraded.
+func parseIPMasqExclude(netconf map[string]interface{}) ([]*net.IPNet, error) {
+	var cidrs []string
+	if _, err := decodeNetConfKey(netconf, "ipMasqExcludeCIDRs", &cidrs); err != nil {
+		return nil, err
+	}
+
+	var excluded []*net.IPNet
+	for _, cidr := range cidrs {
+		_, ipn, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ipMasqExcludeCIDRs entry %q.", cidr)
+		}
+		excluded = append(excluded, ipn)
+	}
+
+	return excluded, nil
+}
+
+// Return the name of the masquerading exclusion chain for a container.
+func ipMasqExcludeChain(containerID string) string {
+	return "KN-NOMASQ-" + shortHash(containerID)
+}
+
+// Exempt the pod's traffic to the excluded destinations from
+// masquerading, by whichever rule would do it: a chain that accepts
+// it, jumped to from the top of POSTROUTING, ahead of the delegate's
+// and kube-namespace's own masquerading rules.  Returns the chain.
+func installIPMasqExclude(log *logrus.Entry, containerID string, excluded []*net.IPNet, result *types.Result) (string, error) {
+	chain := ipMasqExcludeChain(containerID)
+	comment := "kube-namespace:" + containerID
+
+	for _, ipc := range []*types.IPConfig{result.IP4, result.IP6} {
+		if ipc == nil {
+			continue
+		}
+
+		ipv6 := ipc.IP.IP.To4() == nil
+		iptables := iptablesCommand(ipv6)
+
+		// The chain may be left over from an earlier, failed ADD.
+		runCommand(iptables, "-w", "-t", "nat", "-N", chain)
+		if _, err := runCommand(iptables, "-w", "-t", "nat", "-F", chain); err != nil {
+			return "", err
+		}
+
+		for _, ipn := range excluded {
+			if (ipn.IP.To4() == nil) != ipv6 {
+				continue
+			}
+			if _, err := runCommand(iptables, "-w", "-t", "nat", "-A", chain, "-d", ipn.String(), "-j", "ACCEPT"); err != nil {
+				return "", err
+			}
+		}
+
+		jump := []string{"POSTROUTING", "-s", ipc.IP.IP.String(),
+			"-m", "comment", "--comment", comment, "-j", chain}
+		if _, err := runCommand(iptables, append([]string{"-w", "-t", "nat", "-C"}, jump...)...); err != nil {
+			if _, err := runCommand(iptables, append([]string{"-w", "-t", "nat", "-I"}, jump...)...); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		"chain":    chain,
+		"excluded": len(excluded),
+	}).Debug("Installed masquerading exclusions.")
+
+	return chain, nil
+}
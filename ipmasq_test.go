@@ -0,0 +1,74 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Masquerade everything but the pod's subnet and multicast.
+func TestIPMasqChainRules(t *testing.T) {
+	addr := net.IPNet{IP: net.ParseIP("10.2.0.5").To4(), Mask: net.CIDRMask(16, 32)}
+	assert.Equal(t, [][]string{
+		{"C", "-d", "10.2.0.0/16", "-j", "ACCEPT"},
+		{"C", "!", "-d", "224.0.0.0/4", "-j", "MASQUERADE"},
+	}, ipMasqChainRules("C", addr))
+
+	addr = net.IPNet{IP: net.ParseIP("fd00::5"), Mask: net.CIDRMask(64, 128)}
+	assert.Equal(t, [][]string{
+		{"C", "-d", "fd00::/64", "-j", "ACCEPT"},
+		{"C", "!", "-d", "ff00::/8", "-j", "MASQUERADE"},
+	}, ipMasqChainRules("C", addr))
+}
+
+// Name chains after the network and container, within iptables'
+// limit.
+func TestIPMasqChain(t *testing.T) {
+	chain := ipMasqChain("tenant-a", "abc")
+	assert.True(t, len(chain) <= 28)
+	assert.NotEqual(t, chain, ipMasqChain("tenant-b", "abc"))
+	assert.NotEqual(t, chain, ipMasqChain("tenant-a", "def"))
+}
+
+// Parse and validate excluded destinations.
+func TestParseIPMasqExclude(t *testing.T) {
+	excluded, err := parseIPMasqExclude(map[string]interface{}{
+		"ipMasqExcludeCIDRs": []interface{}{"10.0.0.0/8", "fd00::/8"},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, excluded, 2) {
+		assert.Equal(t, "10.0.0.0/8", excluded[0].String())
+		assert.Equal(t, "fd00::/8", excluded[1].String())
+	}
+
+	excluded, err = parseIPMasqExclude(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Empty(t, excluded)
+
+	_, err = parseIPMasqExclude(map[string]interface{}{"ipMasqExcludeCIDRs": []interface{}{"10.0.0.0"}})
+	assert.Error(t, err)
+}
+
+// Leave masquerading to delegates configured to do it.
+func TestDelegateMasquerades(t *testing.T) {
+	assert.True(t, delegateMasquerades(map[string]interface{}{"type": "bridge", "ipMasq": true}))
+	assert.False(t, delegateMasquerades(map[string]interface{}{"type": "macvlan"}))
+}
@@ -0,0 +1,165 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// How IPv6-only pods get their default route.
+const (
+	// From router advertisements on the pod's interface.
+	ipv6RouteRA = "ra"
+	// Via a static gateway: the configured one, or else the one in the
+	// delegate's result.  The default.
+	ipv6RouteStatic = "static"
+	// Leave the routes to the delegate.
+	ipv6RouteNone = "none"
+)
+
+// Delegate and IPAM types known to support IPv6 addresses.
+var (
+	ipv6Delegates = map[string]bool{"bridge": true, "ptp": true, "macvlan": true, "ipvlan": true, "host-device": true}
+	ipv6IPAMs     = map[string]bool{"host-local": true, "static": true}
+)
+
+// The "ipv6Only" block of a network config, for pods that get no IPv4
+// address at all.
+type ipv6OnlyConfig struct {
+	DefaultRoute string `json:"defaultRoute"`
+	Gateway      string `json:"gateway"`
+}
+
+// The parts of an IPAM config the IPv6 validation looks at.
+type ipamSubnets struct {
+	Type   string `json:"type"`
+	Subnet string `json:"subnet"`
+	Ranges [][]struct {
+		Subnet string `json:"subnet"`
+	} `json:"ranges"`
+}
+
+// Parse and validate the "ipv6Only" block of a network config: the
+// delegate and its IPAM must support IPv6, and any subnets given must
+// be IPv6 ones.
+func parseIPv6Only(netconf map[string]interface{}) (*ipv6OnlyConfig, error) {
+	cfg := &ipv6OnlyConfig{}
+	if ok, err := decodeNetConfKey(netconf, "ipv6Only", cfg); !ok || err != nil {
+		return nil, err
+	}
+
+	switch cfg.DefaultRoute {
+	case "":
+		cfg.DefaultRoute = ipv6RouteStatic
+	case ipv6RouteRA, ipv6RouteStatic, ipv6RouteNone:
+	default:
+		return nil, fmt.Errorf("Unknown ipv6Only defaultRoute %q.", cfg.DefaultRoute)
+	}
+
+	if cfg.Gateway != "" {
+		if ip := net.ParseIP(cfg.Gateway); ip == nil || ip.To4() != nil {
+			return nil, fmt.Errorf("Invalid IPv6 gateway %q.", cfg.Gateway)
+		}
+	}
+
+	delegateType, _ := netconf["type"].(string)
+	if !ipv6Delegates[delegateType] {
+		return nil, fmt.Errorf("Delegate type %q does not support IPv6-only networks.", delegateType)
+	}
+
+	ipam := &ipamSubnets{}
+	if ok, err := decodeNetConfKey(netconf, "ipam", ipam); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, errors.New("IPv6-only networks need an ipam config.")
+	}
+	if !ipv6IPAMs[ipam.Type] {
+		return nil, fmt.Errorf("IPAM type %q does not support IPv6-only networks.", ipam.Type)
+	}
+
+	subnets := []string{}
+	if ipam.Subnet != "" {
+		subnets = append(subnets, ipam.Subnet)
+	}
+	for _, set := range ipam.Ranges {
+		for _, r := range set {
+			subnets = append(subnets, r.Subnet)
+		}
+	}
+	for _, subnet := range subnets {
+		ip, _, err := net.ParseCIDR(subnet)
+		if err != nil || ip.To4() != nil {
+			return nil, fmt.Errorf("Subnet %q of an IPv6-only network is not an IPv6 subnet.", subnet)
+		}
+	}
+
+	return cfg, nil
+}
+
+// Make sure IPv6 is enabled in the pod's network namespace, before the
+// delegate assigns addresses.
+func (cfg *ipv6OnlyConfig) prepare(log *logrus.Entry, netns string) error {
+	return applySysctls(log, netns, map[string]string{
+		"net.ipv6.conf.all.disable_ipv6":     "0",
+		"net.ipv6.conf.default.disable_ipv6": "0",
+	})
+}
+
+// Check the delegate's result, which must have an IPv6 address and no
+// IPv4 one, and set up the pod's default route.
+func (cfg *ipv6OnlyConfig) apply(log *logrus.Entry, netns, ifName string, result *types.Result) error {
+	if result.IP4 != nil {
+		return fmt.Errorf("Delegate assigned IPv4 address %s on an IPv6-only network.", result.IP4.IP.String())
+	}
+	if result.IP6 == nil {
+		return errors.New("Delegate assigned no IPv6 address on an IPv6-only network.")
+	}
+
+	switch cfg.DefaultRoute {
+	case ipv6RouteRA:
+		return applySysctls(log, netns, map[string]string{
+			fmt.Sprintf("net.ipv6.conf.%s.accept_ra", ifName): "2",
+		})
+
+	case ipv6RouteStatic:
+		gateway := cfg.Gateway
+		if gateway == "" && result.IP6.Gateway != nil {
+			gateway = result.IP6.Gateway.String()
+		}
+		if gateway == "" {
+			return errors.New("IPv6-only network has no gateway for the default route.")
+		}
+
+		err := withNetNS(netns, func() error {
+			return routeReplaceDefault(gateway, ifName)
+		})
+		if err != nil {
+			return err
+		}
+
+		log.WithField("gateway", gateway).Debug("Set IPv6 default route.")
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// Accept only delegates, IPAMs and subnets that support IPv6.
+func TestParseIPv6Only(t *testing.T) {
+	cfg, err := parseIPv6Only(map[string]interface{}{
+		"type":     "bridge",
+		"ipv6Only": map[string]interface{}{},
+		"ipam":     map[string]interface{}{"type": "host-local", "subnet": "fd00:10::/64"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, ipv6RouteStatic, cfg.DefaultRoute)
+
+	cfg, err = parseIPv6Only(map[string]interface{}{"type": "bridge"})
+	assert.NoError(t, err)
+	assert.Nil(t, cfg)
+
+	for _, netconf := range []map[string]interface{}{
+		{"type": "flannel", "ipv6Only": map[string]interface{}{}, "ipam": map[string]interface{}{"type": "host-local"}},
+		{"type": "bridge", "ipv6Only": map[string]interface{}{}, "ipam": map[string]interface{}{"type": "dhcp"}},
+		{"type": "bridge", "ipv6Only": map[string]interface{}{}},
+		{"type": "bridge", "ipv6Only": map[string]interface{}{}, "ipam": map[string]interface{}{"type": "host-local", "subnet": "10.0.0.0/24"}},
+		{"type": "bridge", "ipv6Only": map[string]interface{}{"defaultRoute": "dhcp"}, "ipam": map[string]interface{}{"type": "host-local"}},
+		{"type": "bridge", "ipv6Only": map[string]interface{}{"gateway": "10.0.0.1"}, "ipam": map[string]interface{}{"type": "host-local"}},
+		{"type": "bridge", "ipv6Only": map[string]interface{}{}, "ipam": map[string]interface{}{
+			"type":   "host-local",
+			"ranges": []interface{}{[]interface{}{map[string]interface{}{"subnet": "10.0.0.0/24"}}},
+		}},
+	} {
+		_, err := parseIPv6Only(netconf)
+		assert.Error(t, err, "%v", netconf)
+	}
+}
+
+// Reject results with IPv4 addresses or without IPv6 ones.
+func TestIPv6OnlyResult(t *testing.T) {
+	cfg := &ipv6OnlyConfig{DefaultRoute: ipv6RouteNone}
+
+	v4 := &types.IPConfig{IP: net.IPNet{IP: net.ParseIP("10.0.0.2"), Mask: net.CIDRMask(24, 32)}}
+	v6 := &types.IPConfig{IP: net.IPNet{IP: net.ParseIP("fd00:10::2"), Mask: net.CIDRMask(64, 128)}}
+
+	assert.Error(t, cfg.apply(log, "", "eth0", &types.Result{IP4: v4, IP6: v6}))
+	assert.Error(t, cfg.apply(log, "", "eth0", &types.Result{}))
+	assert.NoError(t, cfg.apply(log, "", "eth0", &types.Result{IP6: v6}))
+}
@@ -0,0 +1,87 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"net"
+
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Create the host's ipvlan slave of the master, if needed.
+func ensureIPvlanHostInterface(log *logrus.Entry, m *selector.IPvlanMap) error {
+	name := m.HostInterface()
+	if _, err := net.InterfaceByName(name); err == nil {
+		return nil
+	}
+
+	if _, err := runCommand("ip", "link", "add", "link", m.Master, "name", name,
+		"type", "ipvlan", "mode", m.Mode); err != nil {
+		// Another ADD may have created it meanwhile.
+		if _, err2 := net.InterfaceByName(name); err2 != nil {
+			return err
+		}
+	}
+
+	if _, err := runCommand("ip", "link", "set", name, "up"); err != nil {
+		return err
+	}
+
+	log.WithField("interface", name).Info("Created ipvlan host interface.")
+	return nil
+}
+
+// Route the pod's addresses on the host via the host's ipvlan slave,
+// if the namespace is in the ipvlan map.  Returns the routes added.
+func addIPvlanHostRoutes(log *logrus.Entry, m *selector.IPvlanMap, namespace string, ips []net.IP) ([]string, error) {
+	if _, ok := m.Namespaces[namespace]; !ok {
+		return nil, nil
+	}
+
+	if err := ensureIPvlanHostInterface(log, m); err != nil {
+		return nil, err
+	}
+
+	var routes []string
+	for _, ip := range ips {
+		route := hostRoute(ip)
+		if _, err := runCommand("ip", "route", "replace", route, "dev", m.HostInterface()); err != nil {
+			return routes, err
+		}
+		routes = append(routes, route)
+	}
+
+	log.WithFields(logrus.Fields{
+		"routes":    routes,
+		"interface": m.HostInterface(),
+	}).Debug("Added ipvlan host routes.")
+
+	return routes, nil
+}
+
+// Remove host routes added for a pod.  Removal is best effort, so that
+// DEL can always succeed.
+func removeIPvlanHostRoutes(log *logrus.Entry, dev string, routes []string) {
+	for _, route := range routes {
+		if _, err := runCommand("ip", "route", "del", route, "dev", dev); err != nil {
+			log.WithField("error", err).Warn("Failed to remove ipvlan host route.")
+		}
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+	"github.com/stretchr/testify/assert"
+)
+
+// Leave pods in namespaces outside the ipvlan map alone.
+func TestAddIPvlanHostRoutesUnmapped(t *testing.T) {
+	m := &selector.IPvlanMap{Mode: selector.IPvlanModeL3, Master: "eth0", Namespaces: map[string]string{"tenant-a": "10.4.0.0/24"}}
+
+	routes, err := addIPvlanHostRoutes(log, m, "other", []net.IP{net.ParseIP("10.1.0.5")})
+	assert.NoError(t, err)
+	assert.Nil(t, routes)
+}
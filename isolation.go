@@ -0,0 +1,166 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Namespace isolation is done with ebtables, in the filter table's
+// FORWARD chain, so it applies to frames bridged between pods.
+// Traffic routed through the host is not affected.  The chains are:
+//
+//   FORWARD       -j KN-DST-<pod>   for every isolated pod
+//   KN-DST-<pod>  --ip-dst <pod IP> -j KN-ISO-<namespace>
+//   KN-ISO-<ns>   -j KN-MEM-<ns>, -j KN-MEM-<allowed ns>...; policy DROP
+//   KN-MEM-<ns>   -j KN-SRC-<pod>   for every pod in the namespace
+//   KN-SRC-<pod>  --ip-src <pod IP> -j ACCEPT
+//
+// Chains are named after the container ID, so that DEL, which does not
+// know the pod's addresses, can remove exactly the pod's rules.
+
+func isolationChain(prefix, name string) string {
+	return prefix + shortHash(name)
+}
+
+// Create an ebtables chain with the given policy, unless it exists.
+func ensureEbtablesChain(chain, policy string) error {
+	if _, err := runCommand("ebtables", "-L", chain); err == nil {
+		return nil
+	}
+
+	if _, err := runCommand("ebtables", "-N", chain, "-P", policy); err != nil {
+		// Another pod may have created it concurrently.
+		if _, lerr := runCommand("ebtables", "-L", chain); lerr != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Return the ebtables match arguments for traffic to or from ip.
+func ebtablesIPMatch(ipc *types.IPConfig, direction string) []string {
+	if ipc.IP.IP.To4() != nil {
+		return []string{"-p", "IPv4", "--ip-" + direction, ipc.IP.IP.String()}
+	}
+
+	return []string{"-p", "IPv6", "--ip6-" + direction, ipc.IP.IP.String()}
+}
+
+// Isolate a pod in namespace, so that only pods in the same namespace
+// or in one of allowFrom can reach it over a shared bridge.
+func isolatePod(log *logrus.Entry, containerID, namespace string, allowFrom []string, result *types.Result) error {
+	src := isolationChain("KN-SRC-", containerID)
+	dst := isolationChain("KN-DST-", containerID)
+	mem := isolationChain("KN-MEM-", namespace)
+	iso := isolationChain("KN-ISO-", namespace)
+
+	for _, chain := range []string{src, dst, mem} {
+		if err := ensureEbtablesChain(chain, "RETURN"); err != nil {
+			return err
+		}
+	}
+	if err := ensureEbtablesChain(iso, "DROP"); err != nil {
+		return err
+	}
+
+	for _, chain := range []string{src, dst} {
+		if _, err := runCommand("ebtables", "-F", chain); err != nil {
+			return err
+		}
+	}
+
+	for _, ipc := range []*types.IPConfig{result.IP4, result.IP6} {
+		if ipc == nil {
+			continue
+		}
+
+		srcRule := append([]string{"-A", src}, ebtablesIPMatch(ipc, "src")...)
+		if _, err := runCommand("ebtables", append(srcRule, "-j", "ACCEPT")...); err != nil {
+			return err
+		}
+
+		dstRule := append([]string{"-A", dst}, ebtablesIPMatch(ipc, "dst")...)
+		if _, err := runCommand("ebtables", append(dstRule, "-j", iso)...); err != nil {
+			return err
+		}
+	}
+
+	// Rebuild the namespace's ingress chain, so that changes to
+	// allowFrom are picked up as pods are added.
+	if _, err := runCommand("ebtables", "-F", iso); err != nil {
+		return err
+	}
+	for _, ns := range append([]string{namespace}, allowFrom...) {
+		allowed := isolationChain("KN-MEM-", ns)
+		if err := ensureEbtablesChain(allowed, "RETURN"); err != nil {
+			return err
+		}
+		if _, err := runCommand("ebtables", "-A", iso, "-j", allowed); err != nil {
+			return err
+		}
+	}
+
+	if err := ensureEbtablesRule(log, mem, "-j", src); err != nil {
+		return err
+	}
+	if err := ensureEbtablesRule(log, "FORWARD", "-j", dst); err != nil {
+		return err
+	}
+
+	log.WithFields(logrus.Fields{
+		"namespace":  namespace,
+		"allow_from": allowFrom,
+	}).Debug("Isolated pod from other namespaces.")
+
+	return nil
+}
+
+// Append a rule to chain, replacing any existing copy of it.
+func ensureEbtablesRule(log *logrus.Entry, chain string, rule ...string) error {
+	if _, err := runCommand("ebtables", append([]string{"-D", chain}, rule...)...); err == nil {
+		log.WithField("chain", chain).Debug("Replacing existing ebtables rule.")
+	}
+
+	_, err := runCommand("ebtables", append([]string{"-A", chain}, rule...)...)
+	return err
+}
+
+// Remove a pod's isolation rules.  Errors are logged, since the rules
+// may never have been installed.
+func unisolatePod(log *logrus.Entry, containerID, namespace string) {
+	src := isolationChain("KN-SRC-", containerID)
+	dst := isolationChain("KN-DST-", containerID)
+	mem := isolationChain("KN-MEM-", namespace)
+
+	cmds := [][]string{
+		{"-D", "FORWARD", "-j", dst},
+		{"-D", mem, "-j", src},
+		{"-X", dst},
+		{"-X", src},
+	}
+
+	for _, cmd := range cmds {
+		if _, err := runCommand("ebtables", cmd...); err != nil {
+			log.WithField("error", err).Debug("Failed to remove isolation rule.")
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// How long an ADD or DEL waits for another operation on the same
+// container to finish.  Longer than a delegate slot wait, since the
+// other operation may itself be waiting for one.
+const containerLockTimeout = 2 * delegateSlotTimeout
+
+// Take the lock for operating on a container, waiting if another ADD
+// or DEL holds it.  Returns a function releasing it.  Holding it
+// means that overlapping invocations for the same container, e.g.
+// after a kubelet restart, run the delegate one at a time.
+func (c *config) lockContainer(containerID string) (func(), error) {
+	dir := c.StateDir
+	if dir == "" {
+		dir = defaultStateDir
+	}
+
+	return acquireSlot(c.Logger(), filepath.Join(dir, "locks"), containerLockName(containerID), 1, containerLockTimeout)
+}
+
+func containerLockName(containerID string) string {
+	return "container-" + shortHash(containerID)
+}
+
+// The version of the journal entry format, and the migrations from
+// older ones; see attachmentSchemaVersion.
+const journalSchemaVersion = 1
+
+var journalMigrations = map[int]func(record map[string]interface{}) error{}
+
+// An operation on a container that was started.
+type journalEntry struct {
+	SchemaVersion int `json:"schemaVersion"`
+
+	Op      string    `json:"op"`
+	Pid     int       `json:"pid"`
+	Started time.Time `json:"started"`
+}
+
+// The operation journal records the ADD or DEL in progress for each
+// container, so that one that was interrupted, by the plugin being
+// killed or the node restarting, is noticed by the next one.  Entries
+// are only written with the container's lock held.
+type opJournal struct {
+	dir string
+}
+
+func newOpJournal(stateDir string) *opJournal {
+	return &opJournal{dir: filepath.Join(newAttachmentStore(stateDir).dir, "journal")}
+}
+
+func (j *opJournal) path(containerID string) (string, error) {
+	return (&attachmentStore{dir: j.dir}).path(containerID)
+}
+
+// Record the start of op on a container.  Returns the entry of an
+// earlier operation that never finished, or nil.
+func (j *opJournal) begin(log *logrus.Entry, containerID, op string) (*journalEntry, error) {
+	path, err := j.path(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	interrupted, err := readJournalEntry(log, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if interrupted != nil {
+		log.WithFields(logrus.Fields{
+			"op":      interrupted.Op,
+			"pid":     interrupted.Pid,
+			"started": interrupted.Started,
+		}).Warn("Previous operation on the container was interrupted.")
+	}
+
+	err = writeJournalEntry(path, &journalEntry{
+		Op:      op,
+		Pid:     os.Getpid(),
+		Started: time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return interrupted, nil
+}
+
+// Bring a container's journal entry, if any, to the current format.
+// The caller must hold the container's lock.
+func (j *opJournal) migrate(containerID string) error {
+	path, err := j.path(containerID)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	entry := &journalEntry{}
+	migrated, err := decodeVersioned(path, data, entry, journalSchemaVersion, journalMigrations)
+	if err != nil || !migrated {
+		return err
+	}
+
+	return writeJournalEntry(path, entry)
+}
+
+// Read a journal entry, migrating it if it is in an older format.
+// Returns nil if there is none, or it cannot be read.
+func readJournalEntry(log *logrus.Entry, path string) (*journalEntry, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to read journal: %v", err)
+	}
+
+	entry := &journalEntry{}
+	if _, err := decodeVersioned(path, data, entry, journalSchemaVersion, journalMigrations); err != nil {
+		log.WithField("error", err).Warn("Ignoring unreadable journal entry.")
+		return nil, nil
+	}
+
+	return entry, nil
+}
+
+func writeJournalEntry(path string, entry *journalEntry) error {
+	entry.SchemaVersion = journalSchemaVersion
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal journal entry: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("Failed to create journal directory: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("Failed to write journal: %v", err)
+	}
+
+	return nil
+}
+
+// Record that the operation on a container finished, successfully or
+// not.
+func (j *opJournal) finish(log *logrus.Entry, containerID string) {
+	path, err := j.path(containerID)
+	if err != nil {
+		return
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.WithField("error", err).Warn("Failed to clear journal entry.")
+	}
+}
+
+// Take the container's lock and journal op, for the duration of an
+// ADD or DEL.  Returns the interrupted earlier operation, if any, and
+// a function to call when done.
+func (c *config) beginOp(containerID, op string) (*journalEntry, func(), error) {
+	// Before taking the lock, as migrating takes other containers'.
+	if err := c.migrateState(); err != nil {
+		c.Logger().WithField("error", err).Warn("Failed to migrate state.")
+	}
+
+	release, err := c.lockContainer(containerID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	journal := newOpJournal(c.StateDir)
+	interrupted, err := journal.begin(c.Logger(), containerID, op)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	return interrupted, func() {
+		journal.finish(c.Logger(), containerID)
+		release()
+	}, nil
+}
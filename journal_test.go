@@ -0,0 +1,111 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+	"github.com/stretchr/testify/assert"
+)
+
+// Report an operation that was begun but never finished.
+func TestOpJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-journal")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	journal := newOpJournal(dir)
+
+	interrupted, err := journal.begin(log, "abc", "ADD")
+	assert.NoError(t, err)
+	assert.Nil(t, interrupted)
+
+	interrupted, err = journal.begin(log, "abc", "DEL")
+	assert.NoError(t, err)
+	if assert.NotNil(t, interrupted) {
+		assert.Equal(t, "ADD", interrupted.Op)
+	}
+
+	journal.finish(log, "abc")
+	interrupted, err = journal.begin(log, "abc", "ADD")
+	assert.NoError(t, err)
+	assert.Nil(t, interrupted)
+
+	_, err = journal.begin(log, "../abc", "ADD")
+	assert.Error(t, err)
+}
+
+// Hold the container's lock until the operation is done.
+func TestBeginOp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-journal")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := &config{StateDir: dir}
+
+	_, done, err := config.beginOp("abc", "ADD")
+	assert.NoError(t, err)
+
+	_, err = acquireSlot(log, dir+"/locks", "container-"+shortHash("abc"), 1, 0)
+	assert.Error(t, err)
+
+	done()
+	release, err := acquireSlot(log, dir+"/locks", "container-"+shortHash("abc"), 1, 0)
+	if assert.NoError(t, err) {
+		release()
+	}
+}
+
+// Run the delegate DEL, not another ADD, to clean up after an
+// interrupted ADD.
+func TestInterruptedAddCleanup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-journal")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	commands := filepath.Join(dir, "commands")
+	writeHook(t, dir, "fake", `cat > /dev/null
+echo "$CNI_COMMAND" >> `+commands+`
+echo '{"ip4": {"ip": "10.1.0.5/16"}}'`)
+
+	config, err := parseConfig([]byte(`{
+	  "stateDir": "` + filepath.Join(dir, "state") + `",
+	  "default": {"name": "fake-net", "type": "fake"}
+	}`))
+	assert.NoError(t, err)
+
+	_, err = newOpJournal(config.StateDir).begin(log, "abc", "ADD")
+	assert.NoError(t, err)
+
+	// The environment inherited from the runtime says ADD.
+	args := &skel.CmdArgs{ContainerID: "abc", IfName: "eth0", Args: "K8S_POD_NAMESPACE=web;K8S_POD_NAME=web-1"}
+	env := &selector.DelegateEnv{CNIPath: dir, Args: &invoke.Args{Command: "ADD", ContainerID: "abc", IfName: "eth0"}}
+
+	assert.NoError(t, addNetwork(config, args, env, &bytes.Buffer{}))
+
+	data, err := ioutil.ReadFile(commands)
+	assert.NoError(t, err)
+	assert.Equal(t, "DEL\nADD\n", string(data))
+}
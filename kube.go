@@ -0,0 +1,361 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+)
+
+const (
+	defaultKubeTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	defaultKubeCAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	kubeRequestTimeout   = 10 * time.Second
+)
+
+// How to reach the Kubernetes API, for features that look at pods.
+// The token and CA default to the service account ones, for a plugin
+// installed by a DaemonSet that mounts them on the host.
+type kubeConfig struct {
+	Server    string `json:"server"`
+	TokenFile string `json:"tokenFile"`
+	CAFile    string `json:"caFile"`
+
+	// The HTTP client, built on first use and kept for as long as the
+	// config is, so that a daemon reuses its connections.  The token is
+	// read on every call, as it is rotated.
+	mu   sync.Mutex
+	http *http.Client
+}
+
+// A minimal client for the Kubernetes API.
+type kubeClient struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+// The parts of a pod kube-namespace looks at.
+type kubePod struct {
+	Metadata kubeObjectMeta `json:"metadata"`
+	Spec     struct {
+		ServiceAccountName string          `json:"serviceAccountName"`
+		NodeName           string          `json:"nodeName"`
+		Containers         []kubeContainer `json:"containers"`
+		InitContainers     []kubeContainer `json:"initContainers"`
+	} `json:"spec"`
+	Status struct {
+		QOSClass string `json:"qosClass"`
+	} `json:"status"`
+}
+
+// The parts of a container kube-namespace looks at.  Quantities are
+// left as strings.
+type kubeContainer struct {
+	Resources struct {
+		Requests map[string]string `json:"requests"`
+		Limits   map[string]string `json:"limits"`
+	} `json:"resources"`
+}
+
+// The parts of an object's metadata kube-namespace looks at.
+type kubeObjectMeta struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Return a client for the API server.  Fails if none is configured.
+func (k *kubeConfig) client() (*kubeClient, error) {
+	if k == nil || k.Server == "" {
+		return nil, errors.New("No Kubernetes API server configured.")
+	}
+
+	tokenFile := k.TokenFile
+	if tokenFile == "" {
+		tokenFile = defaultKubeTokenFile
+	}
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read Kubernetes token: %v", err)
+	}
+
+	httpClient, err := k.httpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return &kubeClient{
+		server: strings.TrimSuffix(k.Server, "/"),
+		token:  strings.TrimSpace(string(token)),
+		http:   httpClient,
+	}, nil
+}
+
+// Return the HTTP client trusting the API server's CA, building it
+// on first use.
+func (k *kubeConfig) httpClient() (*http.Client, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.http != nil {
+		return k.http, nil
+	}
+
+	caFile := k.CAFile
+	if caFile == "" {
+		caFile = defaultKubeCAFile
+	}
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read Kubernetes CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("No certificates in Kubernetes CA %q.", caFile)
+	}
+
+	k.http = &http.Client{
+		Timeout:   kubeRequestTimeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}
+	return k.http, nil
+}
+
+// The parts of a kubeconfig file kube-namespace understands.
+type kubeconfigFile struct {
+	CurrentContext string `json:"current-context"`
+	Contexts       []struct {
+		Name    string `json:"name"`
+		Context struct {
+			Cluster string `json:"cluster"`
+			User    string `json:"user"`
+		} `json:"context"`
+	} `json:"contexts"`
+	Clusters []struct {
+		Name    string `json:"name"`
+		Cluster struct {
+			Server                   string `json:"server"`
+			CertificateAuthority     string `json:"certificate-authority"`
+			CertificateAuthorityData []byte `json:"certificate-authority-data"`
+		} `json:"cluster"`
+	} `json:"clusters"`
+	Users []struct {
+		Name string `json:"name"`
+		User struct {
+			Token                 string `json:"token"`
+			TokenFile             string `json:"tokenFile"`
+			ClientCertificate     string `json:"client-certificate"`
+			ClientCertificateData []byte `json:"client-certificate-data"`
+			ClientKey             string `json:"client-key"`
+			ClientKeyData         []byte `json:"client-key-data"`
+		} `json:"user"`
+	} `json:"users"`
+}
+
+// Read inline data, or else the file named by path, relative to dir.
+func kubeconfigData(data []byte, path, dir string) ([]byte, error) {
+	if len(data) > 0 || path == "" {
+		return data, nil
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	return ioutil.ReadFile(path)
+}
+
+// Return a client for the current context of a kubeconfig file, for
+// commands run from outside the cluster.  The file may be YAML, as
+// kubectl writes it, or JSON.  Tokens and client certificates are
+// supported, auth providers and exec plugins are not.
+func kubeconfigClient(path string) (*kubeClient, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read kubeconfig: %v", err)
+	}
+
+	kc := &kubeconfigFile{}
+	if data, err = selector.ToJSON(path, "", data); err == nil {
+		err = json.Unmarshal(data, kc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse kubeconfig %q: %v", path, err)
+	}
+
+	var clusterName, userName string
+	for _, c := range kc.Contexts {
+		if c.Name == kc.CurrentContext {
+			clusterName, userName = c.Context.Cluster, c.Context.User
+		}
+	}
+	if clusterName == "" {
+		return nil, fmt.Errorf("Kubeconfig context %q not found.", kc.CurrentContext)
+	}
+
+	dir := filepath.Dir(path)
+	tlsConfig := &tls.Config{}
+	server := ""
+	for _, c := range kc.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		server = c.Cluster.Server
+
+		ca, err := kubeconfigData(c.Cluster.CertificateAuthorityData, c.Cluster.CertificateAuthority, dir)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read Kubernetes CA: %v", err)
+		}
+		if len(ca) > 0 {
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("No certificates in the CA of cluster %q.", clusterName)
+			}
+		}
+	}
+	if server == "" {
+		return nil, fmt.Errorf("Kubeconfig cluster %q not found or has no server.", clusterName)
+	}
+
+	token := ""
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+
+		data, err := kubeconfigData([]byte(u.User.Token), u.User.TokenFile, dir)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read Kubernetes token: %v", err)
+		}
+		token = strings.TrimSpace(string(data))
+
+		cert, err := kubeconfigData(u.User.ClientCertificateData, u.User.ClientCertificate, dir)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read client certificate: %v", err)
+		}
+		key, err := kubeconfigData(u.User.ClientKeyData, u.User.ClientKey, dir)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read client key: %v", err)
+		}
+		if len(cert) > 0 {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid client certificate of user %q: %v", userName, err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+	}
+
+	return &kubeClient{
+		server: strings.TrimSuffix(server, "/"),
+		token:  token,
+		http: &http.Client{
+			Timeout:   kubeRequestTimeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+// Send a request to the API server, decoding the response into out
+// unless it is nil.  PATCH bodies are JSON merge patches.
+func (c *kubeClient) do(method, path string, body, out interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, c.server+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	req.Header.Set("Accept", "application/json")
+	if method == "PATCH" {
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+	} else if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("Kubernetes API request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respData, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("Failed to read Kubernetes API response: %v", err)
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Kubernetes API %s %s returned %s: %s", method, path, resp.Status,
+			strings.TrimSpace(string(respData)))
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(respData, out); err != nil {
+		return fmt.Errorf("Failed to parse Kubernetes API response: %v", err)
+	}
+
+	return nil
+}
+
+// Get a pod.
+func (c *kubeClient) getPod(namespace, name string) (*kubePod, error) {
+	pod := &kubePod{}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", url.PathEscape(namespace), url.PathEscape(name))
+	if err := c.do("GET", path, nil, pod); err != nil {
+		return nil, err
+	}
+
+	return pod, nil
+}
+
+// Get the data of a secret.
+func (c *kubeClient) getSecret(namespace, name string) (map[string][]byte, error) {
+	secret := struct {
+		Data map[string][]byte `json:"data"`
+	}{}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", url.PathEscape(namespace), url.PathEscape(name))
+	if err := c.do("GET", path, nil, &secret); err != nil {
+		return nil, err
+	}
+
+	return secret.Data, nil
+}
@@ -12,158 +12,814 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build linux
+// +build linux
+
 package main
 
 import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
-	"strings"
+	"time"
 
-	"github.com/containernetworking/cni/pkg/invoke"
 	"github.com/containernetworking/cni/pkg/skel"
-	"github.com/containernetworking/cni/pkg/version"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
 
 	"github.com/Sirupsen/logrus"
 )
 
-var log = logrus.NewEntry(logrus.New())
-
+// The plugin config.  The parts deciding which network config a pod
+// gets are in selector.Config.
 type config struct {
+	*selector.Config `json:"-"`
+
+	CNIVersion string `json:"cniVersion"`
 	Name       string
 	Type       string
 	LogLevel   string `json:"log_level"`
-	Default    map[string]interface{}
-	Namespaces map[string]map[string]interface{}
+
+	// The result of the previous plugin, when kube-namespace runs in
+	// a plugin chain.
+	PrevResult map[string]interface{} `json:"prevResult"`
+
+	// Capability arguments passed by the runtime, e.g. hostPort
+	// mappings.
+	RuntimeConfig runtimeConfig `json:"runtimeConfig"`
+
+	// Block bridged traffic between pods in different configured
+	// namespaces, except from namespaces listed in "allowFrom".
+	IsolateNamespaces bool `json:"isolateNamespaces"`
+
+	// Whether to offload egress rules to the NIC: "off" (the
+	// default), "auto" or "required".
+	RuleOffload string `json:"ruleOffload"`
+
+	// The most invocations of each delegate type, e.g. "dhcp", that
+	// may run at once on the node.  Further invocations wait.
+	DelegateConcurrency map[string]int `json:"delegateConcurrency"`
+
+	// The most delegate invocations of any type that may run at once
+	// on the node.
+	MaxParallelOps int `json:"maxParallelOps"`
+
+	// Unix socket of a kube-namespace daemon to forward ADD and DEL
+	// to.  If the daemon is not running, the plugin handles them
+	// itself.
+	DaemonSocket string `json:"daemonSocket"`
+
+	// File to append a JSON line to for every attachment added or
+	// removed.
+	AuditLog string `json:"auditLog"`
+
+	// Directory holding a record of every attachment.
+	StateDir string `json:"stateDir"`
+
+	// Directory to dump every delegate invocation to, for replaying
+	// with "kube-namespace replay".
+	DebugDir string `json:"debug_dir"`
+
+	// Executables to run around ADD and DEL; see hooks.go.
+	Hooks *hooksConfig `json:"hooks"`
+
+	// Read namespace configs from NamespaceNetwork resources; see
+	// crd.go.
+	NamespaceNetworks *crdConfig `json:"namespaceNetworks"`
+
+	// Read the namespace to profile mapping from etcd; see etcd.go.
+	Etcd *etcdConfig `json:"etcd"`
+
+	// How to reach the Kubernetes API, for features that look up
+	// pods.
+	Kubernetes *kubeConfig `json:"kubernetes"`
+
+	// Masquerade traffic leaving pods' subnets, for delegates that do
+	// not do so themselves; see ipmasq.go.
+	IPMasq bool `json:"ipMasq"`
+
+	// Bring up the pod's loopback interface on ADD, for runtimes that
+	// only invoke one plugin and so never run the loopback plugin.
+	ConfigureLoopback bool `json:"configureLoopback"`
+
+	// Post a Warning Event on pods whose delegate ADD fails.
+	PodEvents bool `json:"podEvents"`
+
+	// Record how pods were networked in their annotations; see
+	// annotations.go.
+	AnnotatePods bool `json:"annotatePods"`
+
+	// File holding the key that network grant annotations are signed
+	// with; see privileged.go.
+	GrantKeyFile string `json:"grantKeyFile"`
+
+	// A candidate plugin config file to select every pod's network
+	// with too, logging where it differs; see shadow.go.
+	ShadowConfig string `json:"shadowConfig"`
+
+	// Pools of SR-IOV VFs, by name; see sriov.go.
+	SRIOVPools map[string]*sriovPool `json:"sriovPools"`
+
+	// Host NICs, by name or PCI address, that network configs may
+	// move into pods of each namespace; see hostdevice.go.
+	HostDevices map[string][]string `json:"hostDevices"`
+
+	// Where to export traces of ADD and DEL to; see tracing.go.
+	Tracing *tracingConfig `json:"tracing"`
+
+	// Limit repeated log lines across invocations; see logging.go.
+	LogRateLimit *logRateLimit `json:"logRateLimit"`
+
+	FaultInjection *faultConfig `json:"faultInjection"`
+
+	// The trace of this invocation, if tracing is configured.  The
+	// daemon sets it on a copy of its cached config for each request.
+	trace *trace
 }
 
-// Return the network config for the given namespace, or the default
-// config if no per-namespace config is found.  If the no config is
-// found for the namespace and no default is specified, return an
-// error.
-func (c *config) getNetConf(args string) (map[string]interface{}, error) {
-	extraArgs := parseExtraArgs(args)
-	namespace, pod := extraArgs["K8S_POD_NAMESPACE"], extraArgs["K8S_POD_NAME"]
+// The network config selected for a pod, and why it was selected.
+type selection = selector.Selection
+
+// The rule names recorded when a pod uses the default config or the
+// system network.
+const (
+	defaultRule = selector.DefaultRule
+	systemRule  = selector.SystemRule
+)
+
+// Parse the plugin config, reading it from configFiles if it lists
+// any; see configsources.go.
+func parseConfig(data []byte) (*config, error) {
+	return parseConfigSources(data, parseConfigData)
+}
 
-	if namespace == "" {
-		return nil, errors.New("Kubernetes namespace argument missing or empty.")
+// Parse a complete plugin config.
+func parseConfigData(data []byte) (*config, error) {
+	config := &config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, selector.ParseError("config", data, err)
 	}
 
-	if cfg, ok := c.Namespaces[namespace]; ok {
-		log.WithFields(logrus.Fields{
-			"namespace": namespace,
-			"pod":       pod,
-			"config":    cfg,
-		}).Debug("Using namespace specific config.")
+	if err := validatePortMappings(config.RuntimeConfig.PortMappings); err != nil {
+		return nil, err
+	}
 
-		return cfg, nil
+	if config.Hooks != nil {
+		if err := config.Hooks.validate(); err != nil {
+			return nil, err
+		}
 	}
 
-	if len(c.Default) == 0 {
-		return nil,
-			fmt.Errorf("Config for namespace %q not found, and no default given.", namespace)
+	if config.Etcd != nil && len(config.Etcd.Endpoints) == 0 {
+		return nil, errors.New("etcd block given without endpoints.")
 	}
 
-	log.WithFields(logrus.Fields{
-		"namespace": namespace,
-		"pod":       pod,
-		"config":    c.Default,
-	}).Debug("Per-namespace config not found. Using default.")
+	for name, pool := range config.SRIOVPools {
+		if pool == nil || pool.Master == "" {
+			return nil, fmt.Errorf("SR-IOV pool %q has no master.", name)
+		}
+	}
 
-	return c.Default, nil
+	if l := config.LogRateLimit; l != nil && (l.Burst <= 0 || l.IntervalSeconds <= 0) {
+		return nil, errors.New("logRateLimit needs a positive burst and intervalSeconds.")
+	}
+
+	var err error
+	if config.Config, err = selector.Parse(data); err != nil {
+		return nil, err
+	}
+
+	return config, nil
 }
 
-func (c *config) setLogLevel() {
-	if c.LogLevel == "" {
-		return
+// Return whether the selected pod should be isolated from other
+// namespaces.  Only namespaces with their own config are; system
+// namespaces are left alone.
+func (c *config) isolated(sel *selection) bool {
+	return c.IsolateNamespaces && sel.Namespace != "" &&
+		sel.Rule != defaultRule && sel.Rule != systemRule
+}
+
+// Return the fault injection settings, or nil if fault injection is
+// not configured or not compiled in.
+func (c *config) faults() *faultConfig {
+	if !faultInjectionEnabled {
+		return nil
+	}
+
+	return c.FaultInjection
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	if ipam := parseIPAMInvocation(args.StdinData); ipam != nil {
+		return ipamAdd(ipam, args, os.Stdout)
 	}
 
-	if logLevel, err := logrus.ParseLevel(c.LogLevel); err != nil {
-		log.Error("Unknown log level. Using default: INFO")
-	} else {
-		log.Logger.Level = logLevel
+	if forwarded, err := forwardToDaemon("ADD", args, os.Stdout); forwarded {
+		return err
+	}
+
+	start := time.Now()
+	config, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	finish := beginInvocation(config, log.Logger, "ADD", args, start)
+	// The process only handles this invocation, so code outside it
+	// logs with its fields too.
+	log = config.Logger()
+	selector.Log = log
+	log.Info("Configuring pod networking.")
+
+	err = addNetwork(config, args, selector.ProcessEnv(), os.Stdout)
+	finish(err)
+	return err
+}
+
+// Set up an invocation of command, begun at start, with its parsed
+// config: start its trace, and give it a logger of its own, writing
+// where base does, with the config's log level and rate limit and
+// fields identifying the request.  The code handling the invocation
+// logs with config.Logger(), so that concurrent invocations in the
+// daemon neither share fields nor change each other's level.  config
+// must not be shared with other invocations; the selector config it
+// points to may be, and is copied.  Returns a function ending the
+// invocation with its error.
+func beginInvocation(config *config, base *logrus.Logger, command string, args *skel.CmdArgs, start time.Time) func(error) {
+	logger := &logrus.Logger{Out: base.Out, Hooks: base.Hooks, Formatter: base.Formatter, Level: base.Level}
+	selConfig := *config.Config
+	selConfig.Log = logrus.NewEntry(logger).WithFields(logrus.Fields{
+		"container_id": args.ContainerID,
+		"request_id":   newRequestID(),
+	})
+	config.Config = &selConfig
+
+	config.trace = newTrace(config.Tracing, command, args.ContainerID, start)
+	config.trace.record("parse config", start, nil)
+
+	config.setLogLevel()
+	saveLogLimits := config.limitLogRate()
+
+	return func(err error) {
+		config.trace.export(config.Logger(), err)
+		saveLogLimits()
 	}
 }
 
-// Parse extra arguments passed in the CNI_ARGS environment variable.
-// Kubernetes uses this to provide the pod name and namespace.
-func parseExtraArgs(args string) map[string]string {
-	parsedArgs := make(map[string]string)
+// Set up networking for a pod, and write the result to stdout.
+func addNetwork(config *config, args *skel.CmdArgs, env *selector.DelegateEnv, stdout io.Writer) (err error) {
+	interrupted, done, err := config.beginOp(args.ContainerID, "ADD")
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	selectSpan := config.trace.start("select", spanKindInternal)
+	pod := config.lookupPod(args.Args)
+	sel, err := config.selectPodWith(args.Args, pod)
+	if err == nil {
+		sel, err = config.expandPodCIDR(sel)
+	}
+	if err == nil {
+		selectSpan.set("network", sel.Rule)
+	}
+	selectSpan.finish(err)
+	config.shadowSelect(args.Args, pod, sel, err)
+	if err != nil {
+		return err
+	}
+	config.reportDeprecation(sel, args)
 
-	for _, s := range strings.Split(args, ";") {
-		s := strings.SplitN(s, "=", 2)
-		if len(s) < 2 {
-			continue
+	options, err := parseNetOptions(sel.NetConf)
+	if err != nil {
+		return err
+	}
+	options.ruleOffload = config.RuleOffload
+	if options.ifNames != nil {
+		if args, env, err = options.ifNames.containerArgs(args, env, "ADD"); err != nil {
+			return err
 		}
+	}
+	options.portMappings = config.RuntimeConfig.PortMappings
 
-		k, v := s[0], s[1]
-		parsedArgs[k] = v
+	if options.frozen {
+		config.Logger().WithField("namespace", sel.Namespace).Warn("Rejecting pod in frozen namespace.")
+		config.reportFrozen(sel, args)
+		return newError(errCodeNamespaceFrozen, "Network config %q for namespace %q is frozen; not adding pod %q.",
+			sel.Rule, sel.Namespace, sel.Pod)
 	}
 
-	return parsedArgs
-}
+	if options.readinessFile != "" {
+		if err := checkReadiness(config.Logger(), options.readinessFile, sel); err != nil {
+			return err
+		}
+	}
+
+	if options.privilege != nil {
+		if err := config.checkPrivileged(options.privilege, sel); err != nil {
+			return err
+		}
+	}
+
+	if config.ConfigureLoopback && !options.networkless {
+		if err := setUpLoopback(args.Netns); err != nil {
+			return err
+		}
+	}
+
+	// An ADD that fails before the delegate has set anything up drops
+	// its quota and VF reservations: the runtime need not follow it
+	// with a DEL.
+	delegated := false
+	defer func() {
+		if err != nil && !delegated {
+			config.releaseReservation(args.ContainerID)
+		}
+	}()
+
+	if options.maxAttachments > 0 && sel.Namespace != "" {
+		if err := config.reserveAttachment(sel, args, options.maxAttachments); err != nil {
+			return err
+		}
+	}
+
+	if options.networkless {
+		return config.addNetworkless(sel, args, stdout)
+	}
+
+	if options.hostDevice != nil {
+		return config.addHostDevice(options.hostDevice, sel, args, stdout)
+	}
+
+	if options.ptpAuto != nil {
+		if sel, err = config.applyPTPAuto(options.ptpAuto, sel, args.ContainerID); err != nil {
+			return err
+		}
+	}
+
+	if options.ipv6Only != nil {
+		if err := options.ipv6Only.prepare(config.Logger(), args.Netns); err != nil {
+			return err
+		}
+	}
 
-func delegateAdd(netconf map[string]interface{}) error {
-	ncBytes, err := json.Marshal(netconf)
+	if config.VLANMap != nil {
+		if err := ensureVLAN(config.Logger(), config.VLANMap, sel.Namespace); err != nil {
+			return err
+		}
+	}
+
+	if options.dhcp != nil {
+		if err := options.dhcp.ensureDaemon(config.Logger(), env.CNIPath); err != nil {
+			return err
+		}
+	}
+
+	faults := config.faults()
+	if faults != nil {
+		faults.delay(config.Logger())
+		if err := faults.failAdd(config.Logger()); err != nil {
+			return err
+		}
+	}
+
+	delegateConf := config.delegateNetConf(sel, options)
+	negotiateVersion(env, delegateConf, config.CNIVersion)
+
+	var vf *sriovVF
+	if options.sriovPool != "" {
+		if vf, err = config.reserveVF(options.sriovPool, sel, args); err != nil {
+			return err
+		}
+		delegateConf["master"], delegateConf["vf"] = vf.Master, vf.VF
+	}
+
+	// An interrupted ADD may have left an interface or an address
+	// allocated; release them so the delegate starts afresh.
+	if interrupted != nil && interrupted.Op == "ADD" {
+		if err := commandEnv(env, args, "DEL").Del(delegateConf); err != nil {
+			config.Logger().WithField("error", err).Warn("Failed to clean up after interrupted ADD.")
+		}
+	}
+
+	if _, err := config.Hooks.run(config.Logger(), hookPreAdd, args, sel, delegateConf, nil); err != nil {
+		return err
+	}
+
+	addEnv := env
+	if options.stickyIP {
+		addEnv = config.stickyAddEnv(env, args)
+	}
+
+	var delegateResult *selector.Result
+	err = options.retry.do(config.Logger(), func() error {
+		release, err := config.delegateSlot(sel.NetConf)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		span := config.trace.start("delegate ADD", spanKindClient)
+		defer func() { span.finish(err) }()
+		span.set("delegate.type", fmt.Sprint(delegateConf["type"]))
+
+		delegateResult, err = addEnv.Add(delegateConf)
+		if err != nil && addEnv != env {
+			// The address may have been taken since; settle for a
+			// new one.
+			config.Logger().WithField("error", err).Warn("Failed to reuse pod's previous address.")
+			addEnv = env
+			delegateResult, err = env.Add(delegateConf)
+		}
+		config.dumpInvocation("ADD", args, addEnv, delegateConf, delegateResult, err)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("Failed to marshal config: %v", err)
+		config.reportAddFailure(sel, args, err)
+		return err
 	}
+	delegated = true
 
-	result, err := invoke.DelegateAdd(netconf["type"].(string), ncBytes)
+	att, err := config.finishAdd(sel, options, args, env, delegateConf, delegateResult, vf)
 	if err != nil {
+		config.rollbackAdd(sel, options, args, env, delegateConf, att, err)
 		return err
 	}
 
-	return result.Print()
+	if options.stickyIP {
+		newStickyStore(config.StateDir).forget(config.Logger(), args.Args)
+	}
+
+	if config.AuditLog != "" {
+		if err := writeAudit(config.AuditLog, newAddAuditRecord(sel, att)); err != nil {
+			return err
+		}
+	}
+
+	config.annotatePod(att)
+
+	result := newResult(att)
+	result.cniVersion = config.CNIVersion
+
+	if faults != nil {
+		return faults.printResult(config.Logger(), result, stdout)
+	}
+
+	return result.print(stdout)
 }
 
-func delegateDel(netconf map[string]interface{}) error {
-	ncBytes, err := json.Marshal(netconf)
+// Complete ADD once the delegate has set up the pod's interface: apply
+// kube-namespace's own options and record the attachment.  The
+// attachment is returned even on failure, recording what was set up,
+// for rollbackAdd.
+func (c *config) finishAdd(sel *selection, options *netOptions, args *skel.CmdArgs, env *selector.DelegateEnv,
+	delegateConf map[string]interface{}, delegateResult *selector.Result, vf *sriovVF) (*attachment, error) {
+	// Enter the pod's network namespace once for all the steps below.
+	// If it cannot be opened, each step reports why.
+	if closeNetns, err := openNetnsSession(args.Netns); err == nil {
+		defer closeNetns()
+	}
+
+	var err error
+	att := &attachment{
+		ContainerID:     args.ContainerID,
+		Namespace:       sel.Namespace,
+		Pod:             sel.Pod,
+		Netns:           args.Netns,
+		IfName:          args.IfName,
+		DelegateType:    fmt.Sprint(sel.NetConf["type"]),
+		networkMetadata: newNetworkMetadata(sel),
+		Result:          delegateResult.Result,
+		Result030:       delegateResult.Result030,
+		Created:         time.Now().UTC(),
+		PTPAuto:         options.ptpAuto != nil,
+		SRIOV:           vf,
+	}
+
+	if options.additionalIPs > 0 {
+		if att.AdditionalIPs, err = addAdditionalIPs(env, args, delegateConf, options.additionalIPs); err != nil {
+			return att, err
+		}
+	}
+
+	if c.IPvlanMap != nil {
+		att.HostRoutes, err = addIPvlanHostRoutes(c.Logger(), c.IPvlanMap, sel.Namespace,
+			podAddresses(att.Result, att.AdditionalIPs))
+		if len(att.HostRoutes) > 0 {
+			att.HostRouteDevice = c.IPvlanMap.HostInterface()
+		}
+		if err != nil {
+			removeIPvlanHostRoutes(c.Logger(), att.HostRouteDevice, att.HostRoutes)
+			return att, err
+		}
+	}
+
+	if err := options.applyAdd(c.Logger(), args, att); err != nil {
+		return att, err
+	}
+
+	if c.isolated(sel) {
+		var allowFrom []string
+		if _, err := decodeNetConfKey(sel.NetConf, "allowFrom", &allowFrom); err != nil {
+			return att, err
+		}
+
+		if err := isolatePod(c.Logger(), args.ContainerID, sel.Namespace, allowFrom, att.Result); err != nil {
+			return att, err
+		}
+	}
+
+	if c.IPMasq && !delegateMasquerades(sel.NetConf) {
+		if att.IPMasqChain, err = installIPMasq(c.Logger(), fmt.Sprint(sel.NetConf["name"]), args.ContainerID, att.Result); err != nil {
+			return att, err
+		}
+	}
+
+	if options.registerDNS != nil {
+		if err := c.updateDNS(options.registerDNS, sel.Pod, podAddresses(att.Result, att.AdditionalIPs)); err != nil {
+			return att, err
+		}
+		att.DNSRegistered = true
+	}
+
+	hooked, err := c.Hooks.run(c.Logger(), hookPostAdd, args, sel, delegateConf,
+		&selector.Result{Result: att.Result, Result030: att.Result030})
 	if err != nil {
-		return fmt.Errorf("Failed to marshal config: %v", err)
+		return att, err
 	}
+	att.Result, att.Result030 = hooked.Result, hooked.Result030
 
-	return invoke.DelegateDel(netconf["type"].(string), ncBytes)
+	return att, newAttachmentStore(c.StateDir).save(att)
 }
 
-func cmdAdd(args *skel.CmdArgs) error {
-	config := &config{}
-	if err := json.Unmarshal(args.StdinData, config); err != nil {
-		return fmt.Errorf("Failed to parse config: %v", err)
+func cmdDel(args *skel.CmdArgs) error {
+	if ipam := parseIPAMInvocation(args.StdinData); ipam != nil {
+		return ipamDel(ipam, args)
 	}
 
-	config.setLogLevel()
-	log = log.WithFields(logrus.Fields{"container_id": args.ContainerID})
-	log.Info("Configuring pod networking.")
+	if forwarded, err := forwardToDaemon("DEL", args, os.Stdout); forwarded {
+		return err
+	}
 
-	delegatedConfig, err := config.getNetConf(args.Args)
+	start := time.Now()
+	config, err := parseConfig(args.StdinData)
 	if err != nil {
 		return err
 	}
 
-	return delegateAdd(delegatedConfig)
+	finish := beginInvocation(config, log.Logger, "DEL", args, start)
+	log = config.Logger()
+	selector.Log = log
+	log.Info("Removing pod networking.")
+
+	err = delNetwork(config, args, selector.ProcessEnv())
+	finish(err)
+	return err
 }
 
-func cmdDel(args *skel.CmdArgs) error {
-	config := &config{}
-	if err := json.Unmarshal(args.StdinData, config); err != nil {
-		return fmt.Errorf("Failed to parse config: %v", err)
+// Tear down networking for a pod.
+func delNetwork(config *config, args *skel.CmdArgs, env *selector.DelegateEnv) error {
+	_, done, err := config.beginOp(args.ContainerID, "DEL")
+	if err != nil {
+		return err
 	}
+	defer done()
 
-	config.setLogLevel()
-	log = log.WithFields(logrus.Fields{"container_id": args.ContainerID})
-	log.Info("Removing pod networking.")
+	selectSpan := config.trace.start("select", spanKindInternal)
+	sel, err := config.selectPod(args.Args)
+	if err != nil {
+		// The pod may be gone from the API server by now; fall back
+		// to the network recorded on ADD.
+		att, _ := newAttachmentStore(config.StateDir).load(args.ContainerID)
+		if att == nil || att.Network == "" {
+			selectSpan.finish(err)
+			return err
+		}
+		if sel, err = config.SelectNamed(att.Network, args.Args); err != nil {
+			selectSpan.finish(err)
+			return err
+		}
+	}
+
+	sel, err = config.expandPodCIDR(sel)
+	selectSpan.finish(err)
+	if err != nil {
+		return err
+	}
+	selectSpan.set("network", sel.Rule)
+
+	options, err := parseNetOptions(sel.NetConf)
+	if err != nil {
+		return err
+	}
+	options.portMappings = config.RuntimeConfig.PortMappings
+	if options.ifNames != nil {
+		if args, env, err = options.ifNames.containerArgs(args, env, "DEL"); err != nil {
+			return err
+		}
+	}
+
+	if options.ptpAuto != nil {
+		sel = config.ptpAutoDelSelection(options.ptpAuto, sel, args.ContainerID)
+	}
+
+	if options.networkless {
+		return config.delNetworkless(sel, args)
+	}
+
+	if options.hostDevice != nil {
+		return config.delHostDevice(sel, args)
+	}
+
+	if faults := config.faults(); faults != nil {
+		faults.delay(config.Logger())
+	}
+
+	store := newAttachmentStore(config.StateDir)
+	att, err := store.load(args.ContainerID)
+	if err != nil {
+		config.Logger().WithField("error", err).Warn("Failed to load attachment.")
+	}
+
+	// Snapshot the counters while the interface still exists.
+	var stats *interfaceStats
+	if config.AuditLog != "" && args.Netns != "" {
+		if stats, err = readInterfaceStats(args.Netns, args.IfName); err != nil {
+			config.Logger().WithField("error", err).Warn("Failed to read interface statistics.")
+		}
+	}
 
-	delegatedConfig, err := config.getNetConf(args.Args)
+	delegateConf := config.delegateNetConf(sel, options)
+	negotiateVersion(env, delegateConf, config.CNIVersion)
+	if _, err := config.Hooks.run(config.Logger(), hookPreDel, args, sel, delegateConf, nil); err != nil {
+		return err
+	}
+
+	// Without the daemon the lease could not be released.
+	if options.dhcp != nil {
+		if err := options.dhcp.ensureDaemon(config.Logger(), env.CNIPath); err != nil {
+			config.Logger().WithField("error", err).Warn("DHCP daemon unavailable for DEL.")
+		}
+	}
+
+	release, err := config.delegateSlot(sel.NetConf)
 	if err != nil {
 		return err
 	}
+	span := config.trace.start("delegate DEL", spanKindClient)
+	span.set("delegate.type", fmt.Sprint(delegateConf["type"]))
+	err = env.Del(delegateConf)
+	span.finish(err)
+	release()
+	config.dumpInvocation("DEL", args, env, delegateConf, nil, err)
+	if options.dhcp != nil && dhcpLeaseGone(err) {
+		config.Logger().WithField("error", err).Info("DHCP lease already gone.")
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	config.releaseAttachment(sel, options, args, env, delegateConf, att)
+
+	if options.stickyIP && att != nil {
+		if err := newStickyStore(config.StateDir).remember(args.Args, att.Result); err != nil {
+			config.Logger().WithField("error", err).Warn("Failed to remember pod's address.")
+		}
+	}
+
+	if _, err := config.Hooks.run(config.Logger(), hookPostDel, args, sel, delegateConf, nil); err != nil {
+		return err
+	}
+
+	if err := store.remove(args.ContainerID); err != nil {
+		return err
+	}
+
+	if config.AuditLog != "" {
+		if att == nil {
+			att = &attachment{
+				ContainerID:     args.ContainerID,
+				Namespace:       sel.Namespace,
+				Pod:             sel.Pod,
+				DelegateType:    fmt.Sprint(sel.NetConf["type"]),
+				networkMetadata: newNetworkMetadata(sel),
+			}
+		}
+
+		record := newAuditRecord(auditDel, att)
+		record.Stats = stats
+		if err := writeAudit(config.AuditLog, record); err != nil {
+			config.Logger().WithField("error", err).Warn("Failed to write audit log.")
+		}
+	}
 
-	return delegateDel(delegatedConfig)
+	return nil
 }
 
 func main() {
 	logrus.SetOutput(os.Stderr)
-	skel.PluginMain(cmdAdd, cmdDel, version.Legacy)
+	selector.Log = log
+
+	if len(os.Args) > 1 {
+		os.Exit(runSubcommand(os.Args[1:]))
+	}
+
+	skel.PluginMain(cmdAdd, cmdDel, supportedVersions)
+}
+
+// Release what kube-namespace set up for a pod around the delegate,
+// once the delegate DEL has run.  att is the attachment recorded on
+// ADD, or nil if there is none, in which case the options say what
+// to release.  Release is best effort, so that DEL can always succeed.
+func (c *config) releaseAttachment(sel *selection, options *netOptions, args *skel.CmdArgs, env *selector.DelegateEnv,
+	delegateConf map[string]interface{}, att *attachment) {
+	if options.additionalIPs > 0 || (att != nil && len(att.AdditionalIPs) > 0) {
+		n := options.additionalIPs
+		if att != nil && len(att.AdditionalIPs) > n {
+			n = len(att.AdditionalIPs)
+		}
+		releaseAdditionalIPs(env, args, delegateConf, n)
+	}
+
+	if att != nil {
+		c.releaseRecorded(att)
+	}
+	options.applyDel(c.Logger(), args, att)
+
+	// Without an attachment, the addresses registered are unknown, and
+	// deleting every record of the pod's name could remove those of a
+	// newer sandbox.
+	if options.registerDNS != nil && att != nil && att.DNSRegistered {
+		if err := c.removeDNS(options.registerDNS, sel.Pod, podAddresses(att.Result, att.AdditionalIPs)); err != nil {
+			c.Logger().WithField("error", err).Warn("Failed to remove DNS records.")
+		}
+	}
+
+	// Attachments recorded before ptpAuto do not say whether the pod
+	// has a link.
+	if options.ptpAuto != nil && (att == nil || !att.PTPAuto) {
+		c.releasePTPAuto(args.ContainerID)
+	}
+
+	if att != nil {
+		return
+	}
+
+	if c.IPMasq && !delegateMasquerades(sel.NetConf) {
+		teardownIPMasq(c.Logger(), ipMasqChain(fmt.Sprint(sel.NetConf["name"]), args.ContainerID))
+	}
+
+	if c.isolated(sel) {
+		unisolatePod(c.Logger(), args.ContainerID, sel.Namespace)
+	}
+}
+
+// Release what kube-namespace set up around the delegate for a pod, as
+// recorded in its attachment: what DEL releases once the delegate DEL
+// has run, and gc --force once it has failed.  Release is best effort.
+func (c *config) releaseRecorded(att *attachment) {
+	log := c.Logger()
+
+	if att.EgressRules {
+		var backend egressBackend = iptablesBackend{}
+		if att.EgressOffloadInterface != "" {
+			backend = flowerBackend{dev: att.EgressOffloadInterface}
+		}
+		backend.teardown(log, att.ContainerID)
+	}
+
+	if att.MirroredInterface != "" {
+		removeMirror(log, att.MirroredInterface)
+	}
+
+	if att.PublishedRoutes != nil {
+		att.PublishedRoutes.withdraw(log)
+	}
+
+	if att.IPMasqExcludeChain != "" {
+		teardownIPMasq(log, att.IPMasqExcludeChain)
+	}
+
+	if att.HostPorts {
+		teardownHostPorts(log, att.ContainerID)
+	}
+
+	if len(att.HostRoutes) > 0 {
+		removeIPvlanHostRoutes(log, att.HostRouteDevice, att.HostRoutes)
+	}
+
+	if att.IPMasqChain != "" {
+		teardownIPMasq(log, att.IPMasqChain)
+	}
+
+	if att.PTPAuto {
+		c.releasePTPAuto(att.ContainerID)
+	}
+
+	if c.isolated(&selection{Namespace: att.Namespace, Rule: att.Rule}) {
+		unisolatePod(log, att.ContainerID, att.Namespace)
+	}
 }
@@ -12,12 +12,17 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build linux
+// +build linux
+
 package main
 
 import (
-	"encoding/json"
+	"bytes"
 	"testing"
 
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -96,60 +101,35 @@ const configNoDefault = `
 }
 `
 
-// Parse CNI_ARGS correctly.
-func TestParseExtraArgs(t *testing.T) {
-	args := "K8S_POD_NAMESPACE=test;AnotherArg=123;BadArg"
-	expected := map[string]string{
-		"K8S_POD_NAMESPACE": "test",
-		"AnotherArg":        "123",
-	}
-
-	assert.Equal(t, expected, parseExtraArgs(args))
-}
-
-// Return the correct namespace config.
-func TestGetNamespaceConfig(t *testing.T) {
-	config := &config{}
-	if err := json.Unmarshal([]byte(configWithDefault), config); err != nil {
+// Only isolate namespaces with their own config.
+func TestIsolated(t *testing.T) {
+	config, err := parseConfig([]byte(configWithDefault))
+	if err != nil {
 		t.Fatalf("Failed to parse config: %v", err)
 	}
 
-	netconf, err := config.getNetConf("K8S_POD_NAMESPACE=isolated")
+	isolated, _ := config.Select("K8S_POD_NAMESPACE=isolated")
+	other, _ := config.Select("K8S_POD_NAMESPACE=non-existent")
 
-	assert.NoError(t, err)
-	assert.Equal(t, "bridge", netconf["type"].(string))
-}
+	assert.False(t, config.isolated(isolated))
 
-// Return the default config.
-func TestGetDefaultConfig(t *testing.T) {
-	config := &config{}
-	if err := json.Unmarshal([]byte(configWithDefault), config); err != nil {
-		t.Fatalf("Failed to parse config: %v", err)
-	}
+	config.IsolateNamespaces = true
 
-	netconf, err := config.getNetConf("K8S_POD_NAMESPACE=non-existent")
-
-	assert.NoError(t, err)
-	assert.Equal(t, "default-bridge", netconf["name"].(string))
+	assert.True(t, config.isolated(isolated))
+	assert.False(t, config.isolated(other))
 }
 
-// Error if no default.
-func TestNoDefaultConfig(t *testing.T) {
-	config := &config{}
-	if err := json.Unmarshal([]byte(configNoDefault), config); err != nil {
-		t.Fatalf("Failed to parse config: %v", err)
-	}
-
-	netconf, err := config.getNetConf("K8S_POD_NAMESPACE=non-existent")
-
-	assert.Error(t, err)
-	assert.Nil(t, netconf)
-}
+// Reject ADDs in a frozen namespace before running the delegate.
+func TestFrozenNamespace(t *testing.T) {
+	config, err := parseConfig([]byte(`{
+	  "namespaces": {
+	    "tenant-a": {"name": "tenant-a", "type": "bridge", "frozen": true}
+	  }
+	}`))
+	assert.NoError(t, err)
 
-// Error if K8S_POD_NAMESPACE is empty.
-func TestNoNamespace(t *testing.T) {
-	config := &config{}
-	_, err := config.getNetConf("")
+	args := &skel.CmdArgs{ContainerID: "abc", Args: "K8S_POD_NAMESPACE=tenant-a;K8S_POD_NAME=web-1"}
+	err = addNetwork(config, args, &selector.DelegateEnv{CNIPath: "/nonexistent"}, &bytes.Buffer{})
 
-	assert.Error(t, err)
+	assert.True(t, selector.IsNamespaceFrozen(err))
 }
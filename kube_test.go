@@ -0,0 +1,162 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Start a fake API server, returning the config to reach it and a
+// function to clean up.
+func fakeKubeAPI(t *testing.T, handler http.HandlerFunc) (*kubeConfig, func()) {
+	server := httptest.NewTLSServer(handler)
+
+	dir, err := ioutil.TempDir("", "kube-namespace-kube")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	k := &kubeConfig{
+		Server:    server.URL,
+		TokenFile: filepath.Join(dir, "token"),
+		CAFile:    filepath.Join(dir, "ca.crt"),
+	}
+	ioutil.WriteFile(k.TokenFile, []byte("secret\n"), 0600)
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	ioutil.WriteFile(k.CAFile, ca, 0600)
+
+	return k, func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// Get a pod with the configured token.
+func TestKubeGetPod(t *testing.T) {
+	k, cleanup := fakeKubeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/api/v1/namespaces/storage/pods/db-0" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"metadata": {"name": "db-0", "namespace": "storage"}, "spec": {"serviceAccountName": "db"}}`))
+	})
+	defer cleanup()
+
+	client, err := k.client()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	pod, err := client.getPod("storage", "db-0")
+	assert.NoError(t, err)
+	assert.Equal(t, "db", pod.Spec.ServiceAccountName)
+
+	_, err = client.getPod("storage", "other")
+	assert.Error(t, err)
+
+	// Later clients reuse the connection pool.
+	again, err := k.client()
+	assert.NoError(t, err)
+	assert.True(t, client.http == again.http)
+}
+
+// Fail without a server.
+func TestKubeNoServer(t *testing.T) {
+	var k *kubeConfig
+	_, err := k.client()
+	assert.Error(t, err)
+}
+
+// Reach the server of the current context of a JSON or YAML
+// kubeconfig, with paths relative to the kubeconfig.
+func TestKubeconfigClient(t *testing.T) {
+	k, cleanup := fakeKubeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"metadata": {"name": "db-0", "namespace": "storage"}}`))
+	})
+	defer cleanup()
+
+	path := filepath.Join(filepath.Dir(k.CAFile), "kubeconfig")
+	ioutil.WriteFile(path, []byte(`{
+	  "current-context": "prod",
+	  "contexts": [
+	    {"name": "staging", "context": {"cluster": "staging", "user": "admin"}},
+	    {"name": "prod", "context": {"cluster": "prod", "user": "admin"}}
+	  ],
+	  "clusters": [
+	    {"name": "staging", "cluster": {"server": "https://staging.invalid"}},
+	    {"name": "prod", "cluster": {"server": "`+k.Server+`", "certificate-authority": "ca.crt"}}
+	  ],
+	  "users": [{"name": "admin", "user": {"tokenFile": "token"}}]
+	}`), 0600)
+
+	client, err := kubeconfigClient(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	pod, err := client.getPod("storage", "db-0")
+	assert.NoError(t, err)
+	assert.Equal(t, "db-0", pod.Metadata.Name)
+
+	// kubectl writes YAML.
+	path = filepath.Join(filepath.Dir(k.CAFile), "config")
+	ioutil.WriteFile(path, []byte(`apiVersion: v1
+current-context: prod
+contexts:
+- name: prod
+  context:
+    cluster: prod
+    user: admin
+clusters:
+- name: prod
+  cluster:
+    server: `+k.Server+`
+    certificate-authority: ca.crt
+users:
+- name: admin
+  user:
+    tokenFile: token
+`), 0600)
+
+	client, err = kubeconfigClient(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	pod, err = client.getPod("storage", "db-0")
+	assert.NoError(t, err)
+	assert.Equal(t, "db-0", pod.Metadata.Name)
+
+	ioutil.WriteFile(path, []byte("apiVersion: v1\n"), 0600)
+	_, err = kubeconfigClient(path)
+	assert.Error(t, err)
+}
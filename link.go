@@ -0,0 +1,45 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// Return the host side of the veth pair whose container end is ifName
+// in the network namespace at netns.
+func hostPeer(netns, ifName string) (*net.Interface, error) {
+	var peerIndex int
+
+	err := withNetNS(netns, func() error {
+		var err error
+		peerIndex, err = linkPeerIndex(ifName)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hostIf, err := net.InterfaceByIndex(peerIndex)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find host-side veth: %v", err)
+	}
+
+	return hostIf, nil
+}
@@ -0,0 +1,389 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/coreos/kube-namespace-cni/pkg/preview"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+)
+
+// The node annotation in which "lint --node-helper" reports the
+// delegate plugins missing from the node's CNI path, comma-separated.
+const missingDelegatesAnnotation = "cni.coreos.com/missing-delegates"
+
+// Kinds of lint findings.
+const (
+	lintMissingNamespace   = "missing-namespace"
+	lintUncoveredNamespace = "uncovered-namespace"
+	lintSubnetOverlap      = "subnet-overlap"
+	lintMissingDelegate    = "missing-delegate"
+	lintUnreportedNode     = "unreported-node"
+)
+
+// A problem lint found in the config, given the cluster.
+type lintFinding struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Node      string `json:"node,omitempty"`
+	Message   string `json:"message"`
+}
+
+// The parts of a node kube-namespace looks at.
+type kubeNode struct {
+	Metadata kubeObjectMeta `json:"metadata"`
+	Spec     struct {
+		PodCIDR  string   `json:"podCIDR"`
+		PodCIDRs []string `json:"podCIDRs"`
+	} `json:"spec"`
+}
+
+// List the names of the namespaces in the cluster.
+func (c *kubeClient) listNamespaces() ([]string, error) {
+	list := struct {
+		Items []struct {
+			Metadata kubeObjectMeta `json:"metadata"`
+		} `json:"items"`
+	}{}
+	if err := c.do("GET", "/api/v1/namespaces", nil, &list); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, item := range list.Items {
+		names = append(names, item.Metadata.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// List the nodes in the cluster.
+func (c *kubeClient) listNodes() ([]*kubeNode, error) {
+	list := struct {
+		Items []*kubeNode `json:"items"`
+	}{}
+	if err := c.do("GET", "/api/v1/nodes", nil, &list); err != nil {
+		return nil, err
+	}
+
+	return list.Items, nil
+}
+
+// Set annotations on a node.
+func (c *kubeClient) annotateNode(name string, annotations map[string]string) error {
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	}
+	return c.do("PATCH", "/api/v1/nodes/"+url.PathEscape(name), patch, nil)
+}
+
+// Return the namespaces the config names.
+func (c *config) referencedNamespaces() []string {
+	seen := map[string]bool{}
+	for ns := range c.Namespaces {
+		seen[ns] = true
+	}
+	for ns := range c.MTUOverrides {
+		seen[ns] = true
+	}
+	if c.VLANMap != nil {
+		for ns := range c.VLANMap.Namespaces {
+			seen[ns] = true
+		}
+	}
+	if c.IPvlanMap != nil {
+		for ns := range c.IPvlanMap.Namespaces {
+			seen[ns] = true
+		}
+	}
+	for _, ns := range c.SystemNamespaces {
+		seen[ns] = true
+	}
+
+	var namespaces []string
+	for ns := range seen {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// Return the plugin binaries the config's networks need: the delegate
+// types, and the IPAM types other than kube-namespace's own.
+func delegateBinaries(rendering preview.Rendering) []string {
+	seen := map[string]bool{}
+	for _, netconf := range rendering {
+		if selector.Networkless(netconf) || selector.HostDevice(netconf) {
+			continue
+		}
+		if t, _ := netconf["type"].(string); t != "" {
+			seen[t] = true
+		}
+
+		ipam, _ := netconf["ipam"].(map[string]interface{})
+		t, _ := ipam["type"].(string)
+		if t != "" && t != "kube-namespace" && t != embeddedIPAMType {
+			seen[t] = true
+		}
+	}
+
+	var binaries []string
+	for t := range seen {
+		binaries = append(binaries, t)
+	}
+	sort.Strings(binaries)
+	return binaries
+}
+
+// Return the binaries not found in any directory of cniPath.
+func missingBinaries(binaries []string, cniPath string) []string {
+	var missing []string
+	for _, b := range binaries {
+		if _, err := invoke.FindInPath(b, filepath.SplitList(cniPath)); err != nil {
+			missing = append(missing, b)
+		}
+	}
+
+	return missing
+}
+
+// Return whether two subnets share addresses.
+func subnetsOverlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// Cross-check a config against the namespaces and nodes of a cluster.
+func lint(c *config, namespaces []string, nodes []*kubeNode) ([]*lintFinding, error) {
+	var findings []*lintFinding
+
+	existing := map[string]bool{}
+	for _, ns := range namespaces {
+		existing[ns] = true
+	}
+	for _, ns := range c.referencedNamespaces() {
+		if !existing[ns] {
+			findings = append(findings, &lintFinding{
+				Kind:      lintMissingNamespace,
+				Namespace: ns,
+				Message:   "Namespace in config does not exist.",
+			})
+		}
+	}
+
+	// Select as for a pod, with the configured modes, but without a
+	// pod to look up.
+	for _, ns := range namespaces {
+		if _, err := c.selectPod(kubeArgs(ns, "")); err != nil {
+			findings = append(findings, &lintFinding{Kind: lintUncoveredNamespace, Namespace: ns, Message: err.Error()})
+		}
+	}
+
+	rendering, err := c.render()
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for key := range rendering {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		ipam := &hostLocalConfig{}
+		if _, err := decodeNetConfKey(rendering[key], "ipam", ipam); err != nil {
+			return nil, fmt.Errorf("Namespace %q: %v", key, err)
+		}
+
+		for _, r := range ipam.ranges() {
+			// Pod CIDR templates are filled in from the node's own.
+			if strings.Contains(r.Subnet, "{{") {
+				continue
+			}
+			_, subnet, err := net.ParseCIDR(r.Subnet)
+			if err != nil {
+				return nil, fmt.Errorf("Namespace %q: Invalid subnet %q: %v", key, r.Subnet, err)
+			}
+
+			for _, node := range nodes {
+				cidrs := node.Spec.PodCIDRs
+				if len(cidrs) == 0 && node.Spec.PodCIDR != "" {
+					cidrs = []string{node.Spec.PodCIDR}
+				}
+				for _, cidr := range cidrs {
+					_, podCIDR, err := net.ParseCIDR(cidr)
+					if err != nil || !subnetsOverlap(subnet, podCIDR) {
+						continue
+					}
+					findings = append(findings, &lintFinding{
+						Kind:      lintSubnetOverlap,
+						Namespace: key,
+						Node:      node.Metadata.Name,
+						Message:   fmt.Sprintf("IPAM subnet %s overlaps the node's pod CIDR %s.", subnet, podCIDR),
+					})
+				}
+			}
+		}
+	}
+
+	for _, node := range nodes {
+		missing, ok := node.Metadata.Annotations[missingDelegatesAnnotation]
+		if !ok {
+			findings = append(findings, &lintFinding{
+				Kind:    lintUnreportedNode,
+				Node:    node.Metadata.Name,
+				Message: "No delegate report from the lint helper.",
+			})
+			continue
+		}
+		if missing == "" {
+			continue
+		}
+		for _, b := range strings.Split(missing, ",") {
+			findings = append(findings, &lintFinding{
+				Kind:    lintMissingDelegate,
+				Node:    node.Metadata.Name,
+				Message: fmt.Sprintf("Plugin %q not found in the node's CNI path.", b),
+			})
+		}
+	}
+
+	return findings, nil
+}
+
+// Report the plugin binaries the config needs that are missing on this
+// node in the node's annotations, every interval if it is not zero.
+func lintNodeHelper(c *config, client *kubeClient, node, cniPath string, interval time.Duration) error {
+	rendering, err := c.render()
+	if err != nil {
+		return err
+	}
+	binaries := delegateBinaries(rendering)
+
+	for {
+		missing := missingBinaries(binaries, cniPath)
+		annotations := map[string]string{missingDelegatesAnnotation: strings.Join(missing, ",")}
+		if err := client.annotateNode(node, annotations); err != nil {
+			return err
+		}
+		log.WithField("missing", missing).Info("Reported missing delegates.")
+
+		if interval == 0 {
+			return nil
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Cross-check the config against a live cluster, or, with
+// --node-helper, report on the delegates of this node.
+func cmdLint(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	configPath := flags.String("config", "", "plugin config file (default stdin)")
+	kubeconfig := flags.String("kubeconfig", "", "kubeconfig file (default the config's kubernetes block)")
+	output := flags.String("output", "text", "output format: text or json")
+	helper := flags.Bool("node-helper", false, "report the delegates missing on this node, as a DaemonSet")
+	node := flags.String("node", os.Getenv("NODE_NAME"), "name of this node, for --node-helper")
+	cniPath := flags.String("cni-path", defaultCNIPath, "directories to look for delegates in, for --node-helper")
+	interval := flags.Duration("interval", 0, "how often to report with --node-helper (default once)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("Unknown output format %q.", *output)
+	}
+
+	config, err := readConfig(*configPath, stdin)
+	if err != nil {
+		return err
+	}
+	config.setLogLevel()
+	if err := config.NamespacesError(); err != nil {
+		return err
+	}
+
+	var client *kubeClient
+	if *kubeconfig != "" {
+		client, err = kubeconfigClient(*kubeconfig)
+	} else {
+		client, err = config.Kubernetes.client()
+	}
+	if err != nil {
+		return err
+	}
+
+	if *helper {
+		if *node == "" {
+			return errors.New("No node name given with --node or NODE_NAME.")
+		}
+		return lintNodeHelper(config, client, *node, *cniPath, *interval)
+	}
+
+	namespaces, err := client.listNamespaces()
+	if err != nil {
+		return err
+	}
+	nodes, err := client.listNodes()
+	if err != nil {
+		return err
+	}
+
+	findings, err := lint(config, namespaces, nodes)
+	if err != nil {
+		return err
+	}
+
+	if *output == "json" {
+		if findings == nil {
+			findings = []*lintFinding{}
+		}
+		data, err := json.MarshalIndent(findings, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s\n", data)
+	} else {
+		for _, f := range findings {
+			var subject []string
+			if f.Namespace != "" {
+				subject = append(subject, f.Namespace)
+			}
+			if f.Node != "" {
+				subject = append(subject, "node "+f.Node)
+			}
+			fmt.Fprintf(stdout, "%s %s: %s\n", f.Kind, strings.Join(subject, " on "), f.Message)
+		}
+	}
+
+	if len(findings) > 0 {
+		return fmt.Errorf("Lint found %d problems.", len(findings))
+	}
+	return nil
+}
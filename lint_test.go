@@ -0,0 +1,149 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Report missing and uncovered namespaces, subnets overlapping pod
+// CIDRs and the delegates nodes lack.
+func TestLint(t *testing.T) {
+	config, err := parseConfig([]byte(`{
+	  "systemNamespaces": ["kube-system"],
+	  "systemNetwork": {"name": "system", "type": "bridge"},
+	  "mtuOverrides": {"legacy": 1400},
+	  "namespaces": {
+	    "tenant-a": {"name": "tenant-a", "type": "bridge", "ipam": {"type": "host-local", "subnet": "10.244.1.0/24"}},
+	    "tenant-b": {"name": "tenant-b", "type": "bridge", "ipam": {"type": "host-local", "subnet": "{{ .PodCIDR }}"}}
+	  }
+	}`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	node := func(name, podCIDR string, annotations map[string]string) *kubeNode {
+		n := &kubeNode{Metadata: kubeObjectMeta{Name: name, Annotations: annotations}}
+		n.Spec.PodCIDR = podCIDR
+		return n
+	}
+	nodes := []*kubeNode{
+		node("node-1", "10.244.0.0/24", map[string]string{missingDelegatesAnnotation: ""}),
+		node("node-2", "10.244.1.0/24", map[string]string{missingDelegatesAnnotation: "bridge,host-local"}),
+		node("node-3", "10.244.2.0/24", nil),
+	}
+
+	findings, err := lint(config, []string{"kube-system", "tenant-a", "tenant-b", "web"}, nodes)
+	assert.NoError(t, err)
+	assert.Equal(t, []*lintFinding{
+		{Kind: lintMissingNamespace, Namespace: "legacy", Message: "Namespace in config does not exist."},
+		{Kind: lintUncoveredNamespace, Namespace: "web", Message: `No network config selected for pod "" in namespace "web".`},
+		{Kind: lintSubnetOverlap, Namespace: "tenant-a", Node: "node-2", Message: "IPAM subnet 10.244.1.0/24 overlaps the node's pod CIDR 10.244.1.0/24."},
+		{Kind: lintMissingDelegate, Node: "node-2", Message: `Plugin "bridge" not found in the node's CNI path.`},
+		{Kind: lintMissingDelegate, Node: "node-2", Message: `Plugin "host-local" not found in the node's CNI path.`},
+		{Kind: lintUnreportedNode, Node: "node-3", Message: "No delegate report from the lint helper."},
+	}, findings)
+}
+
+// Count namespaces covered by NamespaceNetworks, as pods in them are.
+func TestLintNamespaceNetworks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-lint")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	k, cleanup := fakeKubeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(namespaceNetworkList))
+	})
+	defer cleanup()
+
+	config, err := parseConfig([]byte(`{"namespaces": {"tenant-a": {"name": "tenant-a", "type": "bridge"}}}`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	config.Kubernetes = k
+	config.NamespaceNetworks = &crdConfig{Enabled: true, CacheFile: filepath.Join(dir, "cache.json")}
+
+	findings, err := lint(config, []string{"isolated", "tenant-a", "web"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []*lintFinding{
+		{Kind: lintUncoveredNamespace, Namespace: "web", Message: `No network config selected for pod "" in namespace "web".`},
+	}, findings)
+}
+
+// List the delegate and IPAM binaries networks need, except those of
+// kube-namespace itself.
+func TestDelegateBinaries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-lint")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "bridge"), nil, 0755)
+
+	binaries := delegateBinaries(map[string]map[string]interface{}{
+		"*":        {"type": "bridge", "ipam": map[string]interface{}{"type": "host-local"}},
+		"tenant-a": {"type": "macvlan", "ipam": map[string]interface{}{"type": "kube-namespace"}},
+		"tenant-b": {"networkless": true},
+	})
+	assert.Equal(t, []string{"bridge", "host-local", "macvlan"}, binaries)
+	assert.Equal(t, []string{"host-local", "macvlan"}, missingBinaries(binaries, dir))
+}
+
+// Annotate the node with its missing delegates in helper mode, and
+// lint against the API server otherwise.
+func TestCmdLint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-lint")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "bridge"), nil, 0755)
+
+	var patch map[string]map[string]map[string]string
+	k, cleanup := fakeKubeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "PATCH" && r.URL.Path == "/api/v1/nodes/node-1":
+			json.NewDecoder(r.Body).Decode(&patch)
+			w.Write([]byte("{}"))
+		case r.URL.Path == "/api/v1/namespaces":
+			w.Write([]byte(`{"items": [{"metadata": {"name": "web"}}]}`))
+		case r.URL.Path == "/api/v1/nodes":
+			w.Write([]byte(`{"items": [{"metadata": {"name": "node-1", "annotations": {"cni.coreos.com/missing-delegates": "host-local"}}}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer cleanup()
+
+	data, _ := json.Marshal(k)
+	conf := `{"kubernetes": ` + string(data) + `, "default": {"name": "net", "type": "bridge", "ipam": {"type": "host-local"}}}`
+
+	err = cmdLint([]string{"--node-helper", "--node", "node-1", "--cni-path", dir}, strings.NewReader(conf), &bytes.Buffer{})
+	assert.NoError(t, err)
+	assert.Equal(t, "host-local", patch["metadata"]["annotations"][missingDelegatesAnnotation])
+
+	var out bytes.Buffer
+	err = cmdLint(nil, strings.NewReader(conf), &out)
+	assert.EqualError(t, err, "Lint found 1 problems.")
+	assert.Equal(t, "missing-delegate node node-1: Plugin \"host-local\" not found in the node's CNI path.\n", out.String())
+}
@@ -0,0 +1,168 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Under pod churn, every invocation logs the same few lines.  With a
+// "logRateLimit" block, at most burst lines with the same level and
+// message are logged per interval, across all invocations on the
+// node; the first line logged after others were dropped says how many
+// with "suppressed".  Warnings and errors are never dropped.  Each
+// invocation reads the counts from the state directory when it starts
+// and writes them back when it ends, so concurrent invocations may let
+// a few more lines through.
+
+// The file in the state directory holding the counts.
+const logLimitsFile = "log-limits.json"
+
+// The top-level "logRateLimit" block.
+type logRateLimit struct {
+	Burst           int `json:"burst"`
+	IntervalSeconds int `json:"intervalSeconds"`
+}
+
+// The lines of one key logged and dropped in the current interval.
+type logWindow struct {
+	Start      time.Time `json:"start"`
+	Logged     int       `json:"logged"`
+	Suppressed int       `json:"suppressed"`
+}
+
+// Counts lines by key and decides which to drop.
+type logLimiter struct {
+	burst    int
+	interval time.Duration
+
+	mu      sync.Mutex
+	windows map[string]*logWindow
+}
+
+// Return whether to log a line with key at now, and how many lines of
+// the key were dropped since the last one logged.
+func (l *logLimiter) allow(key string, now time.Time) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w := l.windows[key]
+	if w == nil || now.Sub(w.Start) >= l.interval {
+		suppressed := 0
+		if w != nil {
+			suppressed = w.Suppressed
+		}
+		l.windows[key] = &logWindow{Start: now, Logged: 1}
+		return true, suppressed
+	}
+
+	if w.Logged < l.burst {
+		w.Logged++
+		return true, 0
+	}
+
+	w.Suppressed++
+	return false, 0
+}
+
+// Drop the windows that are over and had nothing dropped.
+func (l *logLimiter) prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, w := range l.windows {
+		if now.Sub(w.Start) >= l.interval && w.Suppressed == 0 {
+			delete(l.windows, key)
+		}
+	}
+}
+
+// A formatter dropping lines the limiter does not allow.  logrus
+// writes nothing for an empty line.
+type rateLimitedFormatter struct {
+	logrus.Formatter
+	limiter *logLimiter
+}
+
+func (f *rateLimitedFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	if entry.Level <= logrus.WarnLevel {
+		return f.Formatter.Format(entry)
+	}
+
+	allowed, suppressed := f.limiter.allow(entry.Level.String()+": "+entry.Message, entry.Time)
+	if !allowed {
+		return nil, nil
+	}
+
+	if suppressed > 0 {
+		data := make(logrus.Fields, len(entry.Data)+1)
+		for k, v := range entry.Data {
+			data[k] = v
+		}
+		data["suppressed"] = suppressed
+
+		copied := *entry
+		copied.Data = data
+		entry = &copied
+	}
+
+	return f.Formatter.Format(entry)
+}
+
+// Limit the rate of log lines, if the config asks to.  Returns a
+// function saving the counts for the next invocation.
+func (c *config) limitLogRate() func() {
+	if c.LogRateLimit == nil {
+		return func() {}
+	}
+
+	dir := c.StateDir
+	if dir == "" {
+		dir = defaultStateDir
+	}
+	path := filepath.Join(dir, logLimitsFile)
+
+	limiter := &logLimiter{
+		burst:    c.LogRateLimit.Burst,
+		interval: time.Duration(c.LogRateLimit.IntervalSeconds) * time.Second,
+		windows:  map[string]*logWindow{},
+	}
+	// Without counts, e.g. on the first invocation, start afresh.
+	if data, err := ioutil.ReadFile(path); err == nil {
+		json.Unmarshal(data, &limiter.windows)
+	}
+
+	logger := c.Logger().Logger
+	logger.Formatter = &rateLimitedFormatter{Formatter: logger.Formatter, limiter: limiter}
+
+	return func() {
+		limiter.prune(time.Now())
+
+		limiter.mu.Lock()
+		defer limiter.mu.Unlock()
+		if err := writeJSONAtomic(path, limiter.windows); err != nil {
+			c.Logger().WithField("error", err).Warn("Failed to save log rate limits.")
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// Allow burst lines of a key per interval, then report those dropped.
+func TestLogLimiter(t *testing.T) {
+	l := &logLimiter{burst: 2, interval: time.Minute, windows: map[string]*logWindow{}}
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		allowed, _ := l.allow("a", now)
+		assert.True(t, allowed)
+	}
+	allowed, _ := l.allow("a", now)
+	assert.False(t, allowed)
+	allowed, _ = l.allow("a", now)
+	assert.False(t, allowed)
+
+	allowed, _ = l.allow("b", now)
+	assert.True(t, allowed)
+
+	allowed, suppressed := l.allow("a", now.Add(time.Minute))
+	assert.True(t, allowed)
+	assert.Equal(t, 2, suppressed)
+
+	l.prune(now.Add(3 * time.Minute))
+	assert.Empty(t, l.windows)
+}
+
+// Reject a logRateLimit that would drop everything.
+func TestParseLogRateLimit(t *testing.T) {
+	_, err := parseConfig([]byte(`{"logRateLimit": {"burst": 0, "intervalSeconds": 60}}`))
+	assert.EqualError(t, err, "logRateLimit needs a positive burst and intervalSeconds.")
+}
+
+// Drop repeated info lines but not warnings, carrying the counts over
+// to the next invocation.
+func TestLimitLogRate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-logging")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	logger := &logrus.Logger{
+		Out:       &buf,
+		Formatter: &logrus.TextFormatter{DisableColors: true},
+		Hooks:     make(logrus.LevelHooks),
+		Level:     logrus.InfoLevel,
+	}
+	formatter := logger.Formatter
+
+	config, err := parseConfig([]byte(`{"stateDir": "` + dir + `", "logRateLimit": {"burst": 1, "intervalSeconds": 60}}`))
+	assert.NoError(t, err)
+	config.Log = logrus.NewEntry(logger)
+
+	flush := config.limitLogRate()
+	config.Log.Info("Configuring pod networking.")
+	config.Log.Info("Configuring pod networking.")
+	config.Log.Warn("Delegate is slow.")
+	config.Log.Warn("Delegate is slow.")
+	flush()
+	assert.Equal(t, 3, strings.Count(buf.String(), "\n"))
+
+	// A new invocation installs a fresh formatter with the saved counts.
+	logger.Formatter = formatter
+	buf.Reset()
+	config.limitLogRate()()
+	config.Log.Info("Configuring pod networking.")
+	assert.Empty(t, buf.String())
+}
+
+// Add the number of dropped lines to the next line logged.
+func TestRateLimitedFormatterSuppressed(t *testing.T) {
+	l := &logLimiter{burst: 1, interval: time.Minute, windows: map[string]*logWindow{}}
+	f := &rateLimitedFormatter{Formatter: &logrus.JSONFormatter{}, limiter: l}
+	now := time.Now()
+
+	entry := &logrus.Entry{Logger: logrus.New(), Level: logrus.InfoLevel, Message: "Hello.", Time: now, Data: logrus.Fields{}}
+	line, err := f.Format(entry)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, line)
+
+	line, err = f.Format(entry)
+	assert.NoError(t, err)
+	assert.Empty(t, line)
+
+	entry.Time = now.Add(time.Minute)
+	line, err = f.Format(entry)
+	assert.NoError(t, err)
+	assert.Contains(t, string(line), `"suppressed":1`)
+	assert.Empty(t, entry.Data)
+}
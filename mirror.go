@@ -0,0 +1,173 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const defaultVXLANPort = 4789
+
+// Mirroring of a pod's traffic to a collector.  Either Interface names
+// an existing host interface, or Remote and VNI describe a VXLAN
+// tunnel to the collector, which is created if needed.
+type mirrorConfig struct {
+	Interface string `json:"interface"`
+
+	Remote string `json:"remote"`
+	VNI    int    `json:"vni"`
+	Port   int    `json:"port"`
+
+	// Cap on mirrored traffic, in bits per second.  Packets over the
+	// cap are still delivered, just not mirrored.
+	Rate uint64 `json:"rate"`
+}
+
+// Parse the "mirror" block of a network config, or its shorthand
+// "mirrorTo", naming a host interface.
+func parseMirror(netconf map[string]interface{}) (*mirrorConfig, error) {
+	var mirrorTo string
+	hasMirrorTo, err := decodeNetConfKey(netconf, "mirrorTo", &mirrorTo)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &mirrorConfig{}
+	ok, err := decodeNetConfKey(netconf, "mirror", m)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case hasMirrorTo && ok:
+		return nil, errors.New("Network config sets both mirror and mirrorTo.")
+	case hasMirrorTo:
+		if mirrorTo == "" {
+			return nil, errors.New("mirrorTo names no interface.")
+		}
+		return &mirrorConfig{Interface: mirrorTo}, nil
+	case !ok:
+		return nil, nil
+	}
+
+	switch {
+	case m.Interface != "" && m.Remote != "":
+		return nil, errors.New("Mirror config sets both interface and remote.")
+	case m.Interface != "":
+	case m.Remote != "":
+		if net.ParseIP(m.Remote) == nil {
+			return nil, fmt.Errorf("Invalid mirror remote %q.", m.Remote)
+		}
+		if m.VNI <= 0 || m.VNI >= 1<<24 {
+			return nil, fmt.Errorf("Invalid mirror VNI %d.", m.VNI)
+		}
+		if m.Port == 0 {
+			m.Port = defaultVXLANPort
+		}
+		m.Interface = fmt.Sprintf("knmir%d", m.VNI)
+	default:
+		return nil, errors.New("Mirror config sets neither interface nor remote.")
+	}
+
+	return m, nil
+}
+
+// Create the VXLAN device to the collector, unless it exists.
+func (m *mirrorConfig) ensureTunnel() error {
+	if _, err := net.InterfaceByName(m.Interface); err == nil {
+		return nil
+	}
+
+	_, addErr := runCommand("ip", "link", "add", m.Interface, "type", "vxlan",
+		"id", strconv.Itoa(m.VNI), "remote", m.Remote, "dstport", strconv.Itoa(m.Port))
+	if _, err := net.InterfaceByName(m.Interface); err != nil {
+		return addErr
+	}
+
+	_, err := runCommand("ip", "link", "set", m.Interface, "up")
+	return err
+}
+
+// Return the tc filter arguments mirroring one direction of dev's
+// traffic.
+func (m *mirrorConfig) filterArgs(dev, direction string) []string {
+	args := []string{"filter", "add", "dev", dev, direction, "matchall"}
+
+	if m.Rate > 0 {
+		burst := m.Rate / 8 / 10
+		if burst < minBurst {
+			burst = minBurst
+		}
+
+		// Over the rate, skip the mirror action; under it, go on to it.
+		args = append(args, "action", "police",
+			"rate", strconv.FormatUint(m.Rate, 10)+"bit",
+			"burst", strconv.FormatUint(burst, 10),
+			"conform-exceed", "continue/pipe")
+	}
+
+	return append(args, "action", "mirred", "egress", "mirror", "dev", m.Interface)
+}
+
+// Mirror both directions of the pod's traffic, as seen on the host end
+// of its veth.  Returns the name of the host interface.
+func (m *mirrorConfig) apply(log *logrus.Entry, netns, ifName string) (string, error) {
+	if m.Remote != "" {
+		if err := m.ensureTunnel(); err != nil {
+			return "", err
+		}
+	}
+
+	hostIf, err := hostPeer(netns, ifName)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := runCommand("tc", "qdisc", "replace", "dev", hostIf.Name, "clsact"); err != nil {
+		return "", err
+	}
+
+	for _, direction := range []string{"ingress", "egress"} {
+		if _, err := runCommand("tc", m.filterArgs(hostIf.Name, direction)...); err != nil {
+			return "", err
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		"interface": hostIf.Name,
+		"mirror_to": m.Interface,
+	}).Debug("Mirroring pod traffic.")
+
+	return hostIf.Name, nil
+}
+
+// Remove mirroring from a host interface, if it still exists.
+func removeMirror(log *logrus.Entry, hostIf string) {
+	if _, err := net.InterfaceByName(hostIf); err != nil {
+		return
+	}
+
+	if _, err := runCommand("tc", "qdisc", "del", "dev", hostIf, "clsact"); err != nil {
+		log.WithField("error", err).Warn("Failed to remove traffic mirroring.")
+	}
+}
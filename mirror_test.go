@@ -0,0 +1,76 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Derive the tunnel device from the VNI.
+func TestParseMirrorRemote(t *testing.T) {
+	netconf := map[string]interface{}{
+		"mirror": map[string]interface{}{"remote": "192.0.2.10", "vni": 42},
+	}
+
+	m, err := parseMirror(netconf)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "knmir42", m.Interface)
+	assert.Equal(t, defaultVXLANPort, m.Port)
+}
+
+// Accept mirrorTo as shorthand for a mirror interface.
+func TestParseMirrorTo(t *testing.T) {
+	m, err := parseMirror(map[string]interface{}{"mirrorTo": "mon0"})
+	assert.NoError(t, err)
+	assert.Equal(t, &mirrorConfig{Interface: "mon0"}, m)
+
+	_, err = parseMirror(map[string]interface{}{
+		"mirrorTo": "mon0",
+		"mirror":   map[string]interface{}{"interface": "mon1"},
+	})
+	assert.Error(t, err)
+
+	_, err = parseMirror(map[string]interface{}{"mirrorTo": ""})
+	assert.Error(t, err)
+}
+
+// Error if no target is given.
+func TestParseMirrorNoTarget(t *testing.T) {
+	netconf := map[string]interface{}{
+		"mirror": map[string]interface{}{"rate": 1000000},
+	}
+
+	_, err := parseMirror(netconf)
+
+	assert.Error(t, err)
+}
+
+// Police mirrored traffic before the mirror action.
+func TestMirrorFilterArgs(t *testing.T) {
+	m := &mirrorConfig{Interface: "mon0", Rate: 8000000}
+
+	assert.Equal(t, []string{
+		"filter", "add", "dev", "veth1", "ingress", "matchall",
+		"action", "police", "rate", "8000000bit", "burst", "100000",
+		"conform-exceed", "continue/pipe",
+		"action", "mirred", "egress", "mirror", "dev", "mon0",
+	}, m.filterArgs("veth1", "ingress"))
+}
@@ -0,0 +1,419 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// The steps of ADD that run in the pod's network namespace session
+// talk to the kernel over rtnetlink directly, rather than fork and
+// exec "ip" and "tc" once per step.  Only the few requests
+// kube-namespace makes are supported.  Each request opens its socket
+// on the calling thread, so it talks to the namespace that thread is
+// in.
+
+// Netlink messages are in the host's byte order.  It is found out
+// here, as binary.NativeEndian needs Go 1.21.
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// The link attribute that moves a link to the network namespace of a
+// file descriptor; not in package syscall.
+const iflaNetNsFd = 28
+
+// Traffic control constants not in package syscall.
+const (
+	tcaKind    = 1
+	tcaOptions = 2
+
+	tcaTBFParms  = 1
+	tcaTBFRate64 = 4
+	tcaTBFBurst  = 6
+
+	tcHRoot             = 0xffffffff
+	tcLinkLayerEthernet = 1
+
+	sizeofTcMsg      = 20
+	sizeofTcRateSpec = 12
+	sizeofTcTBFQopt  = 2*sizeofTcRateSpec + 12
+)
+
+// The latency of the token bucket filters of bandwidth limits, which
+// with the rate sets their queue length.
+const tbfLatencyMs = 25
+
+// An rtnetlink request: a message header's type and flags, the fixed
+// part of the message and its attributes.
+type netlinkRequest struct {
+	msgType uint16
+	flags   uint16
+	data    []byte
+}
+
+func newNetlinkRequest(msgType, flags uint16, header []byte) *netlinkRequest {
+	return &netlinkRequest{msgType: msgType, flags: flags, data: header}
+}
+
+// Append an attribute, padded to 4 bytes.
+func (r *netlinkRequest) addAttr(attrType uint16, value []byte) {
+	r.data = append(r.data, netlinkAttr(attrType, value)...)
+}
+
+func netlinkAttr(attrType uint16, value []byte) []byte {
+	attr := make([]byte, syscall.SizeofRtAttr, nlmAlign(syscall.SizeofRtAttr+len(value)))
+	nativeEndian.PutUint16(attr[0:2], uint16(syscall.SizeofRtAttr+len(value)))
+	nativeEndian.PutUint16(attr[2:4], attrType)
+	attr = append(attr, value...)
+	return attr[:cap(attr)]
+}
+
+func nlmAlign(n int) int {
+	return (n + syscall.NLMSG_ALIGNTO - 1) &^ (syscall.NLMSG_ALIGNTO - 1)
+}
+
+// Send the request, wait for the kernel's acknowledgement, and return
+// any messages it answered with before it.
+func (r *netlinkRequest) execute() ([]syscall.NetlinkMessage, error) {
+	s, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(s)
+
+	if err := syscall.Bind(s, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	const seq = 1
+	msg := make([]byte, syscall.NLMSG_HDRLEN, syscall.NLMSG_HDRLEN+len(r.data))
+	nativeEndian.PutUint32(msg[0:4], uint32(syscall.NLMSG_HDRLEN+len(r.data)))
+	nativeEndian.PutUint16(msg[4:6], r.msgType)
+	nativeEndian.PutUint16(msg[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_ACK|r.flags)
+	nativeEndian.PutUint32(msg[8:12], seq)
+	msg = append(msg, r.data...)
+
+	if err := syscall.Sendto(s, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	var replies []syscall.NetlinkMessage
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := syscall.Recvfrom(s, buf, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range msgs {
+			if m.Header.Seq != seq {
+				continue
+			}
+
+			switch m.Header.Type {
+			case syscall.NLMSG_ERROR:
+				if len(m.Data) < 4 {
+					return nil, fmt.Errorf("Short netlink error message.")
+				}
+				if errno := -int32(nativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+					return nil, syscall.Errno(errno)
+				}
+				return replies, nil
+			case syscall.NLMSG_DONE:
+				return replies, nil
+			default:
+				// buf is reused for the next read.
+				m.Data = append([]byte(nil), m.Data...)
+				replies = append(replies, m)
+			}
+		}
+	}
+}
+
+// Return the index of the interface called name.
+func linkIndex(name string) (int, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to find interface %q: %v", name, err)
+	}
+
+	return iface.Index, nil
+}
+
+func ifInfoMsg(index int, flags, change uint32) []byte {
+	b := make([]byte, syscall.SizeofIfInfomsg)
+	b[0] = syscall.AF_UNSPEC
+	nativeEndian.PutUint32(b[4:8], uint32(index))
+	nativeEndian.PutUint32(b[8:12], flags)
+	nativeEndian.PutUint32(b[12:16], change)
+	return b
+}
+
+// Bring the interface called name up, as "ip link set <name> up".
+func linkSetUp(name string) error {
+	index, err := linkIndex(name)
+	if err != nil {
+		return err
+	}
+
+	r := newNetlinkRequest(syscall.RTM_NEWLINK, 0, ifInfoMsg(index, syscall.IFF_UP, syscall.IFF_UP))
+	if _, err := r.execute(); err != nil {
+		return fmt.Errorf("Failed to set %s up: %v", name, err)
+	}
+
+	return nil
+}
+
+// Take the interface called name down, as "ip link set <name> down".
+func linkSetDown(name string) error {
+	index, err := linkIndex(name)
+	if err != nil {
+		return err
+	}
+
+	r := newNetlinkRequest(syscall.RTM_NEWLINK, 0, ifInfoMsg(index, 0, syscall.IFF_UP))
+	if _, err := r.execute(); err != nil {
+		return fmt.Errorf("Failed to set %s down: %v", name, err)
+	}
+
+	return nil
+}
+
+// Rename the interface called name to newName, as "ip link set <name>
+// name <newName>".  The interface must be down.
+func linkRename(name, newName string) error {
+	index, err := linkIndex(name)
+	if err != nil {
+		return err
+	}
+
+	r := newNetlinkRequest(syscall.RTM_NEWLINK, 0, ifInfoMsg(index, 0, 0))
+	r.addAttr(syscall.IFLA_IFNAME, append([]byte(newName), 0))
+	if _, err := r.execute(); err != nil {
+		return fmt.Errorf("Failed to rename %s to %s: %v", name, newName, err)
+	}
+
+	return nil
+}
+
+// Move the interface called name to the network namespace open as
+// netnsFd, as "ip link set <name> netns <path>".
+func linkSetNetns(name string, netnsFd uintptr) error {
+	index, err := linkIndex(name)
+	if err != nil {
+		return err
+	}
+
+	fd := make([]byte, 4)
+	nativeEndian.PutUint32(fd, uint32(netnsFd))
+
+	r := newNetlinkRequest(syscall.RTM_NEWLINK, 0, ifInfoMsg(index, 0, 0))
+	r.addAttr(iflaNetNsFd, fd)
+	if _, err := r.execute(); err != nil {
+		return fmt.Errorf("Failed to move %s to another network namespace: %v", name, err)
+	}
+
+	return nil
+}
+
+// Return the index of the peer of the veth called name, which is in
+// the peer's network namespace.
+func linkPeerIndex(name string) (int, error) {
+	index, err := linkIndex(name)
+	if err != nil {
+		return 0, err
+	}
+
+	replies, err := newNetlinkRequest(syscall.RTM_GETLINK, 0, ifInfoMsg(index, 0, 0)).execute()
+	if err != nil {
+		return 0, fmt.Errorf("Failed to read link %s: %v", name, err)
+	}
+
+	for _, m := range replies {
+		if m.Header.Type != syscall.RTM_NEWLINK {
+			continue
+		}
+
+		attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+		if err != nil {
+			return 0, fmt.Errorf("Failed to parse link %s: %v", name, err)
+		}
+		for _, a := range attrs {
+			if a.Attr.Type != syscall.IFLA_LINK || len(a.Value) < 4 {
+				continue
+			}
+			if peer := int(nativeEndian.Uint32(a.Value)); peer != index {
+				return peer, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("Interface %q has no host-side peer; is it a veth?", name)
+}
+
+// Return the address family of ip, and its bytes in that family.
+func ipFamily(ip net.IP) (uint8, []byte) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return syscall.AF_INET, ip4
+	}
+
+	return syscall.AF_INET6, ip.To16()
+}
+
+// Add the address addr, in CIDR notation, to the interface called
+// name, as "ip addr add <addr> dev <name>".
+func addrAdd(name, addr string) error {
+	ip, ipnet, err := net.ParseCIDR(addr)
+	if err != nil {
+		return err
+	}
+	index, err := linkIndex(name)
+	if err != nil {
+		return err
+	}
+
+	family, ipBytes := ipFamily(ip)
+	prefixLen, _ := ipnet.Mask.Size()
+
+	msg := make([]byte, syscall.SizeofIfAddrmsg)
+	msg[0] = family
+	msg[1] = uint8(prefixLen)
+	nativeEndian.PutUint32(msg[4:8], uint32(index))
+
+	r := newNetlinkRequest(syscall.RTM_NEWADDR, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, msg)
+	r.addAttr(syscall.IFA_LOCAL, ipBytes)
+	r.addAttr(syscall.IFA_ADDRESS, ipBytes)
+	if _, err := r.execute(); err != nil {
+		return fmt.Errorf("Failed to add address %s to %s: %v", addr, name, err)
+	}
+
+	return nil
+}
+
+// Route all traffic via gateway on the interface called name, as "ip
+// route replace default via <gateway> dev <name>".
+func routeReplaceDefault(gateway, name string) error {
+	gw := net.ParseIP(gateway)
+	if gw == nil {
+		return fmt.Errorf("Invalid gateway %q.", gateway)
+	}
+	index, err := linkIndex(name)
+	if err != nil {
+		return err
+	}
+
+	family, gwBytes := ipFamily(gw)
+
+	msg := make([]byte, syscall.SizeofRtMsg)
+	msg[0] = family
+	msg[4] = syscall.RT_TABLE_MAIN
+	msg[5] = syscall.RTPROT_BOOT
+	msg[6] = syscall.RT_SCOPE_UNIVERSE
+	msg[7] = syscall.RTN_UNICAST
+
+	oif := make([]byte, 4)
+	nativeEndian.PutUint32(oif, uint32(index))
+
+	r := newNetlinkRequest(syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE, msg)
+	r.addAttr(syscall.RTA_GATEWAY, gwBytes)
+	r.addAttr(syscall.RTA_OIF, oif)
+	if _, err := r.execute(); err != nil {
+		return fmt.Errorf("Failed to route default via %s dev %s: %v", gateway, name, err)
+	}
+
+	return nil
+}
+
+// Return the burst, in bytes, of a token bucket filter with the given
+// rate and burst in bits.  The default burst is 100ms worth of
+// traffic.
+func tbfBurstBytes(rate, burst uint64) uint64 {
+	burstBytes := burst / 8
+	if burst == 0 {
+		burstBytes = rate / 8 / 10
+	}
+	if burstBytes < minBurst {
+		burstBytes = minBurst
+	}
+
+	return burstBytes
+}
+
+// Add a token bucket filter as the root qdisc of the interface called
+// name, as "tc qdisc add dev <name> root tbf rate <rate>bit burst
+// <burst> latency 25ms".  rate and burst are in bits.
+func qdiscAddTBF(name string, rate, burst uint64) error {
+	index, err := linkIndex(name)
+	if err != nil {
+		return err
+	}
+
+	rateBytes := rate / 8
+	burstBytes := tbfBurstBytes(rate, burst)
+	limit := rateBytes*tbfLatencyMs/1000 + burstBytes
+
+	// struct tc_tbf_qopt: the rate and peak rate tc_ratespecs, then
+	// limit, buffer and mtu.  The burst is given as TCA_TBF_BURST, so
+	// the buffer, in scheduler ticks, need not be computed here.
+	qopt := make([]byte, sizeofTcTBFQopt)
+	qopt[1] = tcLinkLayerEthernet
+	if rateBytes >= 1<<32 {
+		nativeEndian.PutUint32(qopt[8:12], ^uint32(0))
+	} else {
+		nativeEndian.PutUint32(qopt[8:12], uint32(rateBytes))
+	}
+	qopt[sizeofTcRateSpec+1] = tcLinkLayerEthernet
+	nativeEndian.PutUint32(qopt[2*sizeofTcRateSpec:], uint32(limit))
+
+	burstAttr := make([]byte, 4)
+	nativeEndian.PutUint32(burstAttr, uint32(burstBytes))
+	options := append(netlinkAttr(tcaTBFParms, qopt), netlinkAttr(tcaTBFBurst, burstAttr)...)
+	if rateBytes >= 1<<32 {
+		rate64 := make([]byte, 8)
+		nativeEndian.PutUint64(rate64, rateBytes)
+		options = append(options, netlinkAttr(tcaTBFRate64, rate64)...)
+	}
+
+	// struct tcmsg: family and padding, ifindex, handle, parent, info.
+	msg := make([]byte, sizeofTcMsg)
+	nativeEndian.PutUint32(msg[4:8], uint32(index))
+	nativeEndian.PutUint32(msg[12:16], tcHRoot)
+
+	r := newNetlinkRequest(syscall.RTM_NEWQDISC, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, msg)
+	r.addAttr(tcaKind, []byte("tbf\x00"))
+	r.addAttr(tcaOptions, options)
+	if _, err := r.execute(); err != nil {
+		return fmt.Errorf("Failed to add tbf qdisc to %s: %v", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/stretchr/testify/assert"
+)
+
+// Set up, rename and move links, and set up addresses, routes and
+// qdiscs over rtnetlink, checked
+// against what "ip" and "tc" report.
+func TestNetlink(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Creating network namespaces needs root.")
+	}
+
+	podNS, err := ns.NewNS()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer podNS.Close()
+
+	err = withNetNS(podNS.Path(), func() error {
+		if _, err := runCommand("ip", "link", "add", "veth0", "type", "veth", "peer", "name", "veth1"); err != nil {
+			return err
+		}
+
+		show := func(name string, args ...string) string {
+			out, err := runCommand(name, args...)
+			assert.NoError(t, err)
+			return out
+		}
+
+		assert.NoError(t, linkSetUp("lo"))
+		assert.NoError(t, linkSetUp("veth0"))
+		assert.NoError(t, linkSetUp("veth1"))
+		assert.Contains(t, show("ip", "-o", "link", "show", "dev", "veth0"), ",UP")
+		assert.Error(t, linkSetUp("missing0"))
+
+		assert.NoError(t, addrAdd("veth0", "10.9.0.5/24"))
+		assert.NoError(t, addrAdd("veth0", "2001:db8::5/64"))
+		assert.Error(t, addrAdd("veth0", "10.9.0.5/24"))
+		addrs := show("ip", "-o", "addr", "show", "dev", "veth0")
+		assert.Contains(t, addrs, "inet 10.9.0.5/24")
+		assert.Contains(t, addrs, "inet6 2001:db8::5/64")
+
+		assert.NoError(t, routeReplaceDefault("10.9.0.1", "veth0"))
+		assert.NoError(t, routeReplaceDefault("10.9.0.2", "veth0"))
+		assert.Contains(t, show("ip", "route", "show", "default"), "default via 10.9.0.2 dev veth0")
+		assert.NoError(t, routeReplaceDefault("2001:db8::1", "veth0"))
+		assert.Contains(t, show("ip", "-6", "route", "show", "default"), "default via 2001:db8::1 dev veth0")
+		assert.Error(t, routeReplaceDefault("10.8.0.1", "veth0"))
+
+		assert.NoError(t, qdiscAddTBF("veth1", 80000000, 0))
+		assert.Error(t, qdiscAddTBF("veth1", 80000000, 0))
+		assert.Contains(t, show("tc", "qdisc", "show", "dev", "veth1"), "rate 80Mbit")
+		assert.NoError(t, qdiscAddTBF("veth0", 40000000000, 0))
+		assert.Contains(t, show("tc", "qdisc", "show", "dev", "veth0"), "rate 40Gbit")
+
+		peer, err := linkPeerIndex("veth0")
+		assert.NoError(t, err)
+		veth1, err := net.InterfaceByName("veth1")
+		assert.NoError(t, err)
+		assert.Equal(t, veth1.Index, peer)
+
+		_, err = linkPeerIndex("lo")
+		assert.Error(t, err)
+
+		assert.NoError(t, linkSetDown("veth0"))
+		assert.NotContains(t, show("ip", "-o", "link", "show", "dev", "veth0"), ",UP")
+		assert.NoError(t, linkRename("veth0", "eth5"))
+		assert.Contains(t, show("ip", "-o", "link", "show", "dev", "eth5"), "eth5")
+		assert.Error(t, linkRename("veth0", "eth6"))
+
+		otherNS, err := ns.NewNS()
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		defer otherNS.Close()
+		assert.NoError(t, linkSetNetns("eth5", otherNS.Fd()))
+		_, err = net.InterfaceByName("eth5")
+		assert.Error(t, err)
+		return otherNS.Do(func(ns.NetNS) error {
+			_, err := net.InterfaceByName("eth5")
+			assert.NoError(t, err)
+			return nil
+		})
+	})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,167 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/containernetworking/cni/pkg/ns"
+)
+
+// Running code in a pod's network namespace takes an OS thread locked
+// to it.  ns.WithNetNSPath sets one up afresh on every call, reopening
+// the namespace by path each time, and ADD's post-configuration used
+// to make a dozen such calls, so a namespace replaced under the same
+// path mid-ADD could be entered half-way through.  Instead, finishAdd
+// opens the pod's namespace once, for a session: a single locked
+// thread that withNetNS switches into that namespace and back for
+// each step.
+
+// An open network namespace and the thread that enters it.
+type netnsSession struct {
+	path   string
+	target ns.NetNS
+	work   chan netnsWork
+	// Guarded by netnsSessionsMu.
+	refs int
+}
+
+// A function to run in a session's namespace, and where to send its
+// error.
+type netnsWork struct {
+	fn   func() error
+	errc chan<- error
+}
+
+var (
+	netnsSessionsMu sync.Mutex
+	netnsSessions   = map[string]*netnsSession{}
+)
+
+// Open a session for the network namespace at path, until the returned
+// function is called.  Sessions for the same path are shared.
+func openNetnsSession(path string) (func(), error) {
+	netnsSessionsMu.Lock()
+	defer netnsSessionsMu.Unlock()
+
+	s := netnsSessions[path]
+	if s == nil {
+		var err error
+		if s, err = newNetnsSession(path); err != nil {
+			return nil, err
+		}
+		netnsSessions[path] = s
+	}
+	s.refs++
+
+	return s.release, nil
+}
+
+func newNetnsSession(path string) (*netnsSession, error) {
+	target, err := ns.GetNS(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &netnsSession{path: path, target: target, work: make(chan netnsWork)}
+	ready := make(chan error)
+	go s.serve(ready)
+	if err := <-ready; err != nil {
+		target.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Drop a reference to the session, ending it with the last.
+func (s *netnsSession) release() {
+	netnsSessionsMu.Lock()
+	defer netnsSessionsMu.Unlock()
+
+	if s.refs--; s.refs == 0 {
+		delete(netnsSessions, s.path)
+		close(s.work)
+	}
+}
+
+// Run the session's work on a thread locked to this goroutine.
+func (s *netnsSession) serve(ready chan<- error) {
+	runtime.LockOSThread()
+
+	host, err := ns.GetCurrentNS()
+	if err != nil {
+		runtime.UnlockOSThread()
+		ready <- fmt.Errorf("Failed to open current network namespace: %v", err)
+		return
+	}
+	ready <- nil
+
+	var stuck error
+	for w := range s.work {
+		if stuck != nil {
+			w.errc <- stuck
+			continue
+		}
+
+		if err := s.target.Set(); err != nil {
+			w.errc <- fmt.Errorf("Failed to enter network namespace %q: %v", s.path, err)
+			continue
+		}
+		err := w.fn()
+
+		// Leave the thread as it was found, or give it up: a goroutine
+		// exiting locked terminates its thread, rather than leave it
+		// to others in the wrong namespace.
+		if setErr := host.Set(); setErr != nil {
+			stuck = fmt.Errorf("Failed to return to host network namespace: %v", setErr)
+			log.WithField("error", setErr).Error("Failed to return to host network namespace.")
+		}
+		w.errc <- err
+	}
+
+	host.Close()
+	s.target.Close()
+	if stuck == nil {
+		runtime.UnlockOSThread()
+	}
+}
+
+// Run fn inside the network namespace at path, in its session if one is
+// open.
+func withNetNS(path string, fn func() error) error {
+	netnsSessionsMu.Lock()
+	s := netnsSessions[path]
+	if s != nil {
+		s.refs++
+	}
+	netnsSessionsMu.Unlock()
+
+	if s == nil {
+		return ns.WithNetNSPath(path, func(_ ns.NetNS) error {
+			return fn()
+		})
+	}
+	defer s.release()
+
+	errc := make(chan error, 1)
+	s.work <- netnsWork{fn: fn, errc: errc}
+	return <-errc
+}
@@ -0,0 +1,90 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/stretchr/testify/assert"
+)
+
+// Run every step of a session in its namespace, on one thread, and
+// leave the caller's namespace alone.
+func TestNetnsSession(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Creating network namespaces needs root.")
+	}
+
+	podNS, err := ns.NewNS()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer podNS.Close()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	hostPath := netnsPath(t)
+
+	closeNetns, err := openNetnsSession(podNS.Path())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, withNetNS(podNS.Path(), func() error {
+				assert.NotEqual(t, hostPath, netnsPath(t))
+				return nil
+			}))
+		}()
+	}
+	wg.Wait()
+
+	failure := errors.New("step failed")
+	assert.Equal(t, failure, withNetNS(podNS.Path(), func() error { return failure }))
+	assert.Equal(t, hostPath, netnsPath(t))
+
+	closeNetns()
+	netnsSessionsMu.Lock()
+	assert.Empty(t, netnsSessions)
+	netnsSessionsMu.Unlock()
+}
+
+// Return the identity of the current thread's network namespace.
+func netnsPath(t *testing.T) string {
+	path, err := os.Readlink("/proc/thread-self/ns/net")
+	assert.NoError(t, err)
+	return path
+}
+
+// Fall back to entering the namespace for each call without a session.
+func TestWithNetNSNoSession(t *testing.T) {
+	err := withNetNS("/nonexistent/netns", func() error { return nil })
+	assert.Error(t, err)
+
+	_, err = openNetnsSession("/nonexistent/netns")
+	assert.Error(t, err)
+}
@@ -0,0 +1,102 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// A network config with "networkless": true gives pods only a
+// loopback interface, for namespaces that must have no connectivity
+// at all.  No delegate is run, so the config needs no "type".
+
+// Bring up the loopback interface in a network namespace.
+func setUpLoopback(netns string) error {
+	return withNetNS(netns, func() error {
+		return linkSetUp("lo")
+	})
+}
+
+// Set up a pod of a networkless config, and write the result, which
+// has no addresses, to stdout.
+func (c *config) addNetworkless(sel *selection, args *skel.CmdArgs, stdout io.Writer) error {
+	if err := setUpLoopback(args.Netns); err != nil {
+		return err
+	}
+
+	att := &attachment{
+		ContainerID:     args.ContainerID,
+		Namespace:       sel.Namespace,
+		Pod:             sel.Pod,
+		Netns:           args.Netns,
+		IfName:          "lo",
+		networkMetadata: newNetworkMetadata(sel),
+		Result:          &types.Result{},
+		Created:         time.Now().UTC(),
+	}
+
+	if err := newAttachmentStore(c.StateDir).save(att); err != nil {
+		return err
+	}
+
+	if c.AuditLog != "" {
+		if err := writeAudit(c.AuditLog, newAddAuditRecord(sel, att)); err != nil {
+			return err
+		}
+	}
+
+	c.Logger().WithField("namespace", sel.Namespace).Info("Pod is networkless; only set up loopback.")
+
+	result := newResult(att)
+	result.cniVersion = c.CNIVersion
+	return result.print(stdout)
+}
+
+// Tear down a pod of a networkless config: there is only the record
+// to remove, as the loopback interface goes with the namespace.
+func (c *config) delNetworkless(sel *selection, args *skel.CmdArgs) error {
+	store := newAttachmentStore(c.StateDir)
+	att, err := store.load(args.ContainerID)
+	if err != nil {
+		c.Logger().WithField("error", err).Warn("Failed to load attachment.")
+	}
+
+	if err := store.remove(args.ContainerID); err != nil {
+		return err
+	}
+
+	if c.AuditLog != "" {
+		if att == nil {
+			att = &attachment{
+				ContainerID:     args.ContainerID,
+				Namespace:       sel.Namespace,
+				Pod:             sel.Pod,
+				networkMetadata: newNetworkMetadata(sel),
+			}
+		}
+		if err := writeAudit(c.AuditLog, newAuditRecord(auditDel, att)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+	"github.com/stretchr/testify/assert"
+)
+
+// Run no delegate for networkless pods, on ADD or DEL.
+func TestNetworkless(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-networkless")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config, err := parseConfig([]byte(`{
+	  "stateDir": "` + dir + `",
+	  "namespaces": {"scratch": {"name": "scratch", "networkless": true}}
+	}`))
+	assert.NoError(t, err)
+
+	args := &skel.CmdArgs{ContainerID: "abc", Args: "K8S_POD_NAMESPACE=scratch;K8S_POD_NAME=job-1"}
+	env := &selector.DelegateEnv{CNIPath: "/nonexistent"}
+
+	// Without a network namespace, loopback cannot be set up; no
+	// delegate is looked for.
+	err = addNetwork(config, args, env, &bytes.Buffer{})
+	assert.Error(t, err)
+	assert.False(t, selector.Is(err, selector.ErrDelegateNotFound))
+
+	store := newAttachmentStore(dir)
+	assert.NoError(t, store.save(&attachment{ContainerID: "abc", Namespace: "scratch"}))
+
+	assert.NoError(t, delNetwork(config, args, env))
+	att, err := store.load("abc")
+	assert.NoError(t, err)
+	assert.Nil(t, att)
+}
+
+// With configureLoopback, bring up lo in the pod before running the
+// delegate.
+func TestConfigureLoopback(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Creating network namespaces needs root.")
+	}
+
+	podNS, err := ns.NewNS()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer podNS.Close()
+
+	loUp := func() bool {
+		var up bool
+		assert.NoError(t, podNS.Do(func(ns.NetNS) error {
+			lo, err := net.InterfaceByName("lo")
+			if err != nil {
+				return err
+			}
+			up = lo.Flags&net.FlagUp != 0
+			return nil
+		}))
+		return up
+	}
+
+	args := &skel.CmdArgs{ContainerID: "abc", Netns: podNS.Path(), Args: "K8S_POD_NAMESPACE=web;K8S_POD_NAME=web-1"}
+	env := &selector.DelegateEnv{CNIPath: "/nonexistent"}
+
+	config, err := parseConfig([]byte(`{"default": {"name": "default", "type": "bridge"}}`))
+	assert.NoError(t, err)
+	assert.Error(t, addNetwork(config, args, env, &bytes.Buffer{}))
+	assert.False(t, loUp())
+
+	config.ConfigureLoopback = true
+	assert.Error(t, addNetwork(config, args, env, &bytes.Buffer{}))
+	assert.True(t, loUp())
+}
@@ -0,0 +1,133 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+)
+
+// kube-namespace-ipam is an IPAM type served by kube-namespace.  It
+// hands out addresses from ranges given per namespace, keeping the
+// leases of all namespaces in the IPAM store (see ipamstore.go) keyed
+// by namespace rather than by network name, so renaming a network
+// config keeps them.  Pods can have addresses reserved for them.
+
+// The IPAM type of kube-namespace-ipam.
+const embeddedIPAMType = "kube-namespace-ipam"
+
+// The key of namespaceRanges used for namespaces without their own.
+const anyNamespaceRanges = "*"
+
+// An address reserved for a pod.
+type ipamReservation struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	IP        string `json:"ip"`
+}
+
+// Validate a kube-namespace-ipam config.
+func validateEmbeddedIPAM(raw map[string]interface{}) error {
+	ipam := &ipamStoreConfig{}
+	if _, err := decodeNetConfKey(map[string]interface{}{"ipam": raw}, "ipam", ipam); err != nil {
+		return err
+	}
+
+	if len(ipam.NamespaceRanges) == 0 {
+		return fmt.Errorf("%s config has no namespaceRanges.", embeddedIPAMType)
+	}
+	for namespace, ranges := range ipam.NamespaceRanges {
+		if len(ranges) == 0 {
+			return fmt.Errorf("%s ranges of namespace %q are empty.", embeddedIPAMType, namespace)
+		}
+		for _, r := range ranges {
+			if _, _, _, err := r.bounds(); err != nil {
+				return fmt.Errorf("%s ranges of namespace %q: %v", embeddedIPAMType, namespace, err)
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, res := range ipam.Reservations {
+		if res.Namespace == "" || res.Pod == "" || net.ParseIP(res.IP) == nil {
+			return fmt.Errorf("%s reservation %+v needs a namespace, a pod and an address.", embeddedIPAMType, res)
+		}
+		if seen[res.IP] {
+			return fmt.Errorf("%s reserves %s more than once.", embeddedIPAMType, res.IP)
+		}
+		seen[res.IP] = true
+	}
+
+	return nil
+}
+
+// Return the key and ranges of namespaceRanges to lease from for a
+// namespace.
+func (ipam *ipamStoreConfig) rangesFor(namespace string) (string, []ipamRange, error) {
+	if ranges, ok := ipam.NamespaceRanges[namespace]; ok && namespace != "" {
+		return namespace, ranges, nil
+	}
+	if ranges, ok := ipam.NamespaceRanges[anyNamespaceRanges]; ok {
+		return anyNamespaceRanges, ranges, nil
+	}
+
+	return "", nil, fmt.Errorf("No address ranges for namespace %q.", namespace)
+}
+
+// Lease an address to the pod of args from its namespace's ranges, or
+// the address reserved for it.
+func (ipam *ipamStoreConfig) leaseForNamespace(db *ipamDB, args *skel.CmdArgs) (*types.IPConfig, ipamRange, error) {
+	extraArgs := selector.ParseExtraArgs(args.Args)
+	namespace, pod := extraArgs["K8S_POD_NAMESPACE"], extraArgs["K8S_POD_NAME"]
+
+	key, ranges, err := ipam.rangesFor(namespace)
+	if err != nil {
+		return nil, ipamRange{}, err
+	}
+
+	var want net.IP
+	reserved := map[string]bool{}
+	for _, res := range ipam.Reservations {
+		ip := net.ParseIP(res.IP)
+		if res.Namespace == namespace && res.Pod == pod {
+			want = ip
+		} else if ip != nil {
+			reserved[ip.String()] = true
+		}
+	}
+
+	n := db.Namespaces[key]
+	if n == nil {
+		n = &ipamNetwork{Leases: map[string]string{}}
+		db.Namespaces[key] = n
+	}
+
+	return n.allocate(ranges, args.ContainerID, want, reserved)
+}
+
+// Release the addresses leased to containerID from any namespace's
+// ranges.
+func (db *ipamDB) releaseFromNamespaces(containerID string) {
+	for _, n := range db.Namespaces {
+		n.release(containerID)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/stretchr/testify/assert"
+)
+
+// Reject kube-namespace-ipam configs without usable ranges or with
+// bad reservations.
+func TestValidateEmbeddedIPAM(t *testing.T) {
+	netconf := func(ipam string) map[string]interface{} {
+		var conf map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(`{"type": "bridge", "ipam": `+ipam+`}`), &conf))
+		return conf
+	}
+
+	store, err := parseIPAMStore(netconf(`{"type": "kube-namespace-ipam", "namespaceRanges": {"*": [{"subnet": "10.2.0.0/24"}]}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, ipamStoreDB, store)
+
+	_, err = parseIPAMStore(netconf(`{"type": "kube-namespace-ipam"}`))
+	assert.EqualError(t, err, "kube-namespace-ipam config has no namespaceRanges.")
+
+	_, err = parseIPAMStore(netconf(`{"type": "kube-namespace-ipam", "namespaceRanges": {"web": [{"subnet": "10.2.0/24"}]}}`))
+	assert.Error(t, err)
+
+	_, err = parseIPAMStore(netconf(`{"type": "kube-namespace-ipam", "namespaceRanges": {"web": [{"subnet": "10.2.0.0/24"}]},
+	  "reservations": [{"namespace": "web", "pod": "a", "ip": "10.2.0.9"}, {"namespace": "web", "pod": "b", "ip": "10.2.0.9"}]}`))
+	assert.EqualError(t, err, "kube-namespace-ipam reserves 10.2.0.9 more than once.")
+}
+
+// Lease from the namespace's ranges, or the catch-all ones, honoring
+// reservations, and keep leases across network renames.
+func TestNamespaceIPAM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-nsipam")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	netconf := parseIPAMInvocation([]byte(`{"name": "pods", "type": "bridge", "ipam": {
+	  "type": "kube-namespace-ipam", "store": "` + filepath.Join(dir, "ipam.json") + `",
+	  "namespaceRanges": {"web": [{"subnet": "10.2.1.0/24"}], "*": [{"subnet": "10.2.0.0/24"}]},
+	  "reservations": [{"namespace": "web", "pod": "db-0", "ip": "10.2.1.1"}]}}`))
+	if !assert.NotNil(t, netconf) {
+		return
+	}
+
+	add := func(id, namespace, pod string) string {
+		out := &bytes.Buffer{}
+		args := &skel.CmdArgs{ContainerID: id, Args: kubeArgs(namespace, pod)}
+		if err := ipamAdd(netconf, args, out); err != nil {
+			return err.Error()
+		}
+		return out.String()
+	}
+
+	// 10.2.1.1 is reserved for db-0.
+	assert.Contains(t, add("a", "web", "web-1"), `"ip":"10.2.1.2/24"`)
+	assert.Contains(t, add("b", "web", "db-0"), `"ip":"10.2.1.1/24"`)
+	assert.Contains(t, add("c", "batch", "job-1"), `"ip":"10.2.0.1/24"`)
+
+	// After a rename, a repeated ADD gets the same address.
+	netconf.Name = "pods-v2"
+	assert.Contains(t, add("a", "web", "web-1"), `"ip":"10.2.1.2/24"`)
+
+	// A reservation leased to an old sandbox fails until it is gone.
+	assert.Equal(t, "Reserved address 10.2.1.1 is leased to container b.", add("d", "web", "db-0"))
+	assert.NoError(t, ipamDel(netconf, &skel.CmdArgs{ContainerID: "b"}))
+	assert.Contains(t, add("d", "web", "db-0"), `"ip":"10.2.1.1/24"`)
+
+	netconf.IPAM.NamespaceRanges = map[string][]ipamRange{"web": netconf.IPAM.NamespaceRanges["web"]}
+	assert.Equal(t, `No address ranges for namespace "batch".`, add("e", "batch", "job-2"))
+}
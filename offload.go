@@ -0,0 +1,200 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Rule offload modes.
+const (
+	// Always program rules in software.  The default.
+	offloadOff = "off"
+	// Offload rules when the pod's host interface supports it, and
+	// fall back to software otherwise.
+	offloadAuto = "auto"
+	// Offload rules, or fail the ADD.
+	offloadRequired = "required"
+)
+
+// A dataplane backend that programs a pod's egress rules.  New
+// backends, e.g. for other NICs, only need to implement this.
+type egressBackend interface {
+	install(log *logrus.Entry, containerID string, rules []egressRule, result *types.Result) error
+	teardown(log *logrus.Entry, containerID string)
+}
+
+// The software backend, using iptables chains.
+type iptablesBackend struct{}
+
+func (iptablesBackend) install(log *logrus.Entry, containerID string, rules []egressRule, result *types.Result) error {
+	return installEgressRules(log, containerID, rules, result)
+}
+
+func (iptablesBackend) teardown(log *logrus.Entry, containerID string) {
+	teardownEgressRules(log, containerID)
+}
+
+// The hardware offload backend, using tc flower filters marked
+// skip_sw on the ingress of the pod's host-side interface, so that
+// they only run in the NIC.  Connection state comes from the ct
+// action, so that traffic on established connections is allowed, as
+// with the software backend.
+type flowerBackend struct {
+	dev string
+}
+
+// Filters are placed in their own chains, so they can be removed
+// without disturbing other filters on the device.
+const (
+	flowerTrackChain = "1000"
+	flowerRuleChain  = "1001"
+)
+
+// Return the tc filter argument lists for the rules.
+func (f flowerBackend) filters(rules []egressRule, result *types.Result) [][]string {
+	var filters [][]string
+	prio := 1
+
+	add := func(chain, protocol string, args ...string) {
+		filter := []string{"filter", "add", "dev", f.dev, "ingress",
+			"chain", chain, "prio", strconv.Itoa(prio), "protocol", protocol,
+			"flower", "skip_sw"}
+		filters = append(filters, append(filter, args...))
+		prio++
+	}
+
+	for _, ipc := range []*types.IPConfig{result.IP4, result.IP6} {
+		if ipc == nil {
+			continue
+		}
+
+		ipv6 := ipc.IP.IP.To4() == nil
+		protocol := "ip"
+		if ipv6 {
+			protocol = "ipv6"
+		}
+		src := []string{"src_ip", ipc.IP.IP.String()}
+
+		add("0", protocol, append(src, "action", "goto", "chain", flowerTrackChain)...)
+		add(flowerTrackChain, protocol, "ct_state", "-trk", "action", "ct", "action", "goto", "chain", flowerRuleChain)
+		add(flowerRuleChain, protocol, "ct_state", "+trk+est", "action", "pass")
+
+		for _, r := range rules {
+			action := []string{"action", "ct", "commit", "action", "pass"}
+			if r.Action == "deny" {
+				action = []string{"action", "drop"}
+			}
+
+			var match []string
+			if r.Protocol != "" {
+				match = append(match, "ip_proto", r.Protocol)
+			}
+
+			cidrs := r.CIDRs
+			if len(cidrs) == 0 {
+				cidrs = []string{""}
+			}
+			ports := r.Ports
+			if len(ports) == 0 {
+				ports = []int{0}
+			}
+
+			for _, cidr := range cidrs {
+				if cidr != "" && isIPv6CIDR(cidr) != ipv6 {
+					continue
+				}
+
+				for _, port := range ports {
+					args := append([]string{"ct_state", "+trk+new"}, match...)
+					if cidr != "" {
+						args = append(args, "dst_ip", cidr)
+					}
+					if port != 0 {
+						args = append(args, "dst_port", strconv.Itoa(port))
+					}
+					add(flowerRuleChain, protocol, append(args, action...)...)
+				}
+			}
+		}
+	}
+
+	return filters
+}
+
+func (f flowerBackend) install(log *logrus.Entry, containerID string, rules []egressRule, result *types.Result) error {
+	if _, err := runCommand("tc", "qdisc", "replace", "dev", f.dev, "clsact"); err != nil {
+		return err
+	}
+
+	for _, filter := range f.filters(rules, result) {
+		if _, err := runCommand("tc", filter...); err != nil {
+			f.teardown(log, containerID)
+			return err
+		}
+	}
+
+	log.WithField("interface", f.dev).Debug("Offloaded egress rules.")
+	return nil
+}
+
+func (f flowerBackend) teardown(_ *logrus.Entry, _ string) {
+	for _, chain := range []string{"0", flowerTrackChain, flowerRuleChain} {
+		runCommand("tc", "filter", "del", "dev", f.dev, "ingress", "chain", chain)
+	}
+}
+
+// Return whether the NIC behind dev can offload tc filters.
+func offloadCapable(dev string) bool {
+	out, err := runCommand("ethtool", "-k", dev)
+	return err == nil && strings.Contains(out, "hw-tc-offload: on")
+}
+
+// Choose the backend for a pod's egress rules.  Returns the backend,
+// and the host interface if rules are offloaded.
+func chooseEgressBackend(log *logrus.Entry, mode, netns, ifName string) (egressBackend, string, error) {
+	switch mode {
+	case "", offloadOff:
+		return iptablesBackend{}, "", nil
+	case offloadAuto, offloadRequired:
+	default:
+		return nil, "", fmt.Errorf("Unknown ruleOffload mode %q.", mode)
+	}
+
+	hostIf, err := hostPeer(netns, ifName)
+	if err == nil && offloadCapable(hostIf.Name) {
+		return flowerBackend{dev: hostIf.Name}, hostIf.Name, nil
+	}
+
+	if mode == offloadRequired {
+		return nil, "", fmt.Errorf("Rule offload required, but the pod's host interface does not support it.")
+	}
+
+	log.WithFields(logrus.Fields{
+		"mode":  mode,
+		"error": err,
+	}).Debug("Rule offload unavailable. Using software.")
+
+	return iptablesBackend{}, "", nil
+}
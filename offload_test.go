@@ -0,0 +1,65 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// Render egress rules as offloaded flower filters.
+func TestFlowerFilters(t *testing.T) {
+	result := &types.Result{
+		IP4: &types.IPConfig{IP: net.IPNet{IP: net.IPv4(10, 2, 0, 5), Mask: net.CIDRMask(16, 32)}},
+	}
+	rules := []egressRule{
+		{Action: "allow", CIDRs: []string{"10.0.0.0/8", "fd00::/8"}, Protocol: "tcp", Ports: []int{80, 443}},
+		{Action: "deny", CIDRs: []string{"0.0.0.0/0"}},
+	}
+
+	var filters []string
+	for _, f := range (flowerBackend{dev: "rep0"}).filters(rules, result) {
+		filters = append(filters, strings.Join(f, " "))
+	}
+
+	prefix := "filter add dev rep0 ingress chain "
+	assert.Equal(t, []string{
+		prefix + "0 prio 1 protocol ip flower skip_sw src_ip 10.2.0.5 action goto chain 1000",
+		prefix + "1000 prio 2 protocol ip flower skip_sw ct_state -trk action ct action goto chain 1001",
+		prefix + "1001 prio 3 protocol ip flower skip_sw ct_state +trk+est action pass",
+		prefix + "1001 prio 4 protocol ip flower skip_sw ct_state +trk+new ip_proto tcp dst_ip 10.0.0.0/8 dst_port 80 action ct commit action pass",
+		prefix + "1001 prio 5 protocol ip flower skip_sw ct_state +trk+new ip_proto tcp dst_ip 10.0.0.0/8 dst_port 443 action ct commit action pass",
+		prefix + "1001 prio 6 protocol ip flower skip_sw ct_state +trk+new dst_ip 0.0.0.0/0 action drop",
+	}, filters)
+}
+
+// Use software rules unless offload is asked for.
+func TestChooseEgressBackendOff(t *testing.T) {
+	backend, dev, err := chooseEgressBackend(log, "", "/nonexistent", "eth0")
+
+	assert.NoError(t, err)
+	assert.Equal(t, iptablesBackend{}, backend)
+	assert.Equal(t, "", dev)
+
+	_, _, err = chooseEgressBackend(log, "sometimes", "/nonexistent", "eth0")
+	assert.Error(t, err)
+}
@@ -0,0 +1,411 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"net"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Merge the "dns" block of the selected network config into the
+// delegate's result.  Fields set in the config override the ones
+// returned by the delegate; fields left unset are kept as-is.
+func mergeDNS(result *types.Result, netconf map[string]interface{}) error {
+	dns := types.DNS{}
+	if ok, err := decodeNetConfKey(netconf, "dns", &dns); !ok || err != nil {
+		return err
+	}
+
+	if len(dns.Nameservers) > 0 {
+		result.DNS.Nameservers = dns.Nameservers
+	}
+	if dns.Domain != "" {
+		result.DNS.Domain = dns.Domain
+	}
+	if len(dns.Search) > 0 {
+		result.DNS.Search = dns.Search
+	}
+	if len(dns.Options) > 0 {
+		result.DNS.Options = dns.Options
+	}
+
+	return nil
+}
+
+// Options in a network config that kube-namespace applies itself,
+// around the delegate invocation.
+type netOptions struct {
+	netconf   map[string]interface{}
+	sysctls   map[string]string
+	bandwidth *bandwidthConfig
+	vrf       *vrfConfig
+	egress    []egressRule
+	mirror    *mirrorConfig
+	gateways  *gatewayConfig
+	retry     *retryConfig
+	tuning    *hostTuning
+	modes     *bridgeModes
+	probe     *probeConfig
+	privilege *privilegeGuard
+
+	// Addresses to allocate on top of the delegate's.
+	additionalIPs int
+
+	// Send gratuitous ARP and unsolicited NA for the pod's addresses.
+	announce bool
+
+	registerDNS *dnsRegistration
+
+	// The most attachments of the namespace on the node, or 0.
+	maxAttachments int
+
+	// Set in ptp-auto mode; see ptpauto.go.
+	ptpAuto *ptpAutoConfig
+
+	// Only set up loopback; see networkless.go.
+	networkless bool
+
+	// Pass a host NIC through instead of running a delegate; see
+	// hostdevice.go.
+	hostDevice *hostDeviceConfig
+
+	ipv6Only *ipv6OnlyConfig
+
+	// Give a restarted pod sandbox its previous address; see
+	// sticky.go.
+	stickyIP bool
+
+	ifNames *ifNamesConfig
+
+	// Destinations exempt from masquerading; see ipmasq.go.
+	ipMasqExclude []*net.IPNet
+
+	publishRoutes *publishRoutesConfig
+
+	// The SR-IOV pool to take the pod's VF from; see sriov.go.
+	sriovPool string
+
+	transforms *resultTransforms
+
+	// Reject new pods, leaving existing ones alone.
+	frozen bool
+
+	// Reject new pods until this file exists; see readiness.go.
+	readinessFile string
+
+	// How to program egress rules; see offload.go.
+	ruleOffload string
+
+	// How to handle the runtime's hostPort mappings; see hostport.go.
+	hostPorts    string
+	portMappings []portMapping
+
+	// Where the delegate's host-local leases are kept; see
+	// ipamstore.go.
+	ipamStore string
+
+	// The daemon of dhcp IPAM; see dhcp.go.
+	dhcp *dhcpConfig
+}
+
+// Parse and validate kube-namespace's own options in a network
+// config, so that errors are reported before the delegate is run.
+func parseNetOptions(netconf map[string]interface{}) (*netOptions, error) {
+	var err error
+	o := &netOptions{netconf: netconf}
+
+	if o.sysctls, err = parseSysctls(netconf); err != nil {
+		return nil, err
+	}
+
+	if o.bandwidth, err = parseBandwidth(netconf); err != nil {
+		return nil, err
+	}
+
+	if o.vrf, err = parseVRF(netconf); err != nil {
+		return nil, err
+	}
+
+	if o.egress, err = parseEgressRules(netconf); err != nil {
+		return nil, err
+	}
+
+	if o.mirror, err = parseMirror(netconf); err != nil {
+		return nil, err
+	}
+
+	if o.gateways, err = parseGateways(netconf); err != nil {
+		return nil, err
+	}
+
+	if o.retry, err = parseRetry(netconf); err != nil {
+		return nil, err
+	}
+
+	if o.tuning, err = parseHostTuning(netconf); err != nil {
+		return nil, err
+	}
+
+	if o.modes, err = parseBridgeModes(netconf); err != nil {
+		return nil, err
+	}
+
+	if _, err = decodeNetConfKey(netconf, "frozen", &o.frozen); err != nil {
+		return nil, err
+	}
+
+	if o.readinessFile, err = parseReadinessFile(netconf); err != nil {
+		return nil, err
+	}
+
+	if o.probe, err = parseProbe(netconf); err != nil {
+		return nil, err
+	}
+
+	if o.hostPorts, err = parseHostPorts(netconf); err != nil {
+		return nil, err
+	}
+
+	if o.privilege, err = parsePrivilegeGuard(netconf); err != nil {
+		return nil, err
+	}
+
+	if o.additionalIPs, err = parseAdditionalIPs(netconf); err != nil {
+		return nil, err
+	}
+
+	if _, err = decodeNetConfKey(netconf, "announceAddresses", &o.announce); err != nil {
+		return nil, err
+	}
+
+	if o.registerDNS, err = parseDNSRegistration(netconf); err != nil {
+		return nil, err
+	}
+
+	if o.maxAttachments, err = parseMaxAttachments(netconf); err != nil {
+		return nil, err
+	}
+
+	if o.ptpAuto, err = parsePTPAuto(netconf); err != nil {
+		return nil, err
+	}
+
+	if _, err = decodeNetConfKey(netconf, "networkless", &o.networkless); err != nil {
+		return nil, err
+	}
+
+	if o.hostDevice, err = parseHostDevice(netconf); err != nil {
+		return nil, err
+	}
+
+	if o.ipv6Only, err = parseIPv6Only(netconf); err != nil {
+		return nil, err
+	}
+
+	if _, err = decodeNetConfKey(netconf, "stickyIP", &o.stickyIP); err != nil {
+		return nil, err
+	}
+
+	if o.ifNames, err = parseIfNames(netconf); err != nil {
+		return nil, err
+	}
+
+	if o.ipMasqExclude, err = parseIPMasqExclude(netconf); err != nil {
+		return nil, err
+	}
+
+	if o.publishRoutes, err = parsePublishRoutes(netconf); err != nil {
+		return nil, err
+	}
+
+	if o.sriovPool, err = parseSRIOVPool(netconf); err != nil {
+		return nil, err
+	}
+
+	if o.transforms, err = parseResultTransforms(netconf); err != nil {
+		return nil, err
+	}
+
+	if o.ipamStore, err = parseIPAMStore(netconf); err != nil {
+		return nil, err
+	}
+
+	if o.dhcp, err = parseDHCP(netconf); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// Apply the options after the delegate has successfully set up the
+// pod's interface.  Anything needed for cleanup is recorded in att.
+func (o *netOptions) applyAdd(log *logrus.Entry, args *skel.CmdArgs, att *attachment) error {
+	result := att.Result
+
+	if err := mergeDNS(result, o.netconf); err != nil {
+		return err
+	}
+
+	if o.transforms != nil {
+		o.transforms.apply(log, result)
+	}
+
+	// First, so that everything below sees the final names.
+	if o.ifNames != nil && o.ifNames.HostPrefix != "" {
+		hostIf, err := o.ifNames.renameHost(log, args.Netns, args.IfName, att)
+		if err != nil {
+			return err
+		}
+		att.HostInterface = hostIf
+	}
+
+	if err := applySysctls(log, args.Netns, o.sysctls); err != nil {
+		return err
+	}
+
+	if o.ipv6Only != nil {
+		if err := o.ipv6Only.apply(log, args.Netns, args.IfName, result); err != nil {
+			return err
+		}
+	}
+
+	if o.bandwidth != nil {
+		if err := o.bandwidth.apply(log, args.Netns, args.IfName); err != nil {
+			return err
+		}
+	}
+
+	if o.vrf != nil {
+		if err := o.vrf.apply(log, o.netconf, args.Netns, args.IfName, result); err != nil {
+			return err
+		}
+	}
+
+	if o.tuning != nil {
+		if err := o.tuning.apply(log, o.netconf); err != nil {
+			return err
+		}
+	}
+
+	if o.modes != nil {
+		if err := o.modes.apply(log, o.netconf, args.Netns, args.IfName); err != nil {
+			return err
+		}
+	}
+
+	if o.egress != nil {
+		backend, offloadIf, err := chooseEgressBackend(log, o.ruleOffload, args.Netns, args.IfName)
+		if err != nil {
+			return err
+		}
+
+		err = backend.install(log, args.ContainerID, o.egress, result)
+		if err != nil && offloadIf != "" && o.ruleOffload == offloadAuto {
+			log.WithField("error", err).Warn("Failed to offload egress rules. Using software.")
+			backend, offloadIf = iptablesBackend{}, ""
+			err = backend.install(log, args.ContainerID, o.egress, result)
+		}
+		if err != nil {
+			return err
+		}
+		att.EgressRules = true
+		att.EgressOffloadInterface = offloadIf
+	}
+
+	if o.mirror != nil {
+		hostIf, err := o.mirror.apply(log, args.Netns, args.IfName)
+		if err != nil {
+			return err
+		}
+		att.MirroredInterface = hostIf
+	}
+
+	if o.gateways != nil {
+		gateway, err := o.gateways.apply(log, args.Netns, args.IfName, "")
+		if err != nil {
+			return err
+		}
+		att.Gateway = gateway
+	}
+
+	if o.publishRoutes != nil {
+		if err := o.publishRoutes.apply(log, o.netconf, args.Netns, args.IfName, att); err != nil {
+			return err
+		}
+	}
+
+	if o.announce {
+		announceAddresses(log, args.Netns, args.IfName, podAddresses(result, att.AdditionalIPs))
+	}
+
+	if len(o.ipMasqExclude) > 0 {
+		chain, err := installIPMasqExclude(log, args.ContainerID, o.ipMasqExclude, result)
+		if err != nil {
+			return err
+		}
+		att.IPMasqExcludeChain = chain
+	}
+
+	if o.hostPorts == hostPortsDNAT && len(o.portMappings) > 0 {
+		if err := installHostPorts(log, args.ContainerID, o.portMappings, result); err != nil {
+			return err
+		}
+		att.HostPorts = true
+	}
+
+	// Last, so that everything above is in place.
+	if o.probe != nil {
+		if err := o.probe.run(log, args.Netns, result); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Clean up after the delegate has removed the pod's interface what
+// the options say was set up, for what att, the attachment recorded
+// on ADD, does not record; att is nil if there is none.  Cleanup is
+// best effort, so that DEL can always succeed.
+func (o *netOptions) applyDel(log *logrus.Entry, args *skel.CmdArgs, att *attachment) {
+	// Attachments recorded before egressRules do not say whether the
+	// pod has egress rules.
+	if o.egress != nil && (att == nil || !att.EgressRules) {
+		var backend egressBackend = iptablesBackend{}
+		if att != nil && att.EgressOffloadInterface != "" {
+			backend = flowerBackend{dev: att.EgressOffloadInterface}
+		}
+		backend.teardown(log, args.ContainerID)
+	}
+
+	if att != nil {
+		return
+	}
+
+	if len(o.ipMasqExclude) > 0 {
+		teardownIPMasq(log, ipMasqExcludeChain(args.ContainerID))
+	}
+
+	if o.hostPorts == hostPortsDNAT && len(o.portMappings) > 0 {
+		teardownHostPorts(log, args.ContainerID)
+	}
+}
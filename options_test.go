@@ -0,0 +1,66 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// Override delegate DNS fields set in the namespace config.
+func TestMergeDNS(t *testing.T) {
+	result := &types.Result{
+		DNS: types.DNS{
+			Nameservers: []string{"10.0.0.10"},
+			Domain:      "cluster.local",
+		},
+	}
+	netconf := map[string]interface{}{
+		"dns": map[string]interface{}{
+			"nameservers": []string{"10.3.0.53"},
+			"search":      []string{"regulated.svc.cluster.local"},
+		},
+	}
+
+	assert.NoError(t, mergeDNS(result, netconf))
+	assert.Equal(t, []string{"10.3.0.53"}, result.DNS.Nameservers)
+	assert.Equal(t, "cluster.local", result.DNS.Domain)
+	assert.Equal(t, []string{"regulated.svc.cluster.local"}, result.DNS.Search)
+}
+
+// Error if the DNS block is malformed.
+func TestMergeBadDNS(t *testing.T) {
+	netconf := map[string]interface{}{"dns": "8.8.8.8"}
+
+	assert.Error(t, mergeDNS(&types.Result{}, netconf))
+}
+
+// Strip kube-namespace's own keys before delegating.
+func TestDelegateNetConf(t *testing.T) {
+	netconf := map[string]interface{}{
+		"type":    "bridge",
+		"sysctls": map[string]interface{}{"net.core.somaxconn": "1024"},
+	}
+
+	delegated := delegateNetConf(netconf)
+
+	assert.Equal(t, map[string]interface{}{"type": "bridge"}, delegated)
+	assert.Contains(t, netconf, "sysctls")
+}
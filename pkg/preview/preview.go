@@ -0,0 +1,159 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package preview compares the delegate configs that two versions of a
+// kube-namespace config give each namespace, so that CI systems can
+// check a proposed change before it is merged.  The JSON encoding of
+// Report is stable.
+package preview
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// The key of a Rendering holding the config for namespaces without
+// their own entry.
+const DefaultKey = "*"
+
+// Change kinds.
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// The delegate configs a plugin config gives each namespace.
+type Rendering map[string]map[string]interface{}
+
+// A change to the config of one namespace.
+type Change struct {
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
+
+	// Dotted paths of the fields that differ, for changed namespaces.
+	Fields []string `json:"fields,omitempty"`
+
+	Old map[string]interface{} `json:"old,omitempty"`
+	New map[string]interface{} `json:"new,omitempty"`
+}
+
+// The result of previewing a config.
+type Report struct {
+	// Whether the proposed config is valid.
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors,omitempty"`
+
+	Changes []Change `json:"changes"`
+
+	// Namespaces that changed but were not expected to, if the
+	// expected namespaces were given.
+	Unexpected []string `json:"unexpected,omitempty"`
+}
+
+// Return the changes from old to new, sorted by namespace.
+func Diff(old, new Rendering) []Change {
+	changes := []Change{}
+
+	for _, ns := range namespaces(old, new) {
+		o, inOld := old[ns]
+		n, inNew := new[ns]
+
+		switch {
+		case !inOld:
+			changes = append(changes, Change{Namespace: ns, Kind: Added, New: n})
+		case !inNew:
+			changes = append(changes, Change{Namespace: ns, Kind: Removed, Old: o})
+		default:
+			if fields := diffFields("", o, n); len(fields) > 0 {
+				changes = append(changes, Change{Namespace: ns, Kind: Changed, Fields: fields, Old: o, New: n})
+			}
+		}
+	}
+
+	return changes
+}
+
+// Record the changed namespaces not in expected.  A change to the
+// default config is expected only if DefaultKey is listed.
+func (r *Report) Check(expected []string) {
+	allowed := map[string]bool{}
+	for _, ns := range expected {
+		allowed[ns] = true
+	}
+
+	r.Unexpected = nil
+	for _, c := range r.Changes {
+		if !allowed[c.Namespace] {
+			r.Unexpected = append(r.Unexpected, c.Namespace)
+		}
+	}
+}
+
+// Return whether the proposed config is valid and only changed the
+// expected namespaces.
+func (r *Report) OK() bool {
+	return r.Valid && len(r.Unexpected) == 0
+}
+
+// Return the sorted union of the namespaces in the renderings.
+func namespaces(renderings ...Rendering) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, r := range renderings {
+		for ns := range r {
+			if !seen[ns] {
+				seen[ns] = true
+				names = append(names, ns)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Return the sorted dotted paths at which a and b differ.
+func diffFields(prefix string, a, b map[string]interface{}) []string {
+	keys := map[string]bool{}
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+
+	var fields []string
+	for k := range keys {
+		path := k
+		if prefix != "" {
+			path = fmt.Sprintf("%s.%s", prefix, k)
+		}
+
+		av, bv := a[k], b[k]
+		am, aIsMap := av.(map[string]interface{})
+		bm, bIsMap := bv.(map[string]interface{})
+
+		switch {
+		case aIsMap && bIsMap:
+			fields = append(fields, diffFields(path, am, bm)...)
+		case !reflect.DeepEqual(av, bv):
+			fields = append(fields, path)
+		}
+	}
+	sort.Strings(fields)
+
+	return fields
+}
@@ -0,0 +1,67 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preview
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Report added, removed and changed namespaces.
+func TestDiff(t *testing.T) {
+	old := Rendering{
+		"a": {"type": "bridge", "ipam": map[string]interface{}{"subnet": "10.1.0.0/16"}},
+		"b": {"type": "bridge"},
+		"c": {"type": "macvlan"},
+	}
+	new := Rendering{
+		"a": {"type": "bridge", "ipam": map[string]interface{}{"subnet": "10.2.0.0/16"}, "mtu": 1400.0},
+		"b": {"type": "bridge"},
+		"d": {"type": "ipvlan"},
+	}
+
+	changes := Diff(old, new)
+
+	assert.Equal(t, []Change{
+		{Namespace: "a", Kind: Changed, Fields: []string{"ipam.subnet", "mtu"}, Old: old["a"], New: new["a"]},
+		{Namespace: "c", Kind: Removed, Old: old["c"]},
+		{Namespace: "d", Kind: Added, New: new["d"]},
+	}, changes)
+}
+
+// Flag changes outside the expected namespaces.
+func TestReportCheck(t *testing.T) {
+	r := &Report{Valid: true, Changes: []Change{
+		{Namespace: "a", Kind: Changed},
+		{Namespace: DefaultKey, Kind: Changed},
+	}}
+
+	r.Check([]string{"a"})
+	assert.Equal(t, []string{DefaultKey}, r.Unexpected)
+	assert.False(t, r.OK())
+
+	r.Check([]string{"a", DefaultKey})
+	assert.True(t, r.OK())
+}
+
+// Encode an empty diff as an empty list, not null.
+func TestReportJSON(t *testing.T) {
+	data, err := json.Marshal(&Report{Valid: true, Changes: Diff(Rendering{}, Rendering{})})
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"valid": true, "changes": []}`, string(data))
+}
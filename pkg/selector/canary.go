@@ -0,0 +1,122 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// A gradual rollout of an alternate delegate config, set by the
+// "canary" key of a namespace or default config.  Percent of the pods
+// get Delegate instead of the config itself.  Which pods do is decided
+// by a hash of the pod UID, so a pod keeps its config across ADD and
+// DEL, and raising Percent only moves more pods over.
+type Canary struct {
+	Percent  int                    `json:"percent"`
+	Delegate map[string]interface{} `json:"delegate"`
+}
+
+// Parse the "canary" key of a network config.  Returns nil if there is
+// none.
+func parseCanary(netconf map[string]interface{}) (*Canary, error) {
+	raw, ok := netconf["canary"]
+	if !ok {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal canary config: %v", err)
+	}
+
+	canary := &Canary{}
+	if err := json.Unmarshal(data, canary); err != nil {
+		return nil, fmt.Errorf("Failed to parse canary config: %v", err)
+	}
+
+	if canary.Percent < 0 || canary.Percent > 100 {
+		return nil, fmt.Errorf("Invalid canary percent %d.", canary.Percent)
+	}
+	if _, ok := canary.Delegate["type"].(string); !ok {
+		return nil, errors.New("Canary delegate config has no type.")
+	}
+
+	return canary, nil
+}
+
+// Check the canaries of all configs, so that errors are reported when
+// the config is loaded.
+func (c *Config) validateCanaries() error {
+	if _, err := parseCanary(c.Default); err != nil {
+		return fmt.Errorf("Default config: %v", err)
+	}
+
+	for namespace, netconf := range c.Namespaces {
+		if _, err := parseCanary(netconf); err != nil {
+			return fmt.Errorf("Config for namespace %q: %v", namespace, err)
+		}
+	}
+
+	return nil
+}
+
+// Return the bucket, from 0 to 99, of a pod in canary rollouts.  Pods
+// are identified by K8S_POD_UID, which newer kubelets pass, or else by
+// namespace and name.
+func canaryBucket(extraArgs map[string]string) int {
+	key := extraArgs["K8S_POD_UID"]
+	if key == "" {
+		key = extraArgs["K8S_POD_NAMESPACE"] + "/" + extraArgs["K8S_POD_NAME"]
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % 100)
+}
+
+// Swap the selected config for its canary delegate if the pod falls
+// in the canary's share.  Either way the "canary" key is removed.
+func applyCanary(log *logrus.Entry, sel *Selection, extraArgs map[string]string) (*Selection, error) {
+	canary, err := parseCanary(sel.NetConf)
+	if err != nil || canary == nil {
+		return sel, err
+	}
+
+	canarySel := *sel
+	if canaryBucket(extraArgs) < canary.Percent {
+		log.WithFields(logrus.Fields{
+			"percent":  canary.Percent,
+			"delegate": canary.Delegate["type"],
+		}).Debug("Using canary delegate config.")
+
+		canarySel.NetConf = canary.Delegate
+		canarySel.Canary = true
+		return &canarySel, nil
+	}
+
+	netconf := make(map[string]interface{}, len(sel.NetConf))
+	for k, v := range sel.NetConf {
+		if k != "canary" {
+			netconf[k] = v
+		}
+	}
+	canarySel.NetConf = netconf
+	return &canarySel, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const canaryConfig = `{
+  "namespaces": {
+    "web": {
+      "name": "web",
+      "type": "bridge",
+      "canary": {"percent": %d, "delegate": {"name": "web-next", "type": "ipvlan"}}
+    }
+  }
+}`
+
+// Move the canary's share of pods to the canary delegate.
+func TestCanary(t *testing.T) {
+	count := func(percent int) int {
+		c, err := Parse([]byte(fmt.Sprintf(canaryConfig, percent)))
+		if err != nil {
+			t.Fatalf("Failed to parse config: %v", err)
+		}
+
+		canaries := 0
+		for i := 0; i < 1000; i++ {
+			sel, err := c.Select(fmt.Sprintf("K8S_POD_NAMESPACE=web;K8S_POD_NAME=p;K8S_POD_UID=uid-%d", i))
+			assert.NoError(t, err)
+			assert.NotContains(t, sel.NetConf, "canary")
+			if sel.Canary {
+				assert.Equal(t, "ipvlan", sel.NetConf["type"])
+				canaries++
+			} else {
+				assert.Equal(t, "bridge", sel.NetConf["type"])
+			}
+		}
+		return canaries
+	}
+
+	assert.Equal(t, 0, count(0))
+	assert.Equal(t, 1000, count(100))
+	assert.InDelta(t, 250, count(25), 60)
+}
+
+// A pod keeps its delegate as the percentage grows.
+func TestCanaryBucketStable(t *testing.T) {
+	args := map[string]string{"K8S_POD_UID": "6a2f3c1e"}
+	assert.Equal(t, canaryBucket(args), canaryBucket(args))
+
+	byName := map[string]string{"K8S_POD_NAMESPACE": "web", "K8S_POD_NAME": "p"}
+	assert.Equal(t, canaryBucket(byName), canaryBucket(byName))
+}
+
+// Reject invalid canaries when parsing.
+func TestCanaryInvalid(t *testing.T) {
+	_, err := Parse([]byte(fmt.Sprintf(canaryConfig, 101)))
+	assert.Error(t, err)
+
+	_, err = Parse([]byte(`{"default": {"type": "bridge", "canary": {"percent": 5, "delegate": {}}}}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,570 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// The logger used by the package.  Programs may replace it, e.g. with
+// an entry carrying their own fields.
+var Log = logrus.NewEntry(logrus.StandardLogger())
+
+// The rule names recorded when a pod uses the default config or the
+// system network.
+const (
+	DefaultRule = "default"
+	SystemRule  = "system"
+)
+
+// The parts of a kube-namespace plugin config that decide which
+// network config a pod gets.
+type Config struct {
+	Default    map[string]interface{}
+	Namespaces map[string]map[string]interface{}
+
+	// Namespaces, such as kube-system, whose pods always get the
+	// pinned SystemNetwork config.  Their selection bypasses all other
+	// logic, so that control plane pods can come up even if the rest
+	// of the config is broken.
+	SystemNamespaces []string               `json:"systemNamespaces"`
+	SystemNetwork    map[string]interface{} `json:"systemNetwork"`
+
+	// Deep merge namespace configs over the default config, so they
+	// only need to specify the fields that differ.
+	MergeWithDefault bool `json:"mergeWithDefault"`
+
+	// Named base configs that namespace configs, the default and other
+	// profiles can inherit from with "extends"; see profiles.go.
+	Profiles map[string]map[string]interface{} `json:"profiles"`
+
+	// What to do when K8S_POD_NAMESPACE is missing, as it is for
+	// non-Kubernetes callers: "error" (the default), "default" to use
+	// the default config, even if there is a profile of that name, or
+	// the profile to use.  "reject" and "network=<name>", to use the
+	// config with that name, are accepted too.
+	FallbackWhenNoNamespace string `json:"fallbackWhenNoNamespace"`
+
+	// The deprecated alias of FallbackWhenNoNamespace, which wins if
+	// both are set.
+	NonK8sBehavior string `json:"nonK8sBehavior"`
+
+	// VLAN IDs per namespace, from which the delegate config of their
+	// pods is generated.
+	VLANMap *VLANMap `json:"vlanMap"`
+
+	// Subnets per namespace, from which an ipvlan L3 or L3S delegate
+	// config is generated for their pods.
+	IPvlanMap *IPvlanMap `json:"ipvlanMap"`
+
+	// MTUs to set in the delegate config of pods in the given
+	// namespaces, whichever config is selected for them.
+	MTUOverrides map[string]int `json:"mtuOverrides"`
+
+	// Directory of <namespace>.conf files, and optionally a
+	// default.conf, read in addition to the inline configs.
+	NamespacesDir string `json:"namespacesDir"`
+
+	// The format of the files in namespacesDir, "json", "yaml" or
+	// "toml", for files whose name or contents do not tell.
+	ConfigFormat string `json:"configFormat"`
+
+	// How to handle a namespace that is defined more than once.
+	DuplicateNamespaces string `json:"duplicateNamespaces"`
+
+	// Delegate types that may be selected.  If set, any other type is
+	// refused rather than run from CNI_PATH.
+	AllowedDelegateTypes []string `json:"allowedDelegateTypes"`
+
+	// File of the node's labels, for choosing config variants.  See
+	// variants.go.
+	NodeLabelsFile string `json:"nodeLabelsFile"`
+	nodeLabels     map[string]string
+
+	// How to select a pod's network config; see engine.go.
+	Strategies *Strategies `json:"selection"`
+
+	// Ordered selection rules, tried before the namespaces map; see
+	// rules.go.
+	Rules []Rule `json:"rules"`
+
+	// The most privileged tier of network config allowed per
+	// namespace; see tiers.go.
+	TierPolicy *TierPolicy `json:"tierPolicy"`
+
+	// The logger of the invocation selecting with the config, e.g. on
+	// a copy of a config shared by a daemon's requests, carrying the
+	// request's fields.  Nil means Log.
+	Log *logrus.Entry `json:"-"`
+
+	// Error loading the namespace configs.  With system namespaces
+	// configured, it is only returned for pods outside them.
+	namespacesErr error
+
+	// Where each namespace config was defined, and how namespaces
+	// defined more than once were resolved; see duplicates.go.
+	namespaceSources map[string]string
+	duplicates       map[string]*DuplicateResolution
+}
+
+// Return the logger to log selection with.
+func (c *Config) Logger() *logrus.Entry {
+	if c == nil || c.Log == nil {
+		return Log
+	}
+
+	return c.Log
+}
+
+// Parse a plugin config, loading the namespace configs, including
+// those in namespacesDir.  Fields other than Config's are ignored.
+func Parse(data []byte) (*Config, error) {
+	data, err := Migrate(data)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, ParseError("config", data, err)
+	}
+
+	// Decoding into a map silently drops duplicate namespaces, so
+	// decode them again keeping every entry.
+	raw := struct{ Namespaces json.RawMessage }{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, ParseError("config", data, err)
+	}
+
+	if len(c.SystemNamespaces) > 0 && len(c.SystemNetwork) == 0 {
+		return nil, errors.New("systemNamespaces given without a systemNetwork.")
+	}
+
+	if err := c.validateNonK8s(); err != nil {
+		return nil, err
+	}
+
+	if err := CheckFormat(c.ConfigFormat); err != nil {
+		return nil, err
+	}
+
+	if err := c.validateMTUOverrides(); err != nil {
+		return nil, err
+	}
+
+	if c.Strategies != nil {
+		if err := c.Strategies.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.TierPolicy != nil {
+		if err := c.TierPolicy.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.VLANMap != nil {
+		if err := c.VLANMap.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.IPvlanMap != nil {
+		if err := c.IPvlanMap.validate(); err != nil {
+			return nil, err
+		}
+
+		if c.VLANMap != nil {
+			for namespace := range c.IPvlanMap.Namespaces {
+				if _, ok := c.VLANMap.Namespaces[namespace]; ok {
+					return nil, fmt.Errorf("Namespace %q is in both vlanMap and ipvlanMap.", namespace)
+				}
+			}
+		}
+	}
+
+	if err := c.loadNamespaces(raw.Namespaces); err != nil {
+		if len(c.SystemNamespaces) == 0 {
+			return nil, err
+		}
+
+		Log.WithField("error", err).Error("Failed to load namespace configs. Only system namespaces will work.")
+		c.namespacesErr = err
+	}
+
+	if err := c.validateRules(); err != nil {
+		return nil, err
+	}
+
+	if err := c.validateCanaries(); err != nil {
+		return nil, err
+	}
+
+	if err := c.validateTiers(); err != nil {
+		return nil, err
+	}
+
+	if err := c.loadVariants(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Return a copy of the config with the given namespace configs taking
+// the place of its own for those namespaces.  The config itself is
+// left as-is.
+func (c *Config) WithNamespaces(namespaces map[string]map[string]interface{}) *Config {
+	copied := *c
+	copied.Namespaces = make(map[string]map[string]interface{}, len(c.Namespaces)+len(namespaces))
+	for namespace, netconf := range c.Namespaces {
+		copied.Namespaces[namespace] = netconf
+	}
+	for namespace, netconf := range namespaces {
+		copied.Namespaces[namespace] = netconf
+	}
+
+	return &copied
+}
+
+// Return the error loading the namespace configs, if Parse tolerated
+// one because system namespaces are configured.
+func (c *Config) NamespacesError() error {
+	return c.namespacesErr
+}
+
+// Load the namespace and default configs from the inline config and
+// namespacesDir, resolving any duplicates.
+func (c *Config) loadNamespaces(inline json.RawMessage) error {
+	entries, err := decodeNamespaceEntries(inline, "config")
+	if err != nil {
+		return err
+	}
+
+	var defaults []namespaceEntry
+	if len(c.Default) > 0 {
+		defaults = append(defaults, namespaceEntry{DefaultRule, "config", c.Default})
+	}
+
+	if c.NamespacesDir != "" {
+		dirEntries, dirDefault, err := loadNamespacesDir(c.NamespacesDir, c.ConfigFormat)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, dirEntries...)
+		if dirDefault != nil {
+			defaults = append(defaults, *dirDefault)
+		}
+	}
+
+	resolved, err := resolveNamespaces(entries, c.DuplicateNamespaces)
+	if err != nil {
+		return err
+	}
+	c.Namespaces, c.namespaceSources, c.duplicates = resolved.namespaces, resolved.sources, resolved.duplicates
+
+	resolvedDefaults, err := resolveNamespaces(defaults, c.DuplicateNamespaces)
+	if err != nil {
+		return err
+	}
+	c.Default = resolvedDefaults.namespaces[DefaultRule]
+
+	return c.resolveAllExtends()
+}
+
+// The network config selected for a pod, and why it was selected.
+type Selection struct {
+	Namespace string
+	Pod       string
+
+	// The config entry that matched: the namespace, DefaultRule or
+	// SystemRule.
+	Rule    string
+	NetConf map[string]interface{}
+
+	// Whether NetConf is the entry's canary delegate config.
+	Canary bool
+
+	// How the namespace's config was picked, if it is defined more
+	// than once.
+	Duplicate *DuplicateResolution
+
+	// The deprecated profile the entry's config was built from, if
+	// any, and whether the pod got the default config instead because
+	// the profile is past its sunset date.  See deprecation.go.
+	Deprecation    *Deprecation
+	SunsetFallback bool
+}
+
+// Select the network config for the pod named in args, which are
+// CNI_ARGS as passed by Kubernetes.  Pods in namespaces without their
+// own config get the default config.  If there is neither, return an
+// error.
+func (c *Config) Select(args string) (*Selection, error) {
+	sel, err := c.selectNetConf(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if sel, err = c.checkDeprecation(sel, ParseExtraArgs(args)); err != nil {
+		return nil, err
+	}
+
+	if err := c.checkDelegateType(sel); err != nil {
+		return nil, err
+	}
+
+	return sel, nil
+}
+
+func (c *Config) selectNetConf(args string) (*Selection, error) {
+	extraArgs := ParseExtraArgs(args)
+	namespace, pod := extraArgs["K8S_POD_NAMESPACE"], extraArgs["K8S_POD_NAME"]
+
+	for _, ns := range c.SystemNamespaces {
+		if ns == namespace {
+			c.Logger().WithFields(logrus.Fields{
+				"namespace": namespace,
+				"pod":       pod,
+			}).Debug("Using system network.")
+
+			return &Selection{Namespace: namespace, Pod: pod, Rule: SystemRule, NetConf: c.SystemNetwork}, nil
+		}
+	}
+
+	if c.namespacesErr != nil {
+		return nil, c.namespacesErr
+	}
+
+	if namespace == "" {
+		sel, err := c.selectNonK8s(pod)
+		if err != nil {
+			return nil, err
+		}
+		return c.transform(sel, extraArgs)
+	}
+
+	if cfg, ok := c.Namespaces[namespace]; ok {
+		if c.MergeWithDefault {
+			cfg = DeepMerge(c.Default, cfg)
+		}
+
+		c.Logger().WithFields(logrus.Fields{
+			"namespace": namespace,
+			"pod":       pod,
+			"config":    cfg,
+		}).Debug("Using namespace specific config.")
+
+		return c.transform(&Selection{Namespace: namespace, Pod: pod, Rule: namespace, NetConf: cfg, Duplicate: c.duplicates[namespace]}, extraArgs)
+	}
+
+	if len(c.Default) == 0 {
+		return nil, newError(CodeNamespaceNotConfigured,
+			"Config for namespace %q not found, and no default given.", namespace)
+	}
+
+	c.Logger().WithFields(logrus.Fields{
+		"namespace": namespace,
+		"pod":       pod,
+		"config":    c.Default,
+	}).Debug("Per-namespace config not found. Using default.")
+
+	return c.transform(&Selection{Namespace: namespace, Pod: pod, Rule: DefaultRule, NetConf: c.Default}, extraArgs)
+}
+
+// Return whether a network config is networkless: its pods only get a
+// loopback interface, and no delegate is run.
+func Networkless(netconf map[string]interface{}) bool {
+	networkless, _ := netconf["networkless"].(bool)
+	return networkless
+}
+
+// Return whether a network config passes a host device through to its
+// pods, which kube-namespace does itself, without a delegate.
+func HostDevice(netconf map[string]interface{}) bool {
+	_, ok := netconf["hostDevice"]
+	return ok
+}
+
+// Return whether the delegate type of a network config is allowed by
+// allowedDelegateTypes.  Networkless and host device configs have no
+// delegate.
+func (c *Config) AllowsDelegate(netconf map[string]interface{}) bool {
+	if c.AllowedDelegateTypes == nil || Networkless(netconf) || HostDevice(netconf) {
+		return true
+	}
+
+	delegateType, _ := netconf["type"].(string)
+	for _, allowed := range c.AllowedDelegateTypes {
+		if delegateType == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Refuse a selected config whose delegate type is not allowed.
+func (c *Config) checkDelegateType(sel *Selection) error {
+	if c.AllowsDelegate(sel.NetConf) {
+		return nil
+	}
+
+	delegateType, _ := sel.NetConf["type"].(string)
+
+	c.Logger().WithFields(logrus.Fields{
+		"namespace": sel.Namespace,
+		"rule":      sel.Rule,
+		"type":      delegateType,
+	}).Error("Refusing delegate type not in allowedDelegateTypes.")
+
+	return newError(CodeDelegateNotAllowed, "Delegate type %q of config %q is not in allowedDelegateTypes.",
+		delegateType, sel.Rule)
+}
+
+// Apply the entry's node variants and canary, and the per-namespace
+// VLAN, ipvlan and MTU settings, to the selected config.
+func (c *Config) transform(sel *Selection, extraArgs map[string]string) (*Selection, error) {
+	sel, err := c.applyVariants(sel)
+	if err != nil {
+		return nil, err
+	}
+
+	if sel, err = applyCanary(c.Logger(), sel, extraArgs); err != nil {
+		return nil, err
+	}
+
+	if c.VLANMap != nil {
+		sel = c.VLANMap.apply(c.Logger(), sel)
+	}
+	if c.IPvlanMap != nil {
+		sel = c.IPvlanMap.apply(c.Logger(), sel)
+	}
+
+	return c.overrideMTU(sel)
+}
+
+// Return the fallbackWhenNoNamespace setting, or else that of its
+// alias nonK8sBehavior.
+func (c *Config) fallbackWhenNoNamespace() string {
+	if c.FallbackWhenNoNamespace != "" {
+		return c.FallbackWhenNoNamespace
+	}
+
+	return c.NonK8sBehavior
+}
+
+// Check the fallbackWhenNoNamespace and nonK8sBehavior settings.  A
+// profile to fall back to must exist.
+func (c *Config) validateNonK8s() error {
+	if c.FallbackWhenNoNamespace != "" && c.NonK8sBehavior != "" && c.FallbackWhenNoNamespace != c.NonK8sBehavior {
+		Log.WithFields(logrus.Fields{
+			"fallbackWhenNoNamespace": c.FallbackWhenNoNamespace,
+			"nonK8sBehavior":          c.NonK8sBehavior,
+		}).Warn("Both fallbackWhenNoNamespace and nonK8sBehavior given. Using fallbackWhenNoNamespace.")
+	}
+
+	switch fallback := c.fallbackWhenNoNamespace(); {
+	case fallback == "", fallback == "error", fallback == "reject", fallback == "default":
+	case strings.HasPrefix(fallback, "network="):
+		if fallback == "network=" {
+			return errors.New("fallbackWhenNoNamespace network= needs a network name.")
+		}
+	default:
+		if _, ok := c.Profiles[fallback]; !ok {
+			return fmt.Errorf("Unknown fallbackWhenNoNamespace %q; use error, default, network=<name> or a profile.", fallback)
+		}
+	}
+
+	return nil
+}
+
+// Select the network config for a caller that did not pass a
+// Kubernetes namespace, according to the fallbackWhenNoNamespace
+// setting or its alias nonK8sBehavior.  Profile and named configs are
+// merged over the default with mergeWithDefault, as for pods; the
+// caller applies transform.
+func (c *Config) selectNonK8s(pod string) (*Selection, error) {
+	switch fallback := c.fallbackWhenNoNamespace(); {
+	case fallback == "" || fallback == "error" || fallback == "reject":
+		return nil, newError(CodeMissingNamespace, "Kubernetes namespace argument missing or empty.")
+
+	case fallback == "default":
+		if len(c.Default) == 0 {
+			return nil, newError(CodeNamespaceNotConfigured, "Kubernetes namespace argument missing, and no default given.")
+		}
+
+		c.Logger().Debug("Kubernetes namespace argument missing. Using default.")
+		return &Selection{Pod: pod, Rule: DefaultRule, NetConf: c.Default}, nil
+
+	case strings.HasPrefix(fallback, "network="):
+		name := strings.TrimPrefix(fallback, "network=")
+		rule, cfg := c.namedConfig(name)
+		if cfg == nil {
+			return nil, newError(CodeNamespaceNotConfigured, "Kubernetes namespace argument missing, and network %q not found.", name)
+		}
+
+		c.Logger().WithField("network", name).Debug("Kubernetes namespace argument missing. Using named network.")
+		return &Selection{Pod: pod, Rule: rule, NetConf: cfg}, nil
+
+	default:
+		return c.selectFallbackProfile(pod, fallback)
+	}
+}
+
+// Select the profile called name, with everything it extends merged
+// in, for a caller that did not pass a Kubernetes namespace.  The
+// selection's rule is the profile's name.
+func (c *Config) selectFallbackProfile(pod, name string) (*Selection, error) {
+	if _, ok := c.Profiles[name]; !ok {
+		return nil, newError(CodeNamespaceNotConfigured, "Kubernetes namespace argument missing, and profile %q not found.", name)
+	}
+
+	cfg, err := c.Profile(name)
+	if err != nil {
+		return nil, err
+	}
+	if c.MergeWithDefault {
+		cfg = DeepMerge(c.Default, cfg)
+	}
+
+	c.Logger().WithField("profile", name).Debug("Kubernetes namespace argument missing. Using profile.")
+	return &Selection{Pod: pod, Rule: name, NetConf: cfg}, nil
+}
+
+// Parse extra arguments passed in the CNI_ARGS environment variable.
+// Kubernetes uses this to provide the pod name and namespace.
+func ParseExtraArgs(args string) map[string]string {
+	parsedArgs := make(map[string]string)
+
+	for _, s := range strings.Split(args, ";") {
+		s := strings.SplitN(s, "=", 2)
+		if len(s) < 2 {
+			continue
+		}
+
+		k, v := s[0], s[1]
+		parsedArgs[k] = v
+	}
+
+	return parsedArgs
+}
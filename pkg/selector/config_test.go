@@ -0,0 +1,461 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const configWithDefault = `
+{
+  "name": "kube-namespace",
+  "type": "kube-namespace",
+  "log_level": "debug",
+  "namespaces": {
+    "isolated": {
+      "name": "isolated",
+      "type": "bridge",
+      "mtu": 1460,
+      "addIf": "true",
+      "isGateway": true,
+      "ipMasq": true,
+      "ipam": {
+        "type": "host-local",
+        "subnet": "10.2.0.0/16",
+        "gateway": "10.2.0.1",
+        "routes": [
+          {
+            "dst": "0.0.0.0/0"
+          }
+        ]
+      }
+    }
+  },
+  "default": {
+    "name": "default-bridge",
+    "type": "bridge",
+    "bridge": "mybridge",
+    "mtu": 1460,
+    "addIf": "true",
+    "isGateway": true,
+    "ipMasq": true,
+    "ipam": {
+      "type": "host-local",
+      "subnet": "10.1.0.0/16",
+      "gateway": "10.1.0.1",
+      "routes": [
+        {
+          "dst": "0.0.0.0/0"
+        }
+      ]
+    }
+  }
+}
+`
+
+const configNoDefault = `
+{
+  "name": "kube-namespace",
+  "type": "kube-namespace",
+  "log_level": "debug",
+  "namespaces": {
+    "isolated": {
+      "name": "isolated",
+      "type": "bridge",
+      "mtu": 1460,
+      "addIf": "true",
+      "isGateway": true,
+      "ipMasq": true,
+      "ipam": {
+        "type": "host-local",
+        "subnet": "10.2.0.0/16",
+        "gateway": "10.2.0.1",
+        "routes": [
+          {
+            "dst": "0.0.0.0/0"
+          }
+        ]
+      }
+    }
+  }
+}
+`
+
+// Parse CNI_ARGS correctly.
+func TestParseExtraArgs(t *testing.T) {
+	args := "K8S_POD_NAMESPACE=test;AnotherArg=123;BadArg"
+	expected := map[string]string{
+		"K8S_POD_NAMESPACE": "test",
+		"AnotherArg":        "123",
+	}
+
+	assert.Equal(t, expected, ParseExtraArgs(args))
+}
+
+// Return the correct namespace config.
+func TestGetNamespaceConfig(t *testing.T) {
+	config := &Config{}
+	if err := json.Unmarshal([]byte(configWithDefault), config); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	sel, err := config.Select("K8S_POD_NAMESPACE=isolated")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "bridge", sel.NetConf["type"].(string))
+	assert.Equal(t, "isolated", sel.Rule)
+}
+
+// Return the default config.
+func TestGetDefaultConfig(t *testing.T) {
+	config := &Config{}
+	if err := json.Unmarshal([]byte(configWithDefault), config); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	sel, err := config.Select("K8S_POD_NAMESPACE=non-existent")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "default-bridge", sel.NetConf["name"].(string))
+	assert.Equal(t, DefaultRule, sel.Rule)
+}
+
+// Error if no default.
+func TestNoDefaultConfig(t *testing.T) {
+	config := &Config{}
+	if err := json.Unmarshal([]byte(configNoDefault), config); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	sel, err := config.Select("K8S_POD_NAMESPACE=non-existent")
+
+	assert.Error(t, err)
+	assert.Nil(t, sel)
+}
+
+// Error if K8S_POD_NAMESPACE is empty.
+func TestNoNamespace(t *testing.T) {
+	config := &Config{}
+	_, err := config.Select("")
+
+	assert.Error(t, err)
+}
+
+// Select a config for non-Kubernetes callers per nonK8sBehavior.
+func TestNonK8sBehavior(t *testing.T) {
+	config := &Config{}
+	if err := json.Unmarshal([]byte(configWithDefault), config); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	config.NonK8sBehavior = "reject"
+	_, err := config.Select("")
+	assert.Error(t, err)
+
+	config.NonK8sBehavior = "default"
+	sel, err := config.Select("")
+	assert.NoError(t, err)
+	assert.Equal(t, "default-bridge", sel.NetConf["name"])
+
+	config.NonK8sBehavior = "network=isolated"
+	sel, err = config.Select("")
+	assert.NoError(t, err)
+	assert.Equal(t, "isolated", sel.Rule)
+
+	config.NonK8sBehavior = "network=missing"
+	_, err = config.Select("")
+	assert.Error(t, err)
+}
+
+// Apply mergeWithDefault and the config transforms, such as canaries,
+// whichever setting picks the config.
+func TestNonK8sBehaviorTransforms(t *testing.T) {
+	for _, behavior := range []string{
+		`"nonK8sBehavior": "default"`,
+		`"nonK8sBehavior": "network=isolated"`,
+		`"nonK8sBehavior": "network=default-bridge"`,
+		`"fallbackWhenNoNamespace": "isolated"`,
+	} {
+		config, err := Parse([]byte(`{
+		  ` + behavior + `,
+		  "mergeWithDefault": true,
+		  "namespaces": {
+		    "isolated": {"name": "isolated", "canary": {"percent": 100, "delegate": {"name": "isolated-next", "type": "ipvlan"}}}
+		  },
+		  "profiles": {
+		    "isolated": {"name": "isolated", "canary": {"percent": 100, "delegate": {"name": "isolated-next", "type": "ipvlan"}}}
+		  },
+		  "default": {
+		    "name": "default-bridge", "type": "bridge",
+		    "canary": {"percent": 100, "delegate": {"name": "default-next", "type": "ipvlan"}}
+		  }
+		}`))
+		if !assert.NoError(t, err, behavior) {
+			continue
+		}
+
+		sel, err := config.Select("K8S_POD_NAME=web-1")
+		if assert.NoError(t, err, behavior) {
+			assert.True(t, sel.Canary, behavior)
+			assert.Equal(t, "ipvlan", sel.NetConf["type"], behavior)
+			assert.NotContains(t, sel.NetConf, "canary", behavior)
+		}
+	}
+
+	// Without a canary, the namespace config is merged over the default.
+	config, err := Parse([]byte(`{
+	  "nonK8sBehavior": "network=isolated",
+	  "mergeWithDefault": true,
+	  "namespaces": {"isolated": {"name": "isolated"}},
+	  "default": {"name": "default-bridge", "type": "bridge"}
+	}`))
+	assert.NoError(t, err)
+	sel, err := config.Select("")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "isolated", sel.NetConf["name"])
+		assert.Equal(t, "bridge", sel.NetConf["type"])
+	}
+}
+
+// Select a config for non-Kubernetes callers per fallbackWhenNoNamespace.
+// "default" is the default config even if a profile has that name.
+func TestFallbackWhenNoNamespace(t *testing.T) {
+	for fallback, rule := range map[string]string{"default": DefaultRule, "isolated": "isolated"} {
+		config, err := Parse([]byte(`{
+		  "fallbackWhenNoNamespace": "` + fallback + `",
+		  "profiles": {
+		    "base": {"type": "ipvlan", "master": "eth1"},
+		    "isolated": {"extends": "base", "name": "isolated-net"},
+		    "default": {"name": "default-profile", "type": "bridge"}
+		  },
+		  "default": {"name": "default-bridge", "type": "bridge"}
+		}`))
+		if !assert.NoError(t, err, fallback) {
+			continue
+		}
+
+		sel, err := config.Select("")
+		if assert.NoError(t, err, fallback) {
+			assert.Equal(t, rule, sel.Rule)
+			assert.NotEqual(t, "default-profile", sel.NetConf["name"])
+		}
+	}
+
+	config, err := Parse([]byte(`{"fallbackWhenNoNamespace": "error", "default": {"name": "default-bridge", "type": "bridge"}}`))
+	assert.NoError(t, err)
+	_, err = config.Select("")
+	assert.True(t, Is(err, ErrMissingNamespace))
+
+	// The profile is looked up in the profiles, not the namespaces.
+	_, err = Parse([]byte(`{"fallbackWhenNoNamespace": "missing", "namespaces": {"missing": {"name": "missing", "type": "bridge"}}}`))
+	assert.Error(t, err)
+}
+
+// Resolve the profile a caller outside Kubernetes falls back to with
+// everything it extends.
+func TestFallbackWhenNoNamespaceExtends(t *testing.T) {
+	config, err := Parse([]byte(`{
+	  "fallbackWhenNoNamespace": "isolated",
+	  "profiles": {
+	    "base": {"type": "ipvlan", "master": "eth1"},
+	    "isolated": {"extends": "base", "name": "isolated-net"}
+	  }
+	}`))
+	assert.NoError(t, err)
+
+	sel, err := config.Select("")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "isolated-net", sel.NetConf["name"])
+		assert.Equal(t, "ipvlan", sel.NetConf["type"])
+		assert.NotContains(t, sel.NetConf, "extends")
+	}
+}
+
+// Treat nonK8sBehavior as an alias of fallbackWhenNoNamespace, which
+// wins if both are set.
+func TestNonK8sBehaviorAlias(t *testing.T) {
+	config, err := Parse([]byte(`{
+	  "nonK8sBehavior": "isolated",
+	  "profiles": {"isolated": {"name": "isolated-net", "type": "bridge"}}
+	}`))
+	assert.NoError(t, err)
+	sel, err := config.Select("")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "isolated", sel.Rule)
+	}
+
+	config, err = Parse([]byte(`{
+	  "fallbackWhenNoNamespace": "default",
+	  "nonK8sBehavior": "reject",
+	  "default": {"name": "default-bridge", "type": "bridge"}
+	}`))
+	assert.NoError(t, err)
+	sel, err = config.Select("")
+	if assert.NoError(t, err) {
+		assert.Equal(t, DefaultRule, sel.Rule)
+	}
+}
+
+// Refuse unknown nonK8sBehavior values, which are taken as profiles,
+// when parsing.
+func TestNonK8sBehaviorValidate(t *testing.T) {
+	for _, behavior := range []string{"reject", "default", "network=isolated"} {
+		_, err := Parse([]byte(`{"nonK8sBehavior": "` + behavior + `"}`))
+		assert.NoError(t, err, behavior)
+	}
+
+	for _, behavior := range []string{"defualt", "network=", "profile=isolated"} {
+		_, err := Parse([]byte(`{"nonK8sBehavior": "` + behavior + `"}`))
+		assert.Error(t, err, behavior)
+	}
+}
+
+// Pick the same config every time when several have the network's
+// name.
+func TestNonK8sBehaviorNetworkOrder(t *testing.T) {
+	config, err := Parse([]byte(`{
+	  "nonK8sBehavior": "network=shared",
+	  "namespaces": {
+	    "tenant-c": {"name": "shared", "type": "bridge"},
+	    "tenant-a": {"name": "shared", "type": "bridge"},
+	    "tenant-b": {"name": "shared", "type": "bridge"}
+	  }
+	}`))
+	assert.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		sel, err := config.Select("")
+		if assert.NoError(t, err) {
+			assert.Equal(t, "tenant-a", sel.Rule)
+		}
+	}
+}
+
+// Return typed errors for selection failures.
+func TestSelectionErrorCodes(t *testing.T) {
+	config := &Config{}
+	if err := json.Unmarshal([]byte(configNoDefault), config); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	_, err := config.Select("")
+	assert.Equal(t, CodeMissingNamespace, err.(*types.Error).Code)
+
+	_, err = config.Select("K8S_POD_NAMESPACE=non-existent")
+	assert.Equal(t, CodeNamespaceNotConfigured, err.(*types.Error).Code)
+}
+
+// Merge namespace configs over the default with mergeWithDefault.
+func TestMergeWithDefault(t *testing.T) {
+	config, err := Parse([]byte(`{
+	  "mergeWithDefault": true,
+	  "namespaces": {
+	    "isolated": {"name": "isolated", "ipam": {"subnet": "10.2.0.0/16"}}
+	  },
+	  "default": {
+	    "name": "default-bridge",
+	    "type": "bridge",
+	    "ipam": {"type": "host-local", "subnet": "10.1.0.0/16"}
+	  }
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	sel, err := config.Select("K8S_POD_NAMESPACE=isolated")
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"name": "isolated",
+		"type": "bridge",
+		"ipam": map[string]interface{}{"type": "host-local", "subnet": "10.2.0.0/16"},
+	}, sel.NetConf)
+}
+
+// Select the system network for system namespaces, even if the other
+// namespace configs fail to load.
+func TestSystemNamespaces(t *testing.T) {
+	config, err := Parse([]byte(`{
+	  "systemNamespaces": ["kube-system"],
+	  "systemNetwork": {"name": "system", "type": "ptp"},
+	  "namespacesDir": "/nonexistent",
+	  "default": {"name": "default-bridge", "type": "bridge"}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	sel, err := config.Select("K8S_POD_NAMESPACE=kube-system")
+	assert.NoError(t, err)
+	assert.Equal(t, SystemRule, sel.Rule)
+	assert.Equal(t, "ptp", sel.NetConf["type"])
+
+	_, err = config.Select("K8S_POD_NAMESPACE=other")
+	assert.Error(t, err)
+}
+
+// Refuse delegate types outside allowedDelegateTypes.
+func TestAllowedDelegateTypes(t *testing.T) {
+	c, err := Parse([]byte(`{
+	  "allowedDelegateTypes": ["bridge"],
+	  "namespaces": {
+	    "ok": {"name": "ok", "type": "bridge"},
+	    "evil": {"name": "evil", "type": "../../bin/sh"},
+	    "scratch": {"name": "scratch", "networkless": true},
+	    "dpdk": {"name": "dpdk", "hostDevice": {"driver": "mlx5_core"}}
+	  }
+	}`))
+	assert.NoError(t, err)
+
+	_, err = c.Select("K8S_POD_NAMESPACE=ok")
+	assert.NoError(t, err)
+
+	// Networkless and host device configs have no delegate to refuse.
+	_, err = c.Select("K8S_POD_NAMESPACE=scratch")
+	assert.NoError(t, err)
+	_, err = c.Select("K8S_POD_NAMESPACE=dpdk")
+	assert.NoError(t, err)
+
+	_, err = c.Select("K8S_POD_NAMESPACE=evil")
+	assert.True(t, Is(err, ErrDelegateNotAllowed))
+}
+
+// Override namespace configs without touching the original.
+func TestWithNamespaces(t *testing.T) {
+	c, err := Parse([]byte(configNoDefault))
+	assert.NoError(t, err)
+
+	overridden := c.WithNamespaces(map[string]map[string]interface{}{
+		"isolated": {"name": "crd", "type": "ptp"},
+		"other":    {"name": "other", "type": "bridge"},
+	})
+
+	sel, err := overridden.Select("K8S_POD_NAMESPACE=isolated")
+	assert.NoError(t, err)
+	assert.Equal(t, "ptp", sel.NetConf["type"])
+
+	_, err = overridden.Select("K8S_POD_NAMESPACE=other")
+	assert.NoError(t, err)
+
+	sel, err = c.Select("K8S_POD_NAMESPACE=isolated")
+	assert.NoError(t, err)
+	assert.Equal(t, "bridge", sel.NetConf["type"])
+	_, err = c.Select("K8S_POD_NAMESPACE=other")
+	assert.Error(t, err)
+}
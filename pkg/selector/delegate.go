@@ -0,0 +1,181 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// The most of a failed delegate's stderr kept in the error returned.
+const maxDelegateStderr = 2048
+
+// The environment delegates run in: where to find them, and the CNI
+// arguments to pass them.
+type DelegateEnv struct {
+	// Directories to look for delegate plugins in, as in CNI_PATH.
+	CNIPath string
+	Args    invoke.CNIArgs
+	// The logger of the invocation; nil means Log.
+	Log *logrus.Entry
+}
+
+// Return the delegate environment of this process, for plugins that
+// pass their own CNI environment on.
+func ProcessEnv() *DelegateEnv {
+	return &DelegateEnv{
+		CNIPath: os.Getenv("CNI_PATH"),
+		Args:    invoke.ArgsFromEnv(),
+	}
+}
+
+// Return the logger to log delegate invocations with.
+func (e *DelegateEnv) Logger() *logrus.Entry {
+	if e.Log == nil {
+		return Log
+	}
+
+	return e.Log
+}
+
+// Return the path of the delegate plugin for a network config.
+func (e *DelegateEnv) FindDelegate(netconf map[string]interface{}) (string, error) {
+	delegateType, _ := netconf["type"].(string)
+	return e.findPlugin(delegateType)
+}
+
+// Return the path of a plugin in CNI_PATH.
+func (e *DelegateEnv) findPlugin(delegateType string) (string, error) {
+	if delegateType == "" {
+		return "", newError(CodeDelegateNotFound, "Network config has no delegate type.")
+	}
+
+	path, err := invoke.FindInPath(delegateType, filepath.SplitList(e.CNIPath))
+	if err != nil {
+		return "", newError(CodeDelegateNotFound, "Delegate plugin %q not found: %v", delegateType, err)
+	}
+
+	return path, nil
+}
+
+// Run the delegate's ADD with netconf as its config.
+func (e *DelegateEnv) Add(netconf map[string]interface{}) (*Result, error) {
+	delegateType, _ := netconf["type"].(string)
+	return e.AddWithType(delegateType, netconf)
+}
+
+// Run the ADD of the plugin pluginType with netconf as its config,
+// e.g. to run a config's IPAM plugin directly.
+func (e *DelegateEnv) AddWithType(pluginType string, netconf map[string]interface{}) (*Result, error) {
+	ncBytes, err := json.Marshal(netconf)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal config: %v", err)
+	}
+
+	path, err := e.findPlugin(pluginType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Run the plugin directly, as invoke only parses 0.1 and 0.2
+	// results.
+	out, err := e.execPlugin(pluginType, path, ncBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePluginResult(out)
+}
+
+// Run the delegate's DEL with netconf as its config.
+func (e *DelegateEnv) Del(netconf map[string]interface{}) error {
+	delegateType, _ := netconf["type"].(string)
+	return e.DelWithType(delegateType, netconf)
+}
+
+// Run the DEL of the plugin pluginType with netconf as its config.
+func (e *DelegateEnv) DelWithType(pluginType string, netconf map[string]interface{}) error {
+	ncBytes, err := json.Marshal(netconf)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal config: %v", err)
+	}
+
+	path, err := e.findPlugin(pluginType)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.execPlugin(pluginType, path, ncBytes)
+	return err
+}
+
+// Run the plugin at path, passing its stderr on to ours.  If it fails,
+// the end of its stderr is logged and returned in the error's details,
+// as its exit status and message alone rarely explain the failure.
+func (e *DelegateEnv) execPlugin(pluginType, path string, stdin []byte) ([]byte, error) {
+	stderr := &tailBuffer{max: maxDelegateStderr}
+	raw := &invoke.RawExec{Stderr: io.MultiWriter(os.Stderr, stderr)}
+
+	out, err := raw.ExecPlugin(path, stdin, e.Args.AsEnv())
+	if err == nil {
+		return out, nil
+	}
+
+	failure := newError(CodeDelegateFailed, "Delegate %q failed: %v", pluginType, err)
+	if output := stderr.String(); output != "" {
+		e.Logger().WithFields(logrus.Fields{
+			"delegate_type": pluginType,
+			"stderr":        output,
+		}).Warn("Delegate failed.")
+		failure.Details = "stderr: " + output
+	}
+
+	return nil, failure
+}
+
+// A writer keeping the last max bytes written to it.
+type tailBuffer struct {
+	max     int
+	buf     []byte
+	dropped bool
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.max; over > 0 {
+		b.buf = b.buf[over:]
+		b.dropped = true
+	}
+
+	return len(p), nil
+}
+
+// Return the output kept, marked if its start was dropped.
+func (b *tailBuffer) String() string {
+	output := strings.TrimSpace(string(b.buf))
+	if b.dropped && output != "" {
+		output = "..." + output
+	}
+
+	return output
+}
@@ -0,0 +1,70 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// Return a typed error if the delegate is not in CNI_PATH.
+func TestFindDelegateNotFound(t *testing.T) {
+	env := &DelegateEnv{CNIPath: "/nonexistent"}
+
+	_, err := env.FindDelegate(map[string]interface{}{"type": "bridge"})
+
+	assert.Equal(t, CodeDelegateNotFound, err.(*types.Error).Code)
+}
+
+// Return the end of a failed delegate's stderr in the error.
+func TestDelegateStderr(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-delegate")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	script := "#!/bin/sh\necho 'first line' >&2\necho 'failed to set up veth: file exists' >&2\nexit 1\n"
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "failing"), []byte(script), 0755))
+	env := &DelegateEnv{CNIPath: dir, Args: &invoke.Args{Command: "ADD"}}
+
+	_, err = env.Add(map[string]interface{}{"type": "failing"})
+	if assert.Error(t, err) {
+		typed := err.(*types.Error)
+		assert.Equal(t, CodeDelegateFailed, typed.Code)
+		assert.Equal(t, "stderr: first line\nfailed to set up veth: file exists", typed.Details)
+	}
+
+	env.Args = &invoke.Args{Command: "DEL"}
+	err = env.Del(map[string]interface{}{"type": "failing"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.(*types.Error).Details, "file exists")
+	}
+}
+
+// Keep the end of long output.
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{max: 8}
+	b.Write([]byte("abc"))
+	assert.Equal(t, "abc", b.String())
+
+	b.Write([]byte(strings.Repeat("x", 10) + "end"))
+	assert.Equal(t, "...xxxxxend", b.String())
+}
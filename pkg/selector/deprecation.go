@@ -0,0 +1,181 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// The key under which a resolved config records the deprecated
+// profile it was built from.
+const DeprecationKey = "deprecation"
+
+// The layout of sunsetAfter dates.
+const sunsetLayout = "2006-01-02"
+
+// Returns the current time; replaced in tests.
+var now = time.Now
+
+// A deprecated profile, as recorded in the configs extending it.
+type Deprecation struct {
+	Profile string `json:"profile"`
+	// The last day the profile is meant to be used, as YYYY-MM-DD.
+	SunsetAfter string `json:"sunsetAfter,omitempty"`
+	// Give pods the default config instead once the sunset date has
+	// passed.
+	FallbackAfterSunset bool `json:"fallbackAfterSunset,omitempty"`
+}
+
+// Return whether the sunset date has passed at t.
+func (d *Deprecation) Sunset(t time.Time) bool {
+	if d.SunsetAfter == "" {
+		return false
+	}
+
+	day, err := time.Parse(sunsetLayout, d.SunsetAfter)
+	if err != nil {
+		return false
+	}
+
+	return !t.UTC().Before(day.AddDate(0, 0, 1))
+}
+
+// Remove the deprecation keys of the profile called name from its
+// resolved config, and record them under DeprecationKey if it is
+// deprecated.  profile is not modified.
+func profileDeprecation(name string, profile map[string]interface{}) (map[string]interface{}, error) {
+	var keys struct {
+		Deprecated          bool   `json:"deprecated"`
+		SunsetAfter         string `json:"sunsetAfter"`
+		FallbackAfterSunset bool   `json:"fallbackAfterSunset"`
+	}
+
+	_, hasDeprecated := profile["deprecated"]
+	_, hasSunset := profile["sunsetAfter"]
+	_, hasFallback := profile["fallbackAfterSunset"]
+	if !hasDeprecated && !hasSunset && !hasFallback {
+		return profile, nil
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"deprecated":          profile["deprecated"],
+		"sunsetAfter":         profile["sunsetAfter"],
+		"fallbackAfterSunset": profile["fallbackAfterSunset"],
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("Failed to parse deprecation: %v", err)
+	}
+
+	if keys.SunsetAfter != "" {
+		if _, err := time.Parse(sunsetLayout, keys.SunsetAfter); err != nil {
+			return nil, fmt.Errorf("sunsetAfter must be a date as YYYY-MM-DD, not %q.", keys.SunsetAfter)
+		}
+	}
+	if !keys.Deprecated && (keys.SunsetAfter != "" || keys.FallbackAfterSunset) {
+		return nil, errors.New("sunsetAfter and fallbackAfterSunset need deprecated set.")
+	}
+	if keys.FallbackAfterSunset && keys.SunsetAfter == "" {
+		return nil, errors.New("fallbackAfterSunset needs a sunsetAfter date.")
+	}
+
+	resolved := make(map[string]interface{}, len(profile))
+	for k, v := range profile {
+		resolved[k] = v
+	}
+	delete(resolved, "deprecated")
+	delete(resolved, "sunsetAfter")
+	delete(resolved, "fallbackAfterSunset")
+
+	if keys.Deprecated {
+		resolved[DeprecationKey] = map[string]interface{}{
+			"profile":             name,
+			"sunsetAfter":         keys.SunsetAfter,
+			"fallbackAfterSunset": keys.FallbackAfterSunset,
+		}
+	}
+
+	return resolved, nil
+}
+
+// Return the deprecated profile a network config was built from, or
+// nil if there is none.
+func NetConfDeprecation(netconf map[string]interface{}) *Deprecation {
+	raw, ok := netconf[DeprecationKey].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	d := &Deprecation{}
+	d.Profile, _ = raw["profile"].(string)
+	d.SunsetAfter, _ = raw["sunsetAfter"].(string)
+	d.FallbackAfterSunset, _ = raw["fallbackAfterSunset"].(bool)
+	return d
+}
+
+// Record on the selection whether its config comes from a deprecated
+// profile and, once the profile's sunset date has passed, give the pod
+// the default config instead if the profile asks for that.
+func (c *Config) checkDeprecation(sel *Selection, extraArgs map[string]string) (*Selection, error) {
+	if sel.Deprecation != nil {
+		return sel, nil
+	}
+
+	d := NetConfDeprecation(sel.NetConf)
+	if d == nil {
+		return sel, nil
+	}
+
+	fields := logrus.Fields{
+		"namespace": sel.Namespace,
+		"pod":       sel.Pod,
+		"rule":      sel.Rule,
+		"profile":   d.Profile,
+	}
+	if d.SunsetAfter != "" {
+		fields["sunsetAfter"] = d.SunsetAfter
+	}
+
+	if !d.Sunset(now()) || !d.FallbackAfterSunset {
+		copied := *sel
+		copied.Deprecation = d
+		c.Logger().WithFields(fields).Warn("Using network config from a deprecated profile.")
+		return &copied, nil
+	}
+
+	if sel.Rule == DefaultRule || len(c.Default) == 0 || NetConfDeprecation(c.Default) != nil {
+		copied := *sel
+		copied.Deprecation = d
+		c.Logger().WithFields(fields).Warn("Deprecated profile is past its sunset date, but there is no usable default config to fall back to.")
+		return &copied, nil
+	}
+
+	c.Logger().WithFields(fields).Warn("Deprecated profile is past its sunset date. Using default config.")
+
+	fallback, err := c.transform(&Selection{Namespace: sel.Namespace, Pod: sel.Pod, Rule: DefaultRule, NetConf: c.Default}, extraArgs)
+	if err != nil {
+		return nil, err
+	}
+	fallback.Deprecation = d
+	fallback.SunsetFallback = true
+	return fallback, nil
+}
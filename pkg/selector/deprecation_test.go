@@ -0,0 +1,113 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const deprecationConfig = `{
+  "profiles": {
+    "legacy-vlan": {"type": "macvlan", "deprecated": true, "sunsetAfter": "2026-06-30", "fallbackAfterSunset": true},
+    "legacy-jumbo": {"extends": "legacy-vlan", "mtu": 9000}
+  },
+  "namespaces": {
+    "tenant-a": {"extends": "legacy-jumbo", "name": "tenant-a"},
+    "tenant-b": {"type": "bridge", "name": "tenant-b"}
+  },
+  "default": {"type": "bridge", "name": "default"}
+}`
+
+// Record the deprecated profile a config extends, with its keys
+// removed from the config.
+func TestDeprecation(t *testing.T) {
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Date(2026, 6, 30, 23, 0, 0, 0, time.UTC) }
+
+	config, err := Parse([]byte(deprecationConfig))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	sel, err := config.Select("K8S_POD_NAMESPACE=tenant-a;K8S_POD_NAME=web-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "tenant-a", sel.Rule)
+	assert.Equal(t, &Deprecation{Profile: "legacy-vlan", SunsetAfter: "2026-06-30", FallbackAfterSunset: true}, sel.Deprecation)
+	assert.False(t, sel.SunsetFallback)
+	assert.Equal(t, float64(9000), sel.NetConf["mtu"])
+	for _, key := range []string{"deprecated", "sunsetAfter", "fallbackAfterSunset"} {
+		assert.NotContains(t, sel.NetConf, key)
+	}
+
+	sel, err = config.Select("K8S_POD_NAMESPACE=tenant-b")
+	assert.NoError(t, err)
+	assert.Nil(t, sel.Deprecation)
+}
+
+// Give pods the default config once the sunset date has passed, if
+// the profile asks for that.
+func TestDeprecationSunset(t *testing.T) {
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC) }
+
+	config, err := Parse([]byte(deprecationConfig))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	sel, err := config.Select("K8S_POD_NAMESPACE=tenant-a;K8S_POD_NAME=web-1")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultRule, sel.Rule)
+	assert.Equal(t, "default", sel.NetConf["name"])
+	assert.True(t, sel.SunsetFallback)
+	assert.Equal(t, "legacy-vlan", sel.Deprecation.Profile)
+
+	// Without fallbackAfterSunset, or without a default, the profile
+	// is still used.
+	for _, data := range []string{
+		`{"profiles": {"legacy": {"type": "macvlan", "deprecated": true, "sunsetAfter": "2026-06-30"}},
+		  "namespaces": {"tenant-a": {"extends": "legacy"}}, "default": {"type": "bridge"}}`,
+		`{"profiles": {"legacy": {"type": "macvlan", "deprecated": true, "sunsetAfter": "2026-06-30", "fallbackAfterSunset": true}},
+		  "namespaces": {"tenant-a": {"extends": "legacy"}}}`,
+	} {
+		config, err := Parse([]byte(data))
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		sel, err := config.Select("K8S_POD_NAMESPACE=tenant-a")
+		assert.NoError(t, err)
+		assert.Equal(t, "tenant-a", sel.Rule)
+		assert.Equal(t, "macvlan", sel.NetConf["type"])
+		assert.False(t, sel.SunsetFallback)
+		assert.True(t, sel.Deprecation.Sunset(now()))
+	}
+}
+
+// Reject malformed deprecation keys when loading the config.
+func TestDeprecationErrors(t *testing.T) {
+	for _, data := range []string{
+		`{"profiles": {"a": {"deprecated": true, "sunsetAfter": "30/06/2026"}}}`,
+		`{"profiles": {"a": {"deprecated": "yes"}}}`,
+		`{"profiles": {"a": {"sunsetAfter": "2026-06-30"}}}`,
+		`{"profiles": {"a": {"deprecated": true, "fallbackAfterSunset": true}}}`,
+	} {
+		_, err := Parse([]byte(data))
+		assert.Error(t, err, data)
+	}
+}
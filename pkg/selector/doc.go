@@ -0,0 +1,46 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package selector is kube-namespace's network selection logic, for
+// use by other CNI meta-plugins and node agents.
+//
+// Parse reads a kube-namespace plugin config, including any
+// namespacesDir, and resolves namespaces defined more than once:
+//
+//	config, err := selector.Parse(stdinData)
+//
+// Select then picks the network config for a pod from the CNI_ARGS
+// Kubernetes passes, applying the system namespaces, merging with the
+// default config, VLAN map and MTU overrides:
+//
+//	sel, err := config.Select("K8S_POD_NAMESPACE=web;K8S_POD_NAME=web-1")
+//
+// SelectWith instead tries the strategies of the "selection" block,
+// such as pod annotations and label selectors, in order.  Strategies
+// are Selectors; callers can add their own:
+//
+//	selectors := config.Selectors(map[string]selector.Selector{selector.ModeCRD: byCRD})
+//	sel, err := config.SelectWith(selectors, args, podMetadata)
+//
+// and a DelegateEnv runs the delegate plugin with the selected config:
+//
+//	result, err := selector.ProcessEnv().Add(sel.NetConf)
+//
+// Keys handled by kube-namespace itself, such as "sysctls" or
+// "egressRules", are left in the selected config; callers that do not
+// handle them should remove them before running the delegate.
+//
+// Errors are CNI errors whose codes are listed below; test for them
+// with Is and the helpers rather than by message.
+package selector
@@ -0,0 +1,212 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Policies for a namespace that is defined more than once.
+const (
+	// Fail the invocation.
+	duplicateError = "error"
+	// Use the first definition, so earlier sources take priority.
+	duplicateFirst = "first"
+	// Use the last definition.  This is the default, and matches how
+	// duplicate JSON keys were always handled.
+	duplicateLast = "last"
+	// Deep merge later definitions over earlier ones.
+	duplicateMerge = "merge"
+)
+
+// A namespace's network config, along with where it was defined.
+type namespaceEntry struct {
+	namespace string
+	source    string
+	netconf   map[string]interface{}
+}
+
+// How a namespace defined more than once was resolved, for the audit
+// log.  With the merge policy, the winner is the last definition,
+// whose fields take precedence over the losers'.
+type DuplicateResolution struct {
+	Policy string   `json:"policy"`
+	Winner string   `json:"winner"`
+	Losers []string `json:"losers"`
+}
+
+// Decode a JSON object of namespace configs into its entries, in
+// order and keeping any duplicate keys.
+func decodeNamespaceEntries(data []byte, source string) ([]namespaceEntry, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("Namespaces in %s must be an object.", source)
+	}
+
+	var entries []namespaceEntry
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse namespaces in %s: %v", source, err)
+		}
+
+		entry := namespaceEntry{namespace: tok.(string), source: source}
+		if err := dec.Decode(&entry.netconf); err != nil {
+			return nil, fmt.Errorf("Failed to parse config for namespace %q in %s: %v",
+				entry.namespace, source, err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// Namespace configs resolved from their entries, with the source of
+// each and how any duplicates were resolved.
+type resolvedNamespaces struct {
+	namespaces map[string]map[string]interface{}
+	sources    map[string]string
+	duplicates map[string]*DuplicateResolution
+}
+
+// Combine namespace entries into a single map, applying policy to any
+// namespace that is defined more than once.
+func resolveNamespaces(entries []namespaceEntry, policy string) (*resolvedNamespaces, error) {
+	if policy == "" {
+		policy = duplicateLast
+	}
+
+	switch policy {
+	case duplicateError, duplicateFirst, duplicateLast, duplicateMerge:
+	default:
+		return nil, fmt.Errorf("Unknown duplicate namespace policy %q.", policy)
+	}
+
+	r := &resolvedNamespaces{
+		namespaces: make(map[string]map[string]interface{}),
+		sources:    make(map[string]string),
+		duplicates: make(map[string]*DuplicateResolution),
+	}
+	sources := make(map[string][]string)
+
+	for _, e := range entries {
+		sources[e.namespace] = append(sources[e.namespace], e.source)
+
+		existing, ok := r.namespaces[e.namespace]
+		if !ok {
+			r.namespaces[e.namespace] = e.netconf
+			r.sources[e.namespace] = e.source
+			continue
+		}
+
+		switch policy {
+		case duplicateError:
+			return nil, fmt.Errorf("Namespace %q is defined more than once (in %v).",
+				e.namespace, sources[e.namespace])
+		case duplicateLast:
+			r.namespaces[e.namespace] = e.netconf
+			r.sources[e.namespace] = e.source
+		case duplicateMerge:
+			r.namespaces[e.namespace] = DeepMerge(existing, e.netconf)
+			r.sources[e.namespace] = e.source
+		}
+	}
+
+	for namespace, srcs := range sources {
+		if len(srcs) < 2 {
+			continue
+		}
+
+		d := &DuplicateResolution{Policy: policy, Winner: r.sources[namespace]}
+		winnerSeen := false
+		for _, src := range srcs {
+			if src == d.Winner && !winnerSeen {
+				winnerSeen = true
+				continue
+			}
+			d.Losers = append(d.Losers, src)
+		}
+		r.duplicates[namespace] = d
+
+		Log.WithFields(logrus.Fields{
+			"namespace": namespace,
+			"sources":   srcs,
+			"policy":    policy,
+			"winner":    d.Winner,
+		}).Warn("Namespace defined more than once.")
+	}
+
+	return r, nil
+}
+
+// Return a copy of the config with the namespace configs from source,
+// e.g. NamespaceNetwork resources, resolved against its own with its
+// duplicateNamespaces policy.  The config itself is left as-is.
+func (c *Config) WithNamespacesFrom(source string, namespaces map[string]map[string]interface{}) (*Config, error) {
+	names := make([]string, 0, len(namespaces))
+	for namespace := range namespaces {
+		names = append(names, namespace)
+	}
+	sort.Strings(names)
+
+	var entries []namespaceEntry
+	for _, namespace := range names {
+		if existing, ok := c.Namespaces[namespace]; ok {
+			entries = append(entries, namespaceEntry{namespace, c.namespaceSources[namespace], existing})
+		}
+		entries = append(entries, namespaceEntry{namespace, source, namespaces[namespace]})
+	}
+
+	resolved, err := resolveNamespaces(entries, c.DuplicateNamespaces)
+	if err != nil {
+		return nil, err
+	}
+
+	copied := c.WithNamespaces(resolved.namespaces)
+	copied.namespaceSources = make(map[string]string, len(c.namespaceSources)+len(resolved.sources))
+	copied.duplicates = make(map[string]*DuplicateResolution, len(c.duplicates)+len(resolved.duplicates))
+	for namespace, src := range c.namespaceSources {
+		copied.namespaceSources[namespace] = src
+	}
+	for namespace, d := range c.duplicates {
+		copied.duplicates[namespace] = d
+	}
+	for namespace, src := range resolved.sources {
+		copied.namespaceSources[namespace] = src
+	}
+
+	// Sources that already lost to the config's own winner lose to the
+	// new winner too.
+	for namespace, d := range resolved.duplicates {
+		if earlier, ok := c.duplicates[namespace]; ok {
+			merged := *d
+			merged.Losers = append(append([]string{}, earlier.Losers...), d.Losers...)
+			d = &merged
+		}
+		copied.duplicates[namespace] = d
+	}
+
+	return copied, nil
+}
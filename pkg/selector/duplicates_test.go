@@ -0,0 +1,171 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const duplicateNamespaces = `{
+  "isolated": {"type": "bridge", "ipam": {"type": "host-local", "subnet": "10.2.0.0/16"}},
+  "other": {"type": "ptp"},
+  "isolated": {"ipam": {"subnet": "10.3.0.0/16"}}
+}`
+
+func resolveDuplicates(t *testing.T, policy string) (map[string]map[string]interface{}, error) {
+	entries, err := decodeNamespaceEntries([]byte(duplicateNamespaces), "test")
+	if err != nil {
+		t.Fatalf("Failed to decode namespaces: %v", err)
+	}
+
+	resolved, err := resolveNamespaces(entries, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	return resolved.namespaces, nil
+}
+
+// Keep duplicate keys when decoding.
+func TestDecodeNamespaceEntries(t *testing.T) {
+	entries, err := decodeNamespaceEntries([]byte(duplicateNamespaces), "test")
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, "isolated", entries[2].namespace)
+}
+
+// Error on duplicates with the error policy.
+func TestDuplicateError(t *testing.T) {
+	_, err := resolveDuplicates(t, duplicateError)
+
+	assert.Error(t, err)
+}
+
+// Pick the right definition with the first and last policies.
+func TestDuplicateFirstLast(t *testing.T) {
+	namespaces, err := resolveDuplicates(t, duplicateFirst)
+	assert.NoError(t, err)
+	assert.Equal(t, "bridge", namespaces["isolated"]["type"])
+
+	namespaces, err = resolveDuplicates(t, "")
+	assert.NoError(t, err)
+	assert.NotContains(t, namespaces["isolated"], "type")
+}
+
+// Deep merge duplicates with the merge policy.
+func TestDuplicateMerge(t *testing.T) {
+	namespaces, err := resolveDuplicates(t, duplicateMerge)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "bridge", namespaces["isolated"]["type"])
+	assert.Equal(t, map[string]interface{}{
+		"type":   "host-local",
+		"subnet": "10.3.0.0/16",
+	}, namespaces["isolated"]["ipam"])
+}
+
+// Record the winning and losing sources of a duplicate namespace.
+func TestDuplicateResolution(t *testing.T) {
+	dir := writeNamespacesDir(t, map[string]string{
+		"isolated.conf": `{"name": "from-dir", "type": "bridge"}`,
+	})
+	defer os.RemoveAll(dir)
+
+	config, err := Parse([]byte(fmt.Sprintf(`{
+	  "namespacesDir": %q,
+	  "namespaces": {"isolated": {"name": "inline", "type": "bridge"}, "other": {"type": "ptp"}}
+	}`, dir)))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	sel, err := config.Select("K8S_POD_NAMESPACE=isolated")
+	assert.NoError(t, err)
+	assert.Equal(t, "from-dir", sel.NetConf["name"])
+	assert.Equal(t, &DuplicateResolution{
+		Policy: duplicateLast,
+		Winner: filepath.Join(dir, "isolated.conf"),
+		Losers: []string{"config"},
+	}, sel.Duplicate)
+
+	sel, err = config.Select("K8S_POD_NAMESPACE=other")
+	assert.NoError(t, err)
+	assert.Nil(t, sel.Duplicate)
+}
+
+// Resolve namespace configs from another source, such as
+// NamespaceNetworks, with the duplicateNamespaces policy.
+func TestWithNamespacesFrom(t *testing.T) {
+	dir := writeNamespacesDir(t, map[string]string{
+		"isolated.conf": `{"name": "from-dir", "type": "bridge"}`,
+	})
+	defer os.RemoveAll(dir)
+	dirFile := filepath.Join(dir, "isolated.conf")
+
+	crd := map[string]map[string]interface{}{
+		"isolated": {"name": "crd", "ipam": map[string]interface{}{"subnet": "10.9.0.0/16"}},
+		"new":      {"name": "new", "type": "ptp"},
+	}
+
+	for _, tc := range []struct {
+		policy, name, winner string
+		losers               []string
+	}{
+		{duplicateLast, "crd", "NamespaceNetwork", []string{"config", dirFile}},
+		{duplicateFirst, "inline", "config", []string{dirFile, "NamespaceNetwork"}},
+		{duplicateMerge, "crd", "NamespaceNetwork", []string{"config", dirFile}},
+	} {
+		config, err := Parse([]byte(fmt.Sprintf(`{
+		  "duplicateNamespaces": %q,
+		  "namespacesDir": %q,
+		  "namespaces": {"isolated": {"name": "inline", "type": "bridge"}}
+		}`, tc.policy, dir)))
+		if !assert.NoError(t, err, tc.policy) {
+			continue
+		}
+
+		withCRD, err := config.WithNamespacesFrom("NamespaceNetwork", crd)
+		if !assert.NoError(t, err, tc.policy) {
+			continue
+		}
+
+		sel, err := withCRD.Select("K8S_POD_NAMESPACE=isolated")
+		if assert.NoError(t, err, tc.policy) {
+			assert.Equal(t, tc.name, sel.NetConf["name"], tc.policy)
+			assert.Equal(t, &DuplicateResolution{Policy: tc.policy, Winner: tc.winner, Losers: tc.losers}, sel.Duplicate, tc.policy)
+		}
+		if tc.policy == duplicateMerge {
+			assert.Equal(t, "bridge", sel.NetConf["type"])
+		}
+
+		sel, err = withCRD.Select("K8S_POD_NAMESPACE=new")
+		assert.NoError(t, err, tc.policy)
+		assert.Nil(t, sel.Duplicate, tc.policy)
+
+		// The config itself is left alone.
+		assert.NotEqual(t, "crd", config.Namespaces["isolated"]["name"], tc.policy)
+	}
+
+	config, err := Parse([]byte(`{"duplicateNamespaces": "error", "namespaces": {"isolated": {"type": "bridge"}}}`))
+	assert.NoError(t, err)
+	_, err = config.WithNamespacesFrom("NamespaceNetwork", crd)
+	assert.Error(t, err)
+}
@@ -0,0 +1,357 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Selection modes, for the "mode" of the "selection" block.
+const (
+	// The pod's namespace's entry in the namespaces map, or the
+	// default config.  See Select.
+	ModeNamespaceMap = "namespaceMap"
+	// The network named by an annotation on the pod.
+	ModeAnnotation = "annotation"
+	// The network of the first label selector matching the pod.
+	ModeLabelSelector = "labelSelector"
+	// The namespace's NamespaceNetwork resource.  This package has no
+	// selector for it; callers that support it pass their own to
+	// Selectors.
+	ModeCRD = "crd"
+	// The namespace's entry in etcd.  As with ModeCRD, callers that
+	// support it pass their own selector.
+	ModeEtcd = "etcd"
+	// The network of the first resource selector matching the pod's
+	// QoS class and resource requests.  Callers pass their own
+	// ByResources; see resources.go.
+	ModeResources = "resources"
+	// The profile or network of the top-level rules list.  See
+	// rules.go.
+	ModeRules = "rules"
+)
+
+// The annotation ByAnnotation looks at unless configured otherwise.
+const DefaultNetworkAnnotation = "kube-namespace.coreos.com/network"
+
+// The modes used when the config has no "selection" block.
+var DefaultModes = Modes{ModeCRD, ModeEtcd, ModeRules, ModeNamespaceMap}
+
+// The top-level "selection" block: the strategies for selecting a
+// pod's network config, in priority order.
+type Strategies struct {
+	Mode              Modes              `json:"mode"`
+	Annotation        string             `json:"annotation"`
+	LabelSelectors    []LabelSelector    `json:"labelSelectors"`
+	ResourceSelectors []ResourceSelector `json:"resourceSelectors"`
+}
+
+// A list of selection modes.  In JSON it is a list, or a single mode.
+type Modes []string
+
+func (m *Modes) UnmarshalJSON(data []byte) error {
+	var mode string
+	if err := json.Unmarshal(data, &mode); err == nil {
+		*m = Modes{mode}
+		return nil
+	}
+
+	var modes []string
+	if err := json.Unmarshal(data, &modes); err != nil {
+		return errors.New("Selection mode must be a mode or a list of modes.")
+	}
+
+	*m = modes
+	return nil
+}
+
+// A pod label selector, and the name of the network config for the
+// pods it matches.
+type LabelSelector struct {
+	MatchLabels      map[string]string         `json:"matchLabels"`
+	MatchExpressions []NodeSelectorRequirement `json:"matchExpressions"`
+	Network          string                    `json:"network"`
+}
+
+// Return whether the pod's labels match the selector.
+func (s *LabelSelector) matches(labels map[string]string) bool {
+	for k, v := range s.MatchLabels {
+		if value, ok := labels[k]; !ok || value != v {
+			return false
+		}
+	}
+
+	for _, req := range s.MatchExpressions {
+		if !req.matches(labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Validate the selection block.
+func (s *Strategies) validate() error {
+	for _, mode := range s.Mode {
+		switch mode {
+		case ModeNamespaceMap, ModeAnnotation, ModeLabelSelector, ModeCRD, ModeEtcd, ModeResources, ModeRules:
+		default:
+			return fmt.Errorf("Unknown selection mode %q.", mode)
+		}
+	}
+
+	for i, ls := range s.LabelSelectors {
+		if ls.Network == "" {
+			return fmt.Errorf("Label selector %d has no network.", i)
+		}
+		if len(ls.MatchLabels) == 0 && len(ls.MatchExpressions) == 0 {
+			return fmt.Errorf("Label selector %d matches every pod.", i)
+		}
+		for _, req := range ls.MatchExpressions {
+			if err := req.validate(); err != nil {
+				return fmt.Errorf("Label selector %d: %v", i, err)
+			}
+		}
+	}
+
+	for i := range s.ResourceSelectors {
+		if err := s.ResourceSelectors[i].validate(); err != nil {
+			return fmt.Errorf("Resource selector %d %v", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Returns the labels and annotations of the pod being selected for.
+// Selectors that need them call it; callers should look the pod up
+// only once, however often it is called.
+type PodMetadata func() (labels, annotations map[string]string, err error)
+
+// A strategy for selecting a pod's network config.  Select returns
+// nil, and no error, if the strategy has no config for the pod, so
+// that the next strategy is tried.
+type Selector interface {
+	Select(c *Config, args string, pod PodMetadata) (*Selection, error)
+}
+
+// Selects by the namespaces map and default config, as Select does.
+type ByNamespaceMap struct{}
+
+func (ByNamespaceMap) Select(c *Config, args string, pod PodMetadata) (*Selection, error) {
+	sel, err := c.Select(args)
+	if IsNamespaceNotConfigured(err) {
+		return nil, nil
+	}
+
+	return sel, err
+}
+
+// Selects the network named by an annotation on the pod.
+type ByAnnotation struct {
+	Key string
+}
+
+func (s ByAnnotation) Select(c *Config, args string, pod PodMetadata) (*Selection, error) {
+	_, annotations, err := pod()
+	if err != nil {
+		return nil, err
+	}
+
+	network := annotations[s.Key]
+	if network == "" {
+		return nil, nil
+	}
+
+	c.Logger().WithField("annotation", s.Key).Debug("Using network from pod annotation.")
+	return c.SelectNamed(network, args)
+}
+
+// Selects the network of the first label selector matching the pod.
+type ByLabelSelector struct {
+	Selectors []LabelSelector
+}
+
+func (s ByLabelSelector) Select(c *Config, args string, pod PodMetadata) (*Selection, error) {
+	if len(s.Selectors) == 0 {
+		return nil, nil
+	}
+
+	labels, _, err := pod()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range s.Selectors {
+		if s.Selectors[i].matches(labels) {
+			c.Logger().WithField("selector", i).Debug("Using network from pod label selector.")
+			return c.SelectNamed(s.Selectors[i].Network, args)
+		}
+	}
+
+	return nil, nil
+}
+
+// Return the selectors for the configured modes, in order.  Selectors
+// for modes this package does not implement, such as ModeCRD, are
+// taken from custom; modes without one are skipped.
+func (c *Config) Selectors(custom map[string]Selector) []Selector {
+	strategies := c.Strategies
+	if strategies == nil {
+		strategies = &Strategies{}
+	}
+
+	modes := strategies.Mode
+	if len(modes) == 0 {
+		modes = DefaultModes
+	}
+
+	var selectors []Selector
+	for _, mode := range modes {
+		switch mode {
+		case ModeNamespaceMap:
+			selectors = append(selectors, ByNamespaceMap{})
+		case ModeAnnotation:
+			key := strategies.Annotation
+			if key == "" {
+				key = DefaultNetworkAnnotation
+			}
+			selectors = append(selectors, ByAnnotation{Key: key})
+		case ModeLabelSelector:
+			selectors = append(selectors, ByLabelSelector{Selectors: strategies.LabelSelectors})
+		case ModeRules:
+			if len(c.Rules) == 0 {
+				continue
+			}
+			if s, ok := custom[mode]; ok {
+				selectors = append(selectors, s)
+			} else {
+				selectors = append(selectors, ByRules{Rules: c.Rules})
+			}
+		default:
+			if s, ok := custom[mode]; ok {
+				selectors = append(selectors, s)
+			}
+		}
+	}
+
+	return selectors
+}
+
+// Select the network config for the pod named in args with the given
+// selectors, trying them in order.  Pods in system namespaces always
+// get the system network, whatever the selectors.
+func (c *Config) SelectWith(selectors []Selector, args string, pod PodMetadata) (*Selection, error) {
+	extraArgs := ParseExtraArgs(args)
+	namespace := extraArgs["K8S_POD_NAMESPACE"]
+
+	for _, ns := range c.SystemNamespaces {
+		if ns == namespace {
+			return c.Select(args)
+		}
+	}
+
+	for _, s := range selectors {
+		sel, err := s.Select(c, args, pod)
+		if err == nil && sel != nil {
+			sel, err = c.checkDeprecation(sel, extraArgs)
+		}
+		if err == nil && sel != nil {
+			err = c.checkTier(sel)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if sel != nil {
+			return sel, nil
+		}
+	}
+
+	if namespace == "" {
+		return c.Select(args)
+	}
+
+	return nil, newError(CodeNamespaceNotConfigured,
+		"No network config selected for pod %q in namespace %q.", extraArgs["K8S_POD_NAME"], namespace)
+}
+
+// Return the rule and config of the network config with the given
+// name: the default config, or else the first namespace config of that
+// name in namespace order, merged with the default if mergeWithDefault
+// is set.  The config is nil if there is none.
+func (c *Config) namedConfig(name string) (string, map[string]interface{}) {
+	if len(c.Default) > 0 && c.Default["name"] == name {
+		return DefaultRule, c.Default
+	}
+
+	var rules []string
+	for rule := range c.Namespaces {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+
+	for _, rule := range rules {
+		if cfg := c.Namespaces[rule]; cfg["name"] == name {
+			if c.MergeWithDefault {
+				cfg = DeepMerge(c.Default, cfg)
+			}
+			return rule, cfg
+		}
+	}
+
+	return "", nil
+}
+
+// Select the network config with the given name for the pod named in
+// args: the default config or a namespace config, merged with the
+// default if mergeWithDefault is set.
+func (c *Config) SelectNamed(name, args string) (*Selection, error) {
+	if c.namespacesErr != nil {
+		return nil, c.namespacesErr
+	}
+
+	extraArgs := ParseExtraArgs(args)
+	sel := &Selection{
+		Namespace: extraArgs["K8S_POD_NAMESPACE"],
+		Pod:       extraArgs["K8S_POD_NAME"],
+	}
+
+	sel.Rule, sel.NetConf = c.namedConfig(name)
+	if sel.NetConf == nil {
+		return nil, fmt.Errorf("Network %q not found.", name)
+	}
+
+	c.Logger().WithFields(logrus.Fields{
+		"namespace": sel.Namespace,
+		"pod":       sel.Pod,
+		"network":   name,
+	}).Debug("Using named network config.")
+
+	sel, err := c.transform(sel, extraArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.checkDelegateType(sel); err != nil {
+		return nil, err
+	}
+
+	return sel, nil
+}
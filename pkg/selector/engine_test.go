@@ -0,0 +1,114 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const engineConfig = `{
+  "systemNamespaces": ["kube-system"],
+  "systemNetwork": {"name": "host", "type": "bridge"},
+  "namespaces": {
+    "web": {"name": "web", "type": "bridge"},
+    "fast": {"name": "fast", "type": "ipvlan"}
+  },
+  "selection": {
+    "mode": ["annotation", "labelSelector", "namespaceMap"],
+    "labelSelectors": [
+      {"matchLabels": {"tier": "fast"}, "network": "fast"}
+    ]
+  }
+}`
+
+// Return pod metadata with the given labels and annotations.
+func podWith(labels, annotations map[string]string) PodMetadata {
+	return func() (map[string]string, map[string]string, error) {
+		return labels, annotations, nil
+	}
+}
+
+// Accept a single mode or a list, and only known modes.
+func TestModes(t *testing.T) {
+	c, err := Parse([]byte(`{"selection": {"mode": "annotation"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, Modes{ModeAnnotation}, c.Strategies.Mode)
+
+	for _, config := range []string{
+		`{"selection": {"mode": "random"}}`,
+		`{"selection": {"mode": 1}}`,
+		`{"selection": {"labelSelectors": [{"matchLabels": {"a": "b"}}]}}`,
+		`{"selection": {"labelSelectors": [{"network": "web"}]}}`,
+	} {
+		_, err := Parse([]byte(config))
+		assert.Error(t, err, config)
+	}
+}
+
+// Try the strategies in order, falling through to the namespace map.
+func TestSelectWith(t *testing.T) {
+	c, err := Parse([]byte(engineConfig))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	selectors := c.Selectors(nil)
+	args := "K8S_POD_NAMESPACE=web;K8S_POD_NAME=web-1"
+
+	sel, err := c.SelectWith(selectors, args, podWith(nil, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "web", sel.Rule)
+
+	sel, err = c.SelectWith(selectors, args, podWith(map[string]string{"tier": "fast"}, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "fast", sel.Rule)
+	assert.Equal(t, "web", sel.Namespace)
+
+	// The annotation comes first.
+	sel, err = c.SelectWith(selectors, args, podWith(map[string]string{"tier": "fast"},
+		map[string]string{DefaultNetworkAnnotation: "web"}))
+	assert.NoError(t, err)
+	assert.Equal(t, "web", sel.Rule)
+
+	_, err = c.SelectWith(selectors, args, podWith(nil, map[string]string{DefaultNetworkAnnotation: "missing"}))
+	assert.Error(t, err)
+
+	_, err = c.SelectWith(selectors, "K8S_POD_NAMESPACE=other", podWith(nil, nil))
+	assert.True(t, IsNamespaceNotConfigured(err))
+
+	// System namespaces bypass the strategies, even if the pod
+	// cannot be looked up.
+	failing := func() (map[string]string, map[string]string, error) {
+		return nil, nil, errors.New("API server down")
+	}
+	sel, err = c.SelectWith(selectors, "K8S_POD_NAMESPACE=kube-system", failing)
+	assert.NoError(t, err)
+	assert.Equal(t, SystemRule, sel.Rule)
+
+	_, err = c.SelectWith(selectors, args, failing)
+	assert.Error(t, err)
+}
+
+// Skip modes without a selector, and default to the namespace map.
+func TestSelectors(t *testing.T) {
+	c, err := Parse([]byte(`{}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []Selector{ByNamespaceMap{}}, c.Selectors(nil))
+
+	custom := ByAnnotation{Key: "custom"}
+	assert.Equal(t, []Selector{custom, ByNamespaceMap{}}, c.Selectors(map[string]Selector{ModeCRD: custom}))
+}
@@ -0,0 +1,132 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"fmt"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// Error codes returned in the CNI error result.  Codes below 100 are
+// reserved by the CNI spec, and skel uses 100 for untyped errors.
+const (
+	// K8S_POD_NAMESPACE was not passed.  Fatal.
+	CodeMissingNamespace uint = 101 + iota
+	// No config for the namespace, and no default.  Fatal until the
+	// config is changed.
+	CodeNamespaceNotConfigured
+	// The delegate plugin is not in CNI_PATH.  Fatal until the plugin
+	// is installed.
+	CodeDelegateNotFound
+	// The delegate plugin failed.  May be retried.
+	CodeDelegateFailed
+	// The delegate could not be run in time.  May be retried.
+	CodeDelegateTimeout
+	// The namespace has no room for another attachment.  May be
+	// retried once other pods are gone.
+	CodeQuotaExceeded
+	// The namespace is frozen by its config.  Fatal until the config
+	// is changed.
+	CodeNamespaceFrozen
+	// The pod is not allowed to use the network config selected for
+	// it.  Fatal until the pod or the config is changed.
+	CodePodNotPermitted
+	// The selected delegate type is not in allowedDelegateTypes.
+	// Fatal until the config is changed.
+	CodeDelegateNotAllowed
+	// The selected config's tier is above the one allowed in the
+	// pod's namespace.  Fatal until the pod or the config is changed.
+	CodeTierNotAllowed
+	// The SR-IOV pool of the selected config has no free VFs.  May be
+	// retried once other pods are gone.
+	CodeVFPoolExhausted
+	// The selected config's readinessFile does not exist yet.  May be
+	// retried once the underlay is up.
+	CodeNetworkNotReady
+	// No host device allowed for the namespace is free.  May be
+	// retried once other pods are gone.
+	CodeDeviceUnavailable
+	// The interface name the runtime asked for is not allowed by the
+	// selected config.  Fatal until the runtime or the config is
+	// changed.
+	CodeIfNameNotAllowed
+)
+
+// Sentinel errors, one per code.  Returned errors carry their own
+// messages, so compare them with Is rather than ==.
+var (
+	ErrMissingNamespace       = &types.Error{Code: CodeMissingNamespace, Msg: "Kubernetes namespace argument missing."}
+	ErrNamespaceNotConfigured = &types.Error{Code: CodeNamespaceNotConfigured, Msg: "No network config for namespace."}
+	ErrDelegateNotFound       = &types.Error{Code: CodeDelegateNotFound, Msg: "Delegate plugin not found."}
+	ErrDelegateFailed         = &types.Error{Code: CodeDelegateFailed, Msg: "Delegate plugin failed."}
+	ErrDelegateTimeout        = &types.Error{Code: CodeDelegateTimeout, Msg: "Timed out running delegate plugin."}
+	ErrQuotaExceeded          = &types.Error{Code: CodeQuotaExceeded, Msg: "Attachment quota exceeded."}
+	ErrNamespaceFrozen        = &types.Error{Code: CodeNamespaceFrozen, Msg: "Namespace is frozen."}
+	ErrPodNotPermitted        = &types.Error{Code: CodePodNotPermitted, Msg: "Pod not permitted to use network."}
+	ErrDelegateNotAllowed     = &types.Error{Code: CodeDelegateNotAllowed, Msg: "Delegate type not allowed."}
+	ErrTierNotAllowed         = &types.Error{Code: CodeTierNotAllowed, Msg: "Network tier not allowed in namespace."}
+	ErrVFPoolExhausted        = &types.Error{Code: CodeVFPoolExhausted, Msg: "No VFs left in SR-IOV pool."}
+	ErrNetworkNotReady        = &types.Error{Code: CodeNetworkNotReady, Msg: "Network not ready."}
+	ErrDeviceUnavailable      = &types.Error{Code: CodeDeviceUnavailable, Msg: "No free host device."}
+	ErrIfNameNotAllowed       = &types.Error{Code: CodeIfNameNotAllowed, Msg: "Interface name not allowed."}
+)
+
+// Return whether err is a CNI error with the same code as target.
+func Is(err error, target *types.Error) bool {
+	e, ok := err.(*types.Error)
+	return ok && target != nil && e.Code == target.Code
+}
+
+// Return whether err means the pod's namespace is not configured.
+func IsNamespaceNotConfigured(err error) bool {
+	return Is(err, ErrNamespaceNotConfigured)
+}
+
+// Return whether err means the delegate could not be run in time.
+func IsDelegateTimeout(err error) bool {
+	return Is(err, ErrDelegateTimeout)
+}
+
+// Return whether err means an attachment quota was exceeded.
+func IsQuotaExceeded(err error) bool {
+	return Is(err, ErrQuotaExceeded)
+}
+
+// Return whether err means the namespace is frozen.
+func IsNamespaceFrozen(err error) bool {
+	return Is(err, ErrNamespaceFrozen)
+}
+
+// Return whether err means the pod may not use the selected network.
+func IsPodNotPermitted(err error) bool {
+	return Is(err, ErrPodNotPermitted)
+}
+
+// Return whether err is worth retrying, as opposed to needing a
+// config change or an installed plugin.
+func IsTemporary(err error) bool {
+	return Is(err, ErrDelegateFailed) || Is(err, ErrDelegateTimeout) || Is(err, ErrQuotaExceeded) ||
+		Is(err, ErrVFPoolExhausted) || Is(err, ErrNetworkNotReady) ||
+		Is(err, ErrDeviceUnavailable)
+}
+
+// Return a CNI error with the given code.
+func newError(code uint, format string, args ...interface{}) *types.Error {
+	return &types.Error{
+		Code: code,
+		Msg:  fmt.Sprintf(format, args...),
+	}
+}
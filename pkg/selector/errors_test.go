@@ -0,0 +1,41 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// Match errors by code, whatever their message.
+func TestIs(t *testing.T) {
+	err := &types.Error{Code: CodeNamespaceNotConfigured, Msg: "No network config found for namespace \"foo\"."}
+
+	assert.True(t, IsNamespaceNotConfigured(err))
+	assert.False(t, IsQuotaExceeded(err))
+	assert.False(t, IsTemporary(err))
+	assert.False(t, IsNamespaceNotConfigured(errors.New("No network config.")))
+	assert.False(t, IsNamespaceNotConfigured(nil))
+
+	assert.True(t, IsTemporary(&types.Error{Code: CodeDelegateTimeout}))
+	assert.True(t, IsTemporary(&types.Error{Code: CodeVFPoolExhausted}))
+	assert.True(t, IsTemporary(&types.Error{Code: CodeNetworkNotReady}))
+	assert.True(t, IsTemporary(&types.Error{Code: CodeDeviceUnavailable}))
+	assert.True(t, IsPodNotPermitted(&types.Error{Code: CodePodNotPermitted}))
+	assert.False(t, IsTemporary(&types.Error{Code: CodeIfNameNotAllowed}))
+}
@@ -0,0 +1,126 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// The runtime parses the plugin config itself before handing it to
+// kube-namespace, so that must be JSON, and parse errors point out
+// YAML and TOML passed there.  The files kube-namespace reads on its
+// own, namespacesDir entries and configFiles, may also be YAML or TOML,
+// named by a "configFormat" hint or recognized by extension or content.
+
+var (
+	yamlLineRegexp = regexp.MustCompile(`^(---|- |["']?[\w.-]+["']?\s*:(\s|$))`)
+	tomlLineRegexp = regexp.MustCompile(`^(\[[\w."-]+\]|[\w.-]+\s*=)`)
+)
+
+// Return the format data looks like, "YAML" or "TOML", going by its
+// first significant line, or "" if it might be JSON.
+func guessFormat(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] == '{' {
+		return ""
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		switch {
+		case tomlLineRegexp.MatchString(line):
+			return "TOML"
+		case yamlLineRegexp.MatchString(line):
+			return "YAML"
+		}
+		return ""
+	}
+
+	return ""
+}
+
+// The formats a "configFormat" hint may name, and the names used in
+// messages.
+var configFormats = map[string]string{"json": "", "yaml": "YAML", "toml": "TOML"}
+
+// Check a "configFormat" hint: "json", "yaml", "toml", or "" to go by
+// the file.
+func CheckFormat(format string) error {
+	if _, ok := configFormats[format]; format != "" && !ok {
+		return fmt.Errorf("Unknown configFormat %q; use json, yaml or toml.", format)
+	}
+
+	return nil
+}
+
+// Return data, read from the file at path, as JSON.  The format is
+// taken from the configFormat hint if set, else from a .json, .yaml,
+// .yml or .toml extension, and otherwise guessed from the contents.
+func ToJSON(path, hint string, data []byte) ([]byte, error) {
+	if err := CheckFormat(hint); err != nil {
+		return nil, err
+	}
+
+	format := guessFormat(data)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		format = ""
+	case ".yaml", ".yml":
+		format = "YAML"
+	case ".toml":
+		format = "TOML"
+	}
+	if hint != "" {
+		format = configFormats[hint]
+	}
+
+	var value interface{}
+	var err error
+	switch format {
+	case "YAML":
+		value, err = decodeYAML(data)
+	case "TOML":
+		value, err = decodeTOML(data)
+	default:
+		return data, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %s as %s: %v", path, format, err)
+	}
+
+	return json.Marshal(value)
+}
+
+// Return the error for data that failed to parse as JSON with err,
+// prefixed with what, e.g. "config".  If data looks like YAML or TOML,
+// the error says so.
+func ParseError(what string, data []byte, err error) error {
+	if format := guessFormat(data); format != "" {
+		return fmt.Errorf("Failed to parse %s: it looks like %s, but must be JSON: %v", what, format, err)
+	}
+
+	return fmt.Errorf("Failed to parse %s: %v", what, err)
+}
@@ -0,0 +1,191 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Recognize YAML and TOML, so that errors can point them out.
+func TestGuessFormat(t *testing.T) {
+	for data, format := range map[string]string{
+		`{"name": "kube-namespace"}`:                 "",
+		"  \n[1, 2]":                                 "",
+		"name: kube-namespace\ntype: kube-namespace": "YAML",
+		"---\nname: kube-namespace":                  "YAML",
+		"# comment\n\nnamespaces:\n  a: {}":          "YAML",
+		"- a\n- b":                                   "YAML",
+		"name = \"kube-namespace\"":                  "TOML",
+		"[namespaces.tenant-a]\ntype = \"bridge\"":   "TOML",
+		"garbage": "",
+	} {
+		assert.Equal(t, format, guessFormat([]byte(data)), data)
+	}
+}
+
+// Say that a YAML config must be JSON.
+func TestParseYAMLConfig(t *testing.T) {
+	_, err := Parse([]byte("name: kube-namespace\ntype: kube-namespace\n"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "looks like YAML, but must be JSON")
+	}
+}
+
+// Decode YAML and TOML files to the JSON of the same config.
+func TestToJSON(t *testing.T) {
+	want := `{
+	  "name": "tenant-a",
+	  "type": "bridge",
+	  "cniVersion": "0.3.1",
+	  "mtu": 1450,
+	  "isDefaultGateway": true,
+	  "tags": ["a", "b: c"],
+	  "ipam": {
+	    "type": "host-local",
+	    "subnet": "10.20.0.0/16",
+	    "routes": [{"dst": "0.0.0.0/0"}, {"dst": "10.0.0.0/8", "gw": "10.20.0.1"}]
+	  }
+	}`
+
+	yaml := `---
+# tenant-a
+name: tenant-a
+type: "bridge"   # quoted
+cniVersion: '0.3.1'
+mtu: 1450
+isDefaultGateway: true
+tags: [a, 'b: c']
+ipam:
+  type: host-local
+  subnet: 10.20.0.0/16
+  routes:
+  - dst: 0.0.0.0/0
+  - {dst: 10.0.0.0/8, gw: 10.20.0.1}
+`
+	toml := `# tenant-a
+name = "tenant-a"
+type = 'bridge'
+cniVersion = "0.3.1"
+mtu = 1_450
+isDefaultGateway = true
+tags = [
+  "a", # first
+  "b: c",
+]
+
+[ipam]
+type = "host-local"
+subnet = "10.20.0.0/16"
+
+[[ipam.routes]]
+dst = "0.0.0.0/0"
+
+[[ipam.routes]]
+dst = "10.0.0.0/8"
+gw = "10.20.0.1"
+`
+	for path, data := range map[string]string{
+		"tenant-a.yaml": yaml,
+		"tenant-a.conf": yaml,
+		"tenant-a.toml": toml,
+	} {
+		got, err := ToJSON(path, "", []byte(data))
+		if assert.NoError(t, err, path) {
+			assert.JSONEq(t, want, string(got), path)
+		}
+	}
+
+	// JSON is passed through untouched.
+	got, err := ToJSON("tenant-a.conf", "", []byte(`{"name": "tenant-a"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name": "tenant-a"}`, string(got))
+}
+
+// Take the format from the configFormat hint over the file's name and
+// contents.
+func TestToJSONHint(t *testing.T) {
+	// A YAML flow mapping would pass for JSON.
+	got, err := ToJSON("tenant-a.conf", "yaml", []byte(`{name: tenant-a, type: bridge}`))
+	if assert.NoError(t, err) {
+		assert.JSONEq(t, `{"name": "tenant-a", "type": "bridge"}`, string(got))
+	}
+
+	got, err = ToJSON("tenant-a.yaml", "toml", []byte(`name = "tenant-a"`))
+	if assert.NoError(t, err) {
+		assert.JSONEq(t, `{"name": "tenant-a"}`, string(got))
+	}
+
+	got, err = ToJSON("tenant-a.yaml", "json", []byte(`{"name": "tenant-a"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name": "tenant-a"}`, string(got))
+
+	_, err = ToJSON("tenant-a.conf", "yml", []byte(`name: tenant-a`))
+	assert.Error(t, err)
+
+	_, err = Parse([]byte(`{"configFormat": "xml"}`))
+	assert.Error(t, err)
+}
+
+// Decode nested block sequences, including "- - item".
+func TestToJSONNestedSequences(t *testing.T) {
+	got, err := ToJSON("a.yaml", "", []byte(`routes:
+- - a: b
+    c: d
+  - e
+- - - f
+- g
+`))
+	if assert.NoError(t, err) {
+		assert.JSONEq(t, `{"routes": [[{"a": "b", "c": "d"}, "e"], [["f"]], "g"]}`, string(got))
+	}
+}
+
+// Reject what the decoders do not support, rather than misread it.
+func TestToJSONErrors(t *testing.T) {
+	for path, data := range map[string]string{
+		"a.yaml":  "base: &base {type: bridge}\nother: *base",
+		"b.yaml":  "script: |\n  echo hi",
+		"c.yaml":  "a: 1\n---\nb: 2",
+		"d.yaml":  "a: 1\na: 2",
+		"e.yaml":  "a: [1, 2",
+		"f.toml":  "a = 1\na = 2",
+		"g.toml":  "[a]\n[a]",
+		"h.toml":  "created = 2016-01-01",
+		"i.toml":  "a = \"\"\"\nmulti\n\"\"\"",
+		"j.toml":  "a = 010",
+		"k.yaml":  "a:\n\tb: 1",
+		"l.toml":  "a = [1, 2",
+		"m.toml":  "a b = 1",
+		"n.toml":  "a = 1 2",
+		"o.yaml":  "- a\nb: 1",
+		"p.toml":  "[[a]\nb = 1",
+		"q.yaml":  "a: 'unterminated",
+		"r.toml":  "a = 'unterminated",
+		"s.toml":  "a = 1\n[a.b]",
+		"t.yaml":  "a:\n  b: 1\n c: 2",
+		"u.toml":  "a = [1,\n2,\n",
+		"v.yaml":  "!!str a",
+		"w.toml":  "a = {b = 1",
+		"x.yaml":  "a: {b 1}",
+		"y.toml":  "a = truthy",
+		"z.yaml":  "a: 1\n...",
+		"aa.toml": "[a]b = 1",
+	} {
+		_, err := ToJSON(path, "", []byte(data))
+		assert.Error(t, err, path)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// ipvlan map modes.
+const (
+	IPvlanModeL3  = "l3"
+	IPvlanModeL3S = "l3s"
+)
+
+// The top-level "ipvlanMap" block: a subnet per namespace, from which
+// kube-namespace generates an ipvlan L3 or L3S delegate config.  The
+// gateway is the first address of the subnet, and pods get a default
+// route.  ipvlan L3 slaves cannot reach the master's addresses, so
+// kube-namespace also routes each pod address on the host via an
+// ipvlan slave of its own; see HostInterface.
+type IPvlanMap struct {
+	Mode       string            `json:"mode"`
+	Master     string            `json:"master"`
+	Namespaces map[string]string `json:"namespaces"`
+}
+
+// Validate the ipvlan map.
+func (m *IPvlanMap) validate() error {
+	if m.Mode != IPvlanModeL3 && m.Mode != IPvlanModeL3S {
+		return fmt.Errorf("Unknown ipvlanMap mode %q.", m.Mode)
+	}
+	if m.Master == "" {
+		return errors.New("ipvlanMap requires a master.")
+	}
+
+	var subnets []*net.IPNet
+	for namespace, cidr := range m.Namespaces {
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("Invalid subnet %q for namespace %q.", cidr, namespace)
+		}
+
+		for _, other := range subnets {
+			if other.Contains(subnet.IP) || subnet.Contains(other.IP) {
+				return fmt.Errorf("Subnet %q of namespace %q overlaps %q.", cidr, namespace, other)
+			}
+		}
+		subnets = append(subnets, subnet)
+	}
+
+	return nil
+}
+
+// Return the gateway of a subnet: its first address.
+func ipvlanGateway(subnet *net.IPNet) net.IP {
+	gw := make(net.IP, len(subnet.IP))
+	copy(gw, subnet.IP)
+	gw[len(gw)-1]++
+	return gw
+}
+
+// Return the name of the host's own ipvlan slave of the master, which
+// host routes to pods go through.
+func (m *IPvlanMap) HostInterface() string {
+	name := "ipvl-" + m.Master
+	if len(name) > 15 {
+		name = "knipvl"
+	}
+
+	return name
+}
+
+// Turn the selected config into an ipvlan config for the namespace's
+// subnet.  The config is copied, so the parsed config is left as-is.
+func (m *IPvlanMap) apply(log *logrus.Entry, sel *Selection) *Selection {
+	cidr, ok := m.Namespaces[sel.Namespace]
+	if !ok {
+		return sel
+	}
+	_, subnet, _ := net.ParseCIDR(cidr)
+
+	netconf := make(map[string]interface{}, len(sel.NetConf)+3)
+	for k, v := range sel.NetConf {
+		netconf[k] = v
+	}
+
+	defaultRoute := "0.0.0.0/0"
+	if subnet.IP.To4() == nil {
+		defaultRoute = "::/0"
+	}
+
+	netconf["type"] = "ipvlan"
+	netconf["master"] = m.Master
+	netconf["mode"] = m.Mode
+	netconf["ipam"] = map[string]interface{}{
+		"type":    "host-local",
+		"subnet":  subnet.String(),
+		"gateway": ipvlanGateway(subnet).String(),
+		"routes":  []interface{}{map[string]interface{}{"dst": defaultRoute}},
+	}
+
+	log.WithFields(logrus.Fields{
+		"subnet": subnet,
+		"mode":   m.Mode,
+	}).Debug("Using namespace ipvlan subnet.")
+
+	ipvlanSel := *sel
+	ipvlanSel.NetConf = netconf
+	return &ipvlanSel
+}
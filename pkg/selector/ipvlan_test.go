@@ -0,0 +1,62 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Generate ipvlan L3S configs from the ipvlan map.
+func TestIPvlanMap(t *testing.T) {
+	config, err := Parse([]byte(`{
+	  "ipvlanMap": {"mode": "l3s", "master": "eth0", "namespaces": {"tenant-a": "10.4.0.0/24"}},
+	  "default": {"name": "default", "type": "bridge", "ipam": {"type": "dhcp"}}
+	}`))
+	assert.NoError(t, err)
+
+	sel, err := config.Select("K8S_POD_NAMESPACE=tenant-a")
+	assert.NoError(t, err)
+	assert.Equal(t, "ipvlan", sel.NetConf["type"])
+	assert.Equal(t, "eth0", sel.NetConf["master"])
+	assert.Equal(t, "l3s", sel.NetConf["mode"])
+	assert.Equal(t, map[string]interface{}{
+		"type":    "host-local",
+		"subnet":  "10.4.0.0/24",
+		"gateway": "10.4.0.1",
+		"routes":  []interface{}{map[string]interface{}{"dst": "0.0.0.0/0"}},
+	}, sel.NetConf["ipam"])
+	assert.Equal(t, "default", sel.NetConf["name"])
+
+	sel, err = config.Select("K8S_POD_NAMESPACE=other")
+	assert.NoError(t, err)
+	assert.Equal(t, "bridge", sel.NetConf["type"])
+
+	assert.Equal(t, "ipvl-eth0", config.IPvlanMap.HostInterface())
+}
+
+// Reject bad ipvlan maps.
+func TestIPvlanMapValidate(t *testing.T) {
+	assert.Error(t, (&IPvlanMap{Mode: "l2", Master: "eth0"}).validate())
+	assert.Error(t, (&IPvlanMap{Mode: IPvlanModeL3}).validate())
+	assert.Error(t, (&IPvlanMap{Mode: IPvlanModeL3, Master: "eth0", Namespaces: map[string]string{"a": "10.4.0.0/16", "b": "10.4.1.0/24"}}).validate())
+
+	_, err := Parse([]byte(`{
+	  "vlanMap": {"mode": "bridge", "namespaces": {"a": 100}},
+	  "ipvlanMap": {"mode": "l3", "master": "eth0", "namespaces": {"a": "10.4.0.0/24"}}
+	}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,49 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Merge nested objects and replace everything else.
+func TestDeepMerge(t *testing.T) {
+	base := map[string]interface{}{
+		"type": "bridge",
+		"ipam": map[string]interface{}{
+			"type":   "host-local",
+			"subnet": "10.1.0.0/16",
+			"routes": []interface{}{"a"},
+		},
+	}
+	override := map[string]interface{}{
+		"ipam": map[string]interface{}{
+			"subnet": "10.2.0.0/16",
+			"routes": []interface{}{"b"},
+		},
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"type": "bridge",
+		"ipam": map[string]interface{}{
+			"type":   "host-local",
+			"subnet": "10.2.0.0/16",
+			"routes": []interface{}{"b"},
+		},
+	}, DeepMerge(base, override))
+	assert.Equal(t, "10.1.0.0/16", base["ipam"].(map[string]interface{})["subnet"])
+}
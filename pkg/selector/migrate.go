@@ -0,0 +1,220 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// The newest config schema version this package understands.
+//
+// Version 1, the default, is the layout Config decodes: a
+// "namespaces" map and a "default" config.  Version 2 selects configs
+// with an ordered list of "selectors" naming the namespaces, "*" for
+// the default, and the profile or chain of profiles each gets:
+//
+//	{
+//	  "configVersion": 2,
+//	  "profiles": {"base": {...}, "bridge": {...}, "tenant": {...}},
+//	  "chains": {"tenant-bridge": ["base", "bridge", "tenant"]},
+//	  "selectors": [
+//	    {"namespaces": ["tenant-a", "tenant-b"], "chain": "tenant-bridge"},
+//	    {"namespaces": ["*"], "profile": "bridge", "config": {"mtu": 1400}}
+//	  ]
+//	}
+//
+// A chain is merged left to right, as if each profile extended the one
+// before it.  Parse converts version 2 configs to version 1 with
+// Migrate, so node configs can move to it one at a time, after the
+// plugin binary is upgraded.
+const ConfigVersion = 2
+
+// Keys of version 1 replaced in version 2.
+var v1OnlyKeys = map[string]string{
+	"namespaces":     "selectors",
+	"default":        `a selector for namespace "*"`,
+	"nonK8sBehavior": "fallbackWhenNoNamespace",
+}
+
+// Keys of version 1 that are deprecated, and what replaces them.
+var deprecatedKeys = map[string]string{
+	"nonK8sBehavior": "fallbackWhenNoNamespace",
+}
+
+// A version 2 selector.
+type configSelector struct {
+	Namespaces []string               `json:"namespaces"`
+	Profile    string                 `json:"profile"`
+	Chain      string                 `json:"chain"`
+	Config     map[string]interface{} `json:"config"`
+}
+
+// Return the name of the profile made for link i of a chain.
+func chainProfile(chain string, i int) string {
+	return fmt.Sprintf("chain:%s:%d", chain, i)
+}
+
+// Convert a plugin config of any supported schema version, given by
+// its "configVersion", to version 1.  Deprecated keys are logged.
+func Migrate(data []byte) ([]byte, error) {
+	conf := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, ParseError("config", data, err)
+	}
+
+	version := 1
+	if raw, ok := conf["configVersion"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return nil, fmt.Errorf("Invalid configVersion %s.", raw)
+		}
+	}
+
+	switch {
+	case version < 1:
+		return nil, fmt.Errorf("Invalid configVersion %d.", version)
+	case version > ConfigVersion:
+		return nil, fmt.Errorf("Config version %d is newer than this plugin understands (%d); upgrade the plugin.",
+			version, ConfigVersion)
+	case version == 1:
+		for key, replacement := range deprecatedKeys {
+			if _, ok := conf[key]; ok {
+				Log.WithField("key", key).Warnf("%s is deprecated; use %s.", key, replacement)
+			}
+		}
+		return data, nil
+	}
+
+	if err := migrateV2(conf); err != nil {
+		return nil, fmt.Errorf("Config version 2: %v", err)
+	}
+
+	Log.Debug("Converted config version 2 to version 1.")
+	return json.Marshal(conf)
+}
+
+// Convert a version 2 config to version 1 in place.
+func migrateV2(conf map[string]json.RawMessage) error {
+	var keys []string
+	for key := range v1OnlyKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, ok := conf[key]; ok {
+			return fmt.Errorf("%s is replaced by %s.", key, v1OnlyKeys[key])
+		}
+	}
+
+	profiles := map[string]map[string]interface{}{}
+	chains := map[string][]string{}
+	var selectors []configSelector
+	for key, v := range map[string]interface{}{"profiles": &profiles, "chains": &chains, "selectors": &selectors} {
+		if raw, ok := conf[key]; ok {
+			if err := json.Unmarshal(raw, v); err != nil {
+				return fmt.Errorf("Invalid %s: %v", key, err)
+			}
+		}
+	}
+
+	// The profile each chain ends in.
+	chainEnds := map[string]string{}
+	for name, chain := range chains {
+		if len(chain) == 0 {
+			return fmt.Errorf("Chain %q is empty.", name)
+		}
+
+		end := chain[0]
+		if _, ok := profiles[end]; !ok {
+			return fmt.Errorf("Chain %q: profile %q not found.", name, end)
+		}
+		for i, profile := range chain[1:] {
+			netconf, ok := profiles[profile]
+			if !ok {
+				return fmt.Errorf("Chain %q: profile %q not found.", name, profile)
+			}
+			if _, ok := netconf["extends"]; ok {
+				return fmt.Errorf("Chain %q: profile %q extends another, so it can only start a chain.", name, profile)
+			}
+
+			link := chainProfile(name, i+1)
+			if _, ok := profiles[link]; ok {
+				return fmt.Errorf("Profile %q clashes with chain %q.", link, name)
+			}
+			profiles[link] = DeepMerge(netconf, map[string]interface{}{"extends": end})
+			end = link
+		}
+		chainEnds[name] = end
+	}
+
+	namespaces := map[string]map[string]interface{}{}
+	var def map[string]interface{}
+	for i, s := range selectors {
+		base := s.Profile
+		if s.Chain != "" {
+			if base != "" {
+				return fmt.Errorf("Selector %d has both a profile and a chain.", i)
+			}
+			if base = chainEnds[s.Chain]; base == "" {
+				return fmt.Errorf("Selector %d: chain %q not found.", i, s.Chain)
+			}
+		}
+		if len(s.Namespaces) == 0 {
+			return fmt.Errorf("Selector %d names no namespaces.", i)
+		}
+
+		netconf := map[string]interface{}{}
+		for k, v := range s.Config {
+			netconf[k] = v
+		}
+		if base != "" {
+			netconf["extends"] = base
+		}
+
+		for _, ns := range s.Namespaces {
+			if ns == "*" {
+				if def != nil {
+					return fmt.Errorf("Selector %d: the default is already selected.", i)
+				}
+				def = netconf
+				continue
+			}
+			if _, ok := namespaces[ns]; ok {
+				return fmt.Errorf("Selector %d: namespace %q is already selected.", i, ns)
+			}
+			namespaces[ns] = netconf
+		}
+	}
+
+	converted := map[string]interface{}{"namespaces": namespaces}
+	if len(profiles) > 0 {
+		converted["profiles"] = profiles
+	}
+	if def != nil {
+		converted["default"] = def
+	}
+	for key, v := range converted {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		conf[key] = raw
+	}
+	delete(conf, "chains")
+	delete(conf, "selectors")
+
+	return nil
+}
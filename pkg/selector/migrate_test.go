@@ -0,0 +1,92 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Select the same configs from a version 2 config as from version 1.
+func TestMigrateV2(t *testing.T) {
+	config, err := Parse([]byte(`{
+	  "configVersion": 2,
+	  "profiles": {
+	    "base": {"type": "bridge", "ipam": {"type": "host-local"}},
+	    "jumbo": {"mtu": 9000},
+	    "tenant": {"isGateway": true}
+	  },
+	  "chains": {"tenant-jumbo": ["base", "jumbo", "tenant"]},
+	  "selectors": [
+	    {"namespaces": ["tenant-a", "tenant-b"], "chain": "tenant-jumbo", "config": {"name": "tenants"}},
+	    {"namespaces": ["*"], "profile": "base", "config": {"name": "default"}}
+	  ]
+	}`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	sel, err := config.Select("K8S_POD_NAMESPACE=tenant-b")
+	assert.NoError(t, err)
+	assert.Equal(t, "tenant-b", sel.Rule)
+	assert.Equal(t, map[string]interface{}{
+		"name":      "tenants",
+		"type":      "bridge",
+		"mtu":       float64(9000),
+		"isGateway": true,
+		"ipam":      map[string]interface{}{"type": "host-local"},
+	}, sel.NetConf)
+
+	sel, err = config.Select("K8S_POD_NAMESPACE=other")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultRule, sel.Rule)
+	assert.Equal(t, map[string]interface{}{
+		"name": "default",
+		"type": "bridge",
+		"ipam": map[string]interface{}{"type": "host-local"},
+	}, sel.NetConf)
+}
+
+// Leave version 1 configs alone, and refuse unknown versions and
+// version 1 keys in version 2.
+func TestMigrateVersions(t *testing.T) {
+	v1 := []byte(`{"namespaces": {"a": {"type": "bridge"}}}`)
+	data, err := Migrate(v1)
+	assert.NoError(t, err)
+	assert.Equal(t, v1, data)
+
+	_, err = Migrate([]byte(`{"configVersion": 3}`))
+	assert.EqualError(t, err, "Config version 3 is newer than this plugin understands (2); upgrade the plugin.")
+
+	_, err = Migrate([]byte(`{"configVersion": 2, "namespaces": {}}`))
+	assert.EqualError(t, err, "Config version 2: namespaces is replaced by selectors.")
+}
+
+// Reject version 2 configs that do not say what to select.
+func TestMigrateV2Errors(t *testing.T) {
+	for conf, msg := range map[string]string{
+		`{"selectors": [{"namespaces": ["a"], "profile": "p", "chain": "c"}]}`:        "Selector 0 has both a profile and a chain.",
+		`{"selectors": [{"namespaces": ["a"], "chain": "c"}]}`:                        `Selector 0: chain "c" not found.`,
+		`{"selectors": [{"profile": "p"}]}`:                                           "Selector 0 names no namespaces.",
+		`{"selectors": [{"namespaces": ["a"]}, {"namespaces": ["a"]}]}`:               `Selector 1: namespace "a" is already selected.`,
+		`{"chains": {"c": []}}`:                                                       `Chain "c" is empty.`,
+		`{"profiles": {"p": {}, "q": {"extends": "p"}}, "chains": {"c": ["p", "q"]}}`: `Chain "c": profile "q" extends another, so it can only start a chain.`,
+		`{"profiles": {"p": {}}, "chains": {"c": ["p", "missing"]}}`:                  `Chain "c": profile "missing" not found.`,
+	} {
+		_, err := Migrate([]byte(`{"configVersion": 2, ` + conf[1:]))
+		assert.EqualError(t, err, "Config version 2: "+msg, conf)
+	}
+}
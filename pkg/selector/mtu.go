@@ -0,0 +1,65 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"fmt"
+)
+
+// Delegate types whose config takes an "mtu" field.
+var mtuDelegates = map[string]bool{
+	"bridge":  true,
+	"ipvlan":  true,
+	"macvlan": true,
+	"ptp":     true,
+	"vlan":    true,
+}
+
+// Validate the mtuOverrides map.
+func (c *Config) validateMTUOverrides() error {
+	for namespace, mtu := range c.MTUOverrides {
+		if mtu < 68 || mtu > 65535 {
+			return fmt.Errorf("Invalid mtuOverrides value %d for namespace %q.", mtu, namespace)
+		}
+	}
+
+	return nil
+}
+
+// Set the "mtu" field of the selected config if the namespace has an
+// override.  The config is copied, so the parsed config is left as-is.
+func (c *Config) overrideMTU(sel *Selection) (*Selection, error) {
+	mtu, ok := c.MTUOverrides[sel.Namespace]
+	if !ok {
+		return sel, nil
+	}
+
+	delegateType, _ := sel.NetConf["type"].(string)
+	if !mtuDelegates[delegateType] {
+		return nil, fmt.Errorf("mtuOverrides set for namespace %q, but its delegate %q takes no MTU.", sel.Namespace, delegateType)
+	}
+
+	netconf := make(map[string]interface{}, len(sel.NetConf)+1)
+	for k, v := range sel.NetConf {
+		netconf[k] = v
+	}
+	netconf["mtu"] = mtu
+
+	c.Logger().WithField("mtu", mtu).Debug("Overriding MTU.")
+
+	overridden := *sel
+	overridden.NetConf = netconf
+	return &overridden, nil
+}
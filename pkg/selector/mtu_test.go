@@ -0,0 +1,52 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Override the MTU of the selected config, by namespace.
+func TestMTUOverrides(t *testing.T) {
+	config, err := Parse([]byte(`{
+	  "mtuOverrides": {"overlay": 1410, "legacy": 1400},
+	  "default": {"name": "default", "type": "bridge", "mtu": 1500},
+	  "namespaces": {
+	    "legacy": {"name": "legacy", "type": "host-device"}
+	  }
+	}`))
+	assert.NoError(t, err)
+
+	sel, err := config.Select("K8S_POD_NAMESPACE=overlay")
+	assert.NoError(t, err)
+	assert.Equal(t, 1410, sel.NetConf["mtu"])
+	assert.Equal(t, 1500.0, config.Default["mtu"])
+
+	sel, err = config.Select("K8S_POD_NAMESPACE=other")
+	assert.NoError(t, err)
+	assert.Equal(t, 1500.0, sel.NetConf["mtu"])
+
+	_, err = config.Select("K8S_POD_NAMESPACE=legacy")
+	assert.Error(t, err)
+}
+
+// Reject impossible MTUs.
+func TestMTUOverridesInvalid(t *testing.T) {
+	_, err := Parse([]byte(`{"mtuOverrides": {"overlay": 10}}`))
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,91 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The suffixes of namespace config files.  .conf files may hold JSON,
+// YAML or TOML; the others only the format they name.
+var namespaceFileSuffixes = []string{".conf", ".yaml", ".yml", ".toml"}
+
+const defaultNamespaceFile = "default"
+
+// Return the namespace the file name configures, if it is a namespace
+// config file.
+func namespaceFileName(name string) (string, bool) {
+	for _, suffix := range namespaceFileSuffixes {
+		if strings.HasSuffix(name, suffix) && len(name) > len(suffix) {
+			return strings.TrimSuffix(name, suffix), true
+		}
+	}
+
+	return "", false
+}
+
+// Load network configs from a directory of <namespace>.conf files,
+// plus an optional default.conf.  Hidden files are skipped, so tools
+// that write a temporary file and rename it into place never expose a
+// partial config.  Files that vanish while being read are skipped too.
+// format is the configFormat hint.
+func loadNamespacesDir(dir, format string) (entries []namespaceEntry, defaultEntry *namespaceEntry, err error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to read namespaces directory: %v", err)
+	}
+
+	for _, f := range files {
+		name := f.Name()
+		namespace, ok := namespaceFileName(name)
+		if f.IsDir() || strings.HasPrefix(name, ".") || !ok {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("Failed to read %s: %v", path, err)
+		}
+
+		entry := namespaceEntry{
+			namespace: namespace,
+			source:    path,
+		}
+		jsonData, err := ToJSON(path, format, data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := json.Unmarshal(jsonData, &entry.netconf); err != nil {
+			return nil, nil, ParseError(path, data, err)
+		}
+
+		if namespace == defaultNamespaceFile {
+			entry.namespace = DefaultRule
+			defaultEntry = &entry
+		} else {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, defaultEntry, nil
+}
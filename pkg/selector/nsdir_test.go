@@ -0,0 +1,97 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeNamespacesDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "kube-namespace")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	return dir
+}
+
+// Load namespace and default configs, skipping other files.
+func TestLoadNamespacesDir(t *testing.T) {
+	dir := writeNamespacesDir(t, map[string]string{
+		"isolated.conf":      `{"name": "isolated", "type": "bridge"}`,
+		"default.conf":       `{"name": "default-bridge", "type": "bridge"}`,
+		".isolated.conf.tmp": `{"name": "partial`,
+		"README":             "not a config",
+	})
+	defer os.RemoveAll(dir)
+
+	entries, defaultEntry, err := loadNamespacesDir(dir, "")
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "isolated", entries[0].namespace)
+	assert.Equal(t, "default-bridge", defaultEntry.netconf["name"])
+}
+
+// Load YAML and TOML namespace configs.
+func TestLoadNamespacesDirFormats(t *testing.T) {
+	dir := writeNamespacesDir(t, map[string]string{
+		"yaml-ns.yaml": "name: yaml-ns\ntype: bridge\n",
+		"yml-ns.yml":   "name: yml-ns\ntype: bridge\n",
+		"toml-ns.toml": "name = \"toml-ns\"\ntype = \"bridge\"\n",
+		"default.conf": "name: default-bridge\ntype: bridge\n",
+	})
+	defer os.RemoveAll(dir)
+
+	entries, defaultEntry, err := loadNamespacesDir(dir, "")
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+	for _, entry := range entries {
+		assert.Equal(t, entry.namespace, entry.netconf["name"])
+		assert.Equal(t, "bridge", entry.netconf["type"])
+	}
+	assert.Equal(t, "default-bridge", defaultEntry.netconf["name"])
+}
+
+// Combine the directory with the inline config.
+func TestParseConfigNamespacesDir(t *testing.T) {
+	dir := writeNamespacesDir(t, map[string]string{
+		"other.conf":   `{"name": "other", "type": "ptp"}`,
+		"default.conf": `{"name": "default-bridge", "type": "bridge"}`,
+	})
+	defer os.RemoveAll(dir)
+
+	config, err := Parse([]byte(fmt.Sprintf(`{
+	  "namespacesDir": %q,
+	  "namespaces": {"isolated": {"name": "isolated", "type": "bridge"}}
+	}`, dir)))
+
+	assert.NoError(t, err)
+	assert.Len(t, config.Namespaces, 2)
+	assert.Equal(t, "default-bridge", config.Default["name"])
+}
@@ -0,0 +1,118 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Return the name of the profile netconf extends, or "" if none.
+func extendsName(netconf map[string]interface{}) (string, error) {
+	v, ok := netconf["extends"]
+	if !ok {
+		return "", nil
+	}
+
+	name, ok := v.(string)
+	if !ok || name == "" {
+		return "", fmt.Errorf("extends must name a profile, not %v.", v)
+	}
+
+	return name, nil
+}
+
+// Return the profile called name with everything it extends merged
+// in.  path holds the profiles being resolved that led to it, to
+// detect cycles.
+func (c *Config) resolveProfile(name string, path []string) (map[string]interface{}, error) {
+	for _, p := range path {
+		if p == name {
+			return nil, fmt.Errorf("Profiles extend each other in a cycle: %s.", strings.Join(append(path, name), " -> "))
+		}
+	}
+
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("Profile %q not found.", name)
+	}
+
+	profile, err := profileDeprecation(name, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.resolveExtends(profile, append(path, name))
+}
+
+// Return the profile called name, with everything it extends merged
+// in.
+func (c *Config) Profile(name string) (map[string]interface{}, error) {
+	return c.resolveProfile(name, nil)
+}
+
+// Return netconf deep merged over the profile it extends, if any, as
+// with mergeWithDefault.  The "extends" key itself is dropped.
+func (c *Config) resolveExtends(netconf map[string]interface{}, path []string) (map[string]interface{}, error) {
+	name, err := extendsName(netconf)
+	if err != nil || name == "" {
+		return netconf, err
+	}
+
+	base, err := c.resolveProfile(name, path)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := DeepMerge(base, netconf)
+	delete(merged, "extends")
+	return merged, nil
+}
+
+// Resolve "extends" in the namespace configs and the default, and
+// check that every profile resolves, so that mistakes are reported
+// when the config is loaded rather than when a pod uses them.
+func (c *Config) resolveAllExtends() error {
+	var names []string
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, err := c.resolveProfile(name, nil); err != nil {
+			return fmt.Errorf("Profile %q: %v", name, err)
+		}
+	}
+
+	for namespace, netconf := range c.Namespaces {
+		resolved, err := c.resolveExtends(netconf, nil)
+		if err != nil {
+			return fmt.Errorf("Namespace %q: %v", namespace, err)
+		}
+		c.Namespaces[namespace] = resolved
+	}
+
+	if len(c.Default) > 0 {
+		resolved, err := c.resolveExtends(c.Default, nil)
+		if err != nil {
+			return fmt.Errorf("Default config: %v", err)
+		}
+		c.Default = resolved
+	}
+
+	return nil
+}
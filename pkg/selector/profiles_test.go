@@ -0,0 +1,84 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Merge namespace configs over the profiles they extend.
+func TestExtends(t *testing.T) {
+	config, err := Parse([]byte(`{
+	  "profiles": {
+	    "bridge": {"type": "bridge", "isGateway": true, "ipam": {"type": "host-local", "routes": [{"dst": "0.0.0.0/0"}]}},
+	    "jumbo": {"extends": "bridge", "mtu": 9000}
+	  },
+	  "namespaces": {
+	    "tenant-a": {"extends": "jumbo", "name": "tenant-a", "ipam": {"subnet": "10.2.0.0/16"}}
+	  },
+	  "default": {"extends": "bridge", "name": "default", "ipam": {"subnet": "10.1.0.0/16"}}
+	}`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	sel, err := config.Select("K8S_POD_NAMESPACE=tenant-a")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"name":      "tenant-a",
+		"type":      "bridge",
+		"isGateway": true,
+		"mtu":       float64(9000),
+		"ipam": map[string]interface{}{
+			"type":   "host-local",
+			"subnet": "10.2.0.0/16",
+			"routes": []interface{}{map[string]interface{}{"dst": "0.0.0.0/0"}},
+		},
+	}, sel.NetConf)
+
+	sel, err = config.Select("K8S_POD_NAMESPACE=other")
+	assert.NoError(t, err)
+	assert.Equal(t, "bridge", sel.NetConf["type"])
+	assert.NotContains(t, sel.NetConf, "extends")
+
+	// Profiles are left as written, for other entries to extend.
+	assert.Equal(t, "bridge", config.Profiles["jumbo"]["extends"])
+
+	jumbo, err := config.Profile("jumbo")
+	assert.NoError(t, err)
+	assert.Equal(t, "bridge", jumbo["type"])
+	assert.Equal(t, float64(9000), jumbo["mtu"])
+	assert.NotContains(t, jumbo, "extends")
+}
+
+// Reject cycles and unknown profiles when loading the config.
+func TestExtendsErrors(t *testing.T) {
+	for _, data := range []string{
+		`{"profiles": {"a": {"extends": "b"}, "b": {"extends": "a"}}}`,
+		`{"profiles": {"a": {"extends": "a"}}}`,
+		`{"namespaces": {"tenant-a": {"extends": "missing"}}}`,
+		`{"namespaces": {"tenant-a": {"extends": 1}}, "profiles": {"1": {}}}`,
+	} {
+		_, err := Parse([]byte(data))
+		assert.Error(t, err, data)
+	}
+
+	_, err := Parse([]byte(`{"profiles": {"a": {"extends": "b"}, "b": {"extends": "c"}, "c": {"extends": "a"}}}`))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "a -> b -> c -> a")
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Pod QoS classes, as Kubernetes reports them in the pod's status.
+var QOSClasses = []string{"Guaranteed", "Burstable", "BestEffort"}
+
+// Selects a network for pods by their QoS class or resource requests,
+// e.g. for the high-performance network of pods requesting
+// example.com/fastnic.  Every condition given must hold.
+type ResourceSelector struct {
+	QOSClass string `json:"qosClass"`
+	Resource string `json:"resource"`
+	Network  string `json:"network"`
+}
+
+// The parts of a pod's spec and status ResourceSelectors look at.
+type PodResources struct {
+	QOSClass string
+	// Resources requested by any of the pod's containers.
+	Requests []string
+}
+
+// Returns the resources of the pod being selected for.  As with
+// PodMetadata, callers should look the pod up only once.
+type PodSpec func() (*PodResources, error)
+
+func (s *ResourceSelector) validate() error {
+	if s.Network == "" {
+		return errors.New("has no network.")
+	}
+
+	if s.QOSClass == "" && s.Resource == "" {
+		return errors.New("matches every pod.")
+	}
+
+	if s.QOSClass != "" {
+		for _, class := range QOSClasses {
+			if class == s.QOSClass {
+				return nil
+			}
+		}
+		return fmt.Errorf("has unknown qosClass %q; classes are %v.", s.QOSClass, QOSClasses)
+	}
+
+	return nil
+}
+
+func (s *ResourceSelector) matches(pod *PodResources) bool {
+	if s.QOSClass != "" && s.QOSClass != pod.QOSClass {
+		return false
+	}
+
+	if s.Resource != "" {
+		for _, r := range pod.Requests {
+			if r == s.Resource {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// Selects the network of the first resource selector matching the
+// pod.  This package cannot look pod specs up, so callers supporting
+// ModeResources pass their own, with Resources set.
+type ByResources struct {
+	Selectors []ResourceSelector
+	Resources PodSpec
+}
+
+func (s ByResources) Select(c *Config, args string, pod PodMetadata) (*Selection, error) {
+	if len(s.Selectors) == 0 {
+		return nil, nil
+	}
+
+	resources, err := s.Resources()
+	if err != nil {
+		return nil, err
+	}
+	if resources == nil {
+		return nil, nil
+	}
+
+	for i := range s.Selectors {
+		if s.Selectors[i].matches(resources) {
+			c.Logger().WithField("selector", i).Debug("Using network from pod resource selector.")
+			return c.SelectNamed(s.Selectors[i].Network, args)
+		}
+	}
+
+	return nil, nil
+}
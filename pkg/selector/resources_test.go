@@ -0,0 +1,58 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Require every condition of a resource selector to hold.
+func TestResourceSelectorMatches(t *testing.T) {
+	pod := &PodResources{QOSClass: "Burstable", Requests: []string{"cpu", "example.com/fastnic"}}
+
+	assert.True(t, (&ResourceSelector{Resource: "example.com/fastnic"}).matches(pod))
+	assert.True(t, (&ResourceSelector{QOSClass: "Burstable", Resource: "cpu"}).matches(pod))
+	assert.False(t, (&ResourceSelector{QOSClass: "Guaranteed", Resource: "cpu"}).matches(pod))
+	assert.False(t, (&ResourceSelector{Resource: "nvidia.com/gpu"}).matches(pod))
+}
+
+// Reject resource selectors without a network or conditions.
+func TestResourceSelectorValidation(t *testing.T) {
+	for _, config := range []string{
+		`{"selection": {"resourceSelectors": [{"resource": "example.com/fastnic"}]}}`,
+		`{"selection": {"resourceSelectors": [{"network": "fast"}]}}`,
+		`{"selection": {"resourceSelectors": [{"qosClass": "Platinum", "network": "fast"}]}}`,
+	} {
+		_, err := Parse([]byte(config))
+		assert.Error(t, err, config)
+	}
+
+	_, err := Parse([]byte(`{"selection": {"mode": "resources", "resourceSelectors": [{"qosClass": "Guaranteed", "network": "fast"}]}}`))
+	assert.NoError(t, err)
+}
+
+// Fall through when the pod has no resources to select by.
+func TestByResourcesNoPod(t *testing.T) {
+	s := ByResources{
+		Selectors: []ResourceSelector{{QOSClass: "Guaranteed", Network: "fast"}},
+		Resources: func() (*PodResources, error) { return nil, nil },
+	}
+
+	sel, err := s.Select(&Config{}, "", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, sel)
+}
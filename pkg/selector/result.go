@@ -0,0 +1,198 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// A result in the format of CNI spec 0.3, as printed by newer plugins
+// and expected from plugins in a chain.
+type Result030 struct {
+	CNIVersion string          `json:"cniVersion,omitempty"`
+	Interfaces []*Interface030 `json:"interfaces,omitempty"`
+	IPs        []*IPConfig030  `json:"ips,omitempty"`
+	Routes     []types.Route   `json:"routes,omitempty"`
+	DNS        types.DNS       `json:"dns,omitempty"`
+}
+
+// An interface in a 0.3 result.
+type Interface030 struct {
+	Name    string `json:"name"`
+	Mac     string `json:"mac,omitempty"`
+	Sandbox string `json:"sandbox,omitempty"`
+}
+
+// An address in a 0.3 result.
+type IPConfig030 struct {
+	// "4" or "6".
+	Version string `json:"version"`
+	// Index into Interfaces.
+	Interface *int        `json:"interface,omitempty"`
+	Address   types.IPNet `json:"address"`
+	Gateway   net.IP      `json:"gateway,omitempty"`
+}
+
+// Return whether results for cniVersion use the 0.3 format.
+func Is030(cniVersion string) bool {
+	return strings.HasPrefix(cniVersion, "0.3.")
+}
+
+// A plugin's result: the 0.1/0.2 view kube-namespace works with and,
+// if the plugin printed a 0.3 result, that result as printed, so that
+// its interfaces and further addresses can be passed on.
+type Result struct {
+	*types.Result
+	// The plugin's 0.3 result, or nil for 0.1 and 0.2 results.
+	Result030 *Result030
+	// The result as the plugin printed it.
+	Raw []byte
+}
+
+// Parse a plugin's result, in either the 0.1/0.2 or the 0.3 format.
+// 0.3 results are converted, keeping the first address of each
+// family.
+func ParseResult(data []byte) (*types.Result, error) {
+	result, err := ParsePluginResult(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Result, nil
+}
+
+// Parse a plugin's result, in either the 0.1/0.2 or the 0.3 format,
+// keeping 0.3 results next to their legacy view.
+func ParsePluginResult(data []byte) (*Result, error) {
+	probe := struct {
+		IPs json.RawMessage `json:"ips"`
+	}{}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("Failed to parse result: %v", err)
+	}
+
+	if probe.IPs == nil {
+		result := &types.Result{}
+		if err := json.Unmarshal(data, result); err != nil {
+			return nil, fmt.Errorf("Failed to parse result: %v", err)
+		}
+		return &Result{Result: result, Raw: data}, nil
+	}
+
+	r030 := &Result030{}
+	if err := json.Unmarshal(data, r030); err != nil {
+		return nil, fmt.Errorf("Failed to parse result: %v", err)
+	}
+
+	result := &types.Result{DNS: r030.DNS}
+	for _, ip := range r030.IPs {
+		ipc := &types.IPConfig{IP: net.IPNet(ip.Address), Gateway: ip.Gateway}
+		if ip.Version == "4" && result.IP4 == nil {
+			result.IP4 = ipc
+		} else if ip.Version == "6" && result.IP6 == nil {
+			result.IP6 = ipc
+		}
+	}
+
+	for _, route := range r030.Routes {
+		if route.Dst.IP.To4() != nil && result.IP4 != nil {
+			result.IP4.Routes = append(result.IP4.Routes, route)
+		} else if route.Dst.IP.To4() == nil && result.IP6 != nil {
+			result.IP6.Routes = append(result.IP6.Routes, route)
+		}
+	}
+
+	return &Result{Result: result, Result030: r030, Raw: data}, nil
+}
+
+// Convert a result to the 0.3 format, for the pod interface ifName
+// in the network namespace at netns.
+func ConvertTo030(result *types.Result, cniVersion, ifName, netns string) *Result030 {
+	r030 := &Result030{
+		CNIVersion: cniVersion,
+		Interfaces: []*Interface030{{Name: ifName, Sandbox: netns}},
+		DNS:        result.DNS,
+	}
+
+	for _, ipc := range []*types.IPConfig{result.IP4, result.IP6} {
+		if ipc == nil {
+			continue
+		}
+
+		version := "4"
+		if ipc.IP.IP.To4() == nil {
+			version = "6"
+		}
+
+		index := 0
+		r030.IPs = append(r030.IPs, &IPConfig030{
+			Version:   version,
+			Interface: &index,
+			Address:   types.IPNet(ipc.IP),
+			Gateway:   ipc.Gateway,
+		})
+		r030.Routes = append(r030.Routes, ipc.Routes...)
+	}
+
+	return r030
+}
+
+// Return the 0.3 result to pass on for a plugin's result, for the pod
+// interface ifName in the network namespace at netns.  A 0.3 result is
+// passed on as the plugin printed it, with the DNS settings, routes
+// and gateways of its legacy view, which kube-namespace may have
+// changed, e.g. with resultTransforms.  Other results are converted.
+func (r *Result) To030(cniVersion, ifName, netns string) *Result030 {
+	if r.Result030 == nil {
+		return ConvertTo030(r.Result, cniVersion, ifName, netns)
+	}
+
+	r030 := *r.Result030
+	r030.CNIVersion = cniVersion
+	r030.DNS = r.DNS
+	r030.Routes = nil
+	r030.IPs = make([]*IPConfig030, len(r.Result030.IPs))
+
+	first := map[string]*types.IPConfig{"4": r.IP4, "6": r.IP6}
+	for i, ip := range r.Result030.IPs {
+		copied := *ip
+		if ipc := first[ip.Version]; ipc != nil {
+			copied.Gateway = ipc.Gateway
+			first[ip.Version] = nil
+		}
+		r030.IPs[i] = &copied
+	}
+
+	for _, ipc := range []*types.IPConfig{r.IP4, r.IP6} {
+		if ipc != nil {
+			r030.Routes = append(r030.Routes, ipc.Routes...)
+		}
+	}
+
+	// Routes of a family without addresses have no place in the
+	// legacy view.
+	for _, route := range r.Result030.Routes {
+		if (route.Dst.IP.To4() != nil && r.IP4 == nil) || (route.Dst.IP.To4() == nil && r.IP6 == nil) {
+			r030.Routes = append(r030.Routes, route)
+		}
+	}
+
+	return &r030
+}
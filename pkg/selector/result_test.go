@@ -0,0 +1,111 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// Parse results in both formats.
+func TestParseResult(t *testing.T) {
+	legacy, err := ParseResult([]byte(`{"ip4": {"ip": "10.1.0.5/16", "gateway": "10.1.0.1"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "10.1.0.5/16", legacy.IP4.IP.String())
+
+	r, err := ParseResult([]byte(`{
+	  "cniVersion": "0.3.1",
+	  "interfaces": [{"name": "eth0"}],
+	  "ips": [
+	    {"version": "4", "interface": 0, "address": "10.1.0.5/16", "gateway": "10.1.0.1"},
+	    {"version": "6", "interface": 0, "address": "fd00::5/64"}
+	  ],
+	  "routes": [{"dst": "0.0.0.0/0"}, {"dst": "::/0"}],
+	  "dns": {"nameservers": ["10.1.0.10"]}
+	}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "10.1.0.5/16", r.IP4.IP.String())
+	assert.Equal(t, "10.1.0.1", r.IP4.Gateway.String())
+	assert.Len(t, r.IP4.Routes, 1)
+	assert.Equal(t, "fd00::5/64", r.IP6.IP.String())
+	assert.Len(t, r.IP6.Routes, 1)
+	assert.Equal(t, []string{"10.1.0.10"}, r.DNS.Nameservers)
+}
+
+// Convert a result to the 0.3 format.
+func TestConvertTo030(t *testing.T) {
+	_, dst, _ := net.ParseCIDR("0.0.0.0/0")
+	result := &types.Result{
+		IP4: &types.IPConfig{
+			IP:      net.IPNet{IP: net.IPv4(10, 1, 0, 5), Mask: net.CIDRMask(16, 32)},
+			Gateway: net.IPv4(10, 1, 0, 1),
+			Routes:  []types.Route{{Dst: *dst}},
+		},
+	}
+
+	data, err := json.Marshal(ConvertTo030(result, "0.3.1", "eth0", "/var/run/netns/pod"))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+	  "cniVersion": "0.3.1",
+	  "interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/pod"}],
+	  "ips": [{"version": "4", "interface": 0, "address": "10.1.0.5/16", "gateway": "10.1.0.1"}],
+	  "routes": [{"dst": "0.0.0.0/0"}],
+	  "dns": {}
+	}`, string(data))
+}
+
+// Pass a 0.3 result on as printed, with every interface and address.
+func TestResultTo030(t *testing.T) {
+	printed := `{
+	  "cniVersion": "0.3.1",
+	  "interfaces": [
+	    {"name": "cni0", "mac": "0a:58:0a:01:00:01"},
+	    {"name": "veth1a2b3c", "mac": "5e:2d:1f:00:00:01"},
+	    {"name": "eth0", "mac": "0a:58:0a:01:00:05", "sandbox": "/var/run/netns/pod"}
+	  ],
+	  "ips": [
+	    {"version": "4", "interface": 2, "address": "10.1.0.5/16", "gateway": "10.1.0.1"},
+	    {"version": "4", "interface": 2, "address": "10.1.0.6/16", "gateway": "10.1.0.1"},
+	    {"version": "6", "interface": 2, "address": "fd00::5/64", "gateway": "fd00::1"}
+	  ],
+	  "routes": [{"dst": "0.0.0.0/0"}, {"dst": "::/0"}],
+	  "dns": {"nameservers": ["10.1.0.10"]}
+	}`
+
+	r, err := ParsePluginResult([]byte(printed))
+	assert.NoError(t, err)
+	assert.Equal(t, "10.1.0.5/16", r.IP4.IP.String())
+
+	data, err := json.Marshal(r.To030("0.3.1", "eth0", "/var/run/netns/pod"))
+	assert.NoError(t, err)
+	assert.JSONEq(t, printed, string(data))
+
+	// Changes to the legacy view are carried over.
+	r.IP4.Gateway = net.ParseIP("10.1.0.254")
+	r.IP6.Routes = nil
+	r.DNS.Search = []string{"svc.cluster.local"}
+
+	r030 := r.To030("0.3.1", "eth0", "/var/run/netns/pod")
+	assert.Len(t, r030.Interfaces, 3)
+	assert.Equal(t, "10.1.0.254", r030.IPs[0].Gateway.String())
+	assert.Equal(t, "10.1.0.1", r030.IPs[1].Gateway.String())
+	assert.Len(t, r030.Routes, 1)
+	assert.Equal(t, []string{"svc.cluster.local"}, r030.DNS.Search)
+	assert.Equal(t, "10.1.0.1", r.Result030.IPs[0].Gateway.String())
+}
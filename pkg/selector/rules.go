@@ -0,0 +1,265 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// What a matching rule does once it has been applied.
+const (
+	// Stop evaluating rules.  The default.
+	RuleBreak = "break"
+	// Go on to the next rule; a later matching rule with a profile or
+	// network takes the place of this one's.
+	RuleContinue = "continue"
+)
+
+// An entry of the top-level "rules" list.  Rules are evaluated in
+// order; every condition of a rule's match must hold for it to match.
+// A matching rule with a profile or network selects it, and its action
+// says whether to stop there.  A rule with neither only stops the
+// evaluation, keeping what earlier rules selected, which is how
+// exceptions to later rules are written:
+//
+//	"rules": [
+//	  {"match": {"namespaces": ["prod-infra"]}, "action": "break"},
+//	  {"match": {"namespaces": ["prod-*"]}, "profile": "prod"}
+//	]
+type Rule struct {
+	// Named in logs and recorded as the selection's rule.
+	Name    string    `json:"name"`
+	Match   RuleMatch `json:"match"`
+	Profile string    `json:"profile"`
+	Network string    `json:"network"`
+	Action  string    `json:"action"`
+}
+
+// The conditions of a rule.  Namespaces, Pods and ServiceAccounts are
+// shell patterns, as for path.Match; a list matches if any of its
+// patterns do.
+type RuleMatch struct {
+	Namespaces       []string                  `json:"namespaces"`
+	Pods             []string                  `json:"pods"`
+	ServiceAccounts  []string                  `json:"serviceAccounts"`
+	MatchLabels      map[string]string         `json:"matchLabels"`
+	MatchExpressions []NodeSelectorRequirement `json:"matchExpressions"`
+}
+
+// Return whether name matches any of patterns.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Rule) validate(c *Config) error {
+	switch r.Action {
+	case "", RuleBreak, RuleContinue:
+	default:
+		return fmt.Errorf("has unknown action %q; actions are %q and %q.", r.Action, RuleBreak, RuleContinue)
+	}
+
+	if r.Profile != "" && r.Network != "" {
+		return errors.New("has both a profile and a network.")
+	}
+	if r.Profile == "" && r.Network == "" && r.Action == RuleContinue {
+		return errors.New("has no profile or network, so it can only break.")
+	}
+	if r.Profile != "" {
+		if _, ok := c.Profiles[r.Profile]; !ok {
+			return fmt.Errorf("profile %q not found.", r.Profile)
+		}
+	}
+
+	m := &r.Match
+	for _, patterns := range [][]string{m.Namespaces, m.Pods, m.ServiceAccounts} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("has invalid pattern %q.", pattern)
+			}
+		}
+	}
+	for _, req := range m.MatchExpressions {
+		if err := req.validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Check the rules when the config is loaded.
+func (c *Config) validateRules() error {
+	for i := range c.Rules {
+		if err := c.Rules[i].validate(c); err != nil {
+			return fmt.Errorf("Rule %d %v", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Returns the name of the service account the pod being selected for
+// runs as.  As with PodMetadata, callers should look the pod up only
+// once.
+type PodServiceAccount func() (string, error)
+
+// Selects by the rules list.  Rules matching on service accounts need
+// ServiceAccount, which this package cannot look up; callers
+// supporting them pass their own ByRules for ModeRules.
+type ByRules struct {
+	Rules          []Rule
+	ServiceAccount PodServiceAccount
+}
+
+// The pod being selected for, looked up as rules need it.
+type rulePod struct {
+	namespace, name string
+	metadata        PodMetadata
+	serviceAccount  PodServiceAccount
+
+	labels     map[string]string
+	labelsErr  error
+	haveLabels bool
+}
+
+func (p *rulePod) getLabels() (map[string]string, error) {
+	if !p.haveLabels {
+		p.haveLabels = true
+		p.labels, _, p.labelsErr = p.metadata()
+	}
+
+	return p.labels, p.labelsErr
+}
+
+func (m *RuleMatch) matches(pod *rulePod) (bool, error) {
+	if len(m.Namespaces) > 0 && !matchesAny(m.Namespaces, pod.namespace) {
+		return false, nil
+	}
+	if len(m.Pods) > 0 && !matchesAny(m.Pods, pod.name) {
+		return false, nil
+	}
+
+	if len(m.ServiceAccounts) > 0 {
+		if pod.serviceAccount == nil {
+			return false, errors.New("Matching on service accounts is not supported here.")
+		}
+
+		account, err := pod.serviceAccount()
+		if err != nil {
+			return false, err
+		}
+		if !matchesAny(m.ServiceAccounts, account) {
+			return false, nil
+		}
+	}
+
+	if len(m.MatchLabels) > 0 || len(m.MatchExpressions) > 0 {
+		labels, err := pod.getLabels()
+		if err != nil {
+			return false, err
+		}
+
+		ls := LabelSelector{MatchLabels: m.MatchLabels, MatchExpressions: m.MatchExpressions}
+		if !ls.matches(labels) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (s ByRules) Select(c *Config, args string, pod PodMetadata) (*Selection, error) {
+	extraArgs := ParseExtraArgs(args)
+	namespace := extraArgs["K8S_POD_NAMESPACE"]
+	if len(s.Rules) == 0 || namespace == "" {
+		return nil, nil
+	}
+
+	p := &rulePod{
+		namespace:      namespace,
+		name:           extraArgs["K8S_POD_NAME"],
+		metadata:       pod,
+		serviceAccount: s.ServiceAccount,
+	}
+
+	var selected *Rule
+	selectedIndex := -1
+	for i := range s.Rules {
+		r := &s.Rules[i]
+
+		ok, err := r.Match.matches(p)
+		if err != nil {
+			return nil, fmt.Errorf("Rule %d: %v", i, err)
+		}
+		if !ok {
+			continue
+		}
+
+		if r.Profile != "" || r.Network != "" {
+			selected, selectedIndex = r, i
+		}
+		if r.Action != RuleContinue {
+			break
+		}
+	}
+
+	if selected == nil {
+		return nil, nil
+	}
+
+	c.Logger().WithFields(logrus.Fields{
+		"rule":      selectedIndex,
+		"name":      selected.Name,
+		"namespace": namespace,
+		"pod":       p.name,
+	}).Debug("Using network from selection rule.")
+
+	var sel *Selection
+	var err error
+	if selected.Network != "" {
+		sel, err = c.SelectNamed(selected.Network, args)
+	} else {
+		var netconf map[string]interface{}
+		if netconf, err = c.Profile(selected.Profile); err != nil {
+			return nil, err
+		}
+		sel, err = c.WithNamespaces(map[string]map[string]interface{}{namespace: netconf}).Select(args)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	copied := *sel
+	switch {
+	case copied.SunsetFallback:
+		// The default config was selected instead.
+	case selected.Name != "":
+		copied.Rule = selected.Name
+	case selected.Profile != "":
+		copied.Rule = selected.Profile
+	}
+
+	return &copied, nil
+}
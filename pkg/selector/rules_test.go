@@ -0,0 +1,138 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Evaluate rules top-down, with break making an exception to later
+// rules.
+func TestRules(t *testing.T) {
+	config, err := Parse([]byte(`{
+	  "profiles": {
+	    "prod": {"name": "prod", "type": "bridge"},
+	    "prod-fast": {"name": "prod-fast", "type": "ipvlan"}
+	  },
+	  "rules": [
+	    {"match": {"namespaces": ["prod-infra"]}, "action": "break"},
+	    {"name": "prod-namespaces", "match": {"namespaces": ["prod-*"]}, "profile": "prod", "action": "continue"},
+	    {"match": {"namespaces": ["prod-*"], "matchLabels": {"tier": "fast"}}, "profile": "prod-fast"},
+	    {"match": {"pods": ["batch-*"]}, "network": "default"}
+	  ],
+	  "namespaces": {"prod-infra": {"name": "infra", "type": "macvlan"}},
+	  "default": {"name": "default", "type": "bridge"}
+	}`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	selectors := config.Selectors(nil)
+	if !assert.Len(t, selectors, 2) {
+		return
+	}
+	assert.Equal(t, ByRules{Rules: config.Rules}, selectors[0])
+
+	for _, tc := range []struct {
+		args, rule, name string
+		labels           map[string]string
+	}{
+		{"K8S_POD_NAMESPACE=prod-web;K8S_POD_NAME=web-1", "prod-namespaces", "prod", nil},
+		{"K8S_POD_NAMESPACE=prod-web;K8S_POD_NAME=web-1", "prod-fast", "prod-fast", map[string]string{"tier": "fast"}},
+		{"K8S_POD_NAMESPACE=prod-infra;K8S_POD_NAME=batch-1", "prod-infra", "infra", nil},
+		{"K8S_POD_NAMESPACE=dev;K8S_POD_NAME=batch-1", DefaultRule, "default", nil},
+		{"K8S_POD_NAMESPACE=dev;K8S_POD_NAME=web-1", DefaultRule, "default", nil},
+	} {
+		sel, err := config.SelectWith(selectors, tc.args, podWith(tc.labels, nil))
+		if assert.NoError(t, err, tc.args) {
+			assert.Equal(t, tc.rule, sel.Rule, tc.args)
+			assert.Equal(t, tc.name, sel.NetConf["name"], tc.args)
+		}
+	}
+}
+
+// Match on service accounts only when the caller can look them up.
+func TestRulesServiceAccount(t *testing.T) {
+	config, err := Parse([]byte(`{
+	  "profiles": {"ops": {"name": "ops", "type": "macvlan"}},
+	  "rules": [{"match": {"serviceAccounts": ["ops-*"]}, "profile": "ops"}],
+	  "default": {"name": "default", "type": "bridge"}
+	}`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	args := "K8S_POD_NAMESPACE=web;K8S_POD_NAME=web-1"
+	_, err = config.SelectWith(config.Selectors(nil), args, podWith(nil, nil))
+	assert.Error(t, err)
+
+	rules := func(account string, err error) []Selector {
+		return []Selector{ByRules{Rules: config.Rules, ServiceAccount: func() (string, error) { return account, err }}, ByNamespaceMap{}}
+	}
+
+	sel, err := config.SelectWith(rules("ops-deployer", nil), args, podWith(nil, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "ops", sel.Rule)
+
+	sel, err = config.SelectWith(rules("default", nil), args, podWith(nil, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultRule, sel.Rule)
+
+	_, err = config.SelectWith(rules("", errors.New("lookup failed")), args, podWith(nil, nil))
+	assert.Error(t, err)
+}
+
+// Break on a rule with neither a profile nor an action.
+func TestRulesDefaultBreak(t *testing.T) {
+	config, err := Parse([]byte(`{
+	  "profiles": {"prod": {"name": "prod", "type": "bridge"}},
+	  "rules": [
+	    {"match": {"namespaces": ["prod-infra"]}},
+	    {"match": {"namespaces": ["prod-*"]}, "profile": "prod"}
+	  ],
+	  "default": {"name": "default", "type": "bridge"}
+	}`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	sel, err := config.SelectWith(config.Selectors(nil), "K8S_POD_NAMESPACE=prod-infra;K8S_POD_NAME=web-1", podWith(nil, nil))
+	if assert.NoError(t, err) {
+		assert.Equal(t, DefaultRule, sel.Rule)
+	}
+
+	sel, err = config.SelectWith(config.Selectors(nil), "K8S_POD_NAMESPACE=prod-web;K8S_POD_NAME=web-1", podWith(nil, nil))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "prod", sel.Rule)
+	}
+}
+
+// Reject malformed rules when loading the config.
+func TestRulesErrors(t *testing.T) {
+	for _, data := range []string{
+		`{"rules": [{"profile": "missing"}]}`,
+		`{"profiles": {"a": {}}, "rules": [{"profile": "a", "network": "b"}]}`,
+		`{"rules": [{"match": {"namespaces": ["a"]}, "action": "continue"}]}`,
+		`{"profiles": {"a": {}}, "rules": [{"profile": "a", "action": "stop"}]}`,
+		`{"profiles": {"a": {}}, "rules": [{"match": {"pods": ["[a"]}, "profile": "a"}]}`,
+		`{"profiles": {"a": {}}, "rules": [{"match": {"matchExpressions": [{"key": "x", "operator": "Near"}]}, "profile": "a"}]}`,
+	} {
+		_, err := Parse([]byte(data))
+		assert.Error(t, err, data)
+	}
+}
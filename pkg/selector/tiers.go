@@ -0,0 +1,141 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Security tiers of network configs, from least to most privileged.
+// A config's tier is its "tier" key; configs without one are
+// restricted.
+var Tiers = []string{"restricted", "standard", "trusted"}
+
+// The top-level "tierPolicy" block: the most privileged tier each
+// namespace's pods may get, so that pods cannot pick a more privileged
+// network with an annotation or labels.
+type TierPolicy struct {
+	// Tiers per namespace.
+	Namespaces map[string]string `json:"namespaces"`
+	// The tier of namespaces not listed.  Defaults to restricted.
+	Default string `json:"default"`
+}
+
+// Return the rank of tier in Tiers, or -1 if it is unknown.
+func tierRank(tier string) int {
+	for i, t := range Tiers {
+		if t == tier {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Return the tier of a network config.
+func netConfTier(netconf map[string]interface{}) (string, error) {
+	v, ok := netconf["tier"]
+	if !ok {
+		return Tiers[0], nil
+	}
+
+	tier, ok := v.(string)
+	if !ok || tierRank(tier) < 0 {
+		return "", fmt.Errorf("Unknown tier %v; tiers are %v.", v, Tiers)
+	}
+
+	return tier, nil
+}
+
+// Return the most privileged tier allowed in namespace.
+func (p *TierPolicy) allowed(namespace string) string {
+	if tier, ok := p.Namespaces[namespace]; ok {
+		return tier
+	}
+	if p.Default != "" {
+		return p.Default
+	}
+
+	return Tiers[0]
+}
+
+func (p *TierPolicy) validate() error {
+	if p.Default != "" && tierRank(p.Default) < 0 {
+		return fmt.Errorf("Unknown tierPolicy default %q; tiers are %v.", p.Default, Tiers)
+	}
+
+	for namespace, tier := range p.Namespaces {
+		if tierRank(tier) < 0 {
+			return fmt.Errorf("Unknown tier %q for namespace %q in tierPolicy; tiers are %v.", tier, namespace, Tiers)
+		}
+	}
+
+	return nil
+}
+
+// Check that the tiers of the profiles, namespace configs and default
+// are known.
+func (c *Config) validateTiers() error {
+	check := func(what string, netconf map[string]interface{}) error {
+		if _, err := netConfTier(netconf); err != nil {
+			return fmt.Errorf("%s: %v", what, err)
+		}
+		return nil
+	}
+
+	for name, netconf := range c.Profiles {
+		if err := check(fmt.Sprintf("Profile %q", name), netconf); err != nil {
+			return err
+		}
+	}
+	for namespace, netconf := range c.Namespaces {
+		if err := check(fmt.Sprintf("Namespace %q", namespace), netconf); err != nil {
+			return err
+		}
+	}
+
+	return check("Default config", c.Default)
+}
+
+// Refuse the selection if its config's tier is above the one allowed
+// in the pod's namespace.
+func (c *Config) checkTier(sel *Selection) error {
+	if c.TierPolicy == nil || sel.Namespace == "" {
+		return nil
+	}
+
+	tier, err := netConfTier(sel.NetConf)
+	if err != nil {
+		return err
+	}
+
+	allowed := c.TierPolicy.allowed(sel.Namespace)
+	if tierRank(tier) <= tierRank(allowed) {
+		return nil
+	}
+
+	c.Logger().WithFields(logrus.Fields{
+		"namespace": sel.Namespace,
+		"pod":       sel.Pod,
+		"rule":      sel.Rule,
+		"tier":      tier,
+		"allowed":   allowed,
+	}).Error("Refusing network config above the namespace's tier.")
+
+	return newError(CodeTierNotAllowed, "Network config %q is %s, but namespace %q only allows up to %s.",
+		sel.Rule, tier, sel.Namespace, allowed)
+}
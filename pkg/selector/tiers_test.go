@@ -0,0 +1,78 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const tierConfig = `{
+  "profiles": {"host-access": {"type": "macvlan", "tier": "trusted"}},
+  "namespaces": {
+    "ops": {"extends": "host-access", "name": "ops"},
+    "web": {"name": "web", "type": "bridge", "tier": "standard"}
+  },
+  "default": {"name": "default", "type": "bridge"},
+  "selection": {"mode": ["annotation", "namespaceMap"]},
+  "tierPolicy": {"namespaces": {"ops": "trusted", "web": "standard"}}
+}`
+
+// Refuse annotations selecting a network above the namespace's tier.
+func TestTierPolicy(t *testing.T) {
+	c, err := Parse([]byte(tierConfig))
+	if !assert.NoError(t, err) {
+		return
+	}
+	selectors := c.Selectors(nil)
+	annotate := func(network string) PodMetadata {
+		return podWith(nil, map[string]string{DefaultNetworkAnnotation: network})
+	}
+
+	sel, err := c.SelectWith(selectors, "K8S_POD_NAMESPACE=ops", podWith(nil, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "ops", sel.Rule)
+
+	sel, err = c.SelectWith(selectors, "K8S_POD_NAMESPACE=web", annotate("default"))
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultRule, sel.Rule)
+
+	_, err = c.SelectWith(selectors, "K8S_POD_NAMESPACE=web", annotate("ops"))
+	assert.True(t, Is(err, ErrTierNotAllowed))
+	assert.EqualError(t, err, `Network config "ops" is trusted, but namespace "web" only allows up to standard.`)
+
+	// Unlisted namespaces get the default tier, restricted.
+	_, err = c.SelectWith(selectors, "K8S_POD_NAMESPACE=other", annotate("web"))
+	assert.True(t, Is(err, ErrTierNotAllowed))
+
+	sel, err = c.SelectWith(selectors, "K8S_POD_NAMESPACE=other", podWith(nil, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultRule, sel.Rule)
+}
+
+// Reject unknown tiers.
+func TestTierValidation(t *testing.T) {
+	for _, data := range []string{
+		`{"tierPolicy": {"default": "root"}}`,
+		`{"tierPolicy": {"namespaces": {"a": "root"}}}`,
+		`{"namespaces": {"a": {"type": "bridge", "tier": "root"}}}`,
+		`{"profiles": {"a": {"type": "bridge", "tier": 1}}}`,
+		`{"default": {"type": "bridge", "tier": "root"}}`,
+	} {
+		_, err := Parse([]byte(data))
+		assert.Error(t, err, data)
+	}
+}
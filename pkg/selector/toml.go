@@ -0,0 +1,370 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A decoder for the subset of TOML that network configs need: tables,
+// arrays of tables, dotted and quoted keys, strings, integers, floats,
+// booleans, arrays and inline tables.  Dates and multi-line strings
+// are rejected rather than misread.
+
+// Decode a TOML document into the values encoding/json would produce.
+func decodeTOML(data []byte) (interface{}, error) {
+	root := map[string]interface{}{}
+	table := root
+	defined := map[string]bool{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(stripComment(scanner.Text(), "#"))
+		if line == "" {
+			continue
+		}
+
+		// Arrays may span lines; read on until the brackets balance.
+		for !tomlBalanced(line) && scanner.Scan() {
+			number++
+			line += " " + strings.TrimSpace(stripComment(scanner.Text(), "#"))
+		}
+
+		var err error
+		switch {
+		case strings.HasPrefix(line, "[["):
+			table, err = tomlArrayTable(root, line)
+		case strings.HasPrefix(line, "["):
+			table, err = tomlTable(root, line, defined)
+		default:
+			err = tomlKeyValue(table, line)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", number, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return root, nil
+}
+
+// Report whether every bracket and brace in line outside strings is
+// closed.
+func tomlBalanced(line string) bool {
+	depth := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"', '\'':
+			end := quotedEnd(line[i:])
+			if end < 0 {
+				return true
+			}
+			i += end - 1
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		}
+	}
+
+	return depth <= 0
+}
+
+// Open the [table] header line names, creating it in root.
+func tomlTable(root map[string]interface{}, line string, defined map[string]bool) (map[string]interface{}, error) {
+	if !strings.HasSuffix(line, "]") {
+		return nil, fmt.Errorf("bad table header %s", line)
+	}
+	keys, err := tomlKeys(line[1 : len(line)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.Join(keys, "\x00")
+	if defined[name] {
+		return nil, fmt.Errorf("table %s defined twice", line)
+	}
+	defined[name] = true
+
+	return tomlDescend(root, keys)
+}
+
+// Append a table to the [[array]] header line names.
+func tomlArrayTable(root map[string]interface{}, line string) (map[string]interface{}, error) {
+	if !strings.HasSuffix(line, "]]") {
+		return nil, fmt.Errorf("bad table header %s", line)
+	}
+	keys, err := tomlKeys(line[2 : len(line)-2])
+	if err != nil {
+		return nil, err
+	}
+
+	parent, err := tomlDescend(root, keys[:len(keys)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	last := keys[len(keys)-1]
+	array, ok := parent[last].([]interface{})
+	if _, exists := parent[last]; exists && !ok {
+		return nil, fmt.Errorf("key %q is not an array of tables", last)
+	}
+
+	table := map[string]interface{}{}
+	parent[last] = append(array, table)
+	return table, nil
+}
+
+// Return the table at keys below table, creating missing tables.  An
+// array of tables resolves to its last element.
+func tomlDescend(table map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	for _, key := range keys {
+		switch next := table[key].(type) {
+		case nil:
+			child := map[string]interface{}{}
+			table[key] = child
+			table = child
+		case map[string]interface{}:
+			table = next
+		case []interface{}:
+			last, ok := next[len(next)-1].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("key %q is not a table", key)
+			}
+			table = last
+		default:
+			return nil, fmt.Errorf("key %q is not a table", key)
+		}
+	}
+
+	return table, nil
+}
+
+// Set the key = value pair in line in table.
+func tomlKeyValue(table map[string]interface{}, line string) error {
+	p := &tomlParser{text: line}
+	keys, err := p.keys('=')
+	if err != nil {
+		return err
+	}
+	p.pos++
+
+	value, err := p.value()
+	if err != nil {
+		return err
+	}
+	if p.skipSpace(); p.pos < len(p.text) {
+		return fmt.Errorf("unexpected %q after value", p.text[p.pos:])
+	}
+
+	parent, err := tomlDescend(table, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+
+	last := keys[len(keys)-1]
+	if _, exists := parent[last]; exists {
+		return fmt.Errorf("duplicate key %q", last)
+	}
+	parent[last] = value
+	return nil
+}
+
+// Split a dotted key, e.g. namespaces."tenant-a".ipam.
+func tomlKeys(text string) ([]string, error) {
+	p := &tomlParser{text: text}
+	keys, err := p.keys(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.text) {
+		return nil, fmt.Errorf("bad key %q", text)
+	}
+
+	return keys, nil
+}
+
+type tomlParser struct {
+	text string
+	pos  int
+}
+
+func (p *tomlParser) skipSpace() {
+	for p.pos < len(p.text) && (p.text[p.pos] == ' ' || p.text[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// Read a dotted key up to end, or to the end of the text if end is 0.
+func (p *tomlParser) keys(end byte) ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.text) {
+			return nil, fmt.Errorf("expected a key in %q", p.text)
+		}
+
+		if c := p.text[p.pos]; c == '"' || c == '\'' {
+			s, err := p.quoted()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, s)
+		} else {
+			start := p.pos
+			for p.pos < len(p.text) && isBareKeyChar(p.text[p.pos]) {
+				p.pos++
+			}
+			if p.pos == start {
+				return nil, fmt.Errorf("bad key in %q", p.text)
+			}
+			keys = append(keys, p.text[start:p.pos])
+		}
+
+		p.skipSpace()
+		switch {
+		case p.pos < len(p.text) && p.text[p.pos] == '.':
+			p.pos++
+		case end == 0 && p.pos == len(p.text):
+			return keys, nil
+		case end != 0 && p.pos < len(p.text) && p.text[p.pos] == end:
+			return keys, nil
+		default:
+			return nil, fmt.Errorf("bad key in %q", p.text)
+		}
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) quoted() (string, error) {
+	rest := p.text[p.pos:]
+	if strings.HasPrefix(rest, `"""`) || strings.HasPrefix(rest, "'''") {
+		return "", fmt.Errorf("multi-line strings are not supported")
+	}
+
+	end := quotedEnd(rest)
+	if end < 0 {
+		return "", fmt.Errorf("unterminated string")
+	}
+	p.pos += end
+
+	if rest[0] == '\'' {
+		return rest[1 : end-1], nil
+	}
+	var s string
+	if err := json.Unmarshal([]byte(rest[:end]), &s); err != nil {
+		return "", fmt.Errorf("bad string %s: %v", rest[:end], err)
+	}
+	return s, nil
+}
+
+func (p *tomlParser) value() (interface{}, error) {
+	p.skipSpace()
+	if p.pos >= len(p.text) {
+		return nil, fmt.Errorf("expected a value")
+	}
+
+	switch p.text[p.pos] {
+	case '"', '\'':
+		return p.quoted()
+	case '[':
+		p.pos++
+		items := []interface{}{}
+		for {
+			if p.skipSpace(); p.pos < len(p.text) && p.text[p.pos] == ']' {
+				p.pos++
+				return items, nil
+			}
+			item, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			if p.skipSpace(); p.pos < len(p.text) && p.text[p.pos] == ',' {
+				p.pos++
+			} else if p.pos >= len(p.text) || p.text[p.pos] != ']' {
+				return nil, fmt.Errorf("expected \",\" or \"]\" in array")
+			}
+		}
+	case '{':
+		p.pos++
+		table := map[string]interface{}{}
+		for {
+			if p.skipSpace(); p.pos < len(p.text) && p.text[p.pos] == '}' {
+				p.pos++
+				return table, nil
+			}
+			keys, err := p.keys('=')
+			if err != nil {
+				return nil, err
+			}
+			p.pos++
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			parent, err := tomlDescend(table, keys[:len(keys)-1])
+			if err != nil {
+				return nil, err
+			}
+			parent[keys[len(keys)-1]] = value
+			if p.skipSpace(); p.pos < len(p.text) && p.text[p.pos] == ',' {
+				p.pos++
+			} else if p.pos >= len(p.text) || p.text[p.pos] != '}' {
+				return nil, fmt.Errorf("expected \",\" or \"}\" in inline table")
+			}
+		}
+	}
+
+	start := p.pos
+	for p.pos < len(p.text) && !strings.ContainsRune(" \t,]}", rune(p.text[p.pos])) {
+		p.pos++
+	}
+	word := p.text[start:p.pos]
+
+	switch word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+
+	// Leading zeros are not allowed, so "010" is not read as octal.
+	number := strings.Replace(word, "_", "", -1)
+	digits := strings.TrimLeft(number, "+-")
+	leadingZero := len(digits) > 1 && digits[0] == '0' && digits[1] >= '0' && digits[1] <= '9'
+	if leadingZero {
+		return nil, fmt.Errorf("unsupported value %q", word)
+	}
+	if i, err := strconv.ParseInt(number, 0, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(number, 64); err == nil && !strings.ContainsAny(number, "xX") {
+		return f, nil
+	}
+
+	return nil, fmt.Errorf("unsupported value %q", word)
+}
@@ -0,0 +1,276 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// The environment variable holding node labels, as
+// "key=value,key=value", e.g. set from the node's labels by the
+// DaemonSet that installs the plugin.
+const NodeLabelsEnv = "KUBE_NAMESPACE_NODE_LABELS"
+
+// A variant of a network config, used on nodes matching its selector
+// terms.  Its Config is deep merged over the entry it belongs to.
+type Variant struct {
+	NodeSelectorTerms []NodeSelectorTerm     `json:"nodeSelectorTerms"`
+	Config            map[string]interface{} `json:"config"`
+}
+
+// A node selector term, as in a pod's node affinity: it matches if
+// all its expressions do.
+type NodeSelectorTerm struct {
+	MatchExpressions []NodeSelectorRequirement `json:"matchExpressions"`
+}
+
+// A requirement on a node label.  Operator is In, NotIn, Exists,
+// DoesNotExist, Gt or Lt.
+type NodeSelectorRequirement struct {
+	Key      string   `json:"key"`
+	Operator string   `json:"operator"`
+	Values   []string `json:"values"`
+}
+
+// Parse the "variants" key of a network config.
+func parseVariants(netconf map[string]interface{}) ([]Variant, error) {
+	raw, ok := netconf["variants"]
+	if !ok {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal variants: %v", err)
+	}
+
+	var variants []Variant
+	if err := json.Unmarshal(data, &variants); err != nil {
+		return nil, fmt.Errorf("Failed to parse variants: %v", err)
+	}
+
+	for i, v := range variants {
+		if len(v.NodeSelectorTerms) == 0 {
+			return nil, fmt.Errorf("Variant %d has no nodeSelectorTerms.", i)
+		}
+
+		for _, term := range v.NodeSelectorTerms {
+			for _, req := range term.MatchExpressions {
+				if err := req.validate(); err != nil {
+					return nil, fmt.Errorf("Variant %d: %v", i, err)
+				}
+			}
+		}
+	}
+
+	return variants, nil
+}
+
+func (r *NodeSelectorRequirement) validate() error {
+	switch r.Operator {
+	case "In", "NotIn":
+		if len(r.Values) == 0 {
+			return fmt.Errorf("Operator %s on %q requires values.", r.Operator, r.Key)
+		}
+	case "Exists", "DoesNotExist":
+		if len(r.Values) != 0 {
+			return fmt.Errorf("Operator %s on %q takes no values.", r.Operator, r.Key)
+		}
+	case "Gt", "Lt":
+		if len(r.Values) != 1 {
+			return fmt.Errorf("Operator %s on %q requires one value.", r.Operator, r.Key)
+		}
+		if _, err := strconv.ParseInt(r.Values[0], 10, 64); err != nil {
+			return fmt.Errorf("Operator %s on %q requires an integer.", r.Operator, r.Key)
+		}
+	default:
+		return fmt.Errorf("Unknown operator %q.", r.Operator)
+	}
+
+	return nil
+}
+
+// Return whether the node's labels meet the requirement.
+func (r *NodeSelectorRequirement) matches(labels map[string]string) bool {
+	value, ok := labels[r.Key]
+
+	switch r.Operator {
+	case "In", "NotIn":
+		in := false
+		for _, v := range r.Values {
+			if ok && v == value {
+				in = true
+			}
+		}
+		return in == (r.Operator == "In")
+	case "Exists":
+		return ok
+	case "DoesNotExist":
+		return !ok
+	case "Gt", "Lt":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if !ok || err != nil {
+			return false
+		}
+		limit, _ := strconv.ParseInt(r.Values[0], 10, 64)
+		if r.Operator == "Gt" {
+			return n > limit
+		}
+		return n < limit
+	}
+
+	return false
+}
+
+// Return whether the node's labels match any of the terms.
+func matchesTerms(terms []NodeSelectorTerm, labels map[string]string) bool {
+	for _, term := range terms {
+		matched := true
+		for _, req := range term.MatchExpressions {
+			if !req.matches(labels) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Read node labels from a file in the format of the downward API,
+// one key="value" per line, or a JSON object.
+func readNodeLabels(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read node labels: %v", err)
+	}
+
+	labels := map[string]string{}
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		if err := json.Unmarshal(data, &labels); err != nil {
+			return nil, fmt.Errorf("Failed to parse node labels %q: %v", path, err)
+		}
+		return labels, nil
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Invalid node label line %q in %q.", line, path)
+		}
+
+		value := kv[1]
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		labels[kv[0]] = value
+	}
+
+	return labels, nil
+}
+
+// Parse node labels given as "key=value,key=value".
+func parseNodeLabelsEnv(s string) map[string]string {
+	labels := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(kv), "=", 2)
+		if parts[0] == "" {
+			continue
+		}
+		if len(parts) == 2 {
+			labels[parts[0]] = parts[1]
+		} else {
+			labels[parts[0]] = ""
+		}
+	}
+
+	return labels
+}
+
+// Load the node's labels from nodeLabelsFile and the environment, the
+// environment taking precedence, and check the variants of all
+// configs.
+func (c *Config) loadVariants() error {
+	c.nodeLabels = map[string]string{}
+	if c.NodeLabelsFile != "" {
+		labels, err := readNodeLabels(c.NodeLabelsFile)
+		if err != nil {
+			return err
+		}
+		c.nodeLabels = labels
+	}
+	for k, v := range parseNodeLabelsEnv(os.Getenv(NodeLabelsEnv)) {
+		c.nodeLabels[k] = v
+	}
+
+	if _, err := parseVariants(c.Default); err != nil {
+		return fmt.Errorf("Default config: %v", err)
+	}
+
+	for namespace, netconf := range c.Namespaces {
+		if _, err := parseVariants(netconf); err != nil {
+			return fmt.Errorf("Config for namespace %q: %v", namespace, err)
+		}
+	}
+
+	return nil
+}
+
+// Merge the first variant matching the node over the selected config.
+// Either way the "variants" key is removed.
+func (c *Config) applyVariants(sel *Selection) (*Selection, error) {
+	variants, err := parseVariants(sel.NetConf)
+	if err != nil || variants == nil {
+		return sel, err
+	}
+
+	netconf := make(map[string]interface{}, len(sel.NetConf))
+	for k, v := range sel.NetConf {
+		if k != "variants" {
+			netconf[k] = v
+		}
+	}
+
+	for i, v := range variants {
+		if matchesTerms(v.NodeSelectorTerms, c.nodeLabels) {
+			c.Logger().WithFields(logrus.Fields{
+				"rule":    sel.Rule,
+				"variant": i,
+			}).Debug("Using node variant of config.")
+
+			netconf = DeepMerge(netconf, v.Config)
+			break
+		}
+	}
+
+	variantSel := *sel
+	variantSel.NetConf = netconf
+	return &variantSel, nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const variantsConfig = `{
+  "nodeLabelsFile": "%s",
+  "namespaces": {
+    "storage": {
+      "name": "storage",
+      "type": "bridge",
+      "ipam": {"type": "host-local", "subnet": "10.3.0.0/16"},
+      "variants": [
+        {
+          "nodeSelectorTerms": [{"matchExpressions": [{"key": "node.kubernetes.io/instance-type", "operator": "In", "values": ["metal"]}]}],
+          "config": {"type": "sriov", "device": "ens1f0"}
+        }
+      ]
+    }
+  }
+}`
+
+// Pick the variant matching the node's labels.
+func TestVariants(t *testing.T) {
+	f, err := ioutil.TempFile("", "kube-namespace-labels")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	parse := func(labels string) *Config {
+		assert.NoError(t, ioutil.WriteFile(f.Name(), []byte(labels), 0644))
+		c, err := Parse([]byte(fmt.Sprintf(variantsConfig, f.Name())))
+		if err != nil {
+			t.Fatalf("Failed to parse config: %v", err)
+		}
+		return c
+	}
+
+	sel, err := parse(`node.kubernetes.io/instance-type="metal"` + "\n").Select("K8S_POD_NAMESPACE=storage")
+	assert.NoError(t, err)
+	assert.Equal(t, "sriov", sel.NetConf["type"])
+	assert.Equal(t, "ens1f0", sel.NetConf["device"])
+	assert.NotNil(t, sel.NetConf["ipam"])
+	assert.NotContains(t, sel.NetConf, "variants")
+
+	sel, err = parse(`{"node.kubernetes.io/instance-type": "vm"}`).Select("K8S_POD_NAMESPACE=storage")
+	assert.NoError(t, err)
+	assert.Equal(t, "bridge", sel.NetConf["type"])
+	assert.NotContains(t, sel.NetConf, "variants")
+
+	os.Setenv(NodeLabelsEnv, "node.kubernetes.io/instance-type=metal")
+	defer os.Unsetenv(NodeLabelsEnv)
+	sel, err = parse("").Select("K8S_POD_NAMESPACE=storage")
+	assert.NoError(t, err)
+	assert.Equal(t, "sriov", sel.NetConf["type"])
+}
+
+// Match node selector requirements like Kubernetes does.
+func TestNodeSelectorRequirement(t *testing.T) {
+	labels := map[string]string{"zone": "a", "cores": "64"}
+
+	for _, c := range []struct {
+		req   NodeSelectorRequirement
+		match bool
+	}{
+		{NodeSelectorRequirement{"zone", "In", []string{"a", "b"}}, true},
+		{NodeSelectorRequirement{"zone", "NotIn", []string{"a"}}, false},
+		{NodeSelectorRequirement{"rack", "NotIn", []string{"a"}}, true},
+		{NodeSelectorRequirement{"zone", "Exists", nil}, true},
+		{NodeSelectorRequirement{"rack", "DoesNotExist", nil}, true},
+		{NodeSelectorRequirement{"cores", "Gt", []string{"32"}}, true},
+		{NodeSelectorRequirement{"cores", "Lt", []string{"32"}}, false},
+	} {
+		assert.NoError(t, c.req.validate())
+		assert.Equal(t, c.match, c.req.matches(labels), "%+v", c.req)
+	}
+
+	bad := NodeSelectorRequirement{"zone", "Near", nil}
+	assert.Error(t, bad.validate())
+}
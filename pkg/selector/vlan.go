@@ -0,0 +1,101 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// VLAN map modes.
+const (
+	// Attach pods with macvlan to a VLAN subinterface of the master.
+	VLANModeMacvlan = "macvlan"
+	// Attach pods to a VLAN-filtering bridge, tagging their port.
+	VLANModeBridge = "bridge"
+)
+
+// The top-level "vlanMap" block: a VLAN ID per namespace, from which
+// kube-namespace generates the delegate config.
+type VLANMap struct {
+	Mode string `json:"mode"`
+	// The host uplink, for macvlan mode.
+	Master     string         `json:"master"`
+	Namespaces map[string]int `json:"namespaces"`
+}
+
+// Validate the VLAN map.
+func (m *VLANMap) validate() error {
+	switch m.Mode {
+	case VLANModeMacvlan:
+		if m.Master == "" {
+			return fmt.Errorf("vlanMap in macvlan mode requires a master.")
+		}
+	case VLANModeBridge:
+	default:
+		return fmt.Errorf("Unknown vlanMap mode %q.", m.Mode)
+	}
+
+	for namespace, vid := range m.Namespaces {
+		if vid < 1 || vid > 4094 {
+			return fmt.Errorf("Invalid VLAN ID %d for namespace %q.", vid, namespace)
+		}
+	}
+
+	return nil
+}
+
+// Return the name of the VLAN subinterface of the master for a VLAN.
+func (m *VLANMap) Subinterface(vid int) string {
+	name := fmt.Sprintf("%s.%d", m.Master, vid)
+	if len(name) > 15 {
+		name = fmt.Sprintf("knvlan%d", vid)
+	}
+
+	return name
+}
+
+// Turn the selected config into one for the namespace's VLAN.  The
+// config is copied, so the parsed config is left as-is.
+func (m *VLANMap) apply(log *logrus.Entry, sel *Selection) *Selection {
+	vid, ok := m.Namespaces[sel.Namespace]
+	if !ok {
+		return sel
+	}
+
+	netconf := make(map[string]interface{}, len(sel.NetConf)+2)
+	for k, v := range sel.NetConf {
+		netconf[k] = v
+	}
+
+	switch m.Mode {
+	case VLANModeMacvlan:
+		netconf["type"] = "macvlan"
+		netconf["master"] = m.Subinterface(vid)
+	case VLANModeBridge:
+		netconf["type"] = "bridge"
+		netconf["vlan"] = vid
+	}
+
+	log.WithFields(logrus.Fields{
+		"vlan": vid,
+		"mode": m.Mode,
+	}).Debug("Using namespace VLAN.")
+
+	vlanSel := *sel
+	vlanSel.NetConf = netconf
+	return &vlanSel
+}
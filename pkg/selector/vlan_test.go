@@ -0,0 +1,55 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Generate macvlan and bridge configs from the VLAN map.
+func TestVLANMap(t *testing.T) {
+	config, err := Parse([]byte(`{
+	  "vlanMap": {"mode": "macvlan", "master": "eth0", "namespaces": {"tenant-a": 100}},
+	  "default": {"name": "default", "type": "bridge", "ipam": {"type": "dhcp"}}
+	}`))
+	assert.NoError(t, err)
+
+	sel, err := config.Select("K8S_POD_NAMESPACE=tenant-a")
+	assert.NoError(t, err)
+	assert.Equal(t, "macvlan", sel.NetConf["type"])
+	assert.Equal(t, "eth0.100", sel.NetConf["master"])
+	assert.Equal(t, "bridge", config.Default["type"])
+
+	sel, err = config.Select("K8S_POD_NAMESPACE=other")
+	assert.NoError(t, err)
+	assert.Equal(t, "bridge", sel.NetConf["type"])
+
+	m := &VLANMap{Mode: VLANModeBridge, Namespaces: map[string]int{"tenant-a": 100}}
+	sel = m.apply(Log, &Selection{Namespace: "tenant-a", NetConf: map[string]interface{}{"type": "macvlan"}})
+	assert.Equal(t, "bridge", sel.NetConf["type"])
+	assert.Equal(t, 100, sel.NetConf["vlan"])
+}
+
+// Reject bad VLAN maps, and keep subinterface names short.
+func TestVLANMapValidate(t *testing.T) {
+	assert.Error(t, (&VLANMap{Mode: VLANModeMacvlan}).validate())
+	assert.Error(t, (&VLANMap{Mode: VLANModeBridge, Namespaces: map[string]int{"a": 4095}}).validate())
+	assert.Error(t, (&VLANMap{Mode: "vxlan"}).validate())
+
+	m := &VLANMap{Master: "enp0s31f6long"}
+	assert.Equal(t, "knvlan100", m.Subinterface(100))
+}
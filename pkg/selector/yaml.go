@@ -0,0 +1,452 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selector
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A decoder for the subset of YAML that network configs need: block
+// mappings and sequences, flow [...] and {...} collections, plain and
+// quoted scalars, and comments.  Anchors, aliases, tags, block scalars
+// and multiple documents are rejected rather than misread.
+
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+type yamlDecoder struct {
+	lines []yamlLine
+	pos   int
+}
+
+// Decode a YAML document into the values encoding/json would produce.
+func decodeYAML(data []byte) (interface{}, error) {
+	d := &yamlDecoder{}
+	if err := d.split(data); err != nil {
+		return nil, err
+	}
+	if len(d.lines) == 0 {
+		return nil, nil
+	}
+
+	// A document may be a lone flow collection or scalar.
+	if text := d.lines[0].text; len(d.lines) == 1 && !isSequenceItem(text) {
+		if _, _, ok := splitMappingLine(text); !ok {
+			value, err := yamlScalar(text)
+			if err != nil {
+				return nil, d.errorf("%v", err)
+			}
+			return value, nil
+		}
+	}
+
+	value, err := d.block(d.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos < len(d.lines) {
+		return nil, d.errorf("unexpected indentation")
+	}
+
+	return value, nil
+}
+
+// Split data into significant lines, without comments.
+func (d *yamlDecoder) split(data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	documents := 0
+	for number := 1; scanner.Scan(); number++ {
+		raw := strings.TrimRight(scanner.Text(), " \t\r")
+		text := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(text, "\t") {
+			return fmt.Errorf("line %d: tabs are not allowed in indentation", number)
+		}
+
+		text = strings.TrimSpace(stripComment(text, "#"))
+		switch {
+		case text == "":
+			continue
+		case text == "---" && len(d.lines) == 0:
+			documents++
+			if documents > 1 {
+				return fmt.Errorf("line %d: multiple documents are not supported", number)
+			}
+			continue
+		case text == "---" || text == "...":
+			return fmt.Errorf("line %d: multiple documents are not supported", number)
+		}
+
+		d.lines = append(d.lines, yamlLine{number, len(raw) - len(strings.TrimLeft(raw, " ")), text})
+	}
+
+	return scanner.Err()
+}
+
+func (d *yamlDecoder) errorf(format string, args ...interface{}) error {
+	number := 0
+	if d.pos < len(d.lines) {
+		number = d.lines[d.pos].number
+	} else if len(d.lines) > 0 {
+		number = d.lines[len(d.lines)-1].number
+	}
+
+	return fmt.Errorf("line %d: %s", number, fmt.Sprintf(format, args...))
+}
+
+// Decode the mapping or sequence whose lines start at indent.
+func (d *yamlDecoder) block(indent int) (interface{}, error) {
+	if isSequenceItem(d.lines[d.pos].text) {
+		return d.sequence(indent)
+	}
+
+	return d.mapping(indent)
+}
+
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (d *yamlDecoder) sequence(indent int) (interface{}, error) {
+	items := []interface{}{}
+	for d.pos < len(d.lines) && d.lines[d.pos].indent == indent && isSequenceItem(d.lines[d.pos].text) {
+		line := d.lines[d.pos]
+		rest := strings.TrimSpace(strings.TrimPrefix(line.text, "-"))
+
+		if rest == "" {
+			d.pos++
+			item, err := d.nested(indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+
+		// "- - item" starts a sequence indented past the first dash,
+		// and is checked first, as "- a: b" would pass for a key.
+		if isSequenceItem(rest) {
+			d.lines[d.pos] = yamlLine{line.number, indent + len(line.text) - len(rest), rest}
+			item, err := d.sequence(d.lines[d.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+
+		if key, _, ok := splitMappingLine(rest); ok && key != "" {
+			// "- key: value" starts a mapping indented past the dash.
+			d.lines[d.pos] = yamlLine{line.number, indent + len(line.text) - len(rest), rest}
+			item, err := d.mapping(d.lines[d.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+
+		item, err := yamlScalar(rest)
+		if err != nil {
+			return nil, d.errorf("%v", err)
+		}
+		items = append(items, item)
+		d.pos++
+	}
+
+	return items, nil
+}
+
+func (d *yamlDecoder) mapping(indent int) (interface{}, error) {
+	m := map[string]interface{}{}
+	for d.pos < len(d.lines) && d.lines[d.pos].indent == indent && !isSequenceItem(d.lines[d.pos].text) {
+		key, rest, ok := splitMappingLine(d.lines[d.pos].text)
+		if !ok {
+			return nil, d.errorf("expected \"key: value\"")
+		}
+		if _, dup := m[key]; dup {
+			return nil, d.errorf("duplicate key %q", key)
+		}
+		d.pos++
+
+		if rest != "" {
+			value, err := yamlScalar(rest)
+			if err != nil {
+				d.pos--
+				return nil, d.errorf("%v", err)
+			}
+			m[key] = value
+			continue
+		}
+
+		// A sequence may sit at the same indentation as its key.
+		if d.pos < len(d.lines) && d.lines[d.pos].indent == indent && isSequenceItem(d.lines[d.pos].text) {
+			value, err := d.sequence(indent)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = value
+			continue
+		}
+
+		value, err := d.nested(indent)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+
+	return m, nil
+}
+
+// Decode the block indented past indent, if any, or null.
+func (d *yamlDecoder) nested(indent int) (interface{}, error) {
+	if d.pos >= len(d.lines) || d.lines[d.pos].indent <= indent {
+		return nil, nil
+	}
+
+	return d.block(d.lines[d.pos].indent)
+}
+
+// Split "key: value" into its key and value.
+func splitMappingLine(text string) (key, rest string, ok bool) {
+	if text != "" && (text[0] == '"' || text[0] == '\'') {
+		end := quotedEnd(text)
+		if end < 0 {
+			return "", "", false
+		}
+		after := text[end:]
+		if !strings.HasPrefix(after, ":") || (len(after) > 1 && after[1] != ' ') {
+			return "", "", false
+		}
+		k, err := yamlQuoted(text[:end])
+		if err != nil {
+			return "", "", false
+		}
+		return k, strings.TrimSpace(after[1:]), true
+	}
+
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			key = strings.TrimSpace(text[:i])
+			if key == "" || strings.ContainsAny(key[:1], "[{") {
+				return "", "", false
+			}
+			return key, strings.TrimSpace(text[i+1:]), true
+		}
+	}
+
+	return "", "", false
+}
+
+// Return the index just past the quoted string text starts with, or
+// -1 if it is not terminated.
+func quotedEnd(text string) int {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case text[i] == quote && quote == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == quote:
+			return i + 1
+		}
+	}
+
+	return -1
+}
+
+// Return text up to the first comment starting with marker, ignoring
+// markers inside quoted strings.
+func stripComment(text, marker string) string {
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '"' || text[i] == '\'':
+			end := quotedEnd(text[i:])
+			if end < 0 {
+				return text
+			}
+			i += end - 1
+		case strings.HasPrefix(text[i:], marker) && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return text[:i]
+		}
+	}
+
+	return text
+}
+
+// Decode a scalar or flow collection.
+func yamlScalar(text string) (interface{}, error) {
+	switch text[0] {
+	case '&', '*', '!':
+		return nil, fmt.Errorf("anchors, aliases and tags are not supported")
+	case '|', '>':
+		return nil, fmt.Errorf("block scalars are not supported")
+	case '[', '{':
+		f := &yamlFlow{text: text}
+		value, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		if f.skipSpace(); f.pos < len(f.text) {
+			return nil, fmt.Errorf("unexpected %q after flow collection", f.text[f.pos:])
+		}
+		return value, nil
+	case '"', '\'':
+		if quotedEnd(text) != len(text) {
+			return nil, fmt.Errorf("unterminated or trailing characters in %s", text)
+		}
+		return yamlQuoted(text)
+	}
+
+	return yamlPlain(text), nil
+}
+
+// Decode a single- or double-quoted string.
+func yamlQuoted(text string) (string, error) {
+	if text[0] == '\'' {
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1), nil
+	}
+
+	var s string
+	if err := json.Unmarshal([]byte(text), &s); err != nil {
+		return "", fmt.Errorf("bad string %s: %v", text, err)
+	}
+
+	return s, nil
+}
+
+// Decode a plain scalar: null, a boolean, a number or a string.
+func yamlPlain(text string) interface{} {
+	switch text {
+	case "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil && !strings.ContainsAny(text, "xXnN") {
+		return f
+	}
+
+	return text
+}
+
+// A decoder for flow collections, e.g. [a, b] or {a: 1, b: [2]}.
+type yamlFlow struct {
+	text string
+	pos  int
+}
+
+func (f *yamlFlow) skipSpace() {
+	for f.pos < len(f.text) && f.text[f.pos] == ' ' {
+		f.pos++
+	}
+}
+
+func (f *yamlFlow) value() (interface{}, error) {
+	f.skipSpace()
+	if f.pos >= len(f.text) {
+		return nil, fmt.Errorf("unterminated flow collection")
+	}
+
+	switch c := f.text[f.pos]; c {
+	case '[':
+		f.pos++
+		items := []interface{}{}
+		for {
+			if f.skipSpace(); f.pos < len(f.text) && f.text[f.pos] == ']' {
+				f.pos++
+				return items, nil
+			}
+			item, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			if err := f.separator(']'); err != nil {
+				return nil, err
+			}
+		}
+	case '{':
+		f.pos++
+		m := map[string]interface{}{}
+		for {
+			if f.skipSpace(); f.pos < len(f.text) && f.text[f.pos] == '}' {
+				f.pos++
+				return m, nil
+			}
+			key, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			if f.skipSpace(); f.pos >= len(f.text) || f.text[f.pos] != ':' {
+				return nil, fmt.Errorf("expected \":\" in flow mapping")
+			}
+			f.pos++
+			value, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(key)] = value
+			if err := f.separator('}'); err != nil {
+				return nil, err
+			}
+		}
+	case '"', '\'':
+		end := quotedEnd(f.text[f.pos:])
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated string")
+		}
+		s, err := yamlQuoted(f.text[f.pos : f.pos+end])
+		f.pos += end
+		return s, err
+	}
+
+	start := f.pos
+	for f.pos < len(f.text) && !strings.ContainsRune(",]}", rune(f.text[f.pos])) &&
+		!(f.text[f.pos] == ':' && (f.pos+1 == len(f.text) || f.text[f.pos+1] == ' ')) {
+		f.pos++
+	}
+
+	return yamlPlain(strings.TrimSpace(f.text[start:f.pos])), nil
+}
+
+// Consume the "," between items, or leave the closing bracket.
+func (f *yamlFlow) separator(closing byte) error {
+	f.skipSpace()
+	switch {
+	case f.pos < len(f.text) && f.text[f.pos] == ',':
+		f.pos++
+		return nil
+	case f.pos < len(f.text) && f.text[f.pos] == closing:
+		return nil
+	}
+
+	return fmt.Errorf("expected \",\" or %q in flow collection", closing)
+}
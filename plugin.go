@@ -0,0 +1,96 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/containernetworking/cni/pkg/version"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Parts of the plugin shared by the Linux build and the one for
+// Windows and other platforms in windows.go.
+
+var log = logrus.NewEntry(logrus.New())
+
+// The config versions kube-namespace accepts.  Configs of version 0.3
+// may come from a plugin chain (.conflist), and get 0.3 results.
+var supportedVersions = version.PluginSupports("0.1.0", "0.2.0", "0.3.0", "0.3.1")
+
+// Set the log level from the config's log_level, if given.
+func (c *config) setLogLevel() {
+	if c.LogLevel == "" {
+		return
+	}
+
+	if logLevel, err := logrus.ParseLevel(c.LogLevel); err != nil {
+		c.Logger().Error("Unknown log level. Using default: INFO")
+	} else {
+		c.Logger().Logger.Level = logLevel
+	}
+}
+
+// Return a random ID for a plugin invocation, logged with each of its
+// lines so that they can be told apart from those of other
+// invocations for the same container.
+func newRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Keys of a network config that are handled by kube-namespace itself
+// and are not passed on to the delegate plugin.
+var pluginKeys = []string{"sysctls", "bandwidth", "vrf", "egressRules", "allowFrom", "tenant", "mirror", "mirrorTo", "gateways", "retries", "retryBackoff", "hostTuning", "frozen", "probe", "hostPorts", "requirePrivilegedPods", "privilegedServiceAccounts", "canary", "variants", "additionalIPs", "announceAddresses", "registerDNS", "maxAttachments", "networkless", "ipv6Only", "stickyIP", "interfaceNames", "ipMasqExcludeCIDRs", "tier", "publishRoutes", "sriovPool", "resultTransforms", "ipamStore", "readinessFile", "hostDevice", "dhcp", "verifyConnectivity", "deprecation"}
+
+// Decode the value of key in a network config into v.  Returns false
+// if the key is not present.
+func decodeNetConfKey(netconf map[string]interface{}, key string, v interface{}) (bool, error) {
+	raw, ok := netconf[key]
+	if !ok {
+		return false, nil
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return true, fmt.Errorf("Failed to marshal %q config: %v", key, err)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return true, fmt.Errorf("Failed to parse %q config: %v", key, err)
+	}
+
+	return true, nil
+}
+
+// Return a copy of the network config with kube-namespace's own keys
+// removed, suitable for passing to the delegate.
+func delegateNetConf(netconf map[string]interface{}) map[string]interface{} {
+	delegated := make(map[string]interface{}, len(netconf))
+	for k, v := range netconf {
+		delegated[k] = v
+	}
+
+	for _, k := range pluginKeys {
+		delete(delegated, k)
+	}
+
+	return delegated
+}
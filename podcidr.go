@@ -0,0 +1,128 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+)
+
+// An address range, in the format of the ipRanges capability.
+type ipRange struct {
+	Subnet     string `json:"subnet"`
+	RangeStart string `json:"rangeStart,omitempty"`
+	RangeEnd   string `json:"rangeEnd,omitempty"`
+	Gateway    string `json:"gateway,omitempty"`
+}
+
+// Return the values network configs can reference from the ipRanges
+// passed by the runtime: the first IPv4 and IPv6 subnets, as
+// "PodCIDR" and "PodCIDRv6".  Only those present are set.
+func podCIDRValues(ranges [][]ipRange) map[string]string {
+	values := map[string]string{}
+	for _, set := range ranges {
+		for _, r := range set {
+			ip, _, err := net.ParseCIDR(r.Subnet)
+			if err != nil {
+				continue
+			}
+
+			key := "PodCIDR"
+			if ip.To4() == nil {
+				key = "PodCIDRv6"
+			}
+			if _, ok := values[key]; !ok {
+				values[key] = r.Subnet
+			}
+		}
+	}
+
+	return values
+}
+
+// Expand templates in the string values of a network config,
+// recursively.  Returns whether anything was expanded.
+func expandTemplates(v interface{}, values map[string]string) (interface{}, bool, error) {
+	switch v := v.(type) {
+	case string:
+		if !strings.Contains(v, "{{") {
+			return v, false, nil
+		}
+
+		tmpl, err := template.New("").Option("missingkey=error").Parse(v)
+		if err != nil {
+			return nil, false, fmt.Errorf("Invalid template %q: %v", v, err)
+		}
+
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, values); err != nil {
+			return nil, false, fmt.Errorf("Failed to expand %q; the runtime passed no matching ipRanges: %v", v, err)
+		}
+		return out.String(), true, nil
+
+	case map[string]interface{}:
+		expanded := make(map[string]interface{}, len(v))
+		changed := false
+		for k, value := range v {
+			e, c, err := expandTemplates(value, values)
+			if err != nil {
+				return nil, false, err
+			}
+			expanded[k], changed = e, changed || c
+		}
+		if !changed {
+			return v, false, nil
+		}
+		return expanded, true, nil
+
+	case []interface{}:
+		expanded := make([]interface{}, len(v))
+		changed := false
+		for i, value := range v {
+			e, c, err := expandTemplates(value, values)
+			if err != nil {
+				return nil, false, err
+			}
+			expanded[i], changed = e, changed || c
+		}
+		if !changed {
+			return v, false, nil
+		}
+		return expanded, true, nil
+	}
+
+	return v, false, nil
+}
+
+// Expand references to the node's pod CIDR, e.g.
+// "subnet": "{{ .PodCIDR }}", in the selected config, from the
+// ipRanges the runtime passed.  The selection is copied if anything
+// is expanded.
+func (c *config) expandPodCIDR(sel *selection) (*selection, error) {
+	expanded, changed, err := expandTemplates(sel.NetConf, podCIDRValues(c.RuntimeConfig.IPRanges))
+	if err != nil || !changed {
+		return sel, err
+	}
+
+	expandedSel := *sel
+	expandedSel.NetConf = expanded.(map[string]interface{})
+	return &expandedSel, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Fill in the pod CIDRs passed as ipRanges.
+func TestExpandPodCIDR(t *testing.T) {
+	config, err := parseConfig([]byte(`{
+	  "runtimeConfig": {"ipRanges": [[{"subnet": "10.1.7.0/24"}], [{"subnet": "fd00:7::/64"}]]},
+	  "namespaces": {
+	    "web": {
+	      "name": "web",
+	      "type": "bridge",
+	      "ipam": {
+	        "type": "host-local",
+	        "ranges": [[{"subnet": "{{ .PodCIDR }}"}], [{"subnet": "{{ .PodCIDRv6 }}"}]]
+	      }
+	    },
+	    "static": {"name": "static", "type": "bridge"}
+	  }
+	}`))
+	assert.NoError(t, err)
+
+	sel, err := config.Select("K8S_POD_NAMESPACE=web")
+	assert.NoError(t, err)
+	expanded, err := config.expandPodCIDR(sel)
+	assert.NoError(t, err)
+
+	ranges := expanded.NetConf["ipam"].(map[string]interface{})["ranges"].([]interface{})
+	assert.Equal(t, "10.1.7.0/24", ranges[0].([]interface{})[0].(map[string]interface{})["subnet"])
+	assert.Equal(t, "fd00:7::/64", ranges[1].([]interface{})[0].(map[string]interface{})["subnet"])
+
+	// The parsed config is left as-is.
+	sel, err = config.Select("K8S_POD_NAMESPACE=web")
+	assert.NoError(t, err)
+	ranges = sel.NetConf["ipam"].(map[string]interface{})["ranges"].([]interface{})
+	assert.Equal(t, "{{ .PodCIDR }}", ranges[0].([]interface{})[0].(map[string]interface{})["subnet"])
+
+	// Configs without templates are not copied.
+	sel, err = config.Select("K8S_POD_NAMESPACE=static")
+	assert.NoError(t, err)
+	expanded, err = config.expandPodCIDR(sel)
+	assert.NoError(t, err)
+	assert.True(t, sel == expanded)
+
+	// Without ipRanges, referencing the pod CIDR fails.
+	config.RuntimeConfig.IPRanges = nil
+	sel, err = config.Select("K8S_POD_NAMESPACE=web")
+	assert.NoError(t, err)
+	_, err = config.expandPodCIDR(sel)
+	assert.Error(t, err)
+}
@@ -0,0 +1,163 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/coreos/kube-namespace-cni/pkg/preview"
+)
+
+// Return the delegate config each configured namespace would get, and
+// the default config under preview.DefaultKey.
+func (c *config) render() (preview.Rendering, error) {
+	rendering := preview.Rendering{}
+	if len(c.Default) > 0 {
+		if !c.AllowsDelegate(c.Default) {
+			return nil, fmt.Errorf("Default config: delegate type %q not in allowedDelegateTypes.", c.Default["type"])
+		}
+		rendering[preview.DefaultKey] = delegateNetConf(c.Default)
+	}
+
+	var namespaces []string
+	for ns := range c.Namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	overridden := map[string]bool{}
+	for ns := range c.MTUOverrides {
+		overridden[ns] = true
+	}
+	if c.VLANMap != nil {
+		for ns := range c.VLANMap.Namespaces {
+			overridden[ns] = true
+		}
+	}
+	if c.IPvlanMap != nil {
+		for ns := range c.IPvlanMap.Namespaces {
+			overridden[ns] = true
+		}
+	}
+	for ns := range overridden {
+		if _, ok := c.Namespaces[ns]; !ok && len(c.Default) > 0 {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	namespaces = append(namespaces, c.SystemNamespaces...)
+
+	for _, ns := range namespaces {
+		sel, err := c.Select(kubeArgs(ns, ""))
+		if err != nil {
+			return nil, fmt.Errorf("Namespace %q: %v", ns, err)
+		}
+
+		if _, err := parseNetOptions(sel.NetConf); err != nil {
+			return nil, fmt.Errorf("Namespace %q: %v", ns, err)
+		}
+		rendering[ns] = delegateNetConf(sel.NetConf)
+	}
+
+	return rendering, nil
+}
+
+// Read and render the config in the file named by path.
+func renderConfig(path string, stdin io.Reader) (preview.Rendering, error) {
+	config, err := readConfig(path, stdin)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.NamespacesError(); err != nil {
+		return nil, err
+	}
+
+	return config.render()
+}
+
+// Validate a proposed config and report which namespaces it changes
+// relative to a base config.
+func cmdPreview(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("preview", flag.ContinueOnError)
+	configPath := flags.String("config", "", "proposed plugin config file (default stdin)")
+	basePath := flags.String("base", "", "current plugin config file")
+	expect := flags.String("expect", "", "comma-separated namespaces the change may affect, \"*\" for the default")
+	output := flags.String("output", "text", "output format: text or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("Unknown output format %q.", *output)
+	}
+
+	base := preview.Rendering{}
+	if *basePath != "" {
+		var err error
+		if base, err = renderConfig(*basePath, nil); err != nil {
+			return fmt.Errorf("Invalid base config: %v", err)
+		}
+	}
+
+	report := &preview.Report{Valid: true}
+	proposed, err := renderConfig(*configPath, stdin)
+	if err != nil {
+		report.Valid = false
+		report.Errors = []string{err.Error()}
+		proposed = preview.Rendering{}
+	}
+	report.Changes = preview.Diff(base, proposed)
+	if *expect != "" {
+		report.Check(strings.Split(*expect, ","))
+	}
+
+	if *output == "json" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s\n", data)
+	} else {
+		printReport(report, stdout)
+	}
+
+	if !report.OK() {
+		return errors.New("Preview failed.")
+	}
+	return nil
+}
+
+// Print a report for humans.
+func printReport(report *preview.Report, w io.Writer) {
+	for _, e := range report.Errors {
+		fmt.Fprintf(w, "error: %s\n", e)
+	}
+
+	for _, c := range report.Changes {
+		if c.Kind == preview.Changed {
+			fmt.Fprintf(w, "%s %s: %s\n", c.Kind, c.Namespace, strings.Join(c.Fields, ", "))
+		} else {
+			fmt.Fprintf(w, "%s %s\n", c.Kind, c.Namespace)
+		}
+	}
+
+	for _, ns := range report.Unexpected {
+		fmt.Fprintf(w, "unexpected change to %s\n", ns)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/kube-namespace-cni/pkg/preview"
+	"github.com/stretchr/testify/assert"
+)
+
+const previewBase = `{
+  "name": "kube-namespace",
+  "type": "kube-namespace",
+  "default": {"name": "default", "type": "bridge"},
+  "namespaces": {
+    "a": {"name": "a", "type": "bridge", "mtu": 1500},
+    "b": {"name": "b", "type": "bridge"}
+  }
+}`
+
+// Report the namespaces a proposed config changes, as JSON.
+func TestCmdPreviewJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-preview")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "base.json")
+	assert.NoError(t, ioutil.WriteFile(base, []byte(previewBase), 0644))
+
+	proposed := strings.Replace(previewBase, "1500", "1400", 1)
+	stdout := &bytes.Buffer{}
+	err = cmdPreview([]string{"--base", base, "--expect", "a", "--output", "json"},
+		strings.NewReader(proposed), stdout)
+	assert.NoError(t, err)
+
+	report := &preview.Report{}
+	assert.NoError(t, json.Unmarshal(stdout.Bytes(), report))
+	assert.True(t, report.Valid)
+	assert.Len(t, report.Changes, 1)
+	assert.Equal(t, "a", report.Changes[0].Namespace)
+	assert.Equal(t, []string{"mtu"}, report.Changes[0].Fields)
+
+	err = cmdPreview([]string{"--base", base, "--expect", "b"},
+		strings.NewReader(proposed), &bytes.Buffer{})
+	assert.Error(t, err)
+}
+
+// Report an invalid proposed config.
+func TestCmdPreviewInvalid(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	err := cmdPreview([]string{"--output", "json"}, strings.NewReader(`{"systemNamespaces": ["kube-system"]}`), stdout)
+	assert.Error(t, err)
+
+	report := &preview.Report{}
+	assert.NoError(t, json.Unmarshal(stdout.Bytes(), report))
+	assert.False(t, report.Valid)
+	assert.NotEmpty(t, report.Errors)
+}
+
+// Report a default config whose delegate type is not allowed.
+func TestRenderDisallowedDefault(t *testing.T) {
+	config, err := parseConfig([]byte(`{"allowedDelegateTypes": ["ptp"], "default": {"name": "d", "type": "bridge"}}`))
+	assert.NoError(t, err)
+
+	_, err = config.render()
+	assert.Error(t, err)
+}
@@ -0,0 +1,132 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// The pod annotation granting a pod the use of a privileged network.
+const networkGrantAnnotation = "kube-namespace.coreos.com/network-grant"
+
+// The guard on a high-privilege network config, such as one on a host
+// VLAN or with SR-IOV devices, set by "requirePrivilegedPods" and
+// "privilegedServiceAccounts".  Only pods running as one of the
+// service accounts, or carrying a grant signed with the grant key,
+// may use it, so that tenants cannot select themselves into it.
+type privilegeGuard struct {
+	// Service accounts, as "name" for the pod's own namespace or
+	// "namespace:name".
+	ServiceAccounts []string
+}
+
+// Parse the privilege guard of a network config.  Returns nil unless
+// "requirePrivilegedPods" is true.
+func parsePrivilegeGuard(netconf map[string]interface{}) (*privilegeGuard, error) {
+	var required bool
+	if _, err := decodeNetConfKey(netconf, "requirePrivilegedPods", &required); err != nil || !required {
+		return nil, err
+	}
+
+	g := &privilegeGuard{}
+	if _, err := decodeNetConfKey(netconf, "privilegedServiceAccounts", &g.ServiceAccounts); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// Return the grant for a network in a namespace: a hex HMAC-SHA256
+// of "<namespace>/<network>" keyed with the grant key.  Grants are
+// per namespace, so that pods created by controllers can carry them.
+func networkGrant(key []byte, namespace, network string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(namespace + "/" + network))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Return whether the pod may use the guarded network.  key is the
+// grant key, or nil if grants are not configured.
+func (g *privilegeGuard) permits(pod *kubePod, network string, key []byte) bool {
+	for _, sa := range g.ServiceAccounts {
+		namespace, name := pod.Metadata.Namespace, sa
+		if i := strings.Index(sa, ":"); i >= 0 {
+			namespace, name = sa[:i], sa[i+1:]
+		}
+
+		if namespace == pod.Metadata.Namespace && name == pod.Spec.ServiceAccountName {
+			return true
+		}
+	}
+
+	grant := pod.Metadata.Annotations[networkGrantAnnotation]
+	if len(key) == 0 || grant == "" {
+		return false
+	}
+
+	return hmac.Equal([]byte(grant), []byte(networkGrant(key, pod.Metadata.Namespace, network)))
+}
+
+// Refuse the selected network to a pod that the guard does not permit.
+// The pod is looked up in the Kubernetes API.
+func (c *config) checkPrivileged(g *privilegeGuard, sel *selection) error {
+	network, _ := sel.NetConf["name"].(string)
+
+	if sel.Namespace == "" || sel.Pod == "" {
+		return newError(errCodePodNotPermitted, "Network %q requires a privileged pod, and the pod is unknown.", network)
+	}
+
+	client, err := c.Kubernetes.client()
+	if err != nil {
+		return err
+	}
+
+	pod, err := client.getPod(sel.Namespace, sel.Pod)
+	if err != nil {
+		return err
+	}
+
+	var key []byte
+	if c.GrantKeyFile != "" {
+		if key, err = ioutil.ReadFile(c.GrantKeyFile); err != nil {
+			return fmt.Errorf("Failed to read grant key: %v", err)
+		}
+		key = bytes.TrimSpace(key)
+	}
+
+	if !g.permits(pod, network, key) {
+		c.Logger().WithFields(logrus.Fields{
+			"namespace":      sel.Namespace,
+			"pod":            sel.Pod,
+			"serviceAccount": pod.Spec.ServiceAccountName,
+			"network":        network,
+		}).Warn("Refusing privileged network to pod.")
+		return newError(errCodePodNotPermitted, "Pod %q in namespace %q may not use privileged network %q.",
+			sel.Pod, sel.Namespace, network)
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+	"github.com/stretchr/testify/assert"
+)
+
+// Permit pods by service account or signed grant.
+func TestPrivilegeGuardPermits(t *testing.T) {
+	g, err := parsePrivilegeGuard(map[string]interface{}{
+		"requirePrivilegedPods":     true,
+		"privilegedServiceAccounts": []interface{}{"storage-agent", "kube-system:csi"},
+	})
+	assert.NoError(t, err)
+
+	pod := &kubePod{}
+	pod.Metadata.Namespace = "tenant-a"
+
+	pod.Spec.ServiceAccountName = "storage-agent"
+	assert.True(t, g.permits(pod, "storage", nil))
+
+	pod.Spec.ServiceAccountName = "csi"
+	assert.False(t, g.permits(pod, "storage", nil))
+
+	key := []byte("key")
+	pod.Metadata.Annotations = map[string]string{networkGrantAnnotation: networkGrant(key, "tenant-a", "storage")}
+	assert.True(t, g.permits(pod, "storage", key))
+	assert.False(t, g.permits(pod, "other", key))
+	assert.False(t, g.permits(pod, "storage", nil))
+
+	g, err = parsePrivilegeGuard(map[string]interface{}{"requirePrivilegedPods": false})
+	assert.NoError(t, err)
+	assert.Nil(t, g)
+}
+
+// Refuse a privileged network to a pod with the wrong service account.
+func TestCheckPrivileged(t *testing.T) {
+	k, cleanup := fakeKubeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"metadata": {"name": "web-1", "namespace": "tenant-a"}, "spec": {"serviceAccountName": "default"}}`)
+	})
+	defer cleanup()
+
+	config, err := parseConfig([]byte(`{
+	  "namespaces": {
+	    "tenant-a": {"name": "storage", "type": "macvlan", "requirePrivilegedPods": true, "privilegedServiceAccounts": ["storage-agent"]}
+	  }
+	}`))
+	assert.NoError(t, err)
+	config.Kubernetes = k
+
+	sel, err := config.Select("K8S_POD_NAMESPACE=tenant-a;K8S_POD_NAME=web-1")
+	assert.NoError(t, err)
+	options, err := parseNetOptions(sel.NetConf)
+	assert.NoError(t, err)
+
+	err = config.checkPrivileged(options.privilege, sel)
+	assert.True(t, selector.IsPodNotPermitted(err))
+}
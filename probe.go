@@ -0,0 +1,185 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const defaultProbeTimeoutMs = 1000
+
+// Probe failure policies.
+const (
+	// Fail the ADD.  The default.
+	probePolicyFail = "fail"
+	// Log a warning and carry on.
+	probePolicyWarn = "warn"
+)
+
+// The "probe" block of a network config: connectivity checks run from
+// the pod after it is attached, to catch broken attachments, such as a
+// wrong VLAN or a down uplink, when the pod is created.
+type probeConfig struct {
+	// Ping the gateways in the result.
+	Gateway bool `json:"gateway"`
+	// Other addresses to ping.
+	Ping []string `json:"ping"`
+	// A host:port to open a TCP connection to.
+	TCP       string `json:"tcp"`
+	TimeoutMs int    `json:"timeoutMs"`
+	Policy    string `json:"policy"`
+}
+
+// The "verifyConnectivity" block, the same checks as "probe" in the
+// terms of other CNI plugins: the timeout is a duration such as "2s".
+type verifyConnectivity struct {
+	PingGateway bool     `json:"pingGateway"`
+	Ping        []string `json:"ping"`
+	TCP         string   `json:"tcp"`
+	Timeout     string   `json:"timeout"`
+	Policy      string   `json:"policy"`
+}
+
+// Return the probe the block asks for.
+func (v *verifyConnectivity) probe() (*probeConfig, error) {
+	p := &probeConfig{Gateway: v.PingGateway, Ping: v.Ping, TCP: v.TCP, Policy: v.Policy}
+	if v.Timeout != "" {
+		timeout, err := time.ParseDuration(v.Timeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("Invalid verifyConnectivity timeout %q.", v.Timeout)
+		}
+		p.TimeoutMs = int(timeout / time.Millisecond)
+	}
+
+	return p, nil
+}
+
+// Parse the "probe" or "verifyConnectivity" block of a network config.
+func parseProbe(netconf map[string]interface{}) (*probeConfig, error) {
+	p := &probeConfig{}
+	hasProbe, err := decodeNetConfKey(netconf, "probe", p)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &verifyConnectivity{}
+	hasVerify, err := decodeNetConfKey(netconf, "verifyConnectivity", v)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case hasProbe && hasVerify:
+		return nil, errors.New("Only one of probe and verifyConnectivity may be given.")
+	case hasVerify:
+		if p, err = v.probe(); err != nil {
+			return nil, err
+		}
+	case !hasProbe:
+		return nil, nil
+	}
+
+	for _, addr := range p.Ping {
+		if net.ParseIP(addr) == nil {
+			return nil, fmt.Errorf("Invalid probe ping address %q.", addr)
+		}
+	}
+
+	if p.TCP != "" {
+		if _, _, err := net.SplitHostPort(p.TCP); err != nil {
+			return nil, fmt.Errorf("Invalid probe tcp endpoint %q: %v", p.TCP, err)
+		}
+	}
+
+	switch p.Policy {
+	case "":
+		p.Policy = probePolicyFail
+	case probePolicyFail, probePolicyWarn:
+	default:
+		return nil, fmt.Errorf("Unknown probe policy %q.", p.Policy)
+	}
+
+	if p.TimeoutMs <= 0 {
+		p.TimeoutMs = defaultProbeTimeoutMs
+	}
+
+	return p, nil
+}
+
+// Return the gateways to ping for a result.
+func probeGateways(result *types.Result) []string {
+	var gateways []string
+	for _, ipc := range []*types.IPConfig{result.IP4, result.IP6} {
+		if ipc != nil && ipc.Gateway != nil {
+			gateways = append(gateways, ipc.Gateway.String())
+		}
+	}
+
+	return gateways
+}
+
+// Run the checks from inside the pod.  Returns an error if one fails
+// and the policy is to fail.
+func (p *probeConfig) run(log *logrus.Entry, netns string, result *types.Result) error {
+	err := withNetNS(netns, func() error {
+		if p.Gateway {
+			for _, gw := range probeGateways(result) {
+				if !ping(gw, p.TimeoutMs) {
+					return fmt.Errorf("Gateway %s does not answer pings from the pod.", gw)
+				}
+			}
+		}
+
+		for _, addr := range p.Ping {
+			if !ping(addr, p.TimeoutMs) {
+				return fmt.Errorf("%s does not answer pings from the pod.", addr)
+			}
+		}
+
+		if p.TCP != "" {
+			conn, err := net.DialTimeout("tcp", p.TCP, time.Duration(p.TimeoutMs)*time.Millisecond)
+			if err != nil {
+				return fmt.Errorf("Failed to connect to %s from the pod: %v", p.TCP, err)
+			}
+			conn.Close()
+		}
+
+		return nil
+	})
+	if err == nil {
+		log.Debug("Connectivity probe passed.")
+		return nil
+	}
+
+	log.WithFields(logrus.Fields{
+		"error":  err,
+		"policy": p.Policy,
+	}).Warn("Connectivity probe failed.")
+
+	if p.Policy == probePolicyWarn {
+		return nil
+	}
+	return fmt.Errorf("Connectivity probe failed: %v", err)
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// Parse the probe block, with defaults.
+func TestParseProbe(t *testing.T) {
+	p, err := parseProbe(map[string]interface{}{
+		"probe": map[string]interface{}{"gateway": true, "tcp": "10.0.0.10:443"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &probeConfig{Gateway: true, TCP: "10.0.0.10:443", TimeoutMs: 1000, Policy: "fail"}, p)
+
+	_, err = parseProbe(map[string]interface{}{"probe": map[string]interface{}{"tcp": "10.0.0.10"}})
+	assert.Error(t, err)
+
+	_, err = parseProbe(map[string]interface{}{"probe": map[string]interface{}{"policy": "ignore"}})
+	assert.Error(t, err)
+}
+
+// Translate verifyConnectivity into a probe.
+func TestParseVerifyConnectivity(t *testing.T) {
+	p, err := parseProbe(map[string]interface{}{
+		"verifyConnectivity": map[string]interface{}{"pingGateway": true, "ping": []interface{}{"10.0.0.10"}, "timeout": "2s"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &probeConfig{Gateway: true, Ping: []string{"10.0.0.10"}, TimeoutMs: 2000, Policy: "fail"}, p)
+
+	_, err = parseProbe(map[string]interface{}{"verifyConnectivity": map[string]interface{}{"timeout": "2"}})
+	assert.EqualError(t, err, `Invalid verifyConnectivity timeout "2".`)
+
+	_, err = parseProbe(map[string]interface{}{"verifyConnectivity": map[string]interface{}{"ping": []interface{}{"gw"}}})
+	assert.EqualError(t, err, `Invalid probe ping address "gw".`)
+
+	_, err = parseProbe(map[string]interface{}{
+		"probe":              map[string]interface{}{"gateway": true},
+		"verifyConnectivity": map[string]interface{}{"pingGateway": true},
+	})
+	assert.EqualError(t, err, "Only one of probe and verifyConnectivity may be given.")
+}
+
+// Ping the gateways of both address families.
+func TestProbeGateways(t *testing.T) {
+	result := &types.Result{
+		IP4: &types.IPConfig{Gateway: net.ParseIP("10.1.0.1")},
+		IP6: &types.IPConfig{},
+	}
+
+	assert.Equal(t, []string{"10.1.0.1"}, probeGateways(result))
+}
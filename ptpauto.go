@@ -0,0 +1,376 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// The network config mode in which kube-namespace generates a ptp
+	// delegate config itself.
+	modePTPAuto = "ptp-auto"
+
+	defaultPTPBlockSize = 24
+)
+
+// A namespace network config with "mode": "ptp-auto".  Each namespace
+// gets a block of the pool, and each of its pods a /31 of the block:
+// the host end of the pod's veth gets the first address, and the pod
+// the second.  The block is routed to a blackhole on the host, so that
+// it can be announced as a whole while pods are routed individually.
+type ptpAutoConfig struct {
+	Pool      string `json:"pool"`
+	BlockSize int    `json:"blockSize"`
+
+	pool *net.IPNet
+}
+
+// Keys of a ptp-auto config that are not passed on to the ptp plugin.
+var ptpAutoKeys = []string{"mode", "pool", "blockSize"}
+
+// Parse a ptp-auto network config.  Returns nil if the config is not
+// in ptp-auto mode.
+func parsePTPAuto(netconf map[string]interface{}) (*ptpAutoConfig, error) {
+	if mode, _ := netconf["mode"].(string); mode != modePTPAuto {
+		return nil, nil
+	}
+
+	p := &ptpAutoConfig{}
+	if _, err := decodeNetConfKey(netconf, "pool", &p.Pool); err != nil {
+		return nil, err
+	}
+	if _, err := decodeNetConfKey(netconf, "blockSize", &p.BlockSize); err != nil {
+		return nil, err
+	}
+
+	_, pool, err := net.ParseCIDR(p.Pool)
+	if err != nil || pool.IP.To4() == nil {
+		return nil, fmt.Errorf("ptp-auto requires an IPv4 pool, not %q.", p.Pool)
+	}
+	p.pool = pool
+
+	if p.BlockSize == 0 {
+		p.BlockSize = defaultPTPBlockSize
+	}
+	if ones, _ := pool.Mask.Size(); p.BlockSize < ones || p.BlockSize > 31 {
+		return nil, fmt.Errorf("Invalid ptp-auto blockSize %d for pool %q.", p.BlockSize, p.Pool)
+	}
+
+	return p, nil
+}
+
+// A pod's /31.
+type ptpLink struct {
+	Namespace string `json:"namespace"`
+	Link      string `json:"link"`
+}
+
+// The version of the ptp-auto state format, and the migrations from
+// older ones; see attachmentSchemaVersion.
+const ptpAutoSchemaVersion = 1
+
+var ptpAutoMigrations = map[int]func(record map[string]interface{}) error{}
+
+// The ptp-auto allocations on the node.
+type ptpAutoState struct {
+	SchemaVersion int `json:"schemaVersion"`
+
+	// Namespace blocks, by namespace.
+	Blocks map[string]string `json:"blocks"`
+	// Pod links, by container ID.
+	Links map[string]ptpLink `json:"links"`
+}
+
+// The file in the state directory holding ptp-auto allocations.
+const ptpAutoStateFile = "ptp-auto.json"
+
+// The ptp-auto allocations are kept in one file under the state
+// directory, and only read and written with its lock held.
+type ptpAllocator struct {
+	dir string
+}
+
+func newPTPAllocator(stateDir string) *ptpAllocator {
+	return &ptpAllocator{dir: newAttachmentStore(stateDir).dir}
+}
+
+func (a *ptpAllocator) path() string {
+	return filepath.Join(a.dir, ptpAutoStateFile)
+}
+
+// Run f on the allocations, saving them afterwards if f succeeds.
+func (a *ptpAllocator) update(f func(*ptpAutoState) error) error {
+	release, err := acquireSlot(log, filepath.Join(a.dir, "locks"), "ptp-auto", 1, delegateSlotTimeout)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	state := &ptpAutoState{}
+	data, err := ioutil.ReadFile(a.path())
+	if err == nil {
+		if _, err := decodeVersioned(a.path(), data, state, ptpAutoSchemaVersion, ptpAutoMigrations); err != nil {
+			return fmt.Errorf("Failed to parse ptp-auto state: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("Failed to read ptp-auto state: %v", err)
+	}
+	if state.Blocks == nil {
+		state.Blocks = map[string]string{}
+	}
+	if state.Links == nil {
+		state.Links = map[string]ptpLink{}
+	}
+
+	if err := f(state); err != nil {
+		return err
+	}
+
+	state.SchemaVersion = ptpAutoSchemaVersion
+	if err := writeJSONAtomic(a.path(), state); err != nil {
+		return fmt.Errorf("Failed to save ptp-auto state: %v", err)
+	}
+
+	return nil
+}
+
+func ipToUint32(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+func uint32ToIP(n uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
+
+// Return the subnets of the given prefix length in subnet, in order.
+func subnets(subnet *net.IPNet, prefix int) []*net.IPNet {
+	ones, _ := subnet.Mask.Size()
+	size := uint32(1) << uint(32-prefix)
+	start := ipToUint32(subnet.IP)
+
+	var nets []*net.IPNet
+	for i := uint32(0); i < uint32(1)<<uint(prefix-ones); i++ {
+		nets = append(nets, &net.IPNet{
+			IP:   uint32ToIP(start + i*size),
+			Mask: net.CIDRMask(prefix, 32),
+		})
+	}
+
+	return nets
+}
+
+func overlaps(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// Allocate a /31 for a container in namespace.  Returns the link, the
+// namespace's block, and whether the block was newly allocated.  A
+// container that already has a link keeps it.
+func (a *ptpAllocator) allocate(p *ptpAutoConfig, namespace, containerID string) (link, block *net.IPNet, newBlock bool, err error) {
+	err = a.update(func(state *ptpAutoState) error {
+		if cidr, ok := state.Blocks[namespace]; ok {
+			_, block, _ = net.ParseCIDR(cidr)
+		} else {
+			block, newBlock = p.freeBlock(state), true
+			if block == nil {
+				return fmt.Errorf("No free /%d block in ptp-auto pool %q for namespace %q.", p.BlockSize, p.Pool, namespace)
+			}
+			state.Blocks[namespace] = block.String()
+		}
+
+		if l, ok := state.Links[containerID]; ok && l.Namespace == namespace {
+			_, link, _ = net.ParseCIDR(l.Link)
+			return nil
+		}
+
+		used := map[string]bool{}
+		for _, l := range state.Links {
+			used[l.Link] = true
+		}
+
+		for _, candidate := range subnets(block, 31) {
+			if !used[candidate.String()] {
+				link = candidate
+				state.Links[containerID] = ptpLink{Namespace: namespace, Link: link.String()}
+				return nil
+			}
+		}
+
+		return fmt.Errorf("ptp-auto block %q of namespace %q is full.", block, namespace)
+	})
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	return link, block, newBlock, nil
+}
+
+// Return the first block of the pool that does not overlap an
+// allocated one, or nil.
+func (p *ptpAutoConfig) freeBlock(state *ptpAutoState) *net.IPNet {
+	var allocated []*net.IPNet
+	for _, cidr := range state.Blocks {
+		if _, b, err := net.ParseCIDR(cidr); err == nil {
+			allocated = append(allocated, b)
+		}
+	}
+
+	for _, candidate := range subnets(p.pool, p.BlockSize) {
+		free := true
+		for _, b := range allocated {
+			if overlaps(candidate, b) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return candidate
+		}
+	}
+
+	return nil
+}
+
+// Release a container's /31.  Returns the namespace's block if it was
+// the namespace's last link and the block was released too.
+func (a *ptpAllocator) release(containerID string) (freedBlock string, err error) {
+	err = a.update(func(state *ptpAutoState) error {
+		l, ok := state.Links[containerID]
+		if !ok {
+			return nil
+		}
+		delete(state.Links, containerID)
+
+		for _, other := range state.Links {
+			if other.Namespace == l.Namespace {
+				return nil
+			}
+		}
+
+		freedBlock = state.Blocks[l.Namespace]
+		delete(state.Blocks, l.Namespace)
+		return nil
+	})
+
+	return freedBlock, err
+}
+
+// Return the ptp delegate config for a link.  A nil link, as on DEL of
+// a container without one, gives a config with just the block.
+func (p *ptpAutoConfig) delegateNetConf(netconf map[string]interface{}, link, block *net.IPNet) map[string]interface{} {
+	ptp := make(map[string]interface{}, len(netconf))
+	for k, v := range netconf {
+		ptp[k] = v
+	}
+	for _, k := range ptpAutoKeys {
+		delete(ptp, k)
+	}
+
+	ipam := map[string]interface{}{
+		"type":   "host-local",
+		"subnet": block.String(),
+		"routes": []interface{}{map[string]interface{}{"dst": "0.0.0.0/0"}},
+	}
+	if link != nil {
+		pod := uint32ToIP(ipToUint32(link.IP) + 1).String()
+		ipam["gateway"] = link.IP.String()
+		ipam["rangeStart"] = pod
+		ipam["rangeEnd"] = pod
+	}
+
+	ptp["type"] = "ptp"
+	ptp["ipam"] = ipam
+	return ptp
+}
+
+// Allocate the pod's /31 and turn the selection into a ptp config for
+// it.  The first pod of a namespace also routes the namespace's block
+// to a blackhole.
+func (c *config) applyPTPAuto(p *ptpAutoConfig, sel *selection, containerID string) (*selection, error) {
+	allocator := newPTPAllocator(c.StateDir)
+
+	link, block, newBlock, err := allocator.allocate(p, sel.Namespace, containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if newBlock {
+		if _, err := runCommand("ip", "route", "replace", "blackhole", block.String()); err != nil {
+			allocator.release(containerID)
+			return nil, err
+		}
+	}
+
+	c.Logger().WithFields(logrus.Fields{
+		"link":  link,
+		"block": block,
+	}).Debug("Using ptp-auto link.")
+
+	ptpSel := *sel
+	ptpSel.NetConf = p.delegateNetConf(sel.NetConf, link, block)
+	return &ptpSel, nil
+}
+
+// Return the ptp config for removing a container, whether or not it
+// still has a link.
+func (c *config) ptpAutoDelSelection(p *ptpAutoConfig, sel *selection, containerID string) *selection {
+	block := p.pool
+	var link *net.IPNet
+
+	allocator := newPTPAllocator(c.StateDir)
+	allocator.update(func(state *ptpAutoState) error {
+		if l, ok := state.Links[containerID]; ok {
+			_, link, _ = net.ParseCIDR(l.Link)
+		}
+		if cidr, ok := state.Blocks[sel.Namespace]; ok {
+			_, block, _ = net.ParseCIDR(cidr)
+		}
+		return nil
+	})
+
+	ptpSel := *sel
+	ptpSel.NetConf = p.delegateNetConf(sel.NetConf, link, block)
+	return &ptpSel
+}
+
+// Release a container's /31, and the namespace's block and its route
+// if it was the last.  Release is best effort, so that DEL can always
+// succeed.
+func (c *config) releasePTPAuto(containerID string) {
+	block, err := newPTPAllocator(c.StateDir).release(containerID)
+	if err != nil {
+		c.Logger().WithField("error", err).Warn("Failed to release ptp-auto link.")
+		return
+	}
+
+	if block != "" {
+		if _, err := runCommand("ip", "route", "del", "blackhole", block); err != nil {
+			c.Logger().WithField("error", err).Warn("Failed to remove ptp-auto block route.")
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Only configs in ptp-auto mode are parsed, and their pool checked.
+func TestParsePTPAuto(t *testing.T) {
+	p, err := parsePTPAuto(map[string]interface{}{"type": "bridge"})
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+
+	p, err = parsePTPAuto(map[string]interface{}{"mode": "ptp-auto", "pool": "10.5.0.0/16"})
+	assert.NoError(t, err)
+	assert.Equal(t, defaultPTPBlockSize, p.BlockSize)
+
+	for _, netconf := range []map[string]interface{}{
+		{"mode": "ptp-auto"},
+		{"mode": "ptp-auto", "pool": "fd00::/64"},
+		{"mode": "ptp-auto", "pool": "10.5.0.0/16", "blockSize": 8},
+		{"mode": "ptp-auto", "pool": "10.5.0.0/16", "blockSize": 32},
+	} {
+		_, err := parsePTPAuto(netconf)
+		assert.Error(t, err, "%v", netconf)
+	}
+}
+
+// Give each namespace a block, and each pod a /31 of it.
+func TestPTPAllocator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-ptp")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, err := parsePTPAuto(map[string]interface{}{"mode": "ptp-auto", "pool": "10.5.0.0/24", "blockSize": 30})
+	assert.NoError(t, err)
+	a := newPTPAllocator(dir)
+
+	link, block, newBlock, err := a.allocate(p, "tenant-a", "one")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.5.0.0/31", link.String())
+	assert.Equal(t, "10.5.0.0/30", block.String())
+	assert.True(t, newBlock)
+
+	link, _, newBlock, err = a.allocate(p, "tenant-a", "two")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.5.0.2/31", link.String())
+	assert.False(t, newBlock)
+
+	// A repeated ADD keeps the link.
+	link, _, _, err = a.allocate(p, "tenant-a", "one")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.5.0.0/31", link.String())
+
+	_, _, _, err = a.allocate(p, "tenant-a", "three")
+	assert.Error(t, err)
+
+	_, block, _, err = a.allocate(p, "tenant-b", "four")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.5.0.4/30", block.String())
+
+	freed, err := a.release("one")
+	assert.NoError(t, err)
+	assert.Equal(t, "", freed)
+
+	freed, err = a.release("two")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.5.0.0/30", freed)
+}
+
+// Generate a ptp config with the pod on the second address of its link.
+func TestPTPAutoDelegateNetConf(t *testing.T) {
+	p, err := parsePTPAuto(map[string]interface{}{"mode": "ptp-auto", "pool": "10.5.0.0/16"})
+	assert.NoError(t, err)
+
+	link := subnets(p.pool, 31)[3]
+	block := subnets(p.pool, 24)[0]
+	netconf := p.delegateNetConf(map[string]interface{}{
+		"name": "tenant-a",
+		"mode": "ptp-auto",
+		"pool": "10.5.0.0/16",
+		"mtu":  1460,
+	}, link, block)
+
+	assert.Equal(t, map[string]interface{}{
+		"name": "tenant-a",
+		"type": "ptp",
+		"mtu":  1460,
+		"ipam": map[string]interface{}{
+			"type":       "host-local",
+			"subnet":     "10.5.0.0/24",
+			"gateway":    "10.5.0.6",
+			"rangeStart": "10.5.0.7",
+			"rangeEnd":   "10.5.0.7",
+			"routes":     []interface{}{map[string]interface{}{"dst": "0.0.0.0/0"}},
+		},
+	}, netconf)
+}
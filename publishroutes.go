@@ -0,0 +1,138 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Route protocols publishRoutes can tag routes with, so that a routing
+// daemon can pick them out to redistribute.  The numbers are those in
+// iproute2's rt_protos.
+var routeProtocols = map[string]string{
+	"static": "4",
+	"bird":   "12",
+}
+
+// Publishing a host route to each of a pod's addresses into a routing
+// table, set by a network config's "publishRoutes" block, for routed
+// delegates such as ptp and ipvlan.
+type publishRoutesConfig struct {
+	Protocol string `json:"protocol"`
+	Table    int    `json:"table"`
+}
+
+// Parse the "publishRoutes" block of a network config.
+func parsePublishRoutes(netconf map[string]interface{}) (*publishRoutesConfig, error) {
+	p := &publishRoutesConfig{}
+	if ok, err := decodeNetConfKey(netconf, "publishRoutes", p); !ok || err != nil {
+		return nil, err
+	}
+
+	if p.Table <= 0 {
+		return nil, errors.New("publishRoutes requires a positive routing table number.")
+	}
+
+	if p.Protocol == "" {
+		p.Protocol = "static"
+	}
+	if _, ok := routeProtocols[p.Protocol]; !ok {
+		return nil, fmt.Errorf("Unknown publishRoutes protocol %q; use bird or static.", p.Protocol)
+	}
+
+	return p, nil
+}
+
+// Return the host interface routes to the pod go via: the ipvlan host
+// interface or master for ipvlan and macvlan delegates, and the host
+// end of the veth otherwise.
+func (p *publishRoutesConfig) device(netconf map[string]interface{}, netns, ifName string, att *attachment) (string, error) {
+	if att.HostRouteDevice != "" {
+		return att.HostRouteDevice, nil
+	}
+
+	if master, _ := netconf["master"].(string); master != "" {
+		return master, nil
+	}
+
+	hostIf, err := hostPeer(netns, ifName)
+	if err != nil {
+		return "", fmt.Errorf("Failed to find host interface to publish routes via: %v", err)
+	}
+
+	return hostIf.Name, nil
+}
+
+// Return the arguments of "ip route" selecting a published route.
+func (p *publishRoutesConfig) routeArgs(route, dev string) []string {
+	return []string{route, "dev", dev, "table", strconv.Itoa(p.Table), "proto", routeProtocols[p.Protocol]}
+}
+
+// Add a host route to each of the pod's addresses to the table,
+// recording them in att for DEL.
+func (p *publishRoutesConfig) apply(log *logrus.Entry, netconf map[string]interface{}, netns, ifName string, att *attachment) error {
+	dev, err := p.device(netconf, netns, ifName, att)
+	if err != nil {
+		return err
+	}
+
+	att.PublishedRoutes = &publishedRoutes{Device: dev, Table: p.Table, Protocol: p.Protocol}
+	for _, ip := range podAddresses(att.Result, att.AdditionalIPs) {
+		route := hostRoute(ip)
+		args := append([]string{"route", "replace"}, p.routeArgs(route, dev)...)
+		if _, err := runCommand("ip", args...); err != nil {
+			return err
+		}
+		att.PublishedRoutes.Routes = append(att.PublishedRoutes.Routes, route)
+	}
+
+	log.WithFields(logrus.Fields{
+		"routes":   att.PublishedRoutes.Routes,
+		"table":    p.Table,
+		"protocol": p.Protocol,
+	}).Debug("Published host routes.")
+
+	return nil
+}
+
+// The routes published for a pod, as recorded in its attachment.
+type publishedRoutes struct {
+	Routes   []string `json:"routes"`
+	Device   string   `json:"device"`
+	Table    int      `json:"table"`
+	Protocol string   `json:"protocol"`
+}
+
+// Withdraw the published routes.  Removal is best effort, so that DEL
+// can always succeed.
+func (r *publishedRoutes) withdraw(log *logrus.Entry) {
+	p := &publishRoutesConfig{Table: r.Table, Protocol: r.Protocol}
+	for _, route := range r.Routes {
+		args := append([]string{"route", "del"}, p.routeArgs(route, r.Device)...)
+		if _, err := runCommand("ip", args...); err != nil {
+			log.WithFields(logrus.Fields{
+				"route": route,
+				"error": err,
+			}).Warn("Failed to withdraw published route.")
+		}
+	}
+}
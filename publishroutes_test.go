@@ -0,0 +1,66 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Default the protocol to static, and require a table.
+func TestParsePublishRoutes(t *testing.T) {
+	p, err := parsePublishRoutes(map[string]interface{}{
+		"publishRoutes": map[string]interface{}{"table": 200},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &publishRoutesConfig{Protocol: "static", Table: 200}, p)
+
+	p, err = parsePublishRoutes(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+
+	for _, block := range []map[string]interface{}{
+		{"protocol": "bird"},
+		{"protocol": "ospf", "table": 200},
+	} {
+		_, err := parsePublishRoutes(map[string]interface{}{"publishRoutes": block})
+		assert.Error(t, err, "%v", block)
+	}
+}
+
+// Tag routes with the protocol's number in the table.
+func TestPublishRoutesArgs(t *testing.T) {
+	p := &publishRoutesConfig{Protocol: "bird", Table: 200}
+	assert.Equal(t, []string{"10.2.0.5/32", "dev", "veth1", "table", "200", "proto", "12"},
+		p.routeArgs("10.2.0.5/32", "veth1"))
+}
+
+// Route via the ipvlan host interface, or the master, before looking
+// for a veth peer.
+func TestPublishRoutesDevice(t *testing.T) {
+	p := &publishRoutesConfig{Protocol: "static", Table: 200}
+
+	dev, err := p.device(map[string]interface{}{"master": "eth1"}, "", "eth0", &attachment{HostRouteDevice: "ipvl-host"})
+	assert.NoError(t, err)
+	assert.Equal(t, "ipvl-host", dev)
+
+	dev, err = p.device(map[string]interface{}{"master": "eth1"}, "", "eth0", &attachment{})
+	assert.NoError(t, err)
+	assert.Equal(t, "eth1", dev)
+}
@@ -0,0 +1,109 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// How long to wait for another ADD in the namespace to check its
+// quota.
+const quotaLockTimeout = 30 * time.Second
+
+// Parse the "maxAttachments" key of a network config: the most pods of
+// a namespace that may be attached on the node at once.  0 means no
+// limit.
+func parseMaxAttachments(netconf map[string]interface{}) (int, error) {
+	n := 0
+	if _, err := decodeNetConfKey(netconf, "maxAttachments", &n); err != nil {
+		return 0, err
+	}
+
+	if n < 0 {
+		return 0, fmt.Errorf("Invalid maxAttachments %d.", n)
+	}
+
+	return n, nil
+}
+
+// Reserve an attachment for the pod against the namespace's quota.
+// The reservation is a partial attachment record, which counts towards
+// the quota until ADD overwrites it or DEL removes it.  The check and
+// the reservation are made under a per-namespace lock, so that
+// concurrent ADDs cannot overshoot the quota.
+func (c *config) reserveAttachment(sel *selection, args *skel.CmdArgs, limit int) error {
+	store := newAttachmentStore(c.StateDir)
+
+	release, err := acquireSlot(c.Logger(), filepath.Join(store.dir, "locks"), "quota-"+shortHash(sel.Namespace), 1, quotaLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	attachments, err := store.list()
+	if err != nil {
+		return err
+	}
+
+	active := 0
+	for _, att := range attachments {
+		if att.Namespace == sel.Namespace && att.ContainerID != args.ContainerID {
+			active++
+		}
+	}
+
+	if active >= limit {
+		c.Logger().WithFields(logrus.Fields{
+			"namespace": sel.Namespace,
+			"limit":     limit,
+		}).Warn("Rejecting pod over attachment quota.")
+		return newError(errCodeQuotaExceeded, "Namespace %q has %d of %d attachments on this node; not adding pod %q.",
+			sel.Namespace, active, limit, sel.Pod)
+	}
+
+	return store.save(&attachment{
+		ContainerID:     args.ContainerID,
+		Namespace:       sel.Namespace,
+		Pod:             sel.Pod,
+		Netns:           args.Netns,
+		IfName:          args.IfName,
+		networkMetadata: newNetworkMetadata(sel),
+		Created:         time.Now().UTC(),
+	})
+}
+
+// Forget the reservation of a pod whose ADD failed before anything
+// was set up for it.  Records with a delegate result or a host device
+// are kept, since DEL has to undo those.
+func (c *config) releaseReservation(containerID string) {
+	store := newAttachmentStore(c.StateDir)
+	att, err := store.load(containerID)
+	if err != nil || att == nil || att.Result != nil || att.HostDevice != nil {
+		return
+	}
+
+	if err := store.remove(containerID); err != nil {
+		c.Logger().WithField("error", err).Warn("Failed to release attachment reservation.")
+	}
+}
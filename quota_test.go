@@ -0,0 +1,99 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+	"github.com/stretchr/testify/assert"
+)
+
+// Reject ADDs over the namespace's quota, counting reservations.
+func TestMaxAttachments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-quota")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config, err := parseConfig([]byte(`{
+	  "stateDir": "` + dir + `",
+	  "namespaces": {
+	    "tenant-a": {"name": "tenant-a", "type": "bridge", "maxAttachments": 1}
+	  }
+	}`))
+	assert.NoError(t, err)
+
+	sel, err := config.Select("K8S_POD_NAMESPACE=tenant-a;K8S_POD_NAME=web-1")
+	assert.NoError(t, err)
+
+	assert.NoError(t, config.reserveAttachment(sel, &skel.CmdArgs{ContainerID: "one"}, 1))
+	// A repeated ADD of the same container is not counted twice.
+	assert.NoError(t, config.reserveAttachment(sel, &skel.CmdArgs{ContainerID: "one"}, 1))
+
+	args := &skel.CmdArgs{ContainerID: "two", Args: "K8S_POD_NAMESPACE=tenant-a;K8S_POD_NAME=web-2"}
+	err = addNetwork(config, args, &selector.DelegateEnv{CNIPath: "/nonexistent"}, &bytes.Buffer{})
+	assert.True(t, selector.IsQuotaExceeded(err))
+
+	_, err = parseMaxAttachments(map[string]interface{}{"maxAttachments": -1})
+	assert.Error(t, err)
+}
+
+// Release the reservation of an ADD that fails before the delegate
+// succeeds, so that it does not hold on to the quota.
+func TestMaxAttachmentsReleasedOnFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-quota")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	hooks := filepath.Join(dir, "hooks")
+	assert.NoError(t, os.MkdirAll(hooks, 0755))
+	failingHook := writeHook(t, hooks, "pre-add", "exit 1")
+
+	for _, tc := range []struct {
+		name, hooks string
+	}{
+		{"pre-add hook", `"hooks": {"preAdd": [{"path": "` + failingHook + `"}]},`},
+		{"delegate ADD", ""},
+	} {
+		config, err := parseConfig([]byte(`{
+		  "stateDir": "` + dir + `",` + tc.hooks + `
+		  "namespaces": {
+		    "tenant-a": {"name": "tenant-a", "type": "bridge", "maxAttachments": 1}
+		  }
+		}`))
+		if !assert.NoError(t, err, tc.name) {
+			continue
+		}
+
+		for _, id := range []string{"one", "two"} {
+			args := &skel.CmdArgs{ContainerID: id, Args: "K8S_POD_NAMESPACE=tenant-a;K8S_POD_NAME=web-" + id}
+			err = addNetwork(config, args, &selector.DelegateEnv{CNIPath: "/nonexistent"}, &bytes.Buffer{})
+			assert.Error(t, err, tc.name)
+			assert.False(t, selector.IsQuotaExceeded(err), tc.name)
+		}
+
+		attachments, err := newAttachmentStore(dir).list()
+		assert.NoError(t, err)
+		assert.Empty(t, attachments, tc.name)
+	}
+}
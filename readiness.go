@@ -0,0 +1,65 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// A network config can name a file that something else, e.g. flanneld
+// or a device plugin, creates once the network's underlay is up.
+// Until it exists, ADDs for the config fail with a retryable error, so
+// the kubelet retries them rather than pods starting with broken
+// connectivity.  DEL is never held back.
+
+// Parse and validate the readinessFile option of a network config.
+func parseReadinessFile(netconf map[string]interface{}) (string, error) {
+	path := ""
+	if _, err := decodeNetConfKey(netconf, "readinessFile", &path); err != nil {
+		return "", err
+	}
+
+	if path != "" && !filepath.IsAbs(path) {
+		return "", fmt.Errorf("readinessFile %q is not an absolute path.", path)
+	}
+
+	return path, nil
+}
+
+// Return a retryable error if the readiness file of the selected
+// config does not exist.
+func checkReadiness(log *logrus.Entry, path string, sel *selection) error {
+	_, err := os.Stat(path)
+	if err == nil {
+		return nil
+	}
+
+	log.WithFields(logrus.Fields{
+		"rule":           sel.Rule,
+		"readiness_file": path,
+	}).Warn("Network not ready. Rejecting pod.")
+
+	if os.IsNotExist(err) {
+		return newError(errCodeNetworkNotReady, "Network config %q is not ready: %s does not exist.", sel.Rule, path)
+	}
+	return newError(errCodeNetworkNotReady, "Network config %q is not ready: %v", sel.Rule, err)
+}
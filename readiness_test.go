@@ -0,0 +1,63 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+	"github.com/stretchr/testify/assert"
+)
+
+// Require an absolute readinessFile.
+func TestParseReadinessFile(t *testing.T) {
+	path, err := parseReadinessFile(map[string]interface{}{"readinessFile": "/run/flannel/subnet.env"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/run/flannel/subnet.env", path)
+
+	_, err = parseReadinessFile(map[string]interface{}{"readinessFile": "subnet.env"})
+	assert.EqualError(t, err, `readinessFile "subnet.env" is not an absolute path.`)
+}
+
+// Refuse ADD with a retryable error until the readiness file exists.
+func TestReadinessFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-readiness")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ready := filepath.Join(dir, "subnet.env")
+	config, err := parseConfig([]byte(`{
+	  "stateDir": "` + filepath.Join(dir, "state") + `",
+	  "default": {"name": "flannel-net", "type": "bridge", "readinessFile": "` + ready + `"}
+	}`))
+	assert.NoError(t, err)
+
+	args := &skel.CmdArgs{ContainerID: "abc", IfName: "eth0", Args: "K8S_POD_NAMESPACE=web;K8S_POD_NAME=web-1"}
+	err = addNetwork(config, args, &selector.DelegateEnv{CNIPath: "/nonexistent"}, &bytes.Buffer{})
+	assert.True(t, selector.Is(err, selector.ErrNetworkNotReady))
+	assert.True(t, selector.IsTemporary(err))
+
+	assert.NoError(t, ioutil.WriteFile(ready, nil, 0644))
+	err = addNetwork(config, args, &selector.DelegateEnv{CNIPath: "/nonexistent"}, &bytes.Buffer{})
+	assert.True(t, selector.Is(err, selector.ErrDelegateNotFound))
+}
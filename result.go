@@ -0,0 +1,192 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+)
+
+// Metadata about the network selected for a pod, so that chained
+// plugins and agents can key off the logical network.
+type networkMetadata struct {
+	// The "name" of the selected network config.
+	Network string `json:"network"`
+	// The config entry that matched: the namespace, or "default".
+	Rule string `json:"rule"`
+	// The "tenant" of the selected network config, if set.
+	Tenant string `json:"tenant,omitempty"`
+	// Whether the config's canary delegate config was used.
+	Canary bool `json:"canary,omitempty"`
+}
+
+// Return the metadata for a selected network config.
+func newNetworkMetadata(sel *selection) networkMetadata {
+	network, _ := sel.NetConf["name"].(string)
+	tenant, _ := sel.NetConf["tenant"].(string)
+
+	return networkMetadata{
+		Network: network,
+		Rule:    sel.Rule,
+		Tenant:  tenant,
+		Canary:  sel.Canary,
+	}
+}
+
+// The vendor extension added to the result, attributing the interface
+// to a Kubernetes pod and the network selected for it.
+type resultMetadata struct {
+	Namespace string `json:"namespace,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+	networkMetadata
+	// Secondary addresses of the pod interface, which the 0.1 and 0.2
+	// result formats have no room for.
+	AdditionalIPs []string `json:"additionalIPs,omitempty"`
+}
+
+// The result printed by kube-namespace: the delegate's result, with
+// the metadata added as a vendor extension.
+type result struct {
+	*types.Result
+	KubeNamespace resultMetadata `json:"kubeNamespace"`
+
+	// The delegate's 0.3 result, if it printed one.
+	result030 *selector.Result030
+
+	// The version of the result format to print; see conflist.go.
+	cniVersion string
+	ifName     string
+	netns      string
+	// The host end of the pod's veth, if known.
+	hostIfName string
+}
+
+// Return the result to print for an attachment.
+func newResult(att *attachment) *result {
+	return &result{
+		Result: att.Result,
+		KubeNamespace: resultMetadata{
+			Namespace:       att.Namespace,
+			Pod:             att.Pod,
+			networkMetadata: att.networkMetadata,
+			AdditionalIPs:   att.AdditionalIPs,
+		},
+		result030:  att.Result030,
+		ifName:     att.IfName,
+		netns:      att.Netns,
+		hostIfName: att.HostInterface,
+	}
+}
+
+// Marshal the result in the format of its CNI version.
+func (r *result) MarshalJSON() ([]byte, error) {
+	if !selector.Is030(r.cniVersion) {
+		type legacyResult result
+		return json.Marshal((*legacyResult)(r))
+	}
+
+	delegate := &selector.Result{Result: r.Result, Result030: r.result030}
+	r030 := delegate.To030(r.cniVersion, r.ifName, r.netns)
+
+	// List a renamed host veth the delegate left out first, as bridge
+	// and ptp do, moving the addresses' interface indices along.
+	podIndex := podInterfaceIndex(r030, r.ifName)
+	if r.hostIfName != "" && !hasInterface(r030, r.hostIfName) {
+		r030.Interfaces = append([]*selector.Interface030{{Name: r.hostIfName}}, r030.Interfaces...)
+		for _, ip := range r030.IPs {
+			if ip.Interface != nil {
+				index := *ip.Interface + 1
+				ip.Interface = &index
+			}
+		}
+		if podIndex >= 0 {
+			podIndex++
+		}
+	}
+
+	for _, addr := range r.KubeNamespace.AdditionalIPs {
+		ip, ipnet, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, err
+		}
+		ipnet.IP = ip
+
+		version := "4"
+		if ip.To4() == nil {
+			version = "6"
+		}
+		ipc := &selector.IPConfig030{Version: version, Address: types.IPNet(*ipnet)}
+		if podIndex >= 0 {
+			ipc.Interface = &podIndex
+		}
+		r030.IPs = append(r030.IPs, ipc)
+	}
+
+	return json.Marshal(struct {
+		*selector.Result030
+		KubeNamespace resultMetadata `json:"kubeNamespace"`
+	}{
+		Result030:     r030,
+		KubeNamespace: r.KubeNamespace,
+	})
+}
+
+// Return the index of the pod's interface ifName in a 0.3 result: the
+// interface of that name in a sandbox, else the one the first address
+// is on, or -1 if the result does not say.
+func podInterfaceIndex(r030 *selector.Result030, ifName string) int {
+	for i, iface := range r030.Interfaces {
+		if iface.Name == ifName && iface.Sandbox != "" {
+			return i
+		}
+	}
+
+	for _, ip := range r030.IPs {
+		if ip.Interface != nil {
+			return *ip.Interface
+		}
+	}
+
+	return -1
+}
+
+// Return whether a 0.3 result lists a host interface called name.
+func hasInterface(r030 *selector.Result030, name string) bool {
+	for _, iface := range r030.Interfaces {
+		if iface.Name == name && iface.Sandbox == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Write the result as JSON to w.
+func (r *result) print(w io.Writer) error {
+	data, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,204 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+	"github.com/stretchr/testify/assert"
+)
+
+// Print the delegate's result with the network metadata added.
+func TestResultPrint(t *testing.T) {
+	sel := &selection{
+		Namespace: "isolated",
+		Pod:       "web-1",
+		Rule:      "isolated",
+		NetConf:   map[string]interface{}{"name": "isolated-bridge", "tenant": "acme"},
+	}
+	r := newResult(&attachment{
+		Namespace:       sel.Namespace,
+		Pod:             sel.Pod,
+		networkMetadata: newNetworkMetadata(sel),
+		Result: &types.Result{
+			IP4: &types.IPConfig{IP: net.IPNet{IP: net.IPv4(10, 2, 0, 5), Mask: net.CIDRMask(16, 32)}},
+		},
+	})
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, r.print(buf))
+
+	printed := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &printed))
+	assert.Equal(t, map[string]interface{}{"ip": "10.2.0.5/16"}, printed["ip4"])
+	assert.Equal(t, map[string]interface{}{
+		"namespace": "isolated",
+		"pod":       "web-1",
+		"network":   "isolated-bridge",
+		"rule":      "isolated",
+		"tenant":    "acme",
+	}, printed["kubeNamespace"])
+}
+
+// Print results in the 0.3 format when the config asks for it.
+func TestResultPrint030(t *testing.T) {
+	r := newResult(&attachment{
+		Namespace: "isolated",
+		Pod:       "web-1",
+		Netns:     "/var/run/netns/pod",
+		IfName:    "eth0",
+		Result: &types.Result{
+			IP4: &types.IPConfig{IP: net.IPNet{IP: net.IPv4(10, 2, 0, 5), Mask: net.CIDRMask(16, 32)}},
+		},
+	})
+	r.cniVersion = "0.3.1"
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, r.print(buf))
+
+	printed := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &printed))
+	assert.Equal(t, "0.3.1", printed["cniVersion"])
+	assert.Nil(t, printed["ip4"])
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"version": "4", "interface": float64(0), "address": "10.2.0.5/16",
+	}}, printed["ips"])
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"name": "eth0", "sandbox": "/var/run/netns/pod",
+	}}, printed["interfaces"])
+	assert.Equal(t, "web-1", printed["kubeNamespace"].(map[string]interface{})["pod"])
+}
+
+// List the host veth before the pod's interface in the 0.3 format,
+// with every address pointing at the pod's interface.
+func TestResultPrint030HostInterface(t *testing.T) {
+	r := newResult(&attachment{
+		Netns:         "/var/run/netns/pod",
+		IfName:        "net1",
+		HostInterface: "veth3f1c9a20b7e",
+		AdditionalIPs: []string{"10.2.0.6/16"},
+		Result: &types.Result{
+			IP4: &types.IPConfig{IP: net.IPNet{IP: net.IPv4(10, 2, 0, 5), Mask: net.CIDRMask(16, 32)}},
+		},
+	})
+	r.cniVersion = "0.3.1"
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, r.print(buf))
+
+	printed := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &printed))
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "veth3f1c9a20b7e"},
+		map[string]interface{}{"name": "net1", "sandbox": "/var/run/netns/pod"},
+	}, printed["interfaces"])
+
+	ips := printed["ips"].([]interface{})
+	if assert.Len(t, ips, 2) {
+		for _, ip := range ips {
+			assert.Equal(t, float64(1), ip.(map[string]interface{})["interface"])
+		}
+	}
+}
+
+// List additional addresses in both result formats.
+func TestResultAdditionalIPs(t *testing.T) {
+	r := newResult(&attachment{
+		IfName:        "eth0",
+		AdditionalIPs: []string{"10.2.0.6/16"},
+		Result: &types.Result{
+			IP4: &types.IPConfig{IP: net.IPNet{IP: net.IPv4(10, 2, 0, 5), Mask: net.CIDRMask(16, 32)}},
+		},
+	})
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, r.print(buf))
+	printed := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &printed))
+	assert.Equal(t, []interface{}{"10.2.0.6/16"}, printed["kubeNamespace"].(map[string]interface{})["additionalIPs"])
+
+	r.cniVersion = "0.3.1"
+	buf.Reset()
+	assert.NoError(t, r.print(buf))
+	printed = map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &printed))
+	ips := printed["ips"].([]interface{})
+	if assert.Len(t, ips, 2) {
+		assert.Equal(t, "10.2.0.6/16", ips[1].(map[string]interface{})["address"])
+	}
+}
+
+// Take the interfaces and their indices from the delegate's 0.3
+// result, pointing additional addresses at the pod's interface.
+func TestResultPrint030DelegateInterfaces(t *testing.T) {
+	index := 2
+	r := newResult(&attachment{
+		Netns:         "/var/run/netns/pod",
+		IfName:        "eth0",
+		AdditionalIPs: []string{"10.2.0.6/16"},
+		Result: &types.Result{
+			IP4: &types.IPConfig{IP: net.IPNet{IP: net.IPv4(10, 2, 0, 5), Mask: net.CIDRMask(16, 32)}},
+		},
+		Result030: &selector.Result030{
+			Interfaces: []*selector.Interface030{
+				{Name: "cni0", Mac: "0a:58:0a:02:00:01"},
+				{Name: "veth1a2b3c", Mac: "5e:2d:1f:00:00:01"},
+				{Name: "eth0", Mac: "0a:58:0a:02:00:05", Sandbox: "/var/run/netns/pod"},
+			},
+			IPs: []*selector.IPConfig030{{
+				Version:   "4",
+				Interface: &index,
+				Address:   types.IPNet{IP: net.IPv4(10, 2, 0, 5), Mask: net.CIDRMask(16, 32)},
+			}},
+		},
+	})
+	r.cniVersion = "0.3.1"
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, r.print(buf))
+
+	printed := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &printed))
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "cni0", "mac": "0a:58:0a:02:00:01"},
+		map[string]interface{}{"name": "veth1a2b3c", "mac": "5e:2d:1f:00:00:01"},
+		map[string]interface{}{"name": "eth0", "mac": "0a:58:0a:02:00:05", "sandbox": "/var/run/netns/pod"},
+	}, printed["interfaces"])
+
+	ips := printed["ips"].([]interface{})
+	if assert.Len(t, ips, 2) {
+		for _, ip := range ips {
+			assert.Equal(t, float64(2), ip.(map[string]interface{})["interface"])
+		}
+	}
+
+	// A renamed veth the delegate listed is not listed twice.
+	r.result030.Interfaces[1].Name = "veth3f1c9a20b7e"
+	r.hostIfName = "veth3f1c9a20b7e"
+	buf.Reset()
+	assert.NoError(t, r.print(buf))
+	printed = map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &printed))
+	assert.Len(t, printed["interfaces"], 3)
+	assert.Equal(t, float64(2), printed["ips"].([]interface{})[0].(map[string]interface{})["interface"])
+}
@@ -0,0 +1,150 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// The "resultTransforms" block of a network config: changes to the
+// delegate's result before it is recorded and printed, for delegates
+// whose routes conflict with the node's policy routing and cannot be
+// configured otherwise.
+type resultTransforms struct {
+	// Drop routes whose destination lies within any of these CIDRs.
+	DropRoutes []string `json:"dropRoutes"`
+	// Replace the gateway of the result's addresses of the same
+	// family, and of the routes via it.
+	RewriteGateway string `json:"rewriteGateway"`
+	// Nameservers, search domains and options to add to the result's,
+	// and a domain to set if it has none.
+	AddDNS *types.DNS `json:"addDNS"`
+
+	dropRoutes []*net.IPNet
+	gateway    net.IP
+}
+
+// Parse the "resultTransforms" block of a network config.
+func parseResultTransforms(netconf map[string]interface{}) (*resultTransforms, error) {
+	t := &resultTransforms{}
+	if ok, err := decodeNetConfKey(netconf, "resultTransforms", t); !ok || err != nil {
+		return nil, err
+	}
+
+	for _, cidr := range t.DropRoutes {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid dropRoutes CIDR %q.", cidr)
+		}
+		t.dropRoutes = append(t.dropRoutes, ipnet)
+	}
+
+	if t.RewriteGateway != "" {
+		if t.gateway = net.ParseIP(t.RewriteGateway); t.gateway == nil {
+			return nil, fmt.Errorf("Invalid rewriteGateway %q.", t.RewriteGateway)
+		}
+	}
+
+	return t, nil
+}
+
+// Return whether dst lies within one of the dropped CIDRs.
+func (t *resultTransforms) dropped(dst net.IPNet) bool {
+	dstOnes, dstBits := dst.Mask.Size()
+	for _, ipnet := range t.dropRoutes {
+		ones, bits := ipnet.Mask.Size()
+		if bits == dstBits && dstOnes >= ones && ipnet.Contains(dst.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Return whether ip is of the same family as the rewritten gateway.
+func (t *resultTransforms) sameFamily(ip net.IP) bool {
+	return (ip.To4() == nil) == (t.gateway.To4() == nil)
+}
+
+// Apply the transforms to the result.
+func (t *resultTransforms) apply(log *logrus.Entry, result *types.Result) {
+	for _, ipc := range []*types.IPConfig{result.IP4, result.IP6} {
+		if ipc == nil {
+			continue
+		}
+
+		var routes []types.Route
+		for _, route := range ipc.Routes {
+			if t.dropped(route.Dst) {
+				log.WithField("route", route.Dst.String()).Debug("Dropping route from result.")
+				continue
+			}
+			routes = append(routes, route)
+		}
+
+		if t.gateway != nil && t.sameFamily(ipc.IP.IP) {
+			for i := range routes {
+				if routes[i].GW != nil && routes[i].GW.Equal(ipc.Gateway) {
+					routes[i].GW = t.gateway
+				}
+			}
+			if ipc.Gateway != nil {
+				log.WithFields(logrus.Fields{
+					"from": ipc.Gateway,
+					"to":   t.gateway,
+				}).Debug("Rewriting gateway in result.")
+			}
+			ipc.Gateway = t.gateway
+		}
+
+		ipc.Routes = routes
+	}
+
+	if t.AddDNS != nil {
+		dns := &result.DNS
+		dns.Nameservers = appendMissing(dns.Nameservers, t.AddDNS.Nameservers)
+		dns.Search = appendMissing(dns.Search, t.AddDNS.Search)
+		dns.Options = appendMissing(dns.Options, t.AddDNS.Options)
+		if dns.Domain == "" {
+			dns.Domain = t.AddDNS.Domain
+		}
+	}
+}
+
+// Return list with the values of add it does not have appended.
+func appendMissing(list, add []string) []string {
+	for _, v := range add {
+		found := false
+		for _, have := range list {
+			if have == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, v)
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,100 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// Return the network ip/prefix as a types.Route destination.
+func mustCIDR(t *testing.T, cidr string) net.IPNet {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("Invalid CIDR %q: %v", cidr, err)
+	}
+	return *ipnet
+}
+
+// Drop routes within the CIDRs, rewrite the gateway and add DNS.
+func TestResultTransforms(t *testing.T) {
+	transforms, err := parseResultTransforms(map[string]interface{}{
+		"resultTransforms": map[string]interface{}{
+			"dropRoutes":     []interface{}{"10.0.0.0/8"},
+			"rewriteGateway": "10.2.0.254",
+			"addDNS":         map[string]interface{}{"nameservers": []interface{}{"10.3.0.10", "10.3.0.11"}, "domain": "cluster.local"},
+		},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	gw := net.ParseIP("10.2.0.1")
+	result := &types.Result{
+		IP4: &types.IPConfig{
+			IP:      net.IPNet{IP: net.ParseIP("10.2.0.5"), Mask: net.CIDRMask(16, 32)},
+			Gateway: gw,
+			Routes: []types.Route{
+				{Dst: mustCIDR(t, "0.0.0.0/0"), GW: gw},
+				{Dst: mustCIDR(t, "10.96.0.0/12"), GW: gw},
+				{Dst: mustCIDR(t, "192.168.0.0/16")},
+			},
+		},
+		DNS: types.DNS{Nameservers: []string{"10.3.0.10"}},
+	}
+
+	transforms.apply(log, result)
+
+	assert.Equal(t, "10.2.0.254", result.IP4.Gateway.String())
+	if assert.Len(t, result.IP4.Routes, 2) {
+		assert.Equal(t, "0.0.0.0/0", result.IP4.Routes[0].Dst.String())
+		assert.Equal(t, "10.2.0.254", result.IP4.Routes[0].GW.String())
+		assert.Equal(t, "192.168.0.0/16", result.IP4.Routes[1].Dst.String())
+		assert.Nil(t, result.IP4.Routes[1].GW)
+	}
+	assert.Equal(t, []string{"10.3.0.10", "10.3.0.11"}, result.DNS.Nameservers)
+	assert.Equal(t, "cluster.local", result.DNS.Domain)
+}
+
+// Only routes as specific as the dropped CIDR or more are dropped.
+func TestResultTransformsDropped(t *testing.T) {
+	transforms, err := parseResultTransforms(map[string]interface{}{
+		"resultTransforms": map[string]interface{}{"dropRoutes": []interface{}{"10.96.0.0/12", "fd00::/8"}},
+	})
+	assert.NoError(t, err)
+
+	assert.True(t, transforms.dropped(mustCIDR(t, "10.96.0.0/12")))
+	assert.True(t, transforms.dropped(mustCIDR(t, "10.100.0.0/16")))
+	assert.False(t, transforms.dropped(mustCIDR(t, "0.0.0.0/0")))
+	assert.False(t, transforms.dropped(mustCIDR(t, "10.0.0.0/8")))
+	assert.True(t, transforms.dropped(mustCIDR(t, "fd00:1::/64")))
+}
+
+// Reject invalid CIDRs and gateways.
+func TestParseResultTransformsInvalid(t *testing.T) {
+	for _, block := range []map[string]interface{}{
+		{"dropRoutes": []interface{}{"10.0.0.0"}},
+		{"rewriteGateway": "gateway"},
+	} {
+		_, err := parseResultTransforms(map[string]interface{}{"resultTransforms": block})
+		assert.Error(t, err, "%v", block)
+	}
+}
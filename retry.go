@@ -0,0 +1,115 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	defaultRetryBackoff = 100 * time.Millisecond
+	maxRetryBackoff     = 5 * time.Second
+)
+
+// Substrings of delegate errors that are worth retrying, as the
+// condition behind them usually clears within seconds.
+var transientErrors = []string{
+	"address already in use",
+	"resource temporarily unavailable",
+	"device or resource busy",
+	// The DHCP daemon is not up yet.
+	"connection refused",
+	"dhcp.sock: connect",
+}
+
+// Overridden in tests.
+var sleep = time.Sleep
+
+// How to retry a failing delegate ADD.
+type retryConfig struct {
+	Retries int
+	Backoff time.Duration
+}
+
+// Parse the "retries" and "retryBackoff" keys of a network config.
+// Returns nil if retries are not enabled.
+func parseRetry(netconf map[string]interface{}) (*retryConfig, error) {
+	r := &retryConfig{Backoff: defaultRetryBackoff}
+	if ok, err := decodeNetConfKey(netconf, "retries", &r.Retries); !ok || err != nil {
+		return nil, err
+	}
+	if r.Retries < 0 {
+		return nil, fmt.Errorf("Invalid retries %d.", r.Retries)
+	}
+
+	var backoff string
+	if ok, err := decodeNetConfKey(netconf, "retryBackoff", &backoff); err != nil {
+		return nil, err
+	} else if ok {
+		d, err := time.ParseDuration(backoff)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("Invalid retryBackoff %q.", backoff)
+		}
+		r.Backoff = d
+	}
+
+	return r, nil
+}
+
+// Return whether err looks transient.
+func isTransient(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range transientErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Call fn until it succeeds, fails with a non-transient error, or
+// the retries are used up, doubling the wait between attempts.
+func (r *retryConfig) do(log *logrus.Entry, fn func() error) error {
+	err := fn()
+	if r == nil {
+		return err
+	}
+
+	backoff := r.Backoff
+	for attempt := 1; err != nil && attempt <= r.Retries && isTransient(err); attempt++ {
+		log.WithFields(logrus.Fields{
+			"attempt": attempt,
+			"backoff": backoff,
+			"error":   err,
+		}).Warn("Transient delegate failure. Retrying.")
+
+		sleep(backoff)
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+
+		err = fn()
+	}
+
+	return err
+}
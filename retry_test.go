@@ -0,0 +1,75 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Parse the retry options.
+func TestParseRetry(t *testing.T) {
+	r, err := parseRetry(map[string]interface{}{"retries": 3, "retryBackoff": "50ms"})
+	assert.NoError(t, err)
+	assert.Equal(t, &retryConfig{Retries: 3, Backoff: 50 * time.Millisecond}, r)
+
+	r, err = parseRetry(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+
+	_, err = parseRetry(map[string]interface{}{"retries": 3, "retryBackoff": "soon"})
+	assert.Error(t, err)
+}
+
+// Retry transient errors with exponential backoff.
+func TestRetryTransient(t *testing.T) {
+	var waits []time.Duration
+	sleep = func(d time.Duration) { waits = append(waits, d) }
+	defer func() { sleep = time.Sleep }()
+
+	calls := 0
+	r := &retryConfig{Retries: 5, Backoff: time.Second}
+	err := r.do(log, func() error {
+		if calls++; calls < 4 {
+			return errors.New("listen tcp :80: bind: address already in use")
+		}
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 4, calls)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}, waits)
+}
+
+// Give up at once on permanent errors, and after the retries run out.
+func TestRetryGiveUp(t *testing.T) {
+	sleep = func(time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+
+	calls := 0
+	r := &retryConfig{Retries: 2, Backoff: time.Millisecond}
+	assert.Error(t, r.do(log, func() error { calls++; return errors.New("invalid config") }))
+	assert.Equal(t, 1, calls)
+
+	calls = 0
+	assert.Error(t, r.do(log, func() error { calls++; return errors.New("connection refused") }))
+	assert.Equal(t, 3, calls)
+}
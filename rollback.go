@@ -0,0 +1,76 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Undo an ADD whose delegate succeeded but which failed afterwards,
+// e.g. installing routes or firewall rules: run the delegate DEL,
+// release what att records was set up, and forget the attachment,
+// including any quota or VF reservation.  Otherwise the kubelet's
+// retry would find the pod's address still allocated.  Rollback is
+// best effort; failures are only logged.
+func (c *config) rollbackAdd(sel *selection, options *netOptions, args *skel.CmdArgs, env *selector.DelegateEnv,
+	delegateConf map[string]interface{}, att *attachment, cause error) {
+	c.Logger().WithField("error", cause).Warn("ADD failed after the delegate succeeded. Rolling back.")
+
+	delEnv := commandEnv(env, args, "DEL")
+	release, err := c.delegateSlot(sel.NetConf)
+	if err == nil {
+		err = delEnv.Del(delegateConf)
+		release()
+	}
+	c.dumpInvocation("DEL", args, delEnv, delegateConf, nil, err)
+	if err != nil {
+		c.Logger().WithField("error", err).Error("Failed to roll back delegate ADD.")
+	}
+
+	c.releaseAttachment(sel, options, args, delEnv, delegateConf, att)
+
+	if err := newAttachmentStore(c.StateDir).remove(args.ContainerID); err != nil {
+		c.Logger().WithField("error", err).Warn("Failed to forget rolled back attachment.")
+	}
+
+	c.Logger().WithFields(logrus.Fields{
+		"namespace": sel.Namespace,
+		"pod":       sel.Pod,
+	}).Info("Rolled back ADD.")
+}
+
+// Return the delegate environment of args for running command, e.g.
+// DEL while handling ADD.
+func commandEnv(env *selector.DelegateEnv, args *skel.CmdArgs, command string) *selector.DelegateEnv {
+	return &selector.DelegateEnv{
+		CNIPath: env.CNIPath,
+		Args: &invoke.Args{
+			Command:       command,
+			ContainerID:   args.ContainerID,
+			NetNS:         args.Netns,
+			PluginArgsStr: args.Args,
+			IfName:        args.IfName,
+			Path:          env.CNIPath,
+		},
+		Log: env.Log,
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+	"github.com/stretchr/testify/assert"
+)
+
+// Run the delegate DEL and forget the attachment, including its quota
+// reservation, when a step after the delegate ADD fails.
+func TestRollbackAdd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-rollback")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	deleted := filepath.Join(dir, "deleted")
+	writeHook(t, dir, "fake", `cat > /dev/null
+if [ "$CNI_COMMAND" = DEL ]; then echo "$CNI_CONTAINERID" > `+deleted+`; exit 0; fi
+echo '{"ip4": {"ip": "10.1.0.5/16"}}'`)
+	fail := writeHook(t, dir, "fail", "cat > /dev/null; exit 1")
+
+	config, err := parseConfig([]byte(`{
+	  "stateDir": "` + filepath.Join(dir, "state") + `",
+	  "hooks": {"postAdd": [{"path": "` + fail + `"}]},
+	  "default": {"name": "fake-net", "type": "fake", "maxAttachments": 2}
+	}`))
+	assert.NoError(t, err)
+
+	args := &skel.CmdArgs{ContainerID: "abc", IfName: "eth0", Args: "K8S_POD_NAMESPACE=web;K8S_POD_NAME=web-1"}
+	env := &selector.DelegateEnv{CNIPath: dir, Args: &invoke.Args{Command: "ADD", ContainerID: "abc", IfName: "eth0"}}
+
+	err = addNetwork(config, args, env, &bytes.Buffer{})
+	assert.Error(t, err)
+
+	data, err := ioutil.ReadFile(deleted)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc\n", string(data))
+
+	att, err := newAttachmentStore(config.StateDir).load("abc")
+	assert.NoError(t, err)
+	assert.Nil(t, att)
+}
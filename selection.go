@@ -0,0 +1,162 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"sort"
+
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+)
+
+// Selects the config given by the NamespaceNetwork resource of the
+// pod's namespace, if NamespaceNetwork resources are enabled.
+type byCRD struct {
+	c *config
+}
+
+func (s byCRD) Select(c *selector.Config, args string, pod selector.PodMetadata) (*selection, error) {
+	if s.c.NamespaceNetworks == nil || !s.c.NamespaceNetworks.Enabled {
+		return nil, nil
+	}
+
+	items, err := s.c.listNamespaceNetworks()
+	if err != nil {
+		c.Logger().WithField("error", err).Warn("Failed to read NamespaceNetworks. Using static config.")
+		return nil, nil
+	}
+
+	configs := s.c.namespaceNetworkConfigs(items)
+	if _, ok := configs[selector.ParseExtraArgs(args)["K8S_POD_NAMESPACE"]]; !ok {
+		return nil, nil
+	}
+
+	// NamespaceNetworks come after the static configs, so whether they
+	// override them is up to duplicateNamespaces.
+	withCRD, err := c.WithNamespacesFrom("NamespaceNetwork", configs)
+	if err != nil {
+		return nil, err
+	}
+
+	return withCRD.Select(args)
+}
+
+// The pod named in CNI_ARGS, looked up in the Kubernetes API at most
+// once, however many selectors need it.  Callers outside Kubernetes
+// have no pod.
+type podLookup struct {
+	c               *config
+	namespace, name string
+
+	looked bool
+	pod    *kubePod
+	err    error
+}
+
+func (c *config) lookupPod(args string) *podLookup {
+	extraArgs := selector.ParseExtraArgs(args)
+	return &podLookup{c: c, namespace: extraArgs["K8S_POD_NAMESPACE"], name: extraArgs["K8S_POD_NAME"]}
+}
+
+// Return the pod, or nil if there is none to look up.
+func (l *podLookup) get() (*kubePod, error) {
+	if l.namespace == "" || l.name == "" {
+		return nil, nil
+	}
+
+	if !l.looked {
+		l.looked = true
+
+		var client *kubeClient
+		if client, l.err = l.c.Kubernetes.client(); l.err == nil {
+			l.pod, l.err = client.getPod(l.namespace, l.name)
+		}
+	}
+
+	return l.pod, l.err
+}
+
+// Return the pod's labels and annotations, as a selector.PodMetadata.
+func (l *podLookup) metadata() (map[string]string, map[string]string, error) {
+	pod, err := l.get()
+	if pod == nil || err != nil {
+		return nil, nil, err
+	}
+
+	return pod.Metadata.Labels, pod.Metadata.Annotations, nil
+}
+
+// Return the name of the pod's service account, as a
+// selector.PodServiceAccount.
+func (l *podLookup) serviceAccount() (string, error) {
+	pod, err := l.get()
+	if pod == nil || err != nil {
+		return "", err
+	}
+
+	return pod.Spec.ServiceAccountName, nil
+}
+
+// Return the pod's QoS class and the resources its containers request,
+// as a selector.PodSpec.  Extended resources may be given as limits
+// only, so those count as requests too.
+func (l *podLookup) resources() (*selector.PodResources, error) {
+	pod, err := l.get()
+	if pod == nil || err != nil {
+		return nil, err
+	}
+
+	resources := &selector.PodResources{QOSClass: pod.Status.QOSClass}
+	seen := map[string]bool{}
+	for _, container := range append(pod.Spec.Containers, pod.Spec.InitContainers...) {
+		for _, quantities := range []map[string]string{container.Resources.Requests, container.Resources.Limits} {
+			for name, quantity := range quantities {
+				if quantity != "0" && !seen[name] {
+					seen[name] = true
+					resources.Requests = append(resources.Requests, name)
+				}
+			}
+		}
+	}
+	sort.Strings(resources.Requests)
+
+	return resources, nil
+}
+
+// Select the network config for the pod named in args, with the
+// strategies in the "selection" block.
+func (c *config) selectPod(args string) (*selection, error) {
+	return c.selectPodWith(args, c.lookupPod(args))
+}
+
+// Select the network config for the pod named in args, looking it up
+// with pod.
+func (c *config) selectPodWith(args string, pod *podLookup) (*selection, error) {
+	var resourceSelectors []selector.ResourceSelector
+	if c.Strategies != nil {
+		resourceSelectors = c.Strategies.ResourceSelectors
+	}
+
+	selectors := c.Selectors(map[string]selector.Selector{
+		selector.ModeCRD:       byCRD{c},
+		selector.ModeEtcd:      byEtcd{c},
+		selector.ModeResources: selector.ByResources{Selectors: resourceSelectors, Resources: pod.resources},
+		selector.ModeRules:     selector.ByRules{Rules: c.Rules, ServiceAccount: pod.serviceAccount},
+	})
+
+	return c.SelectWith(selectors, args, pod.metadata)
+}
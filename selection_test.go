@@ -0,0 +1,138 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Look the pod up once, to select by its annotation.
+func TestSelectPodByAnnotation(t *testing.T) {
+	requests := 0
+	k, cleanup := fakeKubeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"metadata": {"name": "web-1", "namespace": "web",
+		  "labels": {"tier": "fast"},
+		  "annotations": {"kube-namespace.coreos.com/network": "isolated"}}}`))
+	})
+	defer cleanup()
+
+	config, err := parseConfig([]byte(`{
+	  "namespaces": {"isolated": {"name": "isolated", "type": "bridge"}},
+	  "default": {"name": "default-bridge", "type": "bridge"},
+	  "selection": {
+	    "mode": ["labelSelector", "annotation", "namespaceMap"],
+	    "labelSelectors": [{"matchLabels": {"tier": "slow"}, "network": "default-bridge"}]
+	  }
+	}`))
+	assert.NoError(t, err)
+	config.Kubernetes = k
+
+	sel, err := config.selectPod("K8S_POD_NAMESPACE=web;K8S_POD_NAME=web-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "isolated", sel.Rule)
+	assert.Equal(t, 1, requests)
+
+	// Without a pod name there is nothing to look up.
+	sel, err = config.selectPod("K8S_POD_NAMESPACE=web")
+	assert.NoError(t, err)
+	assert.Equal(t, defaultRule, sel.Rule)
+	assert.Equal(t, 1, requests)
+}
+
+// Select by the resources the pod's containers request, and by its
+// QoS class, looking the pod up only once.
+func TestSelectPodByResources(t *testing.T) {
+	requests := 0
+	k, cleanup := fakeKubeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/api/v1/namespaces/web/pods/web-1" {
+			w.Write([]byte(`{"metadata": {"name": "web-1", "namespace": "web"},
+			  "spec": {"containers": [
+			    {"resources": {"requests": {"cpu": "1"}}},
+			    {"resources": {"limits": {"example.com/fastnic": "1"}}}
+			  ]},
+			  "status": {"qosClass": "Burstable"}}`))
+			return
+		}
+		w.Write([]byte(`{"metadata": {"name": "db-1", "namespace": "web"}, "status": {"qosClass": "Guaranteed"}}`))
+	})
+	defer cleanup()
+
+	config, err := parseConfig([]byte(`{
+	  "namespaces": {"fast": {"name": "fast", "type": "ipvlan"}, "pinned": {"name": "pinned", "type": "ptp"}},
+	  "default": {"name": "default-bridge", "type": "bridge"},
+	  "selection": {
+	    "mode": ["resources", "namespaceMap"],
+	    "resourceSelectors": [
+	      {"resource": "example.com/fastnic", "network": "fast"},
+	      {"qosClass": "Guaranteed", "network": "pinned"}
+	    ]
+	  }
+	}`))
+	assert.NoError(t, err)
+	config.Kubernetes = k
+
+	pod := config.lookupPod("K8S_POD_NAMESPACE=web;K8S_POD_NAME=web-1")
+	resources, err := pod.resources()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cpu", "example.com/fastnic"}, resources.Requests)
+
+	sel, err := config.selectPodWith("K8S_POD_NAMESPACE=web;K8S_POD_NAME=web-1", pod)
+	assert.NoError(t, err)
+	assert.Equal(t, "fast", sel.Rule)
+	assert.Equal(t, 1, requests)
+
+	sel, err = config.selectPod("K8S_POD_NAMESPACE=web;K8S_POD_NAME=db-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "pinned", sel.Rule)
+
+	// Pods requesting neither get the namespace's config.
+	sel, err = config.selectPod("K8S_POD_NAMESPACE=web")
+	assert.NoError(t, err)
+	assert.Equal(t, defaultRule, sel.Rule)
+}
+
+// Match rules on the pod's service account, looked up with its labels
+// in a single request.
+func TestSelectPodByRules(t *testing.T) {
+	requests := 0
+	k, cleanup := fakeKubeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"metadata": {"name": "deploy-1", "namespace": "prod-web", "labels": {"team": "ops"}},
+		  "spec": {"serviceAccountName": "ops-deployer"}}`))
+	})
+	defer cleanup()
+
+	config, err := parseConfig([]byte(`{
+	  "profiles": {"ops": {"name": "ops", "type": "macvlan"}},
+	  "rules": [{"match": {"matchLabels": {"team": "ops"}, "serviceAccounts": ["ops-*"]}, "profile": "ops"}],
+	  "default": {"name": "default-bridge", "type": "bridge"}
+	}`))
+	assert.NoError(t, err)
+	config.Kubernetes = k
+
+	sel, err := config.selectPod("K8S_POD_NAMESPACE=prod-web;K8S_POD_NAME=deploy-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "ops", sel.Rule)
+	assert.Equal(t, "macvlan", sel.NetConf["type"])
+	assert.Equal(t, 1, requests)
+}
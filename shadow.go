@@ -0,0 +1,106 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"github.com/coreos/kube-namespace-cni/pkg/preview"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Select the pod's network under the candidate config in ShadowConfig
+// and log where it differs from sel, the selection under the live
+// config, or liveErr.  Nothing is done with the candidate's selection,
+// and its failures are only logged, so that a broken candidate cannot
+// break pods.
+func (c *config) shadowSelect(args string, pod *podLookup, sel *selection, liveErr error) {
+	if c.ShadowConfig == "" {
+		return
+	}
+
+	shadow, err := readConfig(c.ShadowConfig, nil)
+	if err != nil {
+		c.Logger().WithField("error", err).Warn("Failed to load shadow config.")
+		return
+	}
+
+	// The candidate file has no runtime arguments of its own.
+	shadow.RuntimeConfig = c.RuntimeConfig
+	if shadow.Kubernetes == nil {
+		shadow.Kubernetes = c.Kubernetes
+	}
+
+	shadowSel, shadowErr := shadow.selectPodWith(args, pod)
+	if shadowErr == nil {
+		shadowSel, shadowErr = shadow.expandPodCIDR(shadowSel)
+	}
+
+	extraArgs := selector.ParseExtraArgs(args)
+	fields := logrus.Fields{
+		"namespace": extraArgs["K8S_POD_NAMESPACE"],
+		"pod":       extraArgs["K8S_POD_NAME"],
+	}
+
+	switch {
+	case liveErr != nil || shadowErr != nil:
+		if liveErr != nil && shadowErr != nil && liveErr.Error() == shadowErr.Error() {
+			c.Logger().WithFields(fields).Debug("Shadow config agrees with live config.")
+			return
+		}
+		fields["error"] = liveErr
+		fields["shadow_error"] = shadowErr
+		if sel != nil {
+			fields["rule"] = sel.Rule
+		}
+		if shadowSel != nil {
+			fields["shadow_rule"] = shadowSel.Rule
+		}
+		c.Logger().WithFields(fields).Warn("Shadow config differs from live config.")
+
+	default:
+		diff := diffSelections(sel, shadowSel)
+		if len(diff) == 0 {
+			c.Logger().WithFields(fields).Debug("Shadow config agrees with live config.")
+			return
+		}
+		fields["rule"] = sel.Rule
+		fields["shadow_rule"] = shadowSel.Rule
+		fields["fields"] = diff
+		c.Logger().WithFields(fields).Warn("Shadow config differs from live config.")
+	}
+}
+
+// Return what differs between two selections: "rule" if they were made
+// by different rules, and the dotted paths of the delegate config
+// fields that differ.
+func diffSelections(live, shadow *selection) []string {
+	var diff []string
+	if live.Rule != shadow.Rule {
+		diff = append(diff, "rule")
+	}
+
+	changes := preview.Diff(
+		preview.Rendering{"pod": delegateNetConf(live.NetConf)},
+		preview.Rendering{"pod": delegateNetConf(shadow.NetConf)})
+	for _, change := range changes {
+		diff = append(diff, change.Fields...)
+	}
+
+	return diff
+}
@@ -0,0 +1,71 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Report the rule and the delegate fields that a candidate config
+// changes for a pod.
+func TestDiffSelections(t *testing.T) {
+	live, err := parseConfig([]byte(`{
+	  "namespaces": {
+	    "tenant-a": {"name": "tenant-a", "type": "bridge", "mtu": 1500, "frozen": false}
+	  },
+	  "default": {"name": "default", "type": "bridge"}
+	}`))
+	assert.NoError(t, err)
+
+	candidate, err := parseConfig([]byte(`{
+	  "namespaces": {
+	    "tenant-a": {"name": "tenant-a", "type": "bridge", "mtu": 9000, "frozen": true}
+	  }
+	}`))
+	assert.NoError(t, err)
+
+	args := kubeArgs("tenant-a", "web-1")
+	liveSel, err := live.selectPod(args)
+	assert.NoError(t, err)
+	shadowSel, err := candidate.selectPod(args)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"mtu"}, diffSelections(liveSel, shadowSel))
+	assert.Empty(t, diffSelections(liveSel, liveSel))
+
+	defaultSel, err := live.selectPod(kubeArgs("tenant-b", "web-1"))
+	assert.NoError(t, err)
+	assert.Contains(t, diffSelections(liveSel, defaultSel), "rule")
+}
+
+// Never let the shadow config affect the pod.
+func TestShadowSelectMissingConfig(t *testing.T) {
+	config, err := parseConfig([]byte(`{
+	  "shadowConfig": "/nonexistent/kube-namespace.conf",
+	  "namespaces": {"tenant-a": {"name": "tenant-a", "type": "bridge"}}
+	}`))
+	assert.NoError(t, err)
+
+	args := kubeArgs("tenant-a", "web-1")
+	sel, err := config.selectPod(args)
+	assert.NoError(t, err)
+
+	config.shadowSelect(args, config.lookupPod(args), sel, nil)
+}
@@ -0,0 +1,211 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// How long to wait for another ADD to take a VF from the same pool.
+const sriovLockTimeout = 30 * time.Second
+
+// A pool of SR-IOV virtual functions of one physical function, set by
+// an entry of the top-level "sriovPools" block.  Network configs of
+// type sriov name their pool with "sriovPool", and each of their pods
+// is given a VF of its own, passed to the delegate as "master" and
+// "vf".
+type sriovPool struct {
+	// The physical function's interface.
+	Master string `json:"master"`
+	// The VFs in the pool.  Defaults to all the master's VFs.
+	VFs []int `json:"vfs"`
+}
+
+// The VF taken by a pod, as recorded in its attachment.
+type sriovVF struct {
+	Pool   string `json:"pool"`
+	Master string `json:"master"`
+	VF     int    `json:"vf"`
+}
+
+// The use of a pool, as listed by "status --pools".
+type sriovPoolUsage struct {
+	Pool   string `json:"pool"`
+	Master string `json:"master"`
+	Total  int    `json:"total"`
+	Used   int    `json:"used"`
+}
+
+// Parse the "sriovPool" key of a network config.
+func parseSRIOVPool(netconf map[string]interface{}) (string, error) {
+	pool := ""
+	if _, err := decodeNetConfKey(netconf, "sriovPool", &pool); err != nil {
+		return "", err
+	}
+
+	if pool != "" && netconf["type"] != "sriov" {
+		return "", fmt.Errorf("sriovPool given for delegate type %v; it needs sriov.", netconf["type"])
+	}
+
+	return pool, nil
+}
+
+// Return the VFs in the pool, reading the master's VF count from sysfs
+// if the pool does not list them.
+func (p *sriovPool) vfs() ([]int, error) {
+	if len(p.VFs) > 0 {
+		return p.VFs, nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(sysClassNet, p.Master, "device", "sriov_numvfs"))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read VF count of %s: %v", p.Master, err)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid VF count of %s: %v", p.Master, err)
+	}
+
+	vfs := make([]int, n)
+	for i := range vfs {
+		vfs[i] = i
+	}
+	return vfs, nil
+}
+
+// Return the VFs of the pool used by attachments other than the
+// container's.
+func usedVFs(pool string, attachments []*attachment, containerID string) map[int]bool {
+	used := map[int]bool{}
+	for _, att := range attachments {
+		if att.SRIOV != nil && att.SRIOV.Pool == pool && att.ContainerID != containerID {
+			used[att.SRIOV.VF] = true
+		}
+	}
+
+	return used
+}
+
+// Take a free VF of the pool for the pod, recording it in a partial
+// attachment record, as reserveAttachment does, so that concurrent
+// ADDs cannot take the same one.  A pod that already holds a VF, e.g.
+// after an interrupted ADD, keeps it.
+func (c *config) reserveVF(name string, sel *selection, args *skel.CmdArgs) (*sriovVF, error) {
+	pool, ok := c.SRIOVPools[name]
+	if !ok {
+		return nil, fmt.Errorf("SR-IOV pool %q not found.", name)
+	}
+
+	vfs, err := pool.vfs()
+	if err != nil {
+		return nil, err
+	}
+
+	store := newAttachmentStore(c.StateDir)
+	release, err := acquireSlot(c.Logger(), filepath.Join(store.dir, "locks"), "sriov-"+shortHash(name), 1, sriovLockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	att, err := store.load(args.ContainerID)
+	if err != nil {
+		return nil, err
+	}
+	if att != nil && att.SRIOV != nil && att.SRIOV.Pool == name {
+		return att.SRIOV, nil
+	}
+
+	attachments, err := store.list()
+	if err != nil {
+		return nil, err
+	}
+	used := usedVFs(name, attachments, args.ContainerID)
+
+	for _, vf := range vfs {
+		if used[vf] {
+			continue
+		}
+
+		if att == nil {
+			att = &attachment{
+				ContainerID:     args.ContainerID,
+				Namespace:       sel.Namespace,
+				Pod:             sel.Pod,
+				Netns:           args.Netns,
+				IfName:          args.IfName,
+				networkMetadata: newNetworkMetadata(sel),
+				Created:         time.Now().UTC(),
+			}
+		}
+		att.SRIOV = &sriovVF{Pool: name, Master: pool.Master, VF: vf}
+		if err := store.save(att); err != nil {
+			return nil, err
+		}
+
+		c.Logger().WithFields(logrus.Fields{
+			"pool": name,
+			"vf":   vf,
+		}).Debug("Reserved SR-IOV VF.")
+		return att.SRIOV, nil
+	}
+
+	c.Logger().WithFields(logrus.Fields{
+		"pool": name,
+		"vfs":  len(vfs),
+	}).Warn("Rejecting pod: SR-IOV pool exhausted.")
+	return nil, newError(errCodeVFPoolExhausted, "No VFs left in pool %s; all %d are in use.", name, len(vfs))
+}
+
+// Return the use of each pool, sorted by name.
+func (c *config) sriovPoolUsage(attachments []*attachment) ([]sriovPoolUsage, error) {
+	var names []string
+	for name := range c.SRIOVPools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	usage := []sriovPoolUsage{}
+	for _, name := range names {
+		pool := c.SRIOVPools[name]
+		vfs, err := pool.vfs()
+		if err != nil {
+			return nil, err
+		}
+
+		usage = append(usage, sriovPoolUsage{
+			Pool:   name,
+			Master: pool.Master,
+			Total:  len(vfs),
+			Used:   len(usedVFs(name, attachments, "")),
+		})
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+	"github.com/stretchr/testify/assert"
+)
+
+// Give each pod a VF of its own, keep a pod's VF across retried ADDs,
+// and fail once the pool is exhausted.
+func TestReserveVF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-sriov")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := &config{
+		StateDir:   dir,
+		SRIOVPools: map[string]*sriovPool{"fast-net": {Master: "ens1f0", VFs: []int{3, 4}}},
+	}
+	sel := &selection{Namespace: "tenant-a", Pod: "web-1"}
+
+	vf, err := config.reserveVF("fast-net", sel, &skel.CmdArgs{ContainerID: "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, &sriovVF{Pool: "fast-net", Master: "ens1f0", VF: 3}, vf)
+
+	vf, err = config.reserveVF("fast-net", sel, &skel.CmdArgs{ContainerID: "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, vf.VF)
+
+	vf, err = config.reserveVF("fast-net", sel, &skel.CmdArgs{ContainerID: "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, vf.VF)
+
+	_, err = config.reserveVF("fast-net", sel, &skel.CmdArgs{ContainerID: "c"})
+	assert.True(t, selector.Is(err, selector.ErrVFPoolExhausted))
+	assert.EqualError(t, err, "No VFs left in pool fast-net; all 2 are in use.")
+
+	// Removing an attachment frees its VF.
+	assert.NoError(t, newAttachmentStore(dir).remove("a"))
+	vf, err = config.reserveVF("fast-net", sel, &skel.CmdArgs{ContainerID: "c"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, vf.VF)
+
+	_, err = config.reserveVF("slow-net", sel, &skel.CmdArgs{ContainerID: "d"})
+	assert.Error(t, err)
+}
+
+// Read the VFs of the master from sysfs, and report the pools' use.
+func TestSRIOVPoolUsage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-sriov")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sysClassNet = filepath.Join(dir, "sys")
+	defer func() { sysClassNet = "/sys/class/net" }()
+	assert.NoError(t, os.MkdirAll(filepath.Join(sysClassNet, "ens2f0", "device"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(sysClassNet, "ens2f0", "device", "sriov_numvfs"), []byte("8\n"), 0644))
+
+	config := &config{SRIOVPools: map[string]*sriovPool{
+		"fast-net": {Master: "ens1f0", VFs: []int{3, 4}},
+		"bulk-net": {Master: "ens2f0"},
+	}}
+	attachments := []*attachment{
+		{ContainerID: "a", SRIOV: &sriovVF{Pool: "bulk-net", VF: 0}},
+		{ContainerID: "b", SRIOV: &sriovVF{Pool: "bulk-net", VF: 5}},
+		{ContainerID: "c"},
+	}
+
+	usage, err := config.sriovPoolUsage(attachments)
+	assert.NoError(t, err)
+	assert.Equal(t, []sriovPoolUsage{
+		{Pool: "bulk-net", Master: "ens2f0", Total: 8, Used: 2},
+		{Pool: "fast-net", Master: "ens1f0", Total: 2, Used: 0},
+	}, usage)
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, printPoolUsage(usage, "text", out))
+	assert.Regexp(t, `bulk-net\s+ens2f0\s+2\s+8\s+6`, out.String())
+}
+
+// Only sriov delegates take a pool.
+func TestParseSRIOVPool(t *testing.T) {
+	pool, err := parseSRIOVPool(map[string]interface{}{"type": "sriov", "sriovPool": "fast-net"})
+	assert.NoError(t, err)
+	assert.Equal(t, "fast-net", pool)
+
+	_, err = parseSRIOVPool(map[string]interface{}{"type": "bridge", "sriovPool": "fast-net"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,441 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+)
+
+const defaultStateDir = "/var/lib/cni/kube-namespace"
+
+// The version of the attachment record format.  When the format
+// changes, bump it and add a migration from the previous version, so
+// that records written by older binaries are still understood after
+// an upgrade.  Records without a version are version 1.
+const attachmentSchemaVersion = 1
+
+// Migrations of raw attachment records, indexed by the version they
+// migrate from.  Each one must leave the record valid for the next
+// version.
+var attachmentMigrations = map[int]func(record map[string]interface{}) error{}
+
+// A record of a pod's network attachment, kept from ADD until DEL.
+type attachment struct {
+	SchemaVersion int `json:"schemaVersion"`
+
+	ContainerID  string `json:"containerID"`
+	Namespace    string `json:"namespace"`
+	Pod          string `json:"pod"`
+	Netns        string `json:"netns"`
+	IfName       string `json:"ifName"`
+	DelegateType string `json:"delegateType"`
+	networkMetadata
+	Result  *types.Result `json:"result"`
+	Created time.Time     `json:"created"`
+
+	// The delegate's result as printed, if in the 0.3 format.
+	Result030 *selector.Result030 `json:"result030,omitempty"`
+	// Whether egress rules were installed for the pod, and the host
+	// interface they were offloaded to, if any.
+	EgressRules            bool   `json:"egressRules,omitempty"`
+	EgressOffloadInterface string `json:"egressOffloadInterface,omitempty"`
+	// Host end of the pod's veth, if kube-namespace renamed it.
+	HostInterface string `json:"hostInterface,omitempty"`
+	// Host interface that traffic mirroring was set up on.
+	MirroredInterface string `json:"mirroredInterface,omitempty"`
+	// Secondary addresses allocated for the pod, in CIDR notation.
+	AdditionalIPs []string `json:"additionalIPs,omitempty"`
+	// Host routes to the pod, and the host interface they go via.
+	HostRoutes      []string `json:"hostRoutes,omitempty"`
+	HostRouteDevice string   `json:"hostRouteDevice,omitempty"`
+	// Whether the pod has a ptp-auto link.
+	PTPAuto bool `json:"ptpAuto,omitempty"`
+	// The SR-IOV VF taken by the pod.
+	SRIOV *sriovVF `json:"sriov,omitempty"`
+	// The host NIC moved into the pod.
+	HostDevice *hostDevice `json:"hostDevice,omitempty"`
+	// Host routes to the pod published into a routing table.
+	PublishedRoutes *publishedRoutes `json:"publishedRoutes,omitempty"`
+	// Whether the pod was registered in DNS.
+	DNSRegistered bool `json:"dnsRegistered,omitempty"`
+	// The chain masquerading the pod's traffic, if kube-namespace set
+	// one up.
+	IPMasqChain string `json:"ipMasqChain,omitempty"`
+	// The chain exempting destinations from masquerading, if any.
+	IPMasqExcludeChain string `json:"ipMasqExcludeChain,omitempty"`
+	// Whether DNAT rules were installed for the pod's hostPorts.
+	HostPorts bool `json:"hostPorts,omitempty"`
+	// The gateway of the pod's default route, if chosen by
+	// kube-namespace.
+	Gateway string `json:"gateway,omitempty"`
+}
+
+// The attachment store keeps one JSON file per container.
+type attachmentStore struct {
+	dir string
+}
+
+func newAttachmentStore(dir string) *attachmentStore {
+	if dir == "" {
+		dir = defaultStateDir
+	}
+
+	return &attachmentStore{dir: dir}
+}
+
+func (s *attachmentStore) path(containerID string) (string, error) {
+	if containerID == "" || strings.ContainsAny(containerID, `/\`) || containerID[0] == '.' {
+		return "", fmt.Errorf("Invalid container ID %q.", containerID)
+	}
+
+	return filepath.Join(s.dir, containerID+".json"), nil
+}
+
+// Save an attachment.  The file is written atomically, so readers
+// never see a partial record.
+func (s *attachmentStore) save(a *attachment) error {
+	path, err := s.path(a.ContainerID)
+	if err != nil {
+		return err
+	}
+
+	a.SchemaVersion = attachmentSchemaVersion
+
+	data, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal attachment: %v", err)
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("Failed to create state directory: %v", err)
+	}
+
+	tmp, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("Failed to save attachment: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Failed to save attachment: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Failed to save attachment: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("Failed to save attachment: %v", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("Failed to save attachment: %v", err)
+	}
+
+	return nil
+}
+
+// Load the attachment for a container.  Returns nil if there is none.
+func (s *attachmentStore) load(containerID string) (*attachment, error) {
+	path, err := s.path(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to read attachment: %v", err)
+	}
+
+	a := &attachment{}
+	migrated, err := decodeVersioned(path, data, a, attachmentSchemaVersion, attachmentMigrations)
+	if err != nil {
+		return nil, err
+	}
+
+	if migrated {
+		// Rewrite the record, so it is only migrated once.  If that
+		// fails, it is simply migrated again next time.
+		if err := s.save(a); err != nil {
+			log.WithField("error", err).Warn("Failed to save migrated attachment.")
+		}
+	}
+
+	return a, nil
+}
+
+// A record written by a newer kube-namespace, in a schema version this
+// one does not know.
+type newerSchemaError struct {
+	path      string
+	version   int
+	supported int
+}
+
+func (e *newerSchemaError) Error() string {
+	if e.path == "" {
+		return fmt.Sprintf("Schema version %d is newer than supported version %d.", e.version, e.supported)
+	}
+	return fmt.Sprintf("Record %q has schema version %d, newer than supported version %d.", e.path, e.version, e.supported)
+}
+
+// Whether err is about a record written by a newer kube-namespace.
+func isNewerSchema(err error) bool {
+	_, ok := err.(*newerSchemaError)
+	return ok
+}
+
+// Decode the JSON record read from path into v, migrating it to
+// schema version target first.  Returns whether it was migrated, in
+// which case the caller should save v, so it is only migrated once.
+func decodeVersioned(path string, data []byte, v interface{}, target int, migrations map[int]func(map[string]interface{}) error) (bool, error) {
+	record := map[string]interface{}{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return false, fmt.Errorf("Failed to parse %q: %v", path, err)
+	}
+
+	migrated, err := migrateRecord(record, target, migrations)
+	if newer, ok := err.(*newerSchemaError); ok {
+		newer.path = path
+		return false, newer
+	} else if err != nil {
+		return false, fmt.Errorf("Failed to migrate %q: %v", path, err)
+	}
+
+	if err := decodeRecord(record, v); err != nil {
+		return false, fmt.Errorf("Failed to parse %q: %v", path, err)
+	}
+
+	return migrated, nil
+}
+
+// Bring a raw record up to schema version target by applying
+// migrations in turn.  Returns whether any migration was applied.
+func migrateRecord(record map[string]interface{}, target int, migrations map[int]func(map[string]interface{}) error) (bool, error) {
+	version := 1
+	if v, ok := record["schemaVersion"].(float64); ok {
+		version = int(v)
+	}
+
+	if version > target {
+		return false, &newerSchemaError{version: version, supported: target}
+	}
+
+	migrated := false
+	for ; version < target; version++ {
+		migrate, ok := migrations[version]
+		if !ok {
+			return false, fmt.Errorf("No migration from schema version %d.", version)
+		}
+
+		if err := migrate(record); err != nil {
+			return false, err
+		}
+
+		record["schemaVersion"] = float64(version + 1)
+		migrated = true
+	}
+
+	return migrated, nil
+}
+
+// Decode a raw JSON record into v.
+func decodeRecord(record map[string]interface{}, v interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// Remove the attachment for a container, if there is one.
+func (s *attachmentStore) remove(containerID string) error {
+	path, err := s.path(containerID)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Failed to remove attachment: %v", err)
+	}
+
+	return nil
+}
+
+// The file in the state directory recording the schema versions its
+// records were last migrated to.  Not named *.json, which would make
+// it an attachment.
+const stateSchemaFile = "schema-versions"
+
+// How long migrating waits for the lock of a container that is being
+// added or deleted, before leaving its records for the next run.
+const migrateLockTimeout = time.Second
+
+// The current schema versions of the records in the state directory,
+// by store.
+func stateSchemaVersions() map[string]int {
+	return map[string]int{
+		"attachments": attachmentSchemaVersion,
+		"ipam":        ipamSchemaVersion,
+		"journal":     journalSchemaVersion,
+		"ptpAuto":     ptpAutoSchemaVersion,
+		"sticky":      stickySchemaVersion,
+	}
+}
+
+// Migrate all records in the state directory to the current schema
+// versions, the first time an upgraded binary runs.  Records are also
+// migrated when read, so one left behind here, e.g. as its container
+// was busy, is still understood, and migrating is retried on the next
+// run.
+func (c *config) migrateState() error {
+	store := newAttachmentStore(c.StateDir)
+	path := filepath.Join(store.dir, stateSchemaFile)
+	want := stateSchemaVersions()
+
+	migrated := func() bool {
+		recorded := map[string]int{}
+		data, err := ioutil.ReadFile(path)
+		return err == nil && json.Unmarshal(data, &recorded) == nil && reflect.DeepEqual(recorded, want)
+	}
+	if migrated() {
+		return nil
+	}
+	if _, err := os.Stat(store.dir); os.IsNotExist(err) {
+		// A new node: there is nothing to migrate.
+		return writeJSONAtomic(path, want)
+	}
+
+	release, err := acquireSlot(c.Logger(), filepath.Join(store.dir, "locks"), "migrate", 1, containerLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if migrated() {
+		return nil
+	}
+
+	if !store.migrate() {
+		return nil
+	}
+
+	c.Logger().WithField("versions", want).Info("Migrated state.")
+	return writeJSONAtomic(path, want)
+}
+
+// Migrate the records of the stores in the state directory.  Returns
+// whether all of them were migrated, or could not be.
+func (s *attachmentStore) migrate() bool {
+	complete := true
+
+	ids := map[string]bool{}
+	journal := &opJournal{dir: filepath.Join(s.dir, "journal")}
+	for _, store := range []*attachmentStore{s, {dir: journal.dir}} {
+		for _, id := range store.ids() {
+			ids[id] = true
+		}
+	}
+	for id := range ids {
+		release, err := acquireSlot(log, filepath.Join(s.dir, "locks"), containerLockName(id), 1, migrateLockTimeout)
+		if err != nil {
+			complete = false
+			continue
+		}
+
+		if _, err := s.load(id); err != nil && !isNewerSchema(err) {
+			log.WithField("error", err).Warn("Failed to migrate attachment.")
+		}
+		if err := journal.migrate(id); err != nil && !isNewerSchema(err) {
+			log.WithField("error", err).Warn("Failed to migrate journal entry.")
+		}
+		release()
+	}
+
+	// Reading migrates sticky records, ptp-auto state and the IPAM
+	// store.
+	paths, _ := filepath.Glob(filepath.Join(s.dir, "sticky", "*.json"))
+	for _, path := range paths {
+		if _, err := readStickyRecord(path); err != nil && !isNewerSchema(err) {
+			log.WithField("error", err).Warn("Failed to migrate sticky address.")
+		}
+	}
+
+	ptp := &ptpAllocator{dir: s.dir}
+	if _, err := os.Stat(ptp.path()); err == nil {
+		if err := ptp.update(func(*ptpAutoState) error { return nil }); err != nil {
+			log.WithField("error", err).Warn("Failed to migrate ptp-auto state.")
+		}
+	}
+
+	ipamPath := filepath.Join(s.dir, ipamStoreFile)
+	if _, err := os.Stat(ipamPath); err == nil {
+		if err := updateIPAMStore(ipamPath, func(*ipamDB) error { return nil }); err != nil {
+			log.WithField("error", err).Warn("Failed to migrate IPAM store.")
+		}
+	}
+
+	return complete
+}
+
+// List all attachments.  Records written by a newer kube-namespace,
+// e.g. before a downgrade, are skipped with a warning.
+func (s *attachmentStore) list() ([]*attachment, error) {
+	var attachments []*attachment
+	for _, id := range s.ids() {
+		a, err := s.load(id)
+		if isNewerSchema(err) {
+			log.WithField("error", err).Warn("Skipping attachment of a newer kube-namespace.")
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if a != nil {
+			attachments = append(attachments, a)
+		}
+	}
+
+	return attachments, nil
+}
+
+// Return the container IDs of the records in the store.  Other stores'
+// files kept in the state directory are left out.
+func (s *attachmentStore) ids() []string {
+	paths, _ := filepath.Glob(filepath.Join(s.dir, "*.json"))
+
+	var ids []string
+	for _, path := range paths {
+		if name := filepath.Base(path); name != ipamStoreFile && name != ptpAutoStateFile {
+			ids = append(ids, strings.TrimSuffix(name, ".json"))
+		}
+	}
+
+	return ids
+}
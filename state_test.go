@@ -0,0 +1,182 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tempStore(t *testing.T) *attachmentStore {
+	dir, err := ioutil.TempDir("", "kube-namespace")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	return newAttachmentStore(dir)
+}
+
+// Save, load, list and remove attachments.
+func TestAttachmentStore(t *testing.T) {
+	store := tempStore(t)
+	defer os.RemoveAll(store.dir)
+
+	a := &attachment{ContainerID: "abc", Namespace: "isolated", Pod: "web-1"}
+	a.Rule = "isolated"
+
+	assert.NoError(t, store.save(a))
+
+	loaded, err := store.load("abc")
+	assert.NoError(t, err)
+	assert.Equal(t, "isolated", loaded.Rule)
+
+	all, err := store.list()
+	assert.NoError(t, err)
+	assert.Len(t, all, 1)
+
+	assert.NoError(t, store.remove("abc"))
+	assert.NoError(t, store.remove("abc"))
+
+	loaded, err = store.load("abc")
+	assert.NoError(t, err)
+	assert.Nil(t, loaded)
+}
+
+// Reject container IDs that would escape the state directory.
+func TestAttachmentStoreBadID(t *testing.T) {
+	store := newAttachmentStore("/nonexistent")
+
+	assert.Error(t, store.save(&attachment{ContainerID: "../etc/passwd"}))
+}
+
+// Migrate records written with an older schema version.
+func TestMigrateRecord(t *testing.T) {
+	migrations := map[int]func(map[string]interface{}) error{
+		1: func(record map[string]interface{}) error {
+			record["pod"] = record["podName"]
+			delete(record, "podName")
+			return nil
+		},
+	}
+
+	record := map[string]interface{}{"containerID": "abc", "podName": "web-1"}
+	migrated, err := migrateRecord(record, 2, migrations)
+
+	assert.NoError(t, err)
+	assert.True(t, migrated)
+	assert.Equal(t, "web-1", record["pod"])
+	assert.Equal(t, float64(2), record["schemaVersion"])
+}
+
+// Refuse records written by a newer binary.
+func TestMigrateRecordTooNew(t *testing.T) {
+	record := map[string]interface{}{"schemaVersion": float64(attachmentSchemaVersion + 1)}
+
+	_, err := migrateRecord(record, attachmentSchemaVersion, attachmentMigrations)
+
+	assert.Error(t, err)
+}
+
+// Skip records written by a newer binary when listing, and leave
+// other stores' files in the state directory out.
+func TestAttachmentStoreListNewer(t *testing.T) {
+	store := tempStore(t)
+	defer os.RemoveAll(store.dir)
+
+	assert.NoError(t, store.save(&attachment{ContainerID: "abc"}))
+	newer := fmt.Sprintf(`{"schemaVersion": %d, "containerID": "def"}`, attachmentSchemaVersion+1)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(store.dir, "def.json"), []byte(newer), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(store.dir, ptpAutoStateFile), []byte(`{"blocks": {}}`), 0644))
+
+	all, err := store.list()
+	assert.NoError(t, err)
+	if assert.Len(t, all, 1) {
+		assert.Equal(t, "abc", all[0].ContainerID)
+	}
+
+	_, err = store.load("def")
+	assert.True(t, isNewerSchema(err))
+}
+
+// Decode records, migrating older ones, and refuse newer ones.
+func TestDecodeVersioned(t *testing.T) {
+	migrations := map[int]func(map[string]interface{}) error{
+		1: func(record map[string]interface{}) error {
+			record["ip"] = record["address"]
+			return nil
+		},
+	}
+
+	record := &stickyRecord{}
+	migrated, err := decodeVersioned("x.json", []byte(`{"address": "10.0.0.5"}`), record, 2, migrations)
+	assert.NoError(t, err)
+	assert.True(t, migrated)
+	assert.Equal(t, "10.0.0.5", record.IP)
+	assert.Equal(t, 2, record.SchemaVersion)
+
+	migrated, err = decodeVersioned("x.json", []byte(`{"schemaVersion": 2, "ip": "10.0.0.6"}`), record, 2, migrations)
+	assert.NoError(t, err)
+	assert.False(t, migrated)
+
+	_, err = decodeVersioned("x.json", []byte(`{"schemaVersion": 3}`), record, 2, migrations)
+	assert.True(t, isNewerSchema(err))
+	assert.Contains(t, err.Error(), "x.json")
+}
+
+// Migrate every store's records once, skipping newer ones, and record
+// the versions.
+func TestMigrateState(t *testing.T) {
+	store := tempStore(t)
+	defer os.RemoveAll(store.dir)
+	c := &config{StateDir: store.dir}
+
+	newer := fmt.Sprintf(`{"schemaVersion": %d, "containerID": "def"}`, attachmentSchemaVersion+1)
+	files := map[string]string{
+		"abc.json":         `{"containerID": "abc", "pod": "web-1"}`,
+		"def.json":         newer,
+		"journal/abc.json": `{"op": "ADD", "pid": 1}`,
+		"sticky/x.json":    `{"namespace": "web", "ip": "10.0.0.5"}`,
+		ptpAutoStateFile:   `{"blocks": {"web": "10.1.0.0/28"}}`,
+		ipamStoreFile:      `{"networks": {}}`,
+	}
+	for name, data := range files {
+		path := filepath.Join(store.dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(data), 0644))
+	}
+
+	assert.NoError(t, c.migrateState())
+
+	versions := map[string]int{}
+	data, err := ioutil.ReadFile(filepath.Join(store.dir, stateSchemaFile))
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(data, &versions))
+	assert.Equal(t, stateSchemaVersions(), versions)
+
+	data, _ = ioutil.ReadFile(filepath.Join(store.dir, "def.json"))
+	assert.Equal(t, newer, string(data))
+
+	data, _ = ioutil.ReadFile(filepath.Join(store.dir, ipamStoreFile))
+	assert.Contains(t, string(data), fmt.Sprintf(`"schemaVersion":%d`, ipamSchemaVersion))
+}
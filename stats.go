@@ -0,0 +1,97 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Lifetime traffic counters of a pod interface.
+type interfaceStats struct {
+	RxBytes   uint64 `json:"rxBytes"`
+	RxPackets uint64 `json:"rxPackets"`
+	TxBytes   uint64 `json:"txBytes"`
+	TxPackets uint64 `json:"txPackets"`
+}
+
+// Read the counters of ifName in the network namespace at netns.
+func readInterfaceStats(netns, ifName string) (*interfaceStats, error) {
+	var stats *interfaceStats
+
+	err := withNetNS(netns, func() error {
+		// /proc/self/net follows the main thread, so read the netns
+		// of the thread that has switched into the pod's.
+		f, err := os.Open(fmt.Sprintf("/proc/self/task/%d/net/dev", syscall.Gettid()))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		stats, err = parseNetDev(f, ifName)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read interface statistics: %v", err)
+	}
+
+	return stats, nil
+}
+
+// Parse the counters of ifName from the contents of /proc/net/dev.
+func parseNetDev(r io.Reader, ifName string) (*interfaceStats, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != ifName {
+			continue
+		}
+
+		// Eight receive counters, then eight transmit counters.
+		fields := strings.Fields(parts[1])
+		if len(fields) < 16 {
+			return nil, fmt.Errorf("Malformed statistics for %q.", ifName)
+		}
+
+		var counters [4]uint64
+		for i, field := range []int{0, 1, 8, 9} {
+			n, err := strconv.ParseUint(fields[field], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Malformed statistics for %q: %v", ifName, err)
+			}
+			counters[i] = n
+		}
+
+		return &interfaceStats{
+			RxBytes:   counters[0],
+			RxPackets: counters[1],
+			TxBytes:   counters[2],
+			TxPackets: counters[3],
+		}, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("Interface %q not found.", ifName)
+}
@@ -0,0 +1,47 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const netDev = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:     100       2    0    0    0     0          0         0      100       2    0    0    0     0       0          0
+  eth0: 1234567    8910    0    0    0     0          0         0   765432    1098    0    0    0     0       0          0
+`
+
+// Parse an interface's counters from /proc/net/dev.
+func TestParseNetDev(t *testing.T) {
+	stats, err := parseNetDev(strings.NewReader(netDev), "eth0")
+
+	assert.NoError(t, err)
+	assert.Equal(t, &interfaceStats{
+		RxBytes:   1234567,
+		RxPackets: 8910,
+		TxBytes:   765432,
+		TxPackets: 1098,
+	}, stats)
+
+	_, err = parseNetDev(strings.NewReader(netDev), "eth1")
+	assert.Error(t, err)
+}
@@ -0,0 +1,224 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// An active attachment, as listed by the status subcommand.
+type statusEntry struct {
+	ContainerID string    `json:"containerID"`
+	Namespace   string    `json:"namespace"`
+	Pod         string    `json:"pod"`
+	Network     string    `json:"network"`
+	Rule        string    `json:"rule"`
+	Delegate    string    `json:"delegate"`
+	IPs         []string  `json:"ips"`
+	Created     time.Time `json:"created"`
+}
+
+// Return the status entries of attachments, sorted by namespace and
+// pod.
+func statusEntries(attachments []*attachment) []statusEntry {
+	entries := []statusEntry{}
+	for _, att := range attachments {
+		entry := statusEntry{
+			ContainerID: att.ContainerID,
+			Namespace:   att.Namespace,
+			Pod:         att.Pod,
+			Network:     att.Network,
+			Rule:        att.Rule,
+			Delegate:    att.DelegateType,
+			IPs:         []string{},
+			Created:     att.Created,
+		}
+
+		// Quota reservations have no result yet.
+		if att.Result != nil {
+			for _, ip := range podAddresses(att.Result, att.AdditionalIPs) {
+				entry.IPs = append(entry.IPs, ip.String())
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+	sortStatusEntries(entries)
+
+	return entries
+}
+
+// Sort status entries by namespace and pod.
+func sortStatusEntries(entries []statusEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Namespace != entries[j].Namespace {
+			return entries[i].Namespace < entries[j].Namespace
+		}
+		if entries[i].Pod != entries[j].Pod {
+			return entries[i].Pod < entries[j].Pod
+		}
+		return entries[i].ContainerID < entries[j].ContainerID
+	})
+}
+
+// Return a duration the way kubectl prints ages, e.g. "5m" or "3d".
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+
+	return fmt.Sprintf("%dd", int(d.Hours()/24))
+}
+
+// Return s, or "-" if it is empty.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// Print the attachments in the state directory.
+func cmdStatus(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	configPath := flags.String("config", "", "plugin config file (default stdin)")
+	stateDir := flags.String("state-dir", "", "state directory to read instead of the config's")
+	output := flags.String("output", "text", "output format: text or json")
+	flags.StringVar(output, "o", "text", "alias for --output")
+	pools := flags.Bool("pools", false, "list the use of SR-IOV pools instead")
+	devices := flags.Bool("devices", false, "list the use of host devices instead")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("Unknown output format %q.", *output)
+	}
+
+	var c *config
+	if *stateDir == "" || *pools || *devices {
+		var err error
+		if c, err = readConfig(*configPath, stdin); err != nil {
+			return err
+		}
+		if *stateDir == "" {
+			*stateDir = c.StateDir
+		}
+	}
+
+	attachments, err := newAttachmentStore(*stateDir).list()
+	if err != nil {
+		return err
+	}
+
+	if *pools {
+		usage, err := c.sriovPoolUsage(attachments)
+		if err != nil {
+			return err
+		}
+		return printPoolUsage(usage, *output, stdout)
+	}
+
+	if *devices {
+		usage, err := c.hostDeviceUsage(attachments)
+		if err != nil {
+			return err
+		}
+		return printHostDeviceUsage(usage, *output, stdout)
+	}
+
+	entries := statusEntries(attachments)
+
+	if *output == "json" {
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s\n", data)
+		return nil
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "CONTAINER\tNAMESPACE\tPOD\tPROFILE\tDELEGATE\tIP\tAGE")
+	for _, e := range entries {
+		id := e.ContainerID
+		if len(id) > 12 {
+			id = id[:12]
+		}
+
+		ips := "-"
+		if len(e.IPs) > 0 {
+			ips = strings.Join(e.IPs, ",")
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", id, orDash(e.Namespace), orDash(e.Pod),
+			orDash(e.Rule), orDash(e.Delegate), ips, formatAge(now.Sub(e.Created)))
+	}
+	return w.Flush()
+}
+
+// Print the use of SR-IOV pools.
+func printPoolUsage(usage []sriovPoolUsage, output string, stdout io.Writer) error {
+	if output == "json" {
+		data, err := json.MarshalIndent(usage, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s\n", data)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "POOL\tMASTER\tUSED\tTOTAL\tFREE")
+	for _, u := range usage {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\n", u.Pool, u.Master, u.Used, u.Total, u.Total-u.Used)
+	}
+	return w.Flush()
+}
+
+// Print the use of host devices.
+func printHostDeviceUsage(usage []hostDeviceUsage, output string, stdout io.Writer) error {
+	if output == "json" {
+		data, err := json.MarshalIndent(usage, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s\n", data)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "DEVICE\tPCI ADDRESS\tDRIVER\tNAMESPACES\tPOD")
+	for _, u := range usage {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", u.Name, orDash(u.PCIAddress), orDash(u.Driver),
+			strings.Join(u.Namespaces, ","), orDash(u.Pod))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,76 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// List attachments as a table and as JSON.
+func TestStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-status")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := newAttachmentStore(dir)
+	assert.NoError(t, store.save(&attachment{
+		ContainerID:     "0123456789abcdef",
+		Namespace:       "tenant-a",
+		Pod:             "web-1",
+		DelegateType:    "bridge",
+		networkMetadata: networkMetadata{Network: "tenant-a-net", Rule: "tenant-a"},
+		Result:          &types.Result{IP4: &types.IPConfig{IP: net.IPNet{IP: net.ParseIP("10.2.0.5"), Mask: net.CIDRMask(16, 32)}}},
+		Created:         time.Now().Add(-90 * time.Minute),
+	}))
+	// A quota reservation, without a result.
+	assert.NoError(t, store.save(&attachment{ContainerID: "fedcba", Namespace: "tenant-a", Pod: "web-0"}))
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, cmdStatus([]string{"--state-dir", dir}, nil, out))
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	if assert.Len(t, lines, 3) {
+		assert.Contains(t, string(lines[1]), "web-0")
+		assert.Regexp(t, `^0123456789ab\s+tenant-a\s+web-1\s+tenant-a\s+bridge\s+10\.2\.0\.5\s+1h$`, string(lines[2]))
+	}
+
+	out.Reset()
+	assert.NoError(t, cmdStatus([]string{"--state-dir", dir, "-o", "json"}, nil, out))
+	var entries []statusEntry
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &entries))
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, []string{"10.2.0.5"}, entries[1].IPs)
+		assert.Equal(t, "tenant-a-net", entries[1].Network)
+	}
+}
+
+// Print ages as kubectl does.
+func TestFormatAge(t *testing.T) {
+	assert.Equal(t, "42s", formatAge(42*time.Second))
+	assert.Equal(t, "5m", formatAge(5*time.Minute))
+	assert.Equal(t, "30h", formatAge(30*time.Hour))
+	assert.Equal(t, "3d", formatAge(72*time.Hour))
+}
@@ -0,0 +1,229 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// How long after DEL a pod's address is kept for it, for the sandbox
+// to be restarted in.
+const stickyIPWindow = 5 * time.Minute
+
+// The version of the sticky record format, and the migrations from
+// older ones; see attachmentSchemaVersion.
+const stickySchemaVersion = 1
+
+var stickyMigrations = map[int]func(record map[string]interface{}) error{}
+
+// The address a pod had when its sandbox was last torn down.
+type stickyRecord struct {
+	SchemaVersion int `json:"schemaVersion"`
+
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	UID       string    `json:"uid"`
+	IP        string    `json:"ip"`
+	Released  time.Time `json:"released"`
+}
+
+// The sticky store keeps one record per pod, keyed by its namespace,
+// name and UID, so that a pod recreated under the same name does not
+// inherit its predecessor's address.
+type stickyStore struct {
+	dir    string
+	window time.Duration
+}
+
+func newStickyStore(stateDir string) *stickyStore {
+	return &stickyStore{
+		dir:    filepath.Join(newAttachmentStore(stateDir).dir, "sticky"),
+		window: stickyIPWindow,
+	}
+}
+
+// Return the path of the record of the pod in CNI_ARGS args, or false
+// if the pod cannot be identified.
+func (s *stickyStore) path(args string) (string, bool) {
+	kv := selector.ParseExtraArgs(args)
+	namespace, pod, uid := kv["K8S_POD_NAMESPACE"], kv["K8S_POD_NAME"], kv["K8S_POD_UID"]
+	if namespace == "" || pod == "" || uid == "" {
+		return "", false
+	}
+
+	return filepath.Join(s.dir, shortHash(namespace+"/"+pod+"/"+uid)+".json"), true
+}
+
+// Record the pod's address from the result of its ADD.  Expired
+// records of other pods are pruned on the way.
+func (s *stickyStore) remember(args string, result *types.Result) error {
+	path, ok := s.path(args)
+	if !ok || result == nil {
+		return nil
+	}
+
+	var ip string
+	for _, ipc := range []*types.IPConfig{result.IP4, result.IP6} {
+		if ipc != nil {
+			ip = ipc.IP.IP.String()
+			break
+		}
+	}
+	if ip == "" {
+		return nil
+	}
+
+	s.prune()
+
+	kv := selector.ParseExtraArgs(args)
+	return writeStickyRecord(path, &stickyRecord{
+		Namespace: kv["K8S_POD_NAMESPACE"],
+		Pod:       kv["K8S_POD_NAME"],
+		UID:       kv["K8S_POD_UID"],
+		IP:        ip,
+		Released:  time.Now().UTC(),
+	})
+}
+
+// Return the address the pod had, if its sandbox was torn down within
+// the window, or "".
+func (s *stickyStore) recall(log *logrus.Entry, args string) string {
+	path, ok := s.path(args)
+	if !ok {
+		return ""
+	}
+
+	record, err := readStickyRecord(path)
+	if err != nil {
+		log.WithField("error", err).Warn("Ignoring unreadable sticky address.")
+		return ""
+	}
+	if record == nil {
+		return ""
+	}
+
+	if time.Since(record.Released) > s.window {
+		s.forget(log, args)
+		return ""
+	}
+
+	return record.IP
+}
+
+// Remove the pod's record, once it has its address back.
+func (s *stickyStore) forget(log *logrus.Entry, args string) {
+	path, ok := s.path(args)
+	if !ok {
+		return
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.WithField("error", err).Warn("Failed to remove sticky address.")
+	}
+}
+
+// Remove the records of pods that were not restarted within the
+// window.
+func (s *stickyStore) prune() {
+	paths, _ := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	for _, path := range paths {
+		record, err := readStickyRecord(path)
+		if isNewerSchema(err) || (err == nil && record != nil && time.Since(record.Released) <= s.window) {
+			continue
+		}
+		os.Remove(path)
+	}
+}
+
+// Read a sticky record, migrating it if it is in an older format.
+// Returns nil if there is none.
+func readStickyRecord(path string) (*stickyRecord, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	record := &stickyRecord{}
+	migrated, err := decodeVersioned(path, data, record, stickySchemaVersion, stickyMigrations)
+	if err != nil {
+		return nil, err
+	}
+
+	if migrated {
+		if err := writeStickyRecord(path, record); err != nil {
+			log.WithField("error", err).Warn("Failed to save migrated sticky address.")
+		}
+	}
+
+	return record, nil
+}
+
+func writeStickyRecord(path string, record *stickyRecord) error {
+	record.SchemaVersion = stickySchemaVersion
+	if err := writeJSONAtomic(path, record); err != nil {
+		return fmt.Errorf("Failed to save sticky address: %v", err)
+	}
+
+	return nil
+}
+
+// Return the environment to run the delegate's ADD in to request ip
+// from its IPAM plugin.  host-local honours the IP argument; IPAM
+// plugins that do not support it ignore it.
+func stickyEnv(env *selector.DelegateEnv, args *skel.CmdArgs, ip string) *selector.DelegateEnv {
+	return &selector.DelegateEnv{
+		CNIPath: env.CNIPath,
+		Args: &invoke.Args{
+			Command:       "ADD",
+			ContainerID:   args.ContainerID,
+			NetNS:         args.Netns,
+			PluginArgsStr: args.Args + ";IP=" + ip,
+			IfName:        args.IfName,
+			Path:          env.CNIPath,
+		},
+		Log: env.Log,
+	}
+}
+
+// Return the environment to run the delegate's ADD in: one requesting
+// the pod's previous address if it has one, otherwise env itself.
+func (c *config) stickyAddEnv(env *selector.DelegateEnv, args *skel.CmdArgs) *selector.DelegateEnv {
+	ip := newStickyStore(c.StateDir).recall(c.Logger(), args.Args)
+	if ip == "" {
+		return env
+	}
+
+	c.Logger().WithFields(logrus.Fields{
+		"ip": ip,
+	}).Info("Requesting pod's previous address.")
+
+	return stickyEnv(env, args, ip)
+}
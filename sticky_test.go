@@ -0,0 +1,67 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+	"github.com/stretchr/testify/assert"
+)
+
+// Give a pod back its address only if it is the same pod, restarted
+// within the window.
+func TestStickyStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-sticky")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := newStickyStore(dir)
+	args := "K8S_POD_NAMESPACE=tenant-a;K8S_POD_NAME=web-1;K8S_POD_UID=6a2f"
+	result := &types.Result{IP4: &types.IPConfig{IP: net.IPNet{IP: net.ParseIP("10.2.0.7"), Mask: net.CIDRMask(16, 32)}}}
+
+	assert.NoError(t, store.remember(args, result))
+	assert.Equal(t, "10.2.0.7", store.recall(log, args))
+	assert.Equal(t, "", store.recall(log, "K8S_POD_NAMESPACE=tenant-a;K8S_POD_NAME=web-1;K8S_POD_UID=9c41"))
+
+	store.forget(log, args)
+	assert.Equal(t, "", store.recall(log, args))
+
+	// Without a UID the pod cannot be told from its successor.
+	noUID := "K8S_POD_NAMESPACE=tenant-a;K8S_POD_NAME=web-1"
+	assert.NoError(t, store.remember(noUID, result))
+	assert.Equal(t, "", store.recall(log, noUID))
+
+	store.window = -1
+	assert.NoError(t, store.remember(args, result))
+	assert.Equal(t, "", store.recall(log, args))
+}
+
+// Request the previous address from the IPAM plugin in CNI_ARGS.
+func TestStickyEnv(t *testing.T) {
+	args := &skel.CmdArgs{ContainerID: "abc", Netns: "/proc/1/ns/net", IfName: "eth0", Args: "IgnoreUnknown=1"}
+	env := stickyEnv(&selector.DelegateEnv{CNIPath: "/opt/cni/bin"}, args, "10.2.0.7")
+
+	assert.Contains(t, env.Args.AsEnv(), "CNI_ARGS=IgnoreUnknown=1;IP=10.2.0.7")
+	assert.Contains(t, env.Args.AsEnv(), "CNI_CONTAINERID=abc")
+}
@@ -0,0 +1,77 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/utils/sysctl"
+
+	"github.com/Sirupsen/logrus"
+)
+
+var sysctlNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+(\.[a-zA-Z0-9_-]+)*$`)
+
+// Parse the "sysctls" map of a network config.  Values may be given
+// as strings or numbers.
+func parseSysctls(netconf map[string]interface{}) (map[string]string, error) {
+	raw := map[string]interface{}{}
+	if ok, err := decodeNetConfKey(netconf, "sysctls", &raw); !ok || err != nil {
+		return nil, err
+	}
+
+	sysctls := make(map[string]string, len(raw))
+	for name, value := range raw {
+		if !sysctlNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("Invalid sysctl name %q.", name)
+		}
+
+		switch value.(type) {
+		case string, float64, bool:
+			sysctls[name] = strings.TrimSpace(fmt.Sprint(value))
+		default:
+			return nil, fmt.Errorf("Invalid value for sysctl %q: %v", name, value)
+		}
+	}
+
+	return sysctls, nil
+}
+
+// Set the given sysctls inside the network namespace at netns.
+func applySysctls(log *logrus.Entry, netns string, sysctls map[string]string) error {
+	if len(sysctls) == 0 {
+		return nil
+	}
+
+	return withNetNS(netns, func() error {
+		for name, value := range sysctls {
+			if _, err := sysctl.Sysctl(name, value); err != nil {
+				return fmt.Errorf("Failed to set sysctl %q to %q: %v", name, value, err)
+			}
+
+			log.WithFields(logrus.Fields{
+				"sysctl": name,
+				"value":  value,
+			}).Debug("Set sysctl in pod network namespace.")
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,59 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Parse string and numeric sysctl values.
+func TestParseSysctls(t *testing.T) {
+	netconf := map[string]interface{}{}
+	err := json.Unmarshal([]byte(`{
+	  "type": "bridge",
+	  "sysctls": {
+	    "net.ipv4.conf.eth0.rp_filter": "2",
+	    "net.core.somaxconn": 1024
+	  }
+	}`), &netconf)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	sysctls, err := parseSysctls(netconf)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"net.ipv4.conf.eth0.rp_filter": "2",
+		"net.core.somaxconn":           "1024",
+	}, sysctls)
+}
+
+// Reject sysctl names that could escape /proc/sys.
+func TestParseSysctlsBadName(t *testing.T) {
+	netconf := map[string]interface{}{
+		"sysctls": map[string]interface{}{"net/../../etc/passwd": "x"},
+	}
+
+	_, err := parseSysctls(netconf)
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,265 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// How long to wait for the collector to take a trace.
+const traceExportTimeout = time.Second
+
+// Where to export traces of ADD and DEL to: an OTLP/HTTP collector,
+// reached at Endpoint, e.g. "http://127.0.0.1:4318", or over the unix
+// socket Socket.  Traces are sent as OTLP JSON.
+type tracingConfig struct {
+	Endpoint    string `json:"endpoint"`
+	Socket      string `json:"socket"`
+	ServiceName string `json:"serviceName"`
+}
+
+// The spans of one plugin invocation.  A nil trace records nothing, so
+// callers need not check whether tracing is on.
+type trace struct {
+	cfg     *tracingConfig
+	traceID string
+	root    *span
+
+	mu    sync.Mutex
+	spans []*span
+}
+
+// A timed operation within a trace.
+type span struct {
+	trace    *trace
+	name     string
+	spanID   string
+	parentID string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    map[string]string
+	err      error
+}
+
+// OTLP span kinds.
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+)
+
+// Return n random bytes, hex encoded.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Start a trace of the operation name on containerID, begun at start.
+// Returns nil if tracing is not configured.
+func newTrace(cfg *tracingConfig, name, containerID string, start time.Time) *trace {
+	if cfg == nil {
+		return nil
+	}
+
+	t := &trace{cfg: cfg, traceID: randomHex(16)}
+	t.root = &span{trace: t, name: name, spanID: randomHex(8), kind: spanKindInternal, start: start}
+	t.root.set("container.id", containerID)
+	t.spans = append(t.spans, t.root)
+	return t
+}
+
+// Start a span under the root span.
+func (t *trace) start(name string, kind int) *span {
+	if t == nil {
+		return nil
+	}
+
+	s := &span{trace: t, name: name, spanID: randomHex(8), parentID: t.root.spanID, kind: kind, start: time.Now()}
+
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	t.mu.Unlock()
+
+	return s
+}
+
+// Record a span that has already happened.
+func (t *trace) record(name string, start time.Time, err error) {
+	t.start(name, spanKindInternal).endAt(start, time.Now(), err)
+}
+
+// Set an attribute of the span.
+func (s *span) set(key, value string) {
+	if s == nil {
+		return
+	}
+
+	if s.attrs == nil {
+		s.attrs = map[string]string{}
+	}
+	s.attrs[key] = value
+}
+
+// End the span, failed if err is not nil.
+func (s *span) finish(err error) {
+	if s != nil {
+		s.endAt(s.start, time.Now(), err)
+	}
+}
+
+func (s *span) endAt(start, end time.Time, err error) {
+	if s == nil {
+		return
+	}
+
+	s.start, s.end, s.err = start, end, err
+}
+
+// Types of the OTLP JSON encoding.
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+func otlpAttributes(attrs map[string]string) []otlpAttribute {
+	var out []otlpAttribute
+	for k, v := range attrs {
+		a := otlpAttribute{Key: k}
+		a.Value.StringValue = v
+		out = append(out, a)
+	}
+	return out
+}
+
+// Return the trace in the OTLP JSON encoding.
+func (t *trace) otlp() map[string]interface{} {
+	service := t.cfg.ServiceName
+	if service == "" {
+		service = "kube-namespace"
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var spans []otlpSpan
+	for _, s := range t.spans {
+		end := s.end
+		if end.IsZero() {
+			end = time.Now()
+		}
+
+		status := otlpStatus{Code: 1}
+		if s.err != nil {
+			status = otlpStatus{Code: 2, Message: s.err.Error()}
+		}
+
+		spans = append(spans, otlpSpan{
+			TraceID:           t.traceID,
+			SpanID:            s.spanID,
+			ParentSpanID:      s.parentID,
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.attrs),
+			Status:            status,
+		})
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]string{"service.name": service}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "kube-namespace"},
+				"spans": spans,
+			}},
+		}},
+	}
+}
+
+// End the root span with err and send the trace to the collector.
+// Exporting is best effort: failures are only logged.
+func (t *trace) export(log *logrus.Entry, err error) {
+	if t == nil {
+		return
+	}
+	t.root.finish(err)
+
+	data, err := json.Marshal(t.otlp())
+	if err != nil {
+		log.WithField("error", err).Warn("Failed to marshal trace.")
+		return
+	}
+
+	client := &http.Client{Timeout: traceExportTimeout}
+	url := t.cfg.Endpoint + "/v1/traces"
+	if t.cfg.Socket != "" {
+		socket := t.cfg.Socket
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		}
+		url = "http://collector/v1/traces"
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.WithField("error", err).Warn("Failed to export trace.")
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		log.WithField("error", fmt.Sprintf("collector returned %s", resp.Status)).Warn("Failed to export trace.")
+	}
+}
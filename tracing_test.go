@@ -0,0 +1,130 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The collector's view of an exported trace.
+type receivedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Status       struct {
+		Code int `json:"code"`
+	} `json:"status"`
+}
+
+// Return a handler recording the spans posted to it on received.
+func collector(received chan<- []receivedSpan) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []receivedSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if r.URL.Path != "/v1/traces" || json.NewDecoder(r.Body).Decode(&body) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		received <- body.ResourceSpans[0].ScopeSpans[0].Spans
+	})
+}
+
+// A nil trace records nothing.
+func TestTracingDisabled(t *testing.T) {
+	tr := newTrace(nil, "ADD", "abc", time.Now())
+	assert.Nil(t, tr)
+
+	span := tr.start("select", spanKindInternal)
+	span.set("network", "default")
+	span.finish(nil)
+	tr.export(log, nil)
+}
+
+// Export spans, parented by the root span, to an HTTP collector.
+func TestTracingEndpoint(t *testing.T) {
+	received := make(chan []receivedSpan, 1)
+	server := httptest.NewServer(collector(received))
+	defer server.Close()
+
+	tr := newTrace(&tracingConfig{Endpoint: server.URL}, "ADD", "abc", time.Now())
+	tr.record("parse config", time.Now(), nil)
+	tr.start("delegate ADD", spanKindClient).finish(errors.New("no IP addresses available"))
+	tr.export(log, errors.New("no IP addresses available"))
+
+	spans := <-received
+	if assert.Len(t, spans, 3) {
+		assert.Equal(t, "ADD", spans[0].Name)
+		assert.Len(t, spans[0].TraceID, 32)
+		assert.Empty(t, spans[0].ParentSpanID)
+		assert.Equal(t, 2, spans[0].Status.Code)
+
+		assert.Equal(t, "parse config", spans[1].Name)
+		assert.Equal(t, spans[0].SpanID, spans[1].ParentSpanID)
+		assert.Equal(t, 1, spans[1].Status.Code)
+
+		assert.Equal(t, "delegate ADD", spans[2].Name)
+		assert.Equal(t, spans[0].TraceID, spans[2].TraceID)
+		assert.Equal(t, 2, spans[2].Status.Code)
+	}
+}
+
+// Export spans to a collector listening on a unix socket.
+func TestTracingSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-tracing")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "otlp.sock")
+	l, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+	received := make(chan []receivedSpan, 1)
+	server := &http.Server{Handler: collector(received)}
+	go server.Serve(l)
+	defer server.Close()
+
+	tr := newTrace(&tracingConfig{Socket: socket}, "DEL", "abc", time.Now())
+	tr.export(log, nil)
+
+	spans := <-received
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "DEL", spans[0].Name)
+	}
+}
+
+// An unreachable collector does not fail the operation.
+func TestTracingUnreachable(t *testing.T) {
+	tr := newTrace(&tracingConfig{Socket: "/nonexistent/otlp.sock"}, "ADD", "abc", time.Now())
+	tr.export(log, nil)
+}
@@ -0,0 +1,75 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/containernetworking/cni/pkg/invoke"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+)
+
+// Run delegates whose network config does not set a cniVersion at the
+// runtime's version, so newer plugins are not left speaking 0.1.0.  If
+// the delegate does not support the runtime's version, it is run at
+// the newest version both support; results of any version are parsed.
+func negotiateVersion(env *selector.DelegateEnv, netconf map[string]interface{}, cniVersion string) {
+	if _, ok := netconf["cniVersion"]; ok || cniVersion == "" {
+		return
+	}
+
+	path, err := env.FindDelegate(netconf)
+	if err != nil {
+		// Leave it to the delegate call to report.
+		return
+	}
+
+	info, err := invoke.GetVersionInfo(path)
+	if err != nil {
+		env.Logger().WithField("error", err).Warn("Failed to get the delegate's supported versions.")
+		return
+	}
+
+	if negotiated := commonVersion(cniVersion, info.SupportedVersions()); negotiated != "" {
+		env.Logger().WithFields(logrus.Fields{
+			"delegate_type": netconf["type"],
+			"cni_version":   negotiated,
+		}).Debug("Negotiated the delegate's CNI version.")
+		netconf["cniVersion"] = negotiated
+	}
+}
+
+// Return cniVersion if the delegate supports it, or else the newest
+// version supported by both the delegate and kube-namespace.
+func commonVersion(cniVersion string, delegateVersions []string) string {
+	supported := make(map[string]bool, len(delegateVersions))
+	for _, v := range delegateVersions {
+		supported[v] = true
+	}
+
+	if supported[cniVersion] {
+		return cniVersion
+	}
+
+	ours := supportedVersions.SupportedVersions()
+	for i := len(ours) - 1; i >= 0; i-- {
+		if supported[ours[i]] {
+			return ours[i]
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+	"github.com/stretchr/testify/assert"
+)
+
+// Prefer the runtime's version, falling back to the newest common one.
+func TestCommonVersion(t *testing.T) {
+	assert.Equal(t, "0.3.1", commonVersion("0.3.1", []string{"0.1.0", "0.2.0", "0.3.0", "0.3.1"}))
+	assert.Equal(t, "0.2.0", commonVersion("0.3.1", []string{"0.1.0", "0.2.0"}))
+	assert.Equal(t, "", commonVersion("0.3.1", []string{"0.4.0"}))
+}
+
+// Run the delegate at a version from its VERSION output, unless its
+// config sets one.
+func TestNegotiateVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-namespace-version")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeHook(t, dir, "legacy", `cat > /dev/null; echo '{"cniVersion": "0.2.0", "supportedVersions": ["0.1.0", "0.2.0"]}'`)
+	writeHook(t, dir, "old", `cat > /dev/null; echo 'unknown CNI_COMMAND: VERSION' >&2; echo '{"code": 100, "msg": "unknown CNI_COMMAND: VERSION"}'; exit 1`)
+	env := &selector.DelegateEnv{CNIPath: dir}
+
+	netconf := map[string]interface{}{"type": "legacy"}
+	negotiateVersion(env, netconf, "0.3.1")
+	assert.Equal(t, "0.2.0", netconf["cniVersion"])
+
+	netconf = map[string]interface{}{"type": "old"}
+	negotiateVersion(env, netconf, "0.3.1")
+	assert.Equal(t, "0.1.0", netconf["cniVersion"])
+
+	netconf = map[string]interface{}{"type": "legacy", "cniVersion": "0.1.0"}
+	negotiateVersion(env, netconf, "0.3.1")
+	assert.Equal(t, "0.1.0", netconf["cniVersion"])
+
+	netconf = map[string]interface{}{"type": "missing"}
+	negotiateVersion(env, netconf, "0.3.1")
+	assert.NotContains(t, netconf, "cniVersion")
+}
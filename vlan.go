@@ -0,0 +1,56 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Create the VLAN subinterface for the namespace on the host, if
+// needed, before the delegate uses it.
+func ensureVLAN(log *logrus.Entry, m *selector.VLANMap, namespace string) error {
+	vid, ok := m.Namespaces[namespace]
+	if !ok || m.Mode != selector.VLANModeMacvlan {
+		return nil
+	}
+
+	name := m.Subinterface(vid)
+	if _, err := net.InterfaceByName(name); err == nil {
+		return nil
+	}
+
+	if _, err := runCommand("ip", "link", "add", "link", m.Master, "name", name,
+		"type", "vlan", "id", fmt.Sprint(vid)); err != nil {
+		// Another ADD may have created it meanwhile.
+		if _, err2 := net.InterfaceByName(name); err2 != nil {
+			return err
+		}
+	}
+
+	if _, err := runCommand("ip", "link", "set", name, "up"); err != nil {
+		return err
+	}
+
+	log.WithField("interface", name).Info("Created VLAN subinterface.")
+	return nil
+}
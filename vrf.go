@@ -0,0 +1,142 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// The bridge plugin's default bridge name.
+const defaultBridgeName = "cni0"
+
+// Return the bridge used by a bridge network config.
+func bridgeName(netconf map[string]interface{}) string {
+	bridge, _ := netconf["bridge"].(string)
+	if bridge == "" {
+		bridge = defaultBridgeName
+	}
+
+	return bridge
+}
+
+// A Linux VRF that a network's host-side interfaces are placed into.
+type vrfConfig struct {
+	// Name of the VRF device.  Defaults to "vrf<table>".
+	Name  string `json:"name"`
+	Table int    `json:"table"`
+}
+
+// Parse the "vrf" block of a network config.
+func parseVRF(netconf map[string]interface{}) (*vrfConfig, error) {
+	vrf := &vrfConfig{}
+	if ok, err := decodeNetConfKey(netconf, "vrf", vrf); !ok || err != nil {
+		return nil, err
+	}
+
+	if vrf.Table <= 0 {
+		return nil, errors.New("VRF config requires a positive routing table number.")
+	}
+
+	if vrf.Name == "" {
+		vrf.Name = fmt.Sprintf("vrf%d", vrf.Table)
+	}
+
+	if len(vrf.Name) > 15 {
+		return nil, fmt.Errorf("VRF name %q is longer than 15 characters.", vrf.Name)
+	}
+
+	return vrf, nil
+}
+
+// Create the VRF device if it does not exist yet.  Another pod may be
+// racing to create it, so a failed create is only an error if the
+// device still does not exist afterwards.
+func (vrf *vrfConfig) ensure() error {
+	if _, err := net.InterfaceByName(vrf.Name); err == nil {
+		return nil
+	}
+
+	_, addErr := runCommand("ip", "link", "add", vrf.Name, "type", "vrf",
+		"table", strconv.Itoa(vrf.Table))
+	if _, err := net.InterfaceByName(vrf.Name); err != nil {
+		return addErr
+	}
+
+	_, err := runCommand("ip", "link", "set", vrf.Name, "up")
+	return err
+}
+
+// Place the pod's host-side interface into the VRF.  For the bridge
+// delegate that is the bridge itself, whose connected routes then move
+// into the VRF's table.  For veth-based delegates like ptp it is the
+// host end of the veth, and host routes to the pod are added to the
+// table.
+func (vrf *vrfConfig) apply(log *logrus.Entry, netconf map[string]interface{}, netns, ifName string, result *types.Result) error {
+	if err := vrf.ensure(); err != nil {
+		return err
+	}
+
+	if netconf["type"] == "bridge" {
+		_, err := runCommand("ip", "link", "set", bridgeName(netconf), "master", vrf.Name)
+		return err
+	}
+
+	hostIf, err := hostPeer(netns, ifName)
+	if err != nil {
+		return err
+	}
+
+	if _, err := runCommand("ip", "link", "set", hostIf.Name, "master", vrf.Name); err != nil {
+		return err
+	}
+
+	for _, ipc := range []*types.IPConfig{result.IP4, result.IP6} {
+		if ipc == nil {
+			continue
+		}
+
+		_, err := runCommand("ip", "route", "replace", hostRoute(ipc.IP.IP),
+			"dev", hostIf.Name, "table", strconv.Itoa(vrf.Table))
+		if err != nil {
+			return err
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		"vrf":       vrf.Name,
+		"interface": hostIf.Name,
+	}).Debug("Placed host interface into VRF.")
+
+	return nil
+}
+
+// Return the single-address prefix for ip.
+func hostRoute(ip net.IP) string {
+	if ip.To4() != nil {
+		return ip.String() + "/32"
+	}
+
+	return ip.String() + "/128"
+}
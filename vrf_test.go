@@ -0,0 +1,47 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Derive the VRF name from the table.
+func TestParseVRF(t *testing.T) {
+	netconf := map[string]interface{}{
+		"vrf": map[string]interface{}{"table": 100},
+	}
+
+	vrf, err := parseVRF(netconf)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &vrfConfig{Name: "vrf100", Table: 100}, vrf)
+}
+
+// Error if no table is given.
+func TestParseVRFNoTable(t *testing.T) {
+	netconf := map[string]interface{}{
+		"vrf": map[string]interface{}{"name": "tenant-a"},
+	}
+
+	_, err := parseVRF(netconf)
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,156 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// On Windows, and any other platform but Linux, kube-namespace
+// selects a pod's network config from its namespace as on Linux, and
+// passes ADD and DEL straight through to the delegate, e.g. win-bridge
+// or win-overlay.  The options that kube-namespace applies itself work
+// on Linux network namespaces, so network configs using them are
+// refused.
+
+// The plugin config, as far as it is understood off Linux.
+type config struct {
+	*selector.Config `json:"-"`
+
+	CNIVersion string `json:"cniVersion"`
+	LogLevel   string `json:"log_level"`
+}
+
+// Parse the plugin config.
+func parseConfig(data []byte) (*config, error) {
+	config := &config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, selector.ParseError("config", data, err)
+	}
+
+	var err error
+	if config.Config, err = selector.Parse(data); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// Return the network config to pass to the delegate, or an error if
+// the selected config uses options only supported on Linux.
+func windowsNetConf(sel *selector.Selection) (map[string]interface{}, error) {
+	for _, k := range pluginKeys {
+		if _, ok := sel.NetConf[k]; ok {
+			return nil, fmt.Errorf("Network config %q sets %q, which is only supported on Linux.", sel.Rule, k)
+		}
+	}
+
+	return delegateNetConf(sel.NetConf), nil
+}
+
+// Parse the config and select the pod's network config.
+func selectDelegate(args *skel.CmdArgs) (*config, map[string]interface{}, error) {
+	config, err := parseConfig(args.StdinData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config.setLogLevel()
+	log = log.WithFields(logrus.Fields{"container_id": args.ContainerID, "request_id": newRequestID()})
+	selector.Log = log
+
+	sel, err := config.Select(args.Args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	netconf, err := windowsNetConf(sel)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return config, netconf, nil
+}
+
+// Print the delegate's result in the format of cniVersion.  For 0.3
+// configs, a 0.3 result is printed as the delegate printed it; older
+// results, and the results of runtimes that speak 0.1 or 0.2, are
+// converted.
+func printWindowsResult(result *selector.Result, cniVersion string, args *skel.CmdArgs, w io.Writer) error {
+	if selector.Is030(cniVersion) && result.Result030 != nil {
+		_, err := w.Write(result.Raw)
+		return err
+	}
+
+	var out interface{} = result.Result
+	if selector.Is030(cniVersion) {
+		out = selector.ConvertTo030(result.Result, cniVersion, args.IfName, args.Netns)
+	}
+
+	data, err := json.MarshalIndent(out, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	config, netconf, err := selectDelegate(args)
+	if err != nil {
+		return err
+	}
+	log.Info("Configuring pod networking.")
+
+	env := selector.ProcessEnv()
+	negotiateVersion(env, netconf, config.CNIVersion)
+	result, err := env.Add(netconf)
+	if err != nil {
+		return err
+	}
+
+	return printWindowsResult(result, config.CNIVersion, args, os.Stdout)
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	config, netconf, err := selectDelegate(args)
+	if err != nil {
+		return err
+	}
+	log.Info("Removing pod networking.")
+
+	env := selector.ProcessEnv()
+	negotiateVersion(env, netconf, config.CNIVersion)
+	return env.Del(netconf)
+}
+
+func main() {
+	logrus.SetOutput(os.Stderr)
+	selector.Log = log
+
+	skel.PluginMain(cmdAdd, cmdDel, supportedVersions)
+}
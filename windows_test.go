@@ -0,0 +1,98 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/coreos/kube-namespace-cni/pkg/selector"
+	"github.com/stretchr/testify/assert"
+)
+
+// Pass plain configs through, and refuse ones using Linux-only
+// options.
+func TestWindowsNetConf(t *testing.T) {
+	config, err := parseConfig([]byte(`{
+	  "namespaces": {
+	    "tenant-a": {"name": "tenant-a", "type": "win-bridge"},
+	    "tenant-b": {"name": "tenant-b", "type": "win-bridge", "bandwidth": {"rate": 1000000}}
+	  }
+	}`))
+	assert.NoError(t, err)
+
+	sel, err := config.Select("K8S_POD_NAMESPACE=tenant-a")
+	assert.NoError(t, err)
+	netconf, err := windowsNetConf(sel)
+	assert.NoError(t, err)
+	assert.Equal(t, "win-bridge", netconf["type"])
+
+	sel, err = config.Select("K8S_POD_NAMESPACE=tenant-b")
+	assert.NoError(t, err)
+	_, err = windowsNetConf(sel)
+	assert.Error(t, err)
+}
+
+// Print results in the runtime's format.
+func TestPrintWindowsResult(t *testing.T) {
+	result := &selector.Result{
+		Result: &types.Result{IP4: &types.IPConfig{IP: net.IPNet{IP: net.ParseIP("10.2.0.7").To4(), Mask: net.CIDRMask(16, 32)}}},
+	}
+	args := &skel.CmdArgs{IfName: "eth0", Netns: "none"}
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, printWindowsResult(result, "0.3.1", args, out))
+	r030 := &selector.Result030{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), r030))
+	if assert.Len(t, r030.IPs, 1) {
+		addr := net.IPNet(r030.IPs[0].Address)
+		assert.Equal(t, "10.2.0.7/16", addr.String())
+	}
+
+	out.Reset()
+	assert.NoError(t, printWindowsResult(result, "0.2.0", args, out))
+	assert.Contains(t, out.String(), `"ip4"`)
+}
+
+// Print a delegate's 0.3 result as is for 0.3 configs.
+func TestPrintWindowsResult030(t *testing.T) {
+	printed := `{
+	  "cniVersion": "0.3.1",
+	  "interfaces": [{"name": "vEthernet (pod)", "mac": "00:15:5d:00:00:05"}, {"name": "eth0", "sandbox": "pod"}],
+	  "ips": [
+	    {"version": "4", "interface": 1, "address": "10.2.0.7/16", "gateway": "10.2.0.1"},
+	    {"version": "4", "interface": 1, "address": "10.2.0.8/16"}
+	  ]
+	}`
+	result, err := selector.ParsePluginResult([]byte(printed))
+	assert.NoError(t, err)
+	args := &skel.CmdArgs{IfName: "eth0", Netns: "none"}
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, printWindowsResult(result, "0.3.1", args, out))
+	assert.JSONEq(t, printed, out.String())
+
+	out.Reset()
+	assert.NoError(t, printWindowsResult(result, "0.2.0", args, out))
+	assert.Contains(t, out.String(), `"ip4"`)
+	assert.NotContains(t, out.String(), `"interfaces"`)
+}